@@ -263,7 +263,7 @@ async function bootstrap() {
 
     // Step 3: Get actual FullID
     console.log('\nStep 3: Getting actual FullID from chaincode...');
-    const fullIdResult = await queryChaincode(ADMIN_CONFIG.kid_name, 'TestGetCallerIdentity', []);
+    const fullIdResult = await queryChaincode(ADMIN_CONFIG.kid_name, 'GetCallerIdentity', []);
     if (!fullIdResult.success || !fullIdResult.data?.fullId) {
       throw new Error('Failed to get FullID from chaincode');
     }
@@ -358,7 +358,7 @@ app.post('/api/auth/register', authenticateToken, requireAdmin, asyncHandler(asy
   }
 
   // Step 3: Get actual FullID from chaincode
-  const fullIdResult = await queryChaincode(kid_name, 'TestGetCallerIdentity', []);
+  const fullIdResult = await queryChaincode(kid_name, 'GetCallerIdentity', []);
   if (!fullIdResult.success || !fullIdResult.data?.fullId) {
     return res.status(500).json({ error: 'Failed to get FullID from chaincode' });
   }
@@ -682,7 +682,7 @@ app.get('/api/shipments/:id', async (req, res) => {
       return res.status(500).json({ error: 'No user available for query' });
     }
 
-    const result = await queryChaincode(kidName, 'GetShipmentPublicDetails', [req.params.id]);
+    const result = await queryChaincode(kidName, 'GetShipmentPublicDetails', [req.params.id, 'false']);
     if (result.success) {
       res.json(result.data);
     } else {
@@ -706,7 +706,7 @@ app.get('/api/shipments/public/:id', async (req, res) => {
       return res.status(500).json({ error: 'No user available for query' });
     }
 
-    const result = await queryChaincode(adminUser.kid_name, 'GetShipmentPublicDetails', [req.params.id]);
+    const result = await queryChaincode(adminUser.kid_name, 'GetShipmentPublicDetails', [req.params.id, 'false']);
     if (result.success) {
       res.json(result.data);
     } else {
@@ -729,7 +729,7 @@ app.get('/api/shipments/:id/qrcode', async (req, res) => {
     if (!adminUser) {
       return res.status(500).json({ error: 'No user available for query' });
     }
-    const result = await queryChaincode(adminUser.kid_name, 'GetShipmentPublicDetails', [req.params.id]);
+    const result = await queryChaincode(adminUser.kid_name, 'GetShipmentPublicDetails', [req.params.id, 'true']);
     if (!result.success) {
       return res.status(500).json({ error: 'Failed to fetch shipment details', details: result.error });
     }
@@ -807,10 +807,10 @@ app.post('/api/shipments/:id/certification/record', authenticateToken, requireRo
 
 app.post('/api/shipments/:id/process', authenticateToken, requireRole(['processor']), async (req, res) => {
   try {
-    const { processorData } = req.body;
+    const { processorData, byproducts } = req.body;
     
     const result = await invokeChaincode(req.user.kid_name, 'ProcessShipment', [
-      req.params.id, JSON.stringify(processorData)
+      req.params.id, JSON.stringify(processorData), JSON.stringify(byproducts || [])
     ]);
     
     if (isCallSuccessful(result)) {
@@ -941,7 +941,7 @@ app.post('/api/shipments/:id/receive', authenticateToken, requireRole(['retailer
 
 app.post('/api/shipments/transform', authenticateToken, requireRole(['processor']), async (req, res) => {
   try {
-    const { inputConsumption, newProductsData, processorData } = req.body;
+    const { inputConsumption, newProductsData, processorData, aggregateEvents } = req.body;
     
     const result = await invokeChaincode(
         req.user.kid_name,
@@ -949,7 +949,8 @@ app.post('/api/shipments/transform', authenticateToken, requireRole(['processor'
         [
           JSON.stringify(inputConsumption),
           JSON.stringify(newProductsData),
-          JSON.stringify(processorData)
+          JSON.stringify(processorData),
+          Boolean(aggregateEvents)
         ]
       );
   
@@ -1040,10 +1041,10 @@ app.post('/api/recalls/initiate', authenticateToken, async (req, res) => {
 
 app.post('/api/recalls/:recallId/linked-shipments', authenticateToken, requireAdmin, async (req, res) => {
   try {
-    const { primaryShipmentId, linkedShipmentIds } = req.body;
+    const { primaryShipmentId, linkedShipmentIds, aggregateEvents } = req.body;
     
     const result = await invokeChaincode(req.user.kid_name, 'AddLinkedShipmentsToRecall', [
-      req.params.recallId, primaryShipmentId, JSON.stringify(linkedShipmentIds)
+      req.params.recallId, primaryShipmentId, JSON.stringify(linkedShipmentIds), Boolean(aggregateEvents)
     ]);
     
     if (isCallSuccessful(result)) {
@@ -1112,10 +1113,10 @@ app.post('/api/ipfs/upload', authenticateToken, requireRole(['certifier', 'farme
   }
 });
 
-// Current user information using TestGetCallerIdentity
+// Current user information using GetCallerIdentity
 app.get('/api/users/current/info', authenticateToken, asyncHandler(async (req, res) => {
   try {
-    const result = await queryChaincode(req.user.kid_name, 'TestGetCallerIdentity', []);
+    const result = await queryChaincode(req.user.kid_name, 'GetCallerIdentity', []);
     
     if (result.success) {
       res.json(result.data);
@@ -1147,7 +1148,7 @@ app.get('/api/users/:alias/admin/status', authenticateToken, requireAdmin, async
 // Debug/Development Routes (keep for testing)
 app.get('/api/debug/caller-identity', authenticateToken, requireAdmin, asyncHandler(async (req, res) => {
   try {
-    const result = await queryChaincode(req.user.kid_name, 'TestGetCallerIdentity', []);
+    const result = await queryChaincode(req.user.kid_name, 'GetCallerIdentity', []);
     
     if (result.success) {
       res.json(result.data);
@@ -1182,7 +1183,7 @@ app.get('/api/system/bootstrap-status', asyncHandler(async (req, res) => {
       });
       
       if (adminUser) {
-        const testResult = await queryChaincode(adminUser.kid_name, 'TestGetCallerIdentity', []);
+        const testResult = await queryChaincode(adminUser.kid_name, 'GetCallerIdentity', []);
         chaincodeResponsive = testResult.success;
       }
     }
@@ -5,9 +5,12 @@
 package contract
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -19,11 +22,17 @@ import (
 
 var idLogger = flogging.MustGetLogger("foodtrace.identitymanager")
 
+// nonAliasCharPattern matches characters replaced when deriving an alias from an enrollment ID.
+var nonAliasCharPattern = regexp.MustCompile(`[^a-z0-9._-]+`)
+
 // Object types for composite keys, also usable as 'docType' or 'objectType' in CouchDB.
 const (
-	identityObjectType  = "IdentityInfo" // Stores IdentityInfo objects. Attribute for composite key: FullID.
-	aliasObjectType     = "Alias"        // Maps ShortName (alias) to FullID. Attribute for composite key: ShortName.
-	adminFlagObjectType = "AdminFlag"    // Stores a flag for admin status. Attribute for composite key: FullID.
+	identityObjectType    = "IdentityInfo"    // Stores IdentityInfo objects. Attribute for composite key: FullID.
+	aliasObjectType       = "Alias"           // Maps ShortName (alias) to FullID. Attribute for composite key: ShortName.
+	adminFlagObjectType   = "AdminFlag"       // Stores a flag for admin status. Attribute for composite key: FullID.
+	enrollmentObjectType  = "EnrollmentID"    // Maps EnrollmentID to FullID. Attribute for composite key: EnrollmentID.
+	aliasNormObjectType   = "AliasNorm"       // Maps lowercased ShortName to FullID. Attribute for composite key: lowercased ShortName.
+	fingerprintObjectType = "CertFingerprint" // Maps certificate fingerprint to FullID. Attribute for composite key: hex SHA-256.
 )
 
 // ValidRoles defines the set of permissible roles in the system.
@@ -33,6 +42,7 @@ var ValidRoles = map[string]bool{
 	"distributor": true,
 	"retailer":    true,
 	"certifier":   true,
+	"registrar":   true, // May register new identities once admins exist, if its MSP is allowed
 	// "admin" is a special status, managed by IsAdmin, not a role in this list.
 }
 
@@ -83,51 +93,455 @@ func (im *IdentityManager) createAdminFlagCompositeKey(fullID string) (string, e
 	return im.Ctx.GetStub().CreateCompositeKey(adminFlagObjectType, []string{fullID})
 }
 
+func (im *IdentityManager) createNormalizedAliasCompositeKey(shortName string) (string, error) {
+	return im.Ctx.GetStub().CreateCompositeKey(aliasNormObjectType, []string{strings.ToLower(strings.TrimSpace(shortName))})
+}
+
+func (im *IdentityManager) createFingerprintCompositeKey(fingerprint string) (string, error) {
+	return im.Ctx.GetStub().CreateCompositeKey(fingerprintObjectType, []string{normalizeCertFingerprint(fingerprint)})
+}
+
+// normalizeCertFingerprint lowercases a hex fingerprint and drops ':' separators so either form can be looked up.
+func normalizeCertFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+// bindCallerCertFingerprint records the SHA-256 fingerprint of the caller's certificate on idInfo and
+// indexes it, removing the mapping for any previously recorded certificate. It only makes sense when the
+// caller is the identity being registered. A caller without an available certificate leaves the fingerprint empty.
+func (im *IdentityManager) bindCallerCertFingerprint(idInfo *model.IdentityInfo) error {
+	cert, err := im.Ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil || cert == nil {
+		idLogger.Warningf("No X.509 certificate available for '%s'; certificate fingerprint not recorded (err: %v)", idInfo.FullID, err)
+		return nil
+	}
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	if idInfo.CertFingerprint != "" && normalizeCertFingerprint(idInfo.CertFingerprint) != fingerprint {
+		// The identity moved to a new certificate; the retired one must no longer resolve to it.
+		oldKey, keyErr := im.createFingerprintCompositeKey(idInfo.CertFingerprint)
+		if keyErr != nil {
+			return fmt.Errorf("failed to create key for previous fingerprint of '%s': %w", idInfo.FullID, keyErr)
+		}
+		if holderBytes, _ := im.Ctx.GetStub().GetState(oldKey); string(holderBytes) == idInfo.FullID {
+			if errDel := im.Ctx.GetStub().DelState(oldKey); errDel != nil {
+				return fmt.Errorf("failed to delete previous certificate fingerprint mapping for '%s': %w", idInfo.FullID, errDel)
+			}
+		}
+	}
+	fingerprintKey, err := im.createFingerprintCompositeKey(fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to create fingerprint key for '%s': %w", idInfo.FullID, err)
+	}
+	if err := im.Ctx.GetStub().PutState(fingerprintKey, []byte(idInfo.FullID)); err != nil {
+		return fmt.Errorf("failed to save certificate fingerprint mapping for '%s': %w", idInfo.FullID, err)
+	}
+	idInfo.CertFingerprint = fingerprint
+	return nil
+}
+
+// findCaseInsensitiveAliasHolder returns the FullID of another identity whose alias equals shortName
+// ignoring case, or "" if none. Identities registered before the normalized index existed are only
+// found once BackfillIdentityIndexes has run.
+func (im *IdentityManager) findCaseInsensitiveAliasHolder(shortName, excludeFullID string) (string, error) {
+	normKey, err := im.createNormalizedAliasCompositeKey(shortName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create normalized alias key for '%s': %w", shortName, err)
+	}
+	holderBytes, err := im.Ctx.GetStub().GetState(normKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to check normalized alias '%s': %w", shortName, err)
+	}
+	if holderBytes != nil && string(holderBytes) != excludeFullID {
+		return string(holderBytes), nil
+	}
+	return "", nil
+}
+
+// deriveAlias builds a deterministic alias for an identity registered without a shortName.
+// An identity that already has an alias keeps it. Otherwise the enrollment ID is used as the base,
+// falling back to a hash of the full ID, and a hash suffix is added if the base is taken.
+func (im *IdentityManager) deriveAlias(targetFullID, enrollmentID string) (string, error) {
+	existing, err := im.GetIdentityInfo(targetFullID)
+	if err == nil && existing != nil && existing.ShortName != "" {
+		return existing.ShortName, nil
+	}
+
+	sum := sha256.Sum256([]byte(targetFullID))
+	hashPart := hex.EncodeToString(sum[:])
+	base := nonAliasCharPattern.ReplaceAllString(strings.ToLower(strings.TrimSpace(enrollmentID)), "-")
+	base = strings.Trim(base, "-")
+	if base == "" {
+		base = "id-" + hashPart[:12]
+	}
+
+	candidates := []string{base, base + "-" + hashPart[:8], base + "-" + hashPart[:16], base + "-" + hashPart}
+	for _, candidate := range candidates {
+		aliasKey, keyErr := im.createAliasCompositeKey(candidate)
+		if keyErr != nil {
+			return "", fmt.Errorf("failed to create alias composite key for '%s': %w", candidate, keyErr)
+		}
+		holderBytes, getErr := im.Ctx.GetStub().GetState(aliasKey)
+		if getErr != nil {
+			return "", fmt.Errorf("failed to check alias availability for '%s': %w", candidate, getErr)
+		}
+		if holderBytes != nil && string(holderBytes) != targetFullID {
+			continue
+		}
+		caseHolder, holderErr := im.findCaseInsensitiveAliasHolder(candidate, targetFullID)
+		if holderErr != nil {
+			return "", holderErr
+		}
+		if caseHolder == "" {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("could not derive a unique alias for identity '%s'", targetFullID)
+}
+
+func (im *IdentityManager) createEnrollmentCompositeKey(enrollmentID string) (string, error) {
+	return im.Ctx.GetStub().CreateCompositeKey(enrollmentObjectType, []string{enrollmentID})
+}
+
+// findEnrollmentIDHolder returns the FullID the enrollment index maps an enrollment ID to, or "" if none.
+// Identities registered before the index existed are only found once BackfillIdentityIndexes has run.
+func (im *IdentityManager) findEnrollmentIDHolder(enrollmentID, excludeFullID string) (string, error) {
+	enrollmentKey, err := im.createEnrollmentCompositeKey(enrollmentID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create enrollment composite key for '%s': %w", enrollmentID, err)
+	}
+	holderBytes, err := im.Ctx.GetStub().GetState(enrollmentKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to check enrollment ID '%s': %w", enrollmentID, err)
+	}
+	if holderBytes != nil && string(holderBytes) != excludeFullID {
+		return string(holderBytes), nil
+	}
+	return "", nil
+}
+
+// BackfillIdentityIndexes adds the normalized alias and enrollment ID mappings missing for identities
+// registered before those indexes existed (admin only). Run it once after upgrading; existing mappings
+// are kept, so repeating it is harmless. When several identities share an unindexed key, the first one
+// scanned is mapped.
+func (im *IdentityManager) BackfillIdentityIndexes() (map[string]interface{}, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for BackfillIdentityIndexes: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller admin status for BackfillIdentityIndexes: %w", err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to backfill identity indexes", callerFullID)
+	}
+
+	resultsIterator, err := im.Ctx.GetStub().GetStateByPartialCompositeKey(identityObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities iterator for backfill: %w", err)
+	}
+	defer resultsIterator.Close()
+
+	// Same-transaction writes are not visible to GetState, so mappings written here are tracked separately.
+	indexedAliases := make(map[string]bool)
+	indexedEnrollmentIDs := make(map[string]bool)
+	scanned := 0
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			idLogger.Warningf("BackfillIdentityIndexes: error iterating identities: %v. Skipping.", iterErr)
+			continue
+		}
+		var idInfo model.IdentityInfo
+		if err := json.Unmarshal(queryResponse.Value, &idInfo); err != nil {
+			idLogger.Warningf("BackfillIdentityIndexes: error unmarshalling identity: %v. Skipping.", err)
+			continue
+		}
+		scanned++
+
+		normAlias := strings.ToLower(strings.TrimSpace(idInfo.ShortName))
+		if normAlias != "" && !indexedAliases[normAlias] {
+			normKey, err := im.createNormalizedAliasCompositeKey(normAlias)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create normalized alias key for '%s': %w", idInfo.ShortName, err)
+			}
+			indexed, err := im.putIndexEntryIfMissing(normKey, idInfo.FullID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to backfill normalized alias '%s': %w", idInfo.ShortName, err)
+			}
+			if indexed {
+				indexedAliases[normAlias] = true
+			}
+		}
+
+		if idInfo.EnrollmentID != "" && !indexedEnrollmentIDs[idInfo.EnrollmentID] {
+			enrollmentKey, err := im.createEnrollmentCompositeKey(idInfo.EnrollmentID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create enrollment composite key for '%s': %w", idInfo.EnrollmentID, err)
+			}
+			indexed, err := im.putIndexEntryIfMissing(enrollmentKey, idInfo.FullID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to backfill enrollment ID '%s': %w", idInfo.EnrollmentID, err)
+			}
+			if indexed {
+				indexedEnrollmentIDs[idInfo.EnrollmentID] = true
+			}
+		}
+	}
+
+	idLogger.Infof("BackfillIdentityIndexes by '%s': scanned %d identities, indexed %d aliases and %d enrollment IDs.",
+		callerFullID, scanned, len(indexedAliases), len(indexedEnrollmentIDs))
+	return map[string]interface{}{
+		"identitiesScanned":        scanned,
+		"normalizedAliasesIndexed": len(indexedAliases),
+		"enrollmentIdsIndexed":     len(indexedEnrollmentIDs),
+	}, nil
+}
+
+// putIndexEntryIfMissing maps key to fullID unless the key already holds a mapping, and reports whether it wrote.
+func (im *IdentityManager) putIndexEntryIfMissing(key, fullID string) (bool, error) {
+	holderBytes, err := im.Ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, err
+	}
+	if holderBytes != nil {
+		return false, nil
+	}
+	if err := im.Ctx.GetStub().PutState(key, []byte(fullID)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// emitAdminDataAccessed records an admin-only read as an AdminDataAccessed event when read auditing is enabled.
+// Failures are logged and never block the read.
+func (im *IdentityManager) emitAdminDataAccessed(callerFullID, functionName string, resultCount int) {
+	enabled, err := getConfigBool(im.Ctx, configAuditAdminReads, false)
+	if err != nil {
+		idLogger.Warningf("emitAdminDataAccessed: failed to read audit setting: %v", err)
+		return
+	}
+	if !enabled {
+		return
+	}
+	now, err := im.getCurrentTxTimestamp()
+	if err != nil {
+		idLogger.Warningf("emitAdminDataAccessed: %v", err)
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"callerId":    callerFullID,
+		"function":    functionName,
+		"resultCount": resultCount,
+		"txId":        im.Ctx.GetStub().GetTxID(),
+		"timestamp":   now.Format(time.RFC3339),
+	})
+	if err != nil {
+		idLogger.Warningf("emitAdminDataAccessed: failed to marshal payload for '%s': %v", functionName, err)
+		return
+	}
+	if errSet := im.Ctx.GetStub().SetEvent("AdminDataAccessed", payload); errSet != nil {
+		idLogger.Warningf("emitAdminDataAccessed: failed to set event for '%s': %v", functionName, errSet)
+	}
+}
+
+// requireAllowedRegistrarMSP rejects registrars whose MSP is not on the admin-managed allowed-registrar
+// list. An empty list lets registrars from any MSP register.
+func (im *IdentityManager) requireAllowedRegistrarMSP() error {
+	allowed, err := getConfigStringList(im.Ctx, configAllowedRegistrarMSPs)
+	if err != nil {
+		return fmt.Errorf("failed to read allowed registrar MSPs: %w", err)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	mspID, err := im.Ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller MSPID for registrar check: %w", err)
+	}
+	for _, m := range allowed {
+		if m == mspID {
+			return nil
+		}
+	}
+	return fmt.Errorf("caller MSP '%s' is not permitted to register identities", mspID)
+}
+
 // --- Public Identity Management Functions ---
 
 func (im *IdentityManager) RegisterIdentity(targetFullID, shortName, enrollmentID string) error {
-	// Check if any admin exists. If not, this is a bootstrap scenario for RegisterIdentity.
+	callerFullID, newOnly, err := im.authorizeRegistrar("RegisterIdentity")
+	if err != nil {
+		return err
+	}
+	reg, err := im.prepareRegistration(targetFullID, shortName, enrollmentID, callerFullID)
+	if err != nil {
+		return err
+	}
+	if newOnly && !reg.isNew {
+		return fmt.Errorf("registrar '%s' may only register new identities; updating '%s' requires an admin", callerFullID, targetFullID)
+	}
+	return im.applyRegistration(reg, callerFullID)
+}
+
+// identityRegistrationRequest is one entry of a RegisterIdentitiesBatch call.
+type identityRegistrationRequest struct {
+	FullID       string `json:"fullId"`
+	ShortName    string `json:"shortName"`
+	EnrollmentID string `json:"enrollmentId"`
+}
+
+// RegisterIdentitiesBatch registers or updates several identities with the same authorization as
+// RegisterIdentity. Every entry is validated before anything is written, so one bad entry aborts the batch.
+// Entries may not repeat a FullID, an alias (ignoring case) or, when uniqueness is enforced, an enrollment ID.
+func (im *IdentityManager) RegisterIdentitiesBatch(requests []identityRegistrationRequest) (map[string]interface{}, error) {
+	callerFullID, newOnly, err := im.authorizeRegistrar("RegisterIdentitiesBatch")
+	if err != nil {
+		return nil, err
+	}
+	enforceUniqueEnrollment, err := getConfigBool(im.Ctx, configEnforceUniqueEnrollmentIDs, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enrollment ID uniqueness setting: %w", err)
+	}
+
+	// Each entry is checked against the ledger through the alias and enrollment indexes. Same-transaction
+	// writes are not visible to GetState, so conflicts between entries are checked here.
+	seenFullIDs := make(map[string]int)
+	seenAliases := make(map[string]int)
+	seenEnrollmentIDs := make(map[string]int)
+	regs := make([]*identityRegistration, 0, len(requests))
+	for i, req := range requests {
+		reg, errPrep := im.prepareRegistration(req.FullID, req.ShortName, req.EnrollmentID, callerFullID)
+		if errPrep != nil {
+			return nil, fmt.Errorf("identities[%d] ('%s'): %w", i, req.FullID, errPrep)
+		}
+		if newOnly && !reg.isNew {
+			return nil, fmt.Errorf("identities[%d]: registrar '%s' may only register new identities; updating '%s' requires an admin", i, callerFullID, req.FullID)
+		}
+		if j, dup := seenFullIDs[reg.idInfo.FullID]; dup {
+			return nil, fmt.Errorf("identities[%d]: identity '%s' is already registered by identities[%d]", i, reg.idInfo.FullID, j)
+		}
+		seenFullIDs[reg.idInfo.FullID] = i
+		aliasLower := strings.ToLower(reg.idInfo.ShortName)
+		if j, dup := seenAliases[aliasLower]; dup {
+			return nil, fmt.Errorf("identities[%d]: shortName (alias) '%s' is already used by identities[%d]", i, reg.idInfo.ShortName, j)
+		}
+		seenAliases[aliasLower] = i
+		if enforceUniqueEnrollment && reg.idInfo.EnrollmentID != "" {
+			if j, dup := seenEnrollmentIDs[reg.idInfo.EnrollmentID]; dup {
+				return nil, fmt.Errorf("identities[%d]: enrollmentID '%s' is already used by identities[%d]", i, reg.idInfo.EnrollmentID, j)
+			}
+			seenEnrollmentIDs[reg.idInfo.EnrollmentID] = i
+		}
+		regs = append(regs, reg)
+	}
+
+	created := []string{}
+	updated := []string{}
+	for _, reg := range regs {
+		if err := im.applyRegistration(reg, callerFullID); err != nil {
+			return nil, err
+		}
+		if reg.isNew {
+			created = append(created, reg.idInfo.FullID)
+		} else {
+			updated = append(updated, reg.idInfo.FullID)
+		}
+	}
+
+	idLogger.Infof("RegisterIdentitiesBatch by '%s': %d created, %d updated.", callerFullID, len(created), len(updated))
+	return map[string]interface{}{
+		"createdCount": len(created),
+		"updatedCount": len(updated),
+		"created":      created,
+		"updated":      updated,
+	}, nil
+}
+
+// authorizeRegistrar checks that the caller may register identities: once any admin exists, an admin or a
+// "registrar" role holder from an allowed registrar MSP; otherwise (bootstrap) any caller. It returns the
+// caller's FullID, or "SYSTEM_BOOTSTRAP" if it cannot be determined during bootstrap, and newOnly when the
+// caller is a registrar, who may only create identities, not update existing ones.
+func (im *IdentityManager) authorizeRegistrar(fnName string) (callerFullID string, newOnly bool, err error) {
+	// Check if any admin exists. If not, this is a bootstrap scenario for registration.
 	anyAdminCurrentlyExists, err := im.AnyAdminExists()
 	if err != nil {
-		return fmt.Errorf("failed to check if any admin exists during RegisterIdentity: %w", err)
+		return "", false, fmt.Errorf("failed to check if any admin exists during %s: %w", fnName, err)
 	}
 
-	callerFullID, err := im.GetCurrentIdentityFullID() // Get caller ID early for logging/use
+	callerFullID, err = im.GetCurrentIdentityFullID() // Get caller ID early for logging/use
 	if err != nil {
 		// If we can't get the caller ID, it might be a very early bootstrap or error
-		idLogger.Warningf("RegisterIdentity: Could not get current caller's FullID: %v", err)
+		idLogger.Warningf("%s: Could not get current caller's FullID: %v", fnName, err)
 		// Depending on policy, might allow if no admins exist, or deny.
 		// For now, let it proceed if no admins exist, but this is a risky state.
 		if anyAdminCurrentlyExists { // If admins exist, not knowing caller is definitely a problem.
-			return fmt.Errorf("failed to get current caller's FullID: %w", err)
+			return "", false, fmt.Errorf("failed to get current caller's FullID: %w", err)
 		}
 		callerFullID = "SYSTEM_BOOTSTRAP" // Placeholder if no admins and no caller ID
 	}
 
-	if anyAdminCurrentlyExists { // If admins DO exist, then the caller MUST be an admin
-		isCallerAdmin, errAdminCheck := im.IsCurrentUserAdmin() // This uses the resolved callerFullID
-		if errAdminCheck != nil {
-			return fmt.Errorf("failed to verify caller admin status for RegisterIdentity: %w", errAdminCheck)
-		}
-		if !isCallerAdmin {
-			return fmt.Errorf("caller '%s' is not authorized to register identities as admins already exist in the system", callerFullID)
-		}
-		idLogger.Infof("RegisterIdentity authorized: Caller '%s' is admin.", callerFullID)
-	} else {
-		idLogger.Infof("RegisterIdentity proceeding in bootstrap mode (no admins exist or caller ID not available): Caller assumed '%s'.", callerFullID)
+	if !anyAdminCurrentlyExists {
+		idLogger.Infof("%s proceeding in bootstrap mode (no admins exist or caller ID not available): Caller assumed '%s'.", fnName, callerFullID)
+		return callerFullID, false, nil
+	}
+	// If admins DO exist, then the caller MUST be an admin or a registrar
+	isCallerAdmin, errAdminCheck := im.IsCurrentUserAdmin() // This uses the resolved callerFullID
+	if errAdminCheck != nil {
+		return "", false, fmt.Errorf("failed to verify caller admin status for %s: %w", fnName, errAdminCheck)
+	}
+	if isCallerAdmin {
+		idLogger.Infof("%s authorized: Caller '%s' is admin.", fnName, callerFullID)
+		return callerFullID, false, nil
+	}
+	isRegistrar, errRole := im.HasRole(callerFullID, "registrar")
+	if errRole != nil {
+		return "", false, fmt.Errorf("failed to verify caller registrar role for %s: %w", fnName, errRole)
 	}
+	if !isRegistrar {
+		return "", false, fmt.Errorf("caller '%s' is not authorized to register identities as admins already exist in the system", callerFullID)
+	}
+	if err := im.requireAllowedRegistrarMSP(); err != nil {
+		return "", false, err
+	}
+	idLogger.Infof("%s authorized: Caller '%s' is a registrar.", fnName, callerFullID)
+	return callerFullID, true, nil
+}
 
+// identityRegistration is a validated registration whose state changes have not been written yet.
+type identityRegistration struct {
+	idInfo               model.IdentityInfo
+	isNew                bool
+	previousShortName    string
+	previousEnrollmentID string
+}
+
+// prepareRegistration validates a registration and builds the resulting IdentityInfo without writing any state.
+func (im *IdentityManager) prepareRegistration(targetFullID, shortName, enrollmentID, callerFullID string) (*identityRegistration, error) {
+	var err error
 	if !isValidX509ID(targetFullID) {
-		return fmt.Errorf("targetFullID '%s' is not a valid X.509 ID format", targetFullID)
+		return nil, fmt.Errorf("targetFullID '%s' is not a valid X.509 ID format", targetFullID)
 	}
 	if strings.TrimSpace(shortName) == "" {
-		return errors.New("shortName cannot be empty")
+		autoAlias, cfgErr := getConfigBool(im.Ctx, configAutoGenerateAliases, false)
+		if cfgErr != nil {
+			return nil, fmt.Errorf("failed to read alias auto-generation setting: %w", cfgErr)
+		}
+		if !autoAlias {
+			return nil, errors.New("shortName cannot be empty")
+		}
+		shortName, err = im.deriveAlias(targetFullID, enrollmentID)
+		if err != nil {
+			return nil, err
+		}
+		idLogger.Infof("RegisterIdentity: derived alias '%s' for identity '%s'", shortName, targetFullID)
 	}
 	// EnrollmentID can be empty, it's optional or might be derived.
 
 	now, err := im.getCurrentTxTimestamp()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Get target's MSPID from the caller's context. This assumes the admin registering
@@ -148,28 +562,52 @@ func (im *IdentityManager) RegisterIdentity(targetFullID, shortName, enrollmentI
 
 	aliasKey, err := im.createAliasCompositeKey(shortName)
 	if err != nil {
-		return fmt.Errorf("failed to create alias composite key for '%s': %w", shortName, err)
+		return nil, fmt.Errorf("failed to create alias composite key for '%s': %w", shortName, err)
 	}
 	existingFullIDForAliasBytes, err := im.Ctx.GetStub().GetState(aliasKey)
 	if err != nil {
-		return fmt.Errorf("failed to check alias availability for '%s': %w", shortName, err)
+		return nil, fmt.Errorf("failed to check alias availability for '%s': %w", shortName, err)
 	}
 	if existingFullIDForAliasBytes != nil && string(existingFullIDForAliasBytes) != targetFullID {
-		return fmt.Errorf("shortName (alias) '%s' is already in use by identity '%s'", shortName, string(existingFullIDForAliasBytes))
+		return nil, fmt.Errorf("shortName (alias) '%s' is already in use by identity '%s'", shortName, string(existingFullIDForAliasBytes))
+	}
+	caseHolder, err := im.findCaseInsensitiveAliasHolder(shortName, targetFullID)
+	if err != nil {
+		return nil, err
+	}
+	if caseHolder != "" {
+		return nil, fmt.Errorf("shortName (alias) '%s' differs only in case from an alias already used by identity '%s'", shortName, caseHolder)
+	}
+
+	enrollmentID = strings.TrimSpace(enrollmentID)
+	if enrollmentID != "" {
+		enforceUniqueEnrollment, cfgErr := getConfigBool(im.Ctx, configEnforceUniqueEnrollmentIDs, false)
+		if cfgErr != nil {
+			return nil, fmt.Errorf("failed to read enrollment ID uniqueness setting: %w", cfgErr)
+		}
+		if enforceUniqueEnrollment {
+			holder, holderErr := im.findEnrollmentIDHolder(enrollmentID, targetFullID)
+			if holderErr != nil {
+				return nil, holderErr
+			}
+			if holder != "" {
+				return nil, fmt.Errorf("enrollmentID '%s' is already in use by identity '%s'", enrollmentID, holder)
+			}
+		}
 	}
 
 	identityKey, err := im.createIdentityCompositeKey(targetFullID)
 	if err != nil {
-		return fmt.Errorf("failed to create identity composite key for '%s': %w", targetFullID, err)
+		return nil, fmt.Errorf("failed to create identity composite key for '%s': %w", targetFullID, err)
 	}
 	identityInfoBytes, err := im.Ctx.GetStub().GetState(identityKey)
 	if err != nil {
-		return fmt.Errorf("failed to get identity state for '%s': %w", targetFullID, err)
+		return nil, fmt.Errorf("failed to get identity state for '%s': %w", targetFullID, err)
 	}
 
-	var idInfo model.IdentityInfo
-	if identityInfoBytes == nil {
-		idInfo = model.IdentityInfo{
+	reg := &identityRegistration{isNew: identityInfoBytes == nil}
+	if reg.isNew {
+		reg.idInfo = model.IdentityInfo{
 			ObjectType:      identityObjectType,
 			FullID:          targetFullID,
 			ShortName:       shortName,
@@ -180,30 +618,61 @@ func (im *IdentityManager) RegisterIdentity(targetFullID, shortName, enrollmentI
 			RegisteredBy:    callerFullID, // Could be "SYSTEM_BOOTSTRAP" if no admins yet
 			RegisteredAt:    now,
 			LastUpdatedAt:   now,
+			IsActive:        true,
 		}
-		idLogger.Infof("Registering new identity: %s with alias %s, MSP %s, by %s", targetFullID, shortName, targetMSPID, idInfo.RegisteredBy)
+		idLogger.Infof("Registering new identity: %s with alias %s, MSP %s, by %s", targetFullID, shortName, targetMSPID, reg.idInfo.RegisteredBy)
 	} else {
-		if err := json.Unmarshal(identityInfoBytes, &idInfo); err != nil {
-			return fmt.Errorf("failed to unmarshal existing IdentityInfo for '%s': %w", targetFullID, err)
-		}
-		if idInfo.ShortName != shortName && idInfo.ShortName != "" {
-			oldAliasKey, keyErr := im.createAliasCompositeKey(idInfo.ShortName)
-			if keyErr == nil {
-				if errDel := im.Ctx.GetStub().DelState(oldAliasKey); errDel != nil {
-					idLogger.Warningf("Failed to delete old alias key '%s' for identity '%s': %v", oldAliasKey, targetFullID, errDel)
-				}
-			} else {
-				idLogger.Warningf("Failed to create key for old alias '%s' for deletion: %v", idInfo.ShortName, keyErr)
-			}
+		if err := json.Unmarshal(identityInfoBytes, &reg.idInfo); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal existing IdentityInfo for '%s': %w", targetFullID, err)
 		}
-		idInfo.ShortName = shortName
-		idInfo.EnrollmentID = enrollmentID   // Update enrollment ID
-		idInfo.OrganizationMSP = targetMSPID // Update MSP ID
-		idInfo.LastUpdatedAt = now
+		reg.previousShortName = reg.idInfo.ShortName
+		reg.previousEnrollmentID = reg.idInfo.EnrollmentID
+		reg.idInfo.ShortName = shortName
+		reg.idInfo.EnrollmentID = enrollmentID   // Update enrollment ID
+		reg.idInfo.OrganizationMSP = targetMSPID // Update MSP ID
+		reg.idInfo.LastUpdatedAt = now
 		// idInfo.RegisteredBy and idInfo.RegisteredAt should remain from original registration
 		idLogger.Infof("Updating existing identity: %s with new alias %s, MSP %s. Updated by %s", targetFullID, shortName, targetMSPID, callerFullID)
 	}
+	return reg, nil
+}
+
+// applyRegistration writes a prepared registration: the IdentityInfo, its alias and enrollment mappings,
+// and the removal of mappings it replaced.
+func (im *IdentityManager) applyRegistration(reg *identityRegistration, callerFullID string) error {
+	idInfo := reg.idInfo
+	targetFullID := idInfo.FullID
+	shortName := idInfo.ShortName
+	enrollmentID := idInfo.EnrollmentID
+
+	if reg.previousShortName != shortName && reg.previousShortName != "" {
+		oldAliasKey, keyErr := im.createAliasCompositeKey(reg.previousShortName)
+		if keyErr == nil {
+			if errDel := im.Ctx.GetStub().DelState(oldAliasKey); errDel != nil {
+				idLogger.Warningf("Failed to delete old alias key '%s' for identity '%s': %v", oldAliasKey, targetFullID, errDel)
+			}
+		} else {
+			idLogger.Warningf("Failed to create key for old alias '%s' for deletion: %v", reg.previousShortName, keyErr)
+		}
+	}
+	if !strings.EqualFold(reg.previousShortName, shortName) && reg.previousShortName != "" {
+		if oldNormKey, keyErr := im.createNormalizedAliasCompositeKey(reg.previousShortName); keyErr == nil {
+			if errDel := im.Ctx.GetStub().DelState(oldNormKey); errDel != nil {
+				idLogger.Warningf("Failed to delete old normalized alias for '%s' on identity '%s': %v", reg.previousShortName, targetFullID, errDel)
+			}
+		}
+	}
+
+	if callerFullID == targetFullID {
+		if err := im.bindCallerCertFingerprint(&idInfo); err != nil {
+			return err
+		}
+	}
 
+	identityKey, err := im.createIdentityCompositeKey(targetFullID)
+	if err != nil {
+		return fmt.Errorf("failed to create identity composite key for '%s': %w", targetFullID, err)
+	}
 	updatedIdentityInfoBytes, err := json.Marshal(idInfo)
 	if err != nil {
 		return fmt.Errorf("failed to marshal IdentityInfo for '%s': %w", targetFullID, err)
@@ -212,10 +681,163 @@ func (im *IdentityManager) RegisterIdentity(targetFullID, shortName, enrollmentI
 		return fmt.Errorf("failed to save IdentityInfo for '%s': %w", targetFullID, err)
 	}
 
+	aliasKey, err := im.createAliasCompositeKey(shortName)
+	if err != nil {
+		return fmt.Errorf("failed to create alias composite key for '%s': %w", shortName, err)
+	}
 	if err := im.Ctx.GetStub().PutState(aliasKey, []byte(targetFullID)); err != nil {
 		return fmt.Errorf("failed to save alias mapping for '%s' -> '%s' (IdentityInfo saved, but alias mapping failed): %w", shortName, targetFullID, err)
 	}
+	normAliasKey, err := im.createNormalizedAliasCompositeKey(shortName)
+	if err != nil {
+		return fmt.Errorf("failed to create normalized alias key for '%s': %w", shortName, err)
+	}
+	if err := im.Ctx.GetStub().PutState(normAliasKey, []byte(targetFullID)); err != nil {
+		return fmt.Errorf("failed to save normalized alias mapping for '%s' -> '%s': %w", shortName, targetFullID, err)
+	}
+
+	if reg.previousEnrollmentID != "" && reg.previousEnrollmentID != enrollmentID {
+		if oldEnrollmentKey, keyErr := im.createEnrollmentCompositeKey(reg.previousEnrollmentID); keyErr == nil {
+			if holderBytes, _ := im.Ctx.GetStub().GetState(oldEnrollmentKey); string(holderBytes) == targetFullID {
+				if errDel := im.Ctx.GetStub().DelState(oldEnrollmentKey); errDel != nil {
+					idLogger.Warningf("Failed to delete old enrollment ID mapping '%s' for identity '%s': %v", reg.previousEnrollmentID, targetFullID, errDel)
+				}
+			}
+		}
+	}
+	if enrollmentID != "" {
+		enrollmentKey, keyErr := im.createEnrollmentCompositeKey(enrollmentID)
+		if keyErr != nil {
+			return fmt.Errorf("failed to create enrollment composite key for '%s': %w", enrollmentID, keyErr)
+		}
+		if err := im.Ctx.GetStub().PutState(enrollmentKey, []byte(targetFullID)); err != nil {
+			return fmt.Errorf("failed to save enrollment ID mapping for '%s' -> '%s': %w", enrollmentID, targetFullID, err)
+		}
+	}
+
+	return nil
+}
+
+// RenameAlias changes an identity's shortName (admin only). The old alias stops resolving; records that
+// store the FullID are unaffected. Renaming to the current name is a no-op. Emits AliasRenamed.
+func (im *IdentityManager) RenameAlias(identityOrAlias, newShortName string) error {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller's FullID for RenameAlias: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return fmt.Errorf("failed to verify caller '%s' admin status for RenameAlias: %w", callerFullID, err)
+	}
+	if !isCallerAdmin {
+		return fmt.Errorf("caller '%s' is not authorized to rename aliases", callerFullID)
+	}
+
+	newShortName = strings.TrimSpace(newShortName)
+	if newShortName == "" {
+		return errors.New("newShortName cannot be empty")
+	}
+	if len(newShortName) > maxStringInputLength {
+		return fmt.Errorf("newShortName exceeds maximum length of %d characters", maxStringInputLength)
+	}
+	targetFullID, err := im.ResolveIdentity(identityOrAlias)
+	if err != nil {
+		return err
+	}
+	idInfo, err := im.getIdentityInfoByFullID(targetFullID)
+	if err != nil {
+		return err
+	}
+	oldShortName := idInfo.ShortName
+	if oldShortName == newShortName {
+		idLogger.Infof("RenameAlias: identity '%s' already uses alias '%s'. No changes made.", targetFullID, newShortName)
+		return nil
+	}
+
+	newAliasKey, err := im.createAliasCompositeKey(newShortName)
+	if err != nil {
+		return fmt.Errorf("failed to create alias composite key for '%s': %w", newShortName, err)
+	}
+	holderBytes, err := im.Ctx.GetStub().GetState(newAliasKey)
+	if err != nil {
+		return fmt.Errorf("failed to check alias availability for '%s': %w", newShortName, err)
+	}
+	if holderBytes != nil && string(holderBytes) != targetFullID {
+		return fmt.Errorf("shortName (alias) '%s' is already in use by identity '%s'", newShortName, string(holderBytes))
+	}
+	caseHolder, err := im.findCaseInsensitiveAliasHolder(newShortName, targetFullID)
+	if err != nil {
+		return err
+	}
+	if caseHolder != "" {
+		return fmt.Errorf("shortName (alias) '%s' differs only in case from an alias already used by identity '%s'", newShortName, caseHolder)
+	}
+
+	if oldShortName != "" {
+		oldAliasKey, keyErr := im.createAliasCompositeKey(oldShortName)
+		if keyErr != nil {
+			return fmt.Errorf("failed to create alias composite key for '%s': %w", oldShortName, keyErr)
+		}
+		if oldHolder, _ := im.Ctx.GetStub().GetState(oldAliasKey); string(oldHolder) == targetFullID {
+			if errDel := im.Ctx.GetStub().DelState(oldAliasKey); errDel != nil {
+				return fmt.Errorf("failed to delete old alias '%s' for identity '%s': %w", oldShortName, targetFullID, errDel)
+			}
+		}
+		if !strings.EqualFold(oldShortName, newShortName) {
+			oldNormKey, keyErr := im.createNormalizedAliasCompositeKey(oldShortName)
+			if keyErr != nil {
+				return fmt.Errorf("failed to create normalized alias key for '%s': %w", oldShortName, keyErr)
+			}
+			if oldHolder, _ := im.Ctx.GetStub().GetState(oldNormKey); string(oldHolder) == targetFullID {
+				if errDel := im.Ctx.GetStub().DelState(oldNormKey); errDel != nil {
+					return fmt.Errorf("failed to delete old normalized alias '%s' for identity '%s': %w", oldShortName, targetFullID, errDel)
+				}
+			}
+		}
+	}
+	if err := im.Ctx.GetStub().PutState(newAliasKey, []byte(targetFullID)); err != nil {
+		return fmt.Errorf("failed to save alias mapping for '%s' -> '%s': %w", newShortName, targetFullID, err)
+	}
+	newNormKey, err := im.createNormalizedAliasCompositeKey(newShortName)
+	if err != nil {
+		return fmt.Errorf("failed to create normalized alias key for '%s': %w", newShortName, err)
+	}
+	if err := im.Ctx.GetStub().PutState(newNormKey, []byte(targetFullID)); err != nil {
+		return fmt.Errorf("failed to save normalized alias mapping for '%s' -> '%s': %w", newShortName, targetFullID, err)
+	}
+
+	now, err := im.getCurrentTxTimestamp()
+	if err != nil {
+		return err
+	}
+	idInfo.ShortName = newShortName
+	idInfo.LastUpdatedAt = now
+	identityKey, err := im.createIdentityCompositeKey(targetFullID)
+	if err != nil {
+		return fmt.Errorf("failed to create identity composite key for '%s': %w", targetFullID, err)
+	}
+	idInfoBytes, err := json.Marshal(idInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal IdentityInfo for '%s': %w", targetFullID, err)
+	}
+	if err := im.Ctx.GetStub().PutState(identityKey, idInfoBytes); err != nil {
+		return fmt.Errorf("failed to save IdentityInfo for '%s': %w", targetFullID, err)
+	}
 
+	payload, err := json.Marshal(map[string]interface{}{
+		"fullId":       targetFullID,
+		"oldShortName": oldShortName,
+		"newShortName": newShortName,
+		"renamedBy":    callerFullID,
+		"txId":         im.Ctx.GetStub().GetTxID(),
+		"timestamp":    now.Format(time.RFC3339),
+	})
+	if err != nil {
+		idLogger.Warningf("RenameAlias: failed to marshal AliasRenamed payload: %v", err)
+	} else if errSet := im.Ctx.GetStub().SetEvent("AliasRenamed", payload); errSet != nil {
+		idLogger.Warningf("RenameAlias: failed to set AliasRenamed event: %v", errSet)
+	}
+	idLogger.Infof("Alias for identity '%s' renamed from '%s' to '%s' by admin '%s'", targetFullID, oldShortName, newShortName, callerFullID)
 	return nil
 }
 
@@ -244,6 +866,19 @@ func (im *IdentityManager) ResolveIdentity(identityOrAlias string) (string, erro
 		return string(fullIDBytes), nil
 	}
 
+	// Fall back to case-insensitive alias lookup
+	normKey, err := im.createNormalizedAliasCompositeKey(trimmedInput)
+	if err != nil {
+		return "", fmt.Errorf("failed to create normalized alias key for resolving '%s': %w", trimmedInput, err)
+	}
+	fullIDBytes, err = im.Ctx.GetStub().GetState(normKey)
+	if err != nil {
+		return "", fmt.Errorf("ledger error when querying normalized alias '%s': %w", trimmedInput, err)
+	}
+	if fullIDBytes != nil {
+		return string(fullIDBytes), nil
+	}
+
 	// For test scenarios, if alias not found, log but still return error
 	idLogger.Debugf("Alias '%s' not found in ledger. In test scenarios, this might be expected.", trimmedInput)
 	return "", fmt.Errorf("alias '%s' not found", trimmedInput)
@@ -320,42 +955,195 @@ func (im *IdentityManager) AssignRole(targetIdentityOrAlias, role string) error
 		return fmt.Errorf("failed to resolve target identity '%s' for AssignRole: %w", targetIdentityOrAlias, err)
 	}
 
+	if _, err := im.assignRoleToFullID(targetFullID, roleLower, callerFullID, false); err != nil {
+		return fmt.Errorf("cannot assign role to '%s': %w", targetIdentityOrAlias, err)
+	}
+	return nil
+}
+
+// assignRoleToFullID adds an already-validated role to a registered identity.
+// It reports false without writing if the identity already holds the role. With dryRun set it
+// reports what would happen but never writes.
+func (im *IdentityManager) assignRoleToFullID(targetFullID, roleLower, callerFullID string, dryRun bool) (bool, error) {
+	added, _, err := im.assignRolesToFullID(targetFullID, []string{roleLower}, callerFullID, dryRun)
+	return len(added) > 0, err
+}
+
+// assignRolesToFullID adds already-validated, distinct roles to a registered identity in one IdentityInfo
+// write, since writes in the same transaction are not visible to later reads. It returns the roles newly
+// added, skipping those already held, and the resulting IdentityInfo. With dryRun set it never writes.
+func (im *IdentityManager) assignRolesToFullID(targetFullID string, rolesLower []string, callerFullID string, dryRun bool) ([]string, *model.IdentityInfo, error) {
 	idInfo, err := im.getIdentityInfoByFullID(targetFullID)
 	if err != nil {
-		return fmt.Errorf("cannot assign role: target identity '%s' (resolved to '%s') must be registered first: %w", targetIdentityOrAlias, targetFullID, err)
+		return nil, nil, fmt.Errorf("target identity '%s' must be registered first: %w", targetFullID, err)
 	}
 
+	held := make(map[string]bool, len(idInfo.Roles))
 	for _, existingRole := range idInfo.Roles {
-		if existingRole == roleLower {
+		held[existingRole] = true
+	}
+	added := []string{}
+	for _, roleLower := range rolesLower {
+		if held[roleLower] {
 			idLogger.Infof("Role '%s' already assigned to identity '%s' (%s). No action needed.", roleLower, idInfo.ShortName, targetFullID)
-			return nil
+			continue
+		}
+		added = append(added, roleLower)
+	}
+	if dryRun || len(added) == 0 {
+		return added, idInfo, nil
+	}
+
+	now, err := im.getCurrentTxTimestamp()
+	if err != nil {
+		return nil, nil, err
+	}
+	idInfo.Roles = append(idInfo.Roles, added...)
+	idInfo.LastUpdatedAt = now
+
+	updatedBytes, err := json.Marshal(idInfo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal IdentityInfo for role assignment: %w", err)
+	}
+	identityKey, err := im.createIdentityCompositeKey(targetFullID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create identity key for role assignment: %w", err)
+	}
+
+	if err := im.Ctx.GetStub().PutState(identityKey, updatedBytes); err != nil {
+		return nil, nil, fmt.Errorf("failed to save IdentityInfo after role assignment for '%s': %w", targetFullID, err)
+	}
+	for _, roleLower := range added {
+		idLogger.Infof("Role '%s' successfully assigned to identity '%s' (%s) by admin '%s'.", roleLower, idInfo.ShortName, targetFullID, callerFullID)
+	}
+	return added, idInfo, nil
+}
+
+// AssignRoleToIdentities assigns one role to several identities and reports each outcome as
+// "assigned", "alreadyPresent" or "failed" (with a reason). A failure for one identity does not
+// stop the others. With dryRun set the same summary is returned without writing any state.
+func (im *IdentityManager) AssignRoleToIdentities(targetIdentitiesOrAliases []string, role string, dryRun bool) (map[string]interface{}, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for AssignRoleToIdentities: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller admin status for AssignRoleToIdentities: %w", err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to assign roles", callerFullID)
+	}
+
+	roleLower := strings.ToLower(strings.TrimSpace(role))
+	if !ValidRoles[roleLower] {
+		return nil, fmt.Errorf("invalid role: '%s'. Valid roles are: %v", role, im.getListOfValidRoles())
+	}
+
+	assigned := []string{}
+	alreadyPresent := []string{}
+	failed := []map[string]string{}
+	processed := make(map[string]bool) // Same-transaction writes are not visible to GetState, so skip repeats.
+
+	for _, target := range targetIdentitiesOrAliases {
+		targetFullID, errResolve := im.ResolveIdentity(target)
+		if errResolve != nil {
+			failed = append(failed, map[string]string{"identity": target, "reason": errResolve.Error()})
+			continue
+		}
+		if processed[targetFullID] {
+			alreadyPresent = append(alreadyPresent, target)
+			continue
+		}
+		processed[targetFullID] = true
+
+		wasAssigned, errAssign := im.assignRoleToFullID(targetFullID, roleLower, callerFullID, dryRun)
+		switch {
+		case errAssign != nil:
+			failed = append(failed, map[string]string{"identity": target, "reason": errAssign.Error()})
+		case wasAssigned:
+			assigned = append(assigned, target)
+		default:
+			alreadyPresent = append(alreadyPresent, target)
+		}
+	}
+
+	idLogger.Infof("AssignRoleToIdentities: role '%s' by admin '%s' (dryRun: %t): %d assigned, %d already present, %d failed.", roleLower, callerFullID, dryRun, len(assigned), len(alreadyPresent), len(failed))
+	return map[string]interface{}{
+		"role":           roleLower,
+		"dryRun":         dryRun,
+		"assigned":       assigned,
+		"alreadyPresent": alreadyPresent,
+		"failed":         failed,
+	}, nil
+}
+
+// AssignRolesBatch adds several roles to one identity in a single IdentityInfo update (admin only).
+// Every role must be valid or nothing is written. Returns the roles newly added; roles the identity
+// already holds are skipped. Emits one RolesAssigned event when anything was added.
+func (im *IdentityManager) AssignRolesBatch(targetIdentityOrAlias string, roles []string) ([]string, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for AssignRolesBatch: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller admin status for AssignRolesBatch: %w", err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to assign roles", callerFullID)
+	}
+
+	requested := make([]string, 0, len(roles))
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		roleLower := strings.ToLower(strings.TrimSpace(role))
+		if !ValidRoles[roleLower] {
+			return nil, fmt.Errorf("invalid role: '%s'. Valid roles are: %v", role, im.getListOfValidRoles())
+		}
+		if !seen[roleLower] {
+			seen[roleLower] = true
+			requested = append(requested, roleLower)
 		}
 	}
 
-	now, err := im.getCurrentTxTimestamp()
+	targetFullID, err := im.ResolveIdentity(targetIdentityOrAlias)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to resolve target identity '%s' for AssignRolesBatch: %w", targetIdentityOrAlias, err)
 	}
-	idInfo.Roles = append(idInfo.Roles, roleLower)
-	idInfo.LastUpdatedAt = now
-
-	updatedBytes, err := json.Marshal(idInfo)
+	added, idInfo, err := im.assignRolesToFullID(targetFullID, requested, callerFullID, false)
 	if err != nil {
-		return fmt.Errorf("failed to marshal IdentityInfo for role assignment: %w", err)
+		return nil, err
 	}
-	identityKey, err := im.createIdentityCompositeKey(targetFullID)
-	if err != nil {
-		return fmt.Errorf("failed to create identity key for role assignment: %w", err)
+	if len(added) == 0 {
+		idLogger.Infof("AssignRolesBatch: identity '%s' (%s) already holds all requested roles. No action needed.", idInfo.ShortName, targetFullID)
+		return added, nil
 	}
 
-	if err := im.Ctx.GetStub().PutState(identityKey, updatedBytes); err != nil {
-		return fmt.Errorf("failed to save IdentityInfo after role assignment for '%s': %w", targetFullID, err)
+	payload, err := json.Marshal(map[string]interface{}{
+		"fullId":     targetFullID,
+		"shortName":  idInfo.ShortName,
+		"roles":      added,
+		"assignedBy": callerFullID,
+		"txId":       im.Ctx.GetStub().GetTxID(),
+		"timestamp":  idInfo.LastUpdatedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		idLogger.Warningf("AssignRolesBatch: failed to marshal RolesAssigned payload: %v", err)
+	} else if errSet := im.Ctx.GetStub().SetEvent("RolesAssigned", payload); errSet != nil {
+		idLogger.Warningf("AssignRolesBatch: failed to set RolesAssigned event: %v", errSet)
 	}
-	idLogger.Infof("Role '%s' successfully assigned to identity '%s' (%s) by admin '%s'.", roleLower, idInfo.ShortName, targetFullID, callerFullID)
-	return nil
+
+	return added, nil
 }
 
 func (im *IdentityManager) RemoveRole(targetIdentityOrAlias, role string) error {
+	return im.RemoveRoleWithForce(targetIdentityOrAlias, role, false)
+}
+
+// RemoveRoleWithForce removes a role. Removing "certifier" from an identity that is the designated
+// certifier of shipments awaiting certification is refused unless force is true.
+func (im *IdentityManager) RemoveRoleWithForce(targetIdentityOrAlias, role string, force bool) error {
 	callerFullID, err := im.GetCurrentIdentityFullID()
 	if err != nil {
 		return fmt.Errorf("failed to get caller's FullID for RemoveRole: %w", err)
@@ -396,6 +1184,12 @@ func (im *IdentityManager) RemoveRole(targetIdentityOrAlias, role string) error
 		return nil
 	}
 
+	if roleLower == "certifier" {
+		if err := im.checkPendingCertifierDesignations(targetFullID, force); err != nil {
+			return fmt.Errorf("cannot remove role 'certifier' from '%s': %w", targetIdentityOrAlias, err)
+		}
+	}
+
 	now, err := im.getCurrentTxTimestamp()
 	if err != nil {
 		return err
@@ -427,6 +1221,9 @@ func (im *IdentityManager) HasRole(identityOrAlias, role string) (bool, error) {
 		}
 		return false, fmt.Errorf("error resolving identity '%s' to check role: %w", identityOrAlias, err)
 	}
+	if !idInfo.IsActive { // Deactivated identities hold no effective roles.
+		return false, nil
+	}
 	roleLower := strings.ToLower(strings.TrimSpace(role))
 	for _, r := range idInfo.Roles {
 		if r == roleLower {
@@ -655,6 +1452,12 @@ func (im *IdentityManager) IsAdmin(identityOrAlias string) (bool, error) {
 	}
 
 	isAdminByFlag := flagBytes != nil && string(flagBytes) == "true"
+	if isAdminByFlag {
+		// Deactivated identities hold no effective admin rights, as with roles in HasRole.
+		if idInfo, errInfo := im.getIdentityInfoByFullID(fullID); errInfo == nil && !idInfo.IsActive {
+			return false, nil
+		}
+	}
 
 	// Optional: Cross-check with IdentityInfo for consistency, log if different.
 	// idInfo, _ := im.getIdentityInfoByFullID(fullID)
@@ -802,13 +1605,404 @@ func (im *IdentityManager) GetAllRegisteredIdentities() ([]model.IdentityInfo, e
 		identities = append(identities, idInfo)
 	}
 	idLogger.Infof("Admin '%s' retrieved %d registered identities.", callerFullID, len(identities))
+	im.emitAdminDataAccessed(callerFullID, "GetAllRegisteredIdentities", len(identities))
+	return identities, nil // Will be [] if empty, not null
+}
+
+// GetIdentitiesRegisteredBy returns all identities whose RegisteredBy matches the resolved registrar.
+// The literal "SYSTEM_BOOTSTRAP" is accepted to list identities registered before any admin existed.
+func (im *IdentityManager) GetIdentitiesRegisteredBy(registrarIdentityOrAlias string) ([]model.IdentityInfo, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for GetIdentitiesRegisteredBy: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller '%s' admin status for GetIdentitiesRegisteredBy: %w", callerFullID, err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to list identities by registrar", callerFullID)
+	}
+
+	registrarFullID := strings.TrimSpace(registrarIdentityOrAlias)
+	if registrarFullID != "SYSTEM_BOOTSTRAP" {
+		registrarFullID, err = im.ResolveIdentity(registrarIdentityOrAlias)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve registrar '%s' for GetIdentitiesRegisteredBy: %w", registrarIdentityOrAlias, err)
+		}
+	}
+
+	resultsIterator, err := im.Ctx.GetStub().GetStateByPartialCompositeKey(identityObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities iterator using objectType '%s': %w", identityObjectType, err)
+	}
+	defer resultsIterator.Close()
+
+	identities := []model.IdentityInfo{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			idLogger.Warningf("Failed to get next identity from iterator during GetIdentitiesRegisteredBy: %v. Skipping.", iterErr)
+			continue
+		}
+		var idInfo model.IdentityInfo
+		if err := json.Unmarshal(queryResponse.Value, &idInfo); err != nil {
+			idLogger.Warningf("Failed to unmarshal identity data for key '%s': %v. Skipping.", queryResponse.Key, err)
+			continue
+		}
+		if idInfo.RegisteredBy != registrarFullID {
+			continue
+		}
+		ensureIdentityInfoSchemaCompliance(&idInfo)
+		identities = append(identities, idInfo)
+	}
+	idLogger.Infof("Admin '%s' retrieved %d identities registered by '%s'.", callerFullID, len(identities), registrarFullID)
+	im.emitAdminDataAccessed(callerFullID, "GetIdentitiesRegisteredBy", len(identities))
+	return identities, nil // Will be [] if empty, not null
+}
+
+// DeactivateIdentity suspends an identity: it keeps its record and alias but no longer passes role checks.
+func (im *IdentityManager) DeactivateIdentity(targetIdentityOrAlias string) error {
+	return im.DeactivateIdentityWithForce(targetIdentityOrAlias, false)
+}
+
+// DeactivateIdentityWithForce deactivates an identity. Deactivating the designated certifier of shipments
+// awaiting certification is refused unless force is true.
+func (im *IdentityManager) DeactivateIdentityWithForce(targetIdentityOrAlias string, force bool) error {
+	return im.setIdentityActive(targetIdentityOrAlias, false, force)
+}
+
+// ReactivateIdentity restores a previously deactivated identity.
+func (im *IdentityManager) ReactivateIdentity(targetIdentityOrAlias string) error {
+	return im.setIdentityActive(targetIdentityOrAlias, true, false)
+}
+
+func (im *IdentityManager) setIdentityActive(targetIdentityOrAlias string, active bool, force bool) error {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller's FullID for identity activation change: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return fmt.Errorf("failed to verify caller admin status for identity activation change: %w", err)
+	}
+	if !isCallerAdmin {
+		return fmt.Errorf("caller '%s' is not authorized to change identity activation", callerFullID)
+	}
+
+	targetFullID, err := im.ResolveIdentity(targetIdentityOrAlias)
+	if err != nil {
+		return fmt.Errorf("failed to resolve target identity '%s': %w", targetIdentityOrAlias, err)
+	}
+	if !active && targetFullID == callerFullID {
+		return errors.New("admins cannot deactivate their own identity")
+	}
+
+	idInfo, err := im.getIdentityInfoByFullID(targetFullID)
+	if err != nil {
+		return fmt.Errorf("cannot change activation: target identity '%s' (resolved to '%s') not found: %w", targetIdentityOrAlias, targetFullID, err)
+	}
+	if idInfo.IsActive == active {
+		idLogger.Infof("Identity '%s' (%s) already has isActive=%t. No action taken.", idInfo.ShortName, targetFullID, active)
+		return nil
+	}
+	if !active {
+		isCertifier, errRole := im.HasRole(targetFullID, "certifier")
+		if errRole != nil {
+			return fmt.Errorf("cannot deactivate '%s': failed to check certifier role: %w", targetIdentityOrAlias, errRole)
+		}
+		// Only certifiers can be designated, so other identities need no shipment lookup.
+		if isCertifier {
+			if err := im.checkPendingCertifierDesignations(targetFullID, force); err != nil {
+				return fmt.Errorf("cannot deactivate '%s': %w", targetIdentityOrAlias, err)
+			}
+		}
+	}
+
+	now, err := im.getCurrentTxTimestamp()
+	if err != nil {
+		return err
+	}
+	idInfo.IsActive = active
+	idInfo.LastUpdatedAt = now
+
+	updatedBytes, err := json.Marshal(idInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal IdentityInfo for activation change: %w", err)
+	}
+	identityKey, err := im.createIdentityCompositeKey(targetFullID)
+	if err != nil {
+		return fmt.Errorf("failed to create identity key for activation change: %w", err)
+	}
+	if err := im.Ctx.GetStub().PutState(identityKey, updatedBytes); err != nil {
+		return fmt.Errorf("failed to save IdentityInfo after activation change for '%s': %w", targetFullID, err)
+	}
+	idLogger.Infof("Identity '%s' (%s) set to isActive=%t by admin '%s'.", idInfo.ShortName, targetFullID, active, callerFullID)
+	return nil
+}
+
+// pendingCertifierDesignations lists shipments awaiting certification (CREATED or PENDING_CERTIFICATION,
+// not archived) whose designated certifier is certifierFullID. At most maxArrayElements IDs are returned.
+// Uses the designated-certifier CouchDB index, scanning all shipments only when rich queries are unavailable.
+func (im *IdentityManager) pendingCertifierDesignations(certifierFullID string) ([]string, error) {
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType":            shipmentObjectType,
+			"designatedCertifierId": certifierFullID,
+			"isArchived":            false,
+			"status":                map[string]interface{}{"$in": []model.ShipmentStatus{model.StatusCreated, model.StatusPendingCertification}},
+		},
+		"use_index": "_design/indexObjectTypeDesignatedCertifierIsArchivedDoc",
+		"limit":     maxArrayElements,
+	}
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal designation query: %w", err)
+	}
+	resultsIterator, err := im.Ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		idLogger.Warningf("pendingCertifierDesignations: CouchDB query failed: %v. Falling back to a key scan of all shipments (SLOW).", err)
+		resultsIterator, err = im.Ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get shipments iterator for designation check: %w", err)
+		}
+	}
+	defer resultsIterator.Close()
+
+	shipmentIDs := []string{}
+	for resultsIterator.HasNext() && len(shipmentIDs) < maxArrayElements {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			continue
+		}
+		var ship model.Shipment
+		if err := json.Unmarshal(queryResponse.Value, &ship); err != nil {
+			continue
+		}
+		if ship.DesignatedCertifierID != certifierFullID || ship.IsArchived {
+			continue
+		}
+		if ship.Status == model.StatusCreated || ship.Status == model.StatusPendingCertification {
+			shipmentIDs = append(shipmentIDs, ship.ID)
+		}
+	}
+	return shipmentIDs, nil
+}
+
+// checkPendingCertifierDesignations refuses (or, with force, only warns about) removing certifying
+// ability from an identity still designated on shipments awaiting certification.
+func (im *IdentityManager) checkPendingCertifierDesignations(certifierFullID string, force bool) error {
+	pending, err := im.pendingCertifierDesignations(certifierFullID)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("identity is the designated certifier of %d shipment(s) awaiting certification %v; redesignate them or retry with force", len(pending), pending)
+	}
+	idLogger.Warningf("Forced change for certifier '%s' leaves %d shipment(s) awaiting certification with this designation: %v", certifierFullID, len(pending), pending)
+	return nil
+}
+
+// GetIdentityByFingerprint returns the identity whose certificate has the given SHA-256 fingerprint
+// (hex, with or without ':' separators). Admins may look up any identity; others only themselves.
+func (im *IdentityManager) GetIdentityByFingerprint(fingerprint string) (*model.IdentityInfo, error) {
+	if normalizeCertFingerprint(fingerprint) == "" {
+		return nil, errors.New("fingerprint cannot be empty")
+	}
+	fingerprintKey, err := im.createFingerprintCompositeKey(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fingerprint key: %w", err)
+	}
+	fullIDBytes, err := im.Ctx.GetStub().GetState(fingerprintKey)
+	if err != nil {
+		return nil, fmt.Errorf("ledger error looking up fingerprint '%s': %w", fingerprint, err)
+	}
+	if fullIDBytes == nil {
+		return nil, fmt.Errorf("no identity registered with certificate fingerprint '%s'", fingerprint)
+	}
+	fullID := string(fullIDBytes)
+
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for GetIdentityByFingerprint: %w", err)
+	}
+	if callerFullID != fullID {
+		isCallerAdmin, errAdmin := im.IsAdmin(callerFullID)
+		if errAdmin != nil {
+			return nil, fmt.Errorf("failed to verify caller '%s' admin status for GetIdentityByFingerprint: %w", callerFullID, errAdmin)
+		}
+		if !isCallerAdmin {
+			return nil, fmt.Errorf("caller '%s' is not authorized to look up other identities by fingerprint", callerFullID)
+		}
+	}
+	return im.getIdentityInfoByFullID(fullID)
+}
+
+// GetInactiveIdentities returns all identities that have been deactivated.
+func (im *IdentityManager) GetInactiveIdentities() ([]model.IdentityInfo, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for GetInactiveIdentities: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller '%s' admin status for GetInactiveIdentities: %w", callerFullID, err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to list inactive identities", callerFullID)
+	}
+
+	resultsIterator, err := im.Ctx.GetStub().GetStateByPartialCompositeKey(identityObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities iterator using objectType '%s': %w", identityObjectType, err)
+	}
+	defer resultsIterator.Close()
+
+	identities := []model.IdentityInfo{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			idLogger.Warningf("Failed to get next identity from iterator during GetInactiveIdentities: %v. Skipping.", iterErr)
+			continue
+		}
+		var idInfo model.IdentityInfo
+		if err := json.Unmarshal(queryResponse.Value, &idInfo); err != nil {
+			idLogger.Warningf("Failed to unmarshal identity data for key '%s': %v. Skipping.", queryResponse.Key, err)
+			continue
+		}
+		if idInfo.IsActive {
+			continue
+		}
+		ensureIdentityInfoSchemaCompliance(&idInfo)
+		identities = append(identities, idInfo)
+	}
+	idLogger.Infof("Admin '%s' retrieved %d inactive identities.", callerFullID, len(identities))
+	im.emitAdminDataAccessed(callerFullID, "GetInactiveIdentities", len(identities))
+	return identities, nil // Will be [] if empty, not null
+}
+
+// GetIdentitiesByMSP returns all identities whose OrganizationMSP matches mspID (case-insensitive).
+func (im *IdentityManager) GetIdentitiesByMSP(mspID string) ([]model.IdentityInfo, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for GetIdentitiesByMSP: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller '%s' admin status for GetIdentitiesByMSP: %w", callerFullID, err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to list identities by MSP", callerFullID)
+	}
+	mspID = strings.TrimSpace(mspID)
+	if mspID == "" {
+		return nil, errors.New("mspID cannot be empty")
+	}
+
+	resultsIterator, err := im.Ctx.GetStub().GetStateByPartialCompositeKey(identityObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities iterator using objectType '%s': %w", identityObjectType, err)
+	}
+	defer resultsIterator.Close()
+
+	identities := []model.IdentityInfo{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			idLogger.Warningf("Failed to get next identity from iterator during GetIdentitiesByMSP: %v. Skipping.", iterErr)
+			continue
+		}
+		var idInfo model.IdentityInfo
+		if err := json.Unmarshal(queryResponse.Value, &idInfo); err != nil {
+			idLogger.Warningf("Failed to unmarshal identity data for key '%s': %v. Skipping.", queryResponse.Key, err)
+			continue
+		}
+		if !strings.EqualFold(idInfo.OrganizationMSP, mspID) {
+			continue
+		}
+		ensureIdentityInfoSchemaCompliance(&idInfo)
+		identities = append(identities, idInfo)
+	}
+	idLogger.Infof("Admin '%s' retrieved %d identities for MSP '%s'.", callerFullID, len(identities), mspID)
+	im.emitAdminDataAccessed(callerFullID, "GetIdentitiesByMSP", len(identities))
 	return identities, nil // Will be [] if empty, not null
 }
 
+// GetIdentityCountsByMSP returns the number of registered identities per OrganizationMSP.
+func (im *IdentityManager) GetIdentityCountsByMSP() (map[string]int, error) {
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller's FullID for GetIdentityCountsByMSP: %w", err)
+	}
+	isCallerAdmin, err := im.IsAdmin(callerFullID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify caller '%s' admin status for GetIdentityCountsByMSP: %w", callerFullID, err)
+	}
+	if !isCallerAdmin {
+		return nil, fmt.Errorf("caller '%s' is not authorized to count identities by MSP", callerFullID)
+	}
+
+	resultsIterator, err := im.Ctx.GetStub().GetStateByPartialCompositeKey(identityObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities iterator using objectType '%s': %w", identityObjectType, err)
+	}
+	defer resultsIterator.Close()
+
+	counts := map[string]int{}
+	total := 0
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			idLogger.Warningf("Failed to get next identity from iterator during GetIdentityCountsByMSP: %v. Skipping.", iterErr)
+			continue
+		}
+		var idInfo model.IdentityInfo
+		if err := json.Unmarshal(queryResponse.Value, &idInfo); err != nil {
+			idLogger.Warningf("Failed to unmarshal identity data for key '%s': %v. Skipping.", queryResponse.Key, err)
+			continue
+		}
+		counts[idInfo.OrganizationMSP]++
+		total++
+	}
+	im.emitAdminDataAccessed(callerFullID, "GetIdentityCountsByMSP", total)
+	return counts, nil
+}
+
+// requireTestFunctionsEnabled rejects calls to test-only helpers unless an admin has enabled them with
+// SetTestFunctionsEnabled. While the setting is unset they stay available until the first admin exists,
+// so bootstrap scripts keep working.
+func (im *IdentityManager) requireTestFunctionsEnabled(functionName string) error {
+	var enabled bool
+	found, err := loadConfigValue(im.Ctx, configTestFunctionsEnabled, &enabled)
+	if err != nil {
+		return fmt.Errorf("%s: failed to read test function setting: %w", functionName, err)
+	}
+	if !found {
+		anyAdminExists, errAdmin := im.AnyAdminExists()
+		if errAdmin != nil {
+			return fmt.Errorf("%s: failed to check admin existence: %w", functionName, errAdmin)
+		}
+		enabled = !anyAdminExists
+	}
+	if !enabled {
+		return fmt.Errorf("%s is a test function and test functions are disabled on this ledger", functionName)
+	}
+	return nil
+}
+
 // AssignRoleUncheckedForTest is a test-only function to assign a role without admin checks.
 // THIS SHOULD NOT BE USED IN PRODUCTION. IT'S ADDED TO SUPPORT THE REFACTORED TestAssignRoleToSelf.
 func (im *IdentityManager) AssignRoleUncheckedForTest(targetIdentityOrAlias, role string) error {
 	idLogger.Warningf("TESTING FUNCTION AssignRoleUncheckedForTest called for role '%s' on '%s'. THIS IS NOT FOR PRODUCTION.", role, targetIdentityOrAlias)
+	if err := im.requireTestFunctionsEnabled("AssignRoleUncheckedForTest"); err != nil {
+		return err
+	}
 	roleLower := strings.ToLower(strings.TrimSpace(role))
 	if !ValidRoles[roleLower] { // Check against ValidRoles even for test
 		return fmt.Errorf("invalid role for test: '%s'. Valid roles are: %v", role, im.getListOfValidRoles())
@@ -0,0 +1,1226 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGetIdentitiesRegisteredBy(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	auditor := newTestIdentity("auditor1", "Org1MSP")
+	e.register(auditor, "")
+	e.must(e.cc.MakeIdentityAdmin(e.as(e.admin), auditor.alias))
+
+	tests := []struct {
+		name      string
+		caller    *testIdentity
+		registrar string
+		want      []string
+		wantErr   string
+	}{
+		{
+			name: "registrar with several registrations", caller: auditor, registrar: e.admin.alias,
+			want: []string{"admin", "auditor1", "certifier1", "distributor1", "farmer1", "processor1", "retailer1"},
+		},
+		{name: "admin with no registrations", caller: e.admin, registrar: auditor.alias, want: []string{}},
+		{name: "non-admin caller", caller: e.farmer, registrar: e.admin.alias, wantErr: "not authorized"},
+		{name: "unknown registrar", caller: e.admin, registrar: "nobody", wantErr: "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identities, err := e.cc.GetIdentitiesRegisteredBy(e.as(tt.caller), tt.registrar)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			got := []string{}
+			for _, idInfo := range identities {
+				got = append(got, idInfo.ShortName)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("registered identities = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterIdentityEnrollmentIDUniqueness(t *testing.T) {
+	tests := []struct {
+		name     string
+		enforced bool
+		legacy   bool // existing holder was written without the enrollment index
+		backfill bool
+		target   string
+		wantErr  string
+	}{
+		{name: "duplicate rejected when enforced", enforced: true, target: "x509::CN=second::CN=ca.org1msp", wantErr: "already in use"},
+		{name: "duplicate on backfilled record rejected", enforced: true, legacy: true, backfill: true, target: "x509::CN=second::CN=ca.org1msp", wantErr: "already in use"},
+		{name: "unindexed record not seen before backfill", enforced: true, legacy: true, target: "x509::CN=second::CN=ca.org1msp"},
+		{name: "re-registering the holder allowed", enforced: true, target: "x509::CN=first::CN=ca.org1msp"},
+		{name: "duplicate allowed when not enforced", enforced: false, target: "x509::CN=second::CN=ca.org1msp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			first := &testIdentity{id: "x509::CN=first::CN=ca.org1msp", alias: "first", mspID: "Org1MSP"}
+			if tt.legacy {
+				ctx := e.as(e.admin)
+				key, err := NewIdentityManager(ctx).createIdentityCompositeKey(first.id)
+				e.must(err)
+				e.must(ctx.GetStub().PutState(key, []byte(`{"objectType":"IdentityInfo","fullId":"`+first.id+`","shortName":"first","enrollmentId":"shared-enroll"}`)))
+				if tt.backfill {
+					_, err := e.cc.BackfillIdentityIndexes(e.as(e.admin))
+					e.must(err)
+				}
+			} else {
+				e.must(e.cc.RegisterIdentity(e.as(e.admin), first.id, first.alias, "shared-enroll"))
+			}
+			e.must(e.cc.SetEnrollmentIDUniquenessEnforced(e.as(e.admin), tt.enforced))
+
+			alias := "second"
+			if tt.target == first.id {
+				alias = first.alias
+			}
+			err := e.cc.RegisterIdentity(e.as(e.admin), tt.target, alias, "shared-enroll")
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("setting requires admin", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetEnrollmentIDUniquenessEnforced(e.as(e.farmer), true), "not an admin")
+	})
+}
+
+func TestBackfillIdentityIndexes(t *testing.T) {
+	legacyIdentity := func(e *testEnv, fullID, alias, enrollmentID string) {
+		ctx := e.as(e.admin)
+		key, err := NewIdentityManager(ctx).createIdentityCompositeKey(fullID)
+		e.must(err)
+		e.must(ctx.GetStub().PutState(key, []byte(`{"objectType":"IdentityInfo","fullId":"`+fullID+`","shortName":"`+alias+`","enrollmentId":"`+enrollmentID+`"}`)))
+	}
+	enrollmentHolder := func(e *testEnv, enrollmentID string) string {
+		holder, err := NewIdentityManager(e.as(e.admin)).findEnrollmentIDHolder(enrollmentID, "")
+		e.must(err)
+		return holder
+	}
+	aliasHolder := func(e *testEnv, alias string) string {
+		holder, err := NewIdentityManager(e.as(e.admin)).findCaseInsensitiveAliasHolder(alias, "")
+		e.must(err)
+		return holder
+	}
+
+	tests := []struct {
+		name        string
+		caller      func(e *testEnv) *testIdentity
+		runs        int
+		wantIndexed []int // normalizedAliasesIndexed and enrollmentIdsIndexed per run
+		wantHolder  string
+		wantErr     string
+	}{
+		{name: "indexes unindexed identities once", caller: func(e *testEnv) *testIdentity { return e.admin }, runs: 2, wantIndexed: []int{1, 0}, wantHolder: "x509::CN=legacy::CN=ca.org1msp"},
+		{name: "non-admin caller", caller: func(e *testEnv) *testIdentity { return e.farmer }, runs: 1, wantErr: "not authorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.must(e.cc.RegisterIdentity(e.as(e.admin), "x509::CN=indexed::CN=ca.org1msp", "indexed", "indexed-enroll"))
+			legacyIdentity(e, "x509::CN=legacy::CN=ca.org1msp", "legacy", "legacy-enroll")
+			for run := 0; run < tt.runs; run++ {
+				result, err := e.cc.BackfillIdentityIndexes(e.as(tt.caller(e)))
+				checkErr(t, err, tt.wantErr)
+				if tt.wantErr != "" {
+					if holder := enrollmentHolder(e, "legacy-enroll") + aliasHolder(e, "LEGACY"); holder != "" {
+						t.Fatalf("index holder = %q after rejected backfill, want none", holder)
+					}
+					return
+				}
+				for _, field := range []string{"normalizedAliasesIndexed", "enrollmentIdsIndexed"} {
+					if got := result[field]; got != tt.wantIndexed[run] {
+						t.Fatalf("run %d: %s = %v, want %d", run, field, got, tt.wantIndexed[run])
+					}
+				}
+			}
+			if holder := enrollmentHolder(e, "legacy-enroll"); holder != tt.wantHolder {
+				t.Fatalf("enrollment holder = %q, want %q", holder, tt.wantHolder)
+			}
+			if holder := aliasHolder(e, "LEGACY"); holder != tt.wantHolder {
+				t.Fatalf("alias holder = %q, want %q", holder, tt.wantHolder)
+			}
+			if holder := enrollmentHolder(e, "indexed-enroll"); holder != "x509::CN=indexed::CN=ca.org1msp" {
+				t.Fatalf("existing mapping changed to %q", holder)
+			}
+		})
+	}
+}
+
+func TestGetInactiveIdentities(t *testing.T) {
+	tests := []struct {
+		name       string
+		deactivate []string
+		reactivate []string
+		caller     func(e *testEnv) *testIdentity
+		want       []string
+		wantErr    string
+	}{
+		{name: "all active", want: []string{}},
+		{name: "deactivated identities listed", deactivate: []string{"farmer1", "retailer1"}, want: []string{"farmer1", "retailer1"}},
+		{name: "reactivated identity dropped", deactivate: []string{"farmer1", "retailer1"}, reactivate: []string{"farmer1"}, want: []string{"retailer1"}},
+		{name: "non-admin caller", caller: func(e *testEnv) *testIdentity { return e.farmer }, wantErr: "not authorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, alias := range tt.deactivate {
+				e.must(e.cc.DeactivateIdentity(e.as(e.admin), alias))
+			}
+			for _, alias := range tt.reactivate {
+				e.must(e.cc.ReactivateIdentity(e.as(e.admin), alias))
+			}
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			identities, err := e.cc.GetInactiveIdentities(e.as(caller))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			got := []string{}
+			for _, idInfo := range identities {
+				got = append(got, idInfo.ShortName)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("inactive identities = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("deactivated identity loses its roles", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.must(e.cc.DeactivateIdentity(e.as(e.admin), e.farmer.alias))
+		checkErr(t, e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, "kg", e.farmerData(nil)), "role")
+		e.must(e.cc.ReactivateIdentity(e.as(e.admin), e.farmer.alias))
+		e.createShipment("SHIP-1")
+	})
+
+	t.Run("deactivated admin loses admin rights", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		auditor := newTestIdentity("auditor1", "Org1MSP")
+		e.register(auditor, "")
+		e.must(e.cc.MakeIdentityAdmin(e.as(e.admin), auditor.alias))
+		e.must(e.cc.DeactivateIdentity(e.as(e.admin), auditor.alias))
+		_, err := e.cc.GetInactiveIdentities(e.as(auditor))
+		checkErr(t, err, "not authorized")
+	})
+
+	t.Run("admin cannot deactivate itself", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.DeactivateIdentity(e.as(e.admin), e.admin.alias), "own identity")
+	})
+}
+
+func TestAdminDataAccessedEvent(t *testing.T) {
+	reads := []struct {
+		name      string
+		read      func(e *testEnv) error
+		wantCount float64
+	}{
+		{name: "GetAllRegisteredIdentities", wantCount: 6, read: func(e *testEnv) error {
+			_, err := e.cc.GetAllIdentities(e.as(e.admin))
+			return err
+		}},
+		{name: "GetIdentitiesRegisteredBy", wantCount: 6, read: func(e *testEnv) error {
+			_, err := e.cc.GetIdentitiesRegisteredBy(e.as(e.admin), e.admin.alias)
+			return err
+		}},
+		{name: "GetInactiveIdentities", wantCount: 0, read: func(e *testEnv) error {
+			_, err := e.cc.GetInactiveIdentities(e.as(e.admin))
+			return err
+		}},
+		{name: "GetIdentitiesByMSP", wantCount: 6, read: func(e *testEnv) error {
+			_, err := e.cc.GetIdentitiesByMSP(e.as(e.admin), "org1msp") // Registered identities take the registrar's MSP
+			return err
+		}},
+	}
+	for _, r := range reads {
+		for _, enabled := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/audit=%t", r.name, enabled), func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				e.must(e.cc.SetAdminReadAuditEnabled(e.as(e.admin), enabled))
+				e.must(r.read(e))
+				if !enabled {
+					if len(e.stub.events) != 0 {
+						t.Fatalf("read set %d events with auditing disabled", len(e.stub.events))
+					}
+					return
+				}
+				name, payload := e.lastEvent()
+				if name != "AdminDataAccessed" {
+					t.Fatalf("event = %s, want AdminDataAccessed", name)
+				}
+				if payload["callerId"] != e.admin.id || payload["function"] != r.name || payload["resultCount"] != r.wantCount {
+					t.Fatalf("payload = %v", payload)
+				}
+				if payload["txId"] != e.stub.TxID {
+					t.Fatalf("txId = %v, want %s", payload["txId"], e.stub.TxID)
+				}
+			})
+		}
+	}
+
+	t.Run("rejected read is not audited", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.must(e.cc.SetAdminReadAuditEnabled(e.as(e.admin), true))
+		_, err := e.cc.GetAllIdentities(e.as(e.farmer))
+		checkErr(t, err, "not authorized")
+		if len(e.stub.events) != 0 {
+			t.Fatalf("rejected read set %d events", len(e.stub.events))
+		}
+	})
+
+	t.Run("only admins toggle auditing", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetAdminReadAuditEnabled(e.as(e.farmer), true), "cannot update config")
+	})
+}
+
+func TestAssignRoleToIdentities(t *testing.T) {
+	tests := []struct {
+		name        string
+		targets     []string
+		dryRun      bool
+		wantAssign  []string
+		wantPresent []string
+		wantFailed  []string
+	}{
+		{name: "fresh assignment", targets: []string{"newcomer1"}, wantAssign: []string{"newcomer1"}, wantPresent: []string{}, wantFailed: []string{}},
+		{name: "already holds role", targets: []string{"farmer1"}, wantAssign: []string{}, wantPresent: []string{"farmer1"}, wantFailed: []string{}},
+		{
+			name: "mixed batch", targets: []string{"farmer1", "newcomer1", "nobody"},
+			wantAssign: []string{"newcomer1"}, wantPresent: []string{"farmer1"}, wantFailed: []string{"nobody"},
+		},
+		{name: "repeated target", targets: []string{"newcomer1", "newcomer1"}, wantAssign: []string{"newcomer1"}, wantPresent: []string{"newcomer1"}, wantFailed: []string{}},
+		{name: "dry run", targets: []string{"newcomer1", "farmer1"}, dryRun: true, wantAssign: []string{"newcomer1"}, wantPresent: []string{"farmer1"}, wantFailed: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.register(newTestIdentity("newcomer1", "Org1MSP"), "")
+			targetsJSON, _ := json.Marshal(tt.targets)
+			summary, err := e.cc.AssignRoleToIdentities(e.as(e.admin), string(targetsJSON), "Farmer", tt.dryRun)
+			e.must(err)
+
+			failed := []string{}
+			for _, f := range summary["failed"].([]map[string]string) {
+				if f["reason"] == "" {
+					t.Errorf("failure for %s has no reason", f["identity"])
+				}
+				failed = append(failed, f["identity"])
+			}
+			if got := summary["assigned"].([]string); !equalStrings(got, tt.wantAssign) {
+				t.Errorf("assigned = %v, want %v", got, tt.wantAssign)
+			}
+			if got := summary["alreadyPresent"].([]string); !equalStrings(got, tt.wantPresent) {
+				t.Errorf("alreadyPresent = %v, want %v", got, tt.wantPresent)
+			}
+			if !equalStrings(failed, tt.wantFailed) {
+				t.Errorf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+
+			idInfo, err := e.cc.GetIdentityDetails(e.as(e.admin), "newcomer1")
+			e.must(err)
+			if holds := len(idInfo.Roles) == 1 && idInfo.Roles[0] == "farmer"; holds != (len(tt.wantAssign) > 0 && !tt.dryRun) {
+				t.Fatalf("newcomer1 roles = %v after dryRun=%t", idInfo.Roles, tt.dryRun)
+			}
+		})
+	}
+
+	t.Run("non-admin caller", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		_, err := e.cc.AssignRoleToIdentities(e.as(e.farmer), `["retailer1"]`, "farmer", false)
+		checkErr(t, err, "not authorized")
+	})
+
+	t.Run("invalid role", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		_, err := e.cc.AssignRoleToIdentities(e.as(e.admin), `["farmer1"]`, "auditor", false)
+		checkErr(t, err, "invalid role")
+	})
+}
+
+func TestAssignRoleToIdentitiesDryRunMatchesApplied(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		targets []string
+	}{
+		{name: "fresh assignments", role: "retailer", targets: []string{"newcomer1", "newcomer2"}},
+		{name: "mixed batch", role: "farmer", targets: []string{"farmer1", "newcomer1", "nobody", "processor1"}},
+		{name: "repeated target", role: "distributor", targets: []string{"newcomer1", "newcomer1", "distributor1"}},
+		{name: "full ID and alias of one identity", role: "certifier", targets: []string{"newcomer2", "x509::CN=newcomer2::CN=ca.org1msp"}},
+		{name: "all unknown", role: "farmer", targets: []string{"nobody", "ghost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.register(newTestIdentity("newcomer1", "Org1MSP"), "")
+			e.register(newTestIdentity("newcomer2", "Org1MSP"), "")
+			targetsJSON, _ := json.Marshal(tt.targets)
+			writesBefore := e.stub.writeCount()
+			preview, err := e.cc.AssignRoleToIdentities(e.as(e.admin), string(targetsJSON), tt.role, true)
+			e.must(err)
+			if writesAfter := e.stub.writeCount(); writesAfter != writesBefore {
+				t.Fatalf("dry run wrote %d ledger entries", writesAfter-writesBefore)
+			}
+			applied, err := e.cc.AssignRoleToIdentities(e.as(e.admin), string(targetsJSON), tt.role, false)
+			e.must(err)
+
+			if preview["dryRun"] != true || applied["dryRun"] != false {
+				t.Fatalf("dryRun flags = %v, %v", preview["dryRun"], applied["dryRun"])
+			}
+			for _, key := range []string{"role", "assigned", "alreadyPresent", "failed"} {
+				if !reflect.DeepEqual(preview[key], applied[key]) {
+					t.Errorf("%s: preview %v, applied %v", key, preview[key], applied[key])
+				}
+			}
+			for _, target := range applied["assigned"].([]string) {
+				holds, err := NewIdentityManager(e.as(e.admin)).HasRole(target, tt.role)
+				e.must(err)
+				if !holds {
+					t.Errorf("%s lacks '%s' after applying", target, tt.role)
+				}
+			}
+		})
+	}
+}
+
+func TestCertifierRemovalWithPendingDesignation(t *testing.T) {
+	removals := []struct {
+		name   string
+		remove func(e *testEnv, force bool) error
+		// removed reports whether the certifier lost its ability to certify.
+		removed func(e *testEnv) bool
+	}{
+		{
+			name: "RemoveRole",
+			remove: func(e *testEnv, force bool) error {
+				return e.cc.RemoveRoleFromIdentityWithForce(e.as(e.admin), e.certifier.alias, "certifier", force)
+			},
+			removed: func(e *testEnv) bool {
+				idInfo, err := e.cc.GetIdentityDetails(e.as(e.admin), e.certifier.alias)
+				e.must(err)
+				return len(idInfo.Roles) == 0
+			},
+		},
+		{
+			name: "Deactivate",
+			remove: func(e *testEnv, force bool) error {
+				return e.cc.DeactivateIdentityWithForce(e.as(e.admin), e.certifier.alias, force)
+			},
+			removed: func(e *testEnv) bool {
+				idInfo, err := e.cc.GetIdentityDetails(e.as(e.admin), e.certifier.alias)
+				e.must(err)
+				return !idInfo.IsActive
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		setup   func(e *testEnv)
+		force   bool
+		wantErr string
+	}{
+		{name: "no designations"},
+		{
+			name:    "pending designation blocks",
+			setup:   func(e *testEnv) { e.must(e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias)) },
+			wantErr: "designated certifier of 1 shipment(s)",
+		},
+		{
+			name:  "pending designation with force",
+			setup: func(e *testEnv) { e.must(e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias)) },
+			force: true,
+		},
+		{
+			name: "designation already decided",
+			setup: func(e *testEnv) {
+				e.must(e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias))
+				e.certify("SHIP-1")
+			},
+		},
+		{
+			name: "designation on archived shipment",
+			setup: func(e *testEnv) {
+				e.must(e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias))
+				archived := e.shipment("SHIP-1")
+				archived.IsArchived = true
+				e.putShipment(archived)
+			},
+		},
+	}
+	for _, noCouch := range []bool{false, true} {
+		for _, r := range removals {
+			for _, tt := range tests {
+				t.Run(fmt.Sprintf("%s/%s/noCouch=%t", r.name, tt.name, noCouch), func(t *testing.T) {
+					e := newSupplyChainEnv(t)
+					e.createShipment("SHIP-1")
+					if tt.setup != nil {
+						tt.setup(e)
+					}
+					e.stub.noCouch = noCouch
+					checkErr(t, r.remove(e, tt.force), tt.wantErr)
+					if removed := r.removed(e); removed != (tt.wantErr == "") {
+						t.Fatalf("certifier removed = %t, want %t", removed, tt.wantErr == "")
+					}
+				})
+			}
+		}
+	}
+
+	t.Run("deactivating a non-certifier skips the designation check", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		// A designation left over from when the identity was a certifier.
+		stale := e.shipment("SHIP-1")
+		stale.DesignatedCertifierID = e.retailer.id
+		e.putShipment(stale)
+
+		checkErr(t, e.cc.DeactivateIdentityWithForce(e.as(e.admin), e.retailer.alias, false), "")
+	})
+}
+
+func TestResolveIdentityIgnoresAliasCase(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "exact alias", input: "farmer1", want: e.farmer.id},
+		{name: "differing case", input: "FARMER1", want: e.farmer.id},
+		{name: "mixed case with padding", input: " Farmer1 ", want: e.farmer.id},
+		{name: "full ID", input: e.farmer.id, want: e.farmer.id},
+		{name: "unknown alias", input: "Farmer9", wantErr: "alias 'Farmer9' not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewIdentityManager(e.as(e.admin)).ResolveIdentity(tt.input)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" && got != tt.want {
+				t.Fatalf("resolved %q to %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAliasCaseCollisions(t *testing.T) {
+	newcomer := "x509::CN=newcomer::CN=ca.org1msp"
+	putLegacy := func(e *testEnv) { // Registered before the normalized alias index existed
+		legacy := "x509::CN=legacy::CN=ca.org1msp"
+		ctx := e.as(e.admin)
+		key, err := NewIdentityManager(ctx).createIdentityCompositeKey(legacy)
+		e.must(err)
+		e.must(ctx.GetStub().PutState(key, []byte(`{"objectType":"IdentityInfo","fullId":"`+legacy+`","shortName":"Legacy"}`)))
+	}
+	tests := []struct {
+		name    string
+		action  func(e *testEnv) error
+		wantErr string
+	}{
+		{
+			name:    "register differing only in case",
+			action:  func(e *testEnv) error { return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "Farmer1", "") },
+			wantErr: "differs only in case",
+		},
+		{
+			name:    "register exact duplicate",
+			action:  func(e *testEnv) error { return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "farmer1", "") },
+			wantErr: "already in use",
+		},
+		{
+			name: "register against backfilled alias",
+			action: func(e *testEnv) error {
+				putLegacy(e)
+				_, err := e.cc.BackfillIdentityIndexes(e.as(e.admin))
+				e.must(err)
+				return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "LEGACY", "")
+			},
+			wantErr: "differs only in case",
+		},
+		{
+			name: "unindexed alias not seen before backfill",
+			action: func(e *testEnv) error {
+				putLegacy(e)
+				return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "LEGACY", "")
+			},
+		},
+		{
+			name:    "register differing from the bootstrap admin only in case",
+			action:  func(e *testEnv) error { return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "ADMIN", "") },
+			wantErr: "differs only in case",
+		},
+		{
+			name:    "rename onto another alias's case variant",
+			action:  func(e *testEnv) error { return e.cc.RenameAlias(e.as(e.admin), e.processor.alias, "FARMER1") },
+			wantErr: "differs only in case",
+		},
+		{
+			name: "rename own alias's case",
+			action: func(e *testEnv) error {
+				e.must(e.cc.RenameAlias(e.as(e.admin), e.farmer.alias, "Farmer1"))
+				if resolved, err := NewIdentityManager(e.as(e.admin)).ResolveIdentity("farmer1"); err != nil || resolved != e.farmer.id {
+					e.t.Fatalf("old spelling resolved to %q (%v)", resolved, err)
+				}
+				return nil
+			},
+		},
+		{
+			name:   "distinct alias",
+			action: func(e *testEnv) error { return e.cc.RegisterIdentity(e.as(e.admin), newcomer, "Farmer2", "") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			checkErr(t, tt.action(e), tt.wantErr)
+		})
+	}
+}
+
+func TestRegisterIdentityAutoGeneratedAlias(t *testing.T) {
+	hashOf := func(fullID string) string {
+		sum := sha256.Sum256([]byte(fullID))
+		return hex.EncodeToString(sum[:])
+	}
+	idA := "x509::CN=auto-a::CN=ca.org1msp"
+	idB := "x509::CN=auto-b::CN=ca.org1msp"
+	idC := "x509::CN=auto-c::CN=ca.org1msp"
+	idD := "x509::CN=auto-d::CN=ca.org1msp"
+
+	t.Run("rejected by default", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.RegisterIdentity(e.as(e.admin), idA, " ", "Org1.User-7"), "shortName cannot be empty")
+	})
+
+	// Registrations run in order against one ledger, so later ones see the aliases taken earlier.
+	e := newSupplyChainEnv(t)
+	e.must(e.cc.SetAliasAutoGenerationEnabled(e.as(e.admin), true))
+	tests := []struct {
+		name         string
+		fullID       string
+		shortName    string
+		enrollmentID string
+		want         string
+	}{
+		{name: "derived from enrollment ID", fullID: idA, enrollmentID: "Org1.User-7", want: "org1.user-7"},
+		{name: "taken base gets hash suffix", fullID: idB, enrollmentID: "org1.user-7", want: "org1.user-7-" + hashOf(idB)[:8]},
+		{name: "no enrollment ID", fullID: idC, want: "id-" + hashOf(idC)[:12]},
+		{name: "re-registration keeps alias", fullID: idA, enrollmentID: "Org1.User-7", want: "org1.user-7"},
+		{name: "base taken in other case", fullID: idD, enrollmentID: "FARMER1", want: "farmer1-" + hashOf(idD)[:8]},
+		{name: "explicit shortName still used", fullID: "x509::CN=named::CN=ca.org1msp", shortName: "named1", enrollmentID: "Org1.User-7", want: "named1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, e.cc.RegisterIdentity(e.as(e.admin), tt.fullID, tt.shortName, tt.enrollmentID), "")
+			idInfo, err := e.cc.GetIdentityDetails(e.as(e.admin), tt.fullID)
+			checkErr(t, err, "")
+			if idInfo.ShortName != tt.want {
+				t.Fatalf("alias = %q, want %q", idInfo.ShortName, tt.want)
+			}
+			resolved, err := NewIdentityManager(e.as(e.admin)).ResolveIdentity(tt.want)
+			checkErr(t, err, "")
+			if resolved != tt.fullID {
+				t.Fatalf("alias %q resolves to %s, want %s", tt.want, resolved, tt.fullID)
+			}
+		})
+	}
+}
+
+func TestCertFingerprint(t *testing.T) {
+	fingerprintOf := func(raw string) string {
+		sum := sha256.Sum256([]byte(raw))
+		return hex.EncodeToString(sum[:])
+	}
+	tests := []struct {
+		name            string
+		cert            string // raw certificate bytes of the registered identity; "" means none is available
+		selfRegistered  bool   // false has another bootstrap-mode caller register the identity
+		wantFingerprint bool
+	}{
+		{name: "self-registration records fingerprint", cert: "farmer1 certificate", selfRegistered: true, wantFingerprint: true},
+		{name: "registration by another caller records none", cert: "farmer1 certificate"},
+		{name: "no certificate available", selfRegistered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			if tt.cert != "" {
+				e.farmer.cert = &x509.Certificate{Raw: []byte(tt.cert)}
+			}
+			registrar := e.processor
+			if tt.selfRegistered {
+				registrar = e.farmer
+			}
+			e.must(e.cc.RegisterIdentity(e.as(registrar), e.farmer.id, e.farmer.alias, e.farmer.alias))
+
+			info, err := NewIdentityManager(e.as(e.farmer)).GetIdentityInfo(e.farmer.id)
+			e.must(err)
+			want := ""
+			if tt.wantFingerprint {
+				want = fingerprintOf(tt.cert)
+			}
+			if info.CertFingerprint != want {
+				t.Fatalf("certFingerprint = %q, want %q", info.CertFingerprint, want)
+			}
+			if tt.cert == "" {
+				return
+			}
+			found, err := e.cc.GetIdentityByFingerprint(e.as(e.farmer), fingerprintOf(tt.cert))
+			if !tt.wantFingerprint {
+				checkErr(t, err, "no identity registered with certificate fingerprint")
+				return
+			}
+			e.must(err)
+			if found.FullID != e.farmer.id {
+				t.Fatalf("fingerprint resolved to %s, want %s", found.FullID, e.farmer.id)
+			}
+		})
+	}
+
+	t.Run("new certificate replaces the old mapping", func(t *testing.T) {
+		e := newTestEnv(t)
+		e.farmer.cert = &x509.Certificate{Raw: []byte("farmer1 certificate")}
+		e.must(e.cc.RegisterIdentity(e.as(e.farmer), e.farmer.id, e.farmer.alias, e.farmer.alias))
+		e.farmer.cert = &x509.Certificate{Raw: []byte("farmer1 renewed certificate")}
+		e.must(e.cc.RegisterIdentity(e.as(e.farmer), e.farmer.id, e.farmer.alias, e.farmer.alias))
+
+		_, err := e.cc.GetIdentityByFingerprint(e.as(e.farmer), fingerprintOf("farmer1 certificate"))
+		checkErr(t, err, "no identity registered")
+		found, err := e.cc.GetIdentityByFingerprint(e.as(e.farmer), fingerprintOf("farmer1 renewed certificate"))
+		checkErr(t, err, "")
+		if found.FullID != e.farmer.id || found.CertFingerprint != fingerprintOf("farmer1 renewed certificate") {
+			t.Fatalf("renewed fingerprint resolved to %+v", found)
+		}
+	})
+
+	e := newTestEnv(t)
+	e.farmer.cert = &x509.Certificate{Raw: []byte("farmer1 certificate")}
+	e.must(e.cc.RegisterIdentity(e.as(e.farmer), e.farmer.id, e.farmer.alias, e.farmer.alias))
+	e.must(e.cc.BootstrapLedger(e.as(e.admin)))
+	fingerprint := fingerprintOf("farmer1 certificate")
+	var colonSeparated []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colonSeparated = append(colonSeparated, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	lookups := []struct {
+		name        string
+		caller      *testIdentity
+		fingerprint string
+		wantErr     string
+	}{
+		{name: "own identity", caller: e.farmer, fingerprint: fingerprint},
+		{name: "upper-case colon-separated form", caller: e.farmer, fingerprint: strings.Join(colonSeparated, ":")},
+		{name: "admin looks up another identity", caller: e.admin, fingerprint: fingerprint},
+		{name: "non-admin looks up another identity", caller: e.retailer, fingerprint: fingerprint, wantErr: "not authorized to look up other identities"},
+		{name: "unknown fingerprint", caller: e.admin, fingerprint: fingerprintOf("unknown"), wantErr: "no identity registered"},
+		{name: "empty fingerprint", caller: e.admin, fingerprint: " : ", wantErr: "fingerprint cannot be empty"},
+	}
+	for _, tt := range lookups {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := e.cc.GetIdentityByFingerprint(e.as(tt.caller), tt.fingerprint)
+			checkErr(t, err, tt.wantErr)
+			if err == nil && found.FullID != e.farmer.id {
+				t.Fatalf("fingerprint resolved to %s, want %s", found.FullID, e.farmer.id)
+			}
+		})
+	}
+}
+
+func TestRenameAlias(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      func(e *testEnv) *testIdentity
+		target      func(e *testEnv) string
+		newName     string
+		wantErr     string
+		wantRenamed bool
+	}{
+		{name: "rename by alias", target: func(e *testEnv) string { return e.farmer.alias }, newName: " grower1 ", wantRenamed: true},
+		{name: "rename by full ID", target: func(e *testEnv) string { return e.farmer.id }, newName: "grower1", wantRenamed: true},
+		{name: "same name is a no-op", target: func(e *testEnv) string { return e.farmer.alias }, newName: "farmer1"},
+		{name: "name taken by another identity", target: func(e *testEnv) string { return e.farmer.alias }, newName: "retailer1", wantErr: "shortName (alias) 'retailer1' is already in use"},
+		{name: "empty name rejected", target: func(e *testEnv) string { return e.farmer.alias }, newName: "  ", wantErr: "newShortName cannot be empty"},
+		{name: "unknown identity rejected", target: func(e *testEnv) string { return "nobody1" }, newName: "grower1", wantErr: "nobody1"},
+		{
+			name:    "non-admin rejected",
+			caller:  func(e *testEnv) *testIdentity { return e.farmer },
+			target:  func(e *testEnv) string { return e.farmer.alias },
+			newName: "grower1", wantErr: "not authorized to rename aliases",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before, err := NewIdentityManager(e.inTx(e.admin)).GetIdentityInfo(e.farmer.id)
+			e.must(err)
+
+			checkErr(t, e.cc.RenameAlias(e.as(caller), tt.target(e), tt.newName), tt.wantErr)
+			im := NewIdentityManager(e.inTx(e.admin))
+			info, err := im.GetIdentityInfo(e.farmer.id)
+			e.must(err)
+			if !tt.wantRenamed {
+				if info.ShortName != "farmer1" || !info.LastUpdatedAt.Equal(before.LastUpdatedAt) {
+					t.Fatalf("identity changed: shortName %q, lastUpdatedAt %s", info.ShortName, info.LastUpdatedAt)
+				}
+				if len(e.stub.events) != 0 {
+					t.Fatalf("unexpected event %s", e.stub.events[0].EventName)
+				}
+				return
+			}
+
+			if info.ShortName != "grower1" || !info.LastUpdatedAt.Equal(e.now) || !equalStrings(info.Roles, before.Roles) {
+				t.Fatalf("identity after rename = %+v", info)
+			}
+			if resolved, errResolve := im.ResolveIdentity("grower1"); errResolve != nil || resolved != e.farmer.id {
+				t.Fatalf("new alias resolved to %q (%v)", resolved, errResolve)
+			}
+			if _, errResolve := im.ResolveIdentity("farmer1"); errResolve == nil {
+				t.Fatal("old alias still resolves")
+			}
+			name, payload := e.lastEvent()
+			if name != "AliasRenamed" || payload["oldShortName"] != "farmer1" || payload["newShortName"] != "grower1" ||
+				payload["fullId"] != e.farmer.id || payload["renamedBy"] != e.admin.id {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+
+			// Shipments store FullIDs, so existing records stay with the renamed identity.
+			if owner := e.shipment("SHIP-1").CurrentOwnerID; owner != e.farmer.id {
+				t.Fatalf("shipment owner = %s, want %s", owner, e.farmer.id)
+			}
+			e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1"))
+			// The freed alias can be taken by another identity.
+			newcomer := "x509::CN=newcomer::CN=ca.org1msp"
+			e.must(e.cc.RegisterIdentity(e.as(e.admin), newcomer, "farmer1", ""))
+			if resolved, errResolve := NewIdentityManager(e.inTx(e.admin)).ResolveIdentity("farmer1"); errResolve != nil || resolved != newcomer {
+				t.Fatalf("freed alias resolved to %q (%v)", resolved, errResolve)
+			}
+		})
+	}
+}
+
+func TestGetIdentitiesByMSP(t *testing.T) {
+	// Registered identities take the registrar's MSP, so each identity registers itself before the admin exists.
+	e := newTestEnv(t)
+	for _, id := range []*testIdentity{e.farmer, e.processor, e.certifier, e.distributor} {
+		e.must(e.cc.RegisterIdentity(e.as(id), id.id, id.alias, id.alias))
+	}
+	e.must(e.cc.BootstrapLedger(e.as(e.admin)))
+	ctx := e.as(e.admin)
+	malformedKey, err := NewIdentityManager(ctx).createIdentityCompositeKey("x509::CN=broken::CN=ca.org2msp")
+	e.must(err)
+	e.must(ctx.GetStub().PutState(malformedKey, []byte(`{"organizationMsp":`)))
+
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		mspID   string
+		want    []string
+		wantErr string
+	}{
+		{name: "exact MSP", mspID: "Org2MSP", want: []string{"certifier1", "processor1"}},
+		{name: "case-insensitive MSP", mspID: " org1msp ", want: []string{"admin", "farmer1"}},
+		{name: "single member", mspID: "ORG3MSP", want: []string{"distributor1"}},
+		{name: "unknown MSP", mspID: "Org9MSP", want: []string{}},
+		{name: "empty MSP rejected", mspID: " ", wantErr: "mspID cannot be empty"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, mspID: "Org1MSP", wantErr: "not authorized to list identities by MSP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			identities, err := e.cc.GetIdentitiesByMSP(e.as(caller), tt.mspID)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if identities == nil {
+				t.Fatal("identities = nil, want a non-nil slice")
+			}
+			got := []string{}
+			for _, idInfo := range identities {
+				got = append(got, idInfo.ShortName)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("identities = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("counts by MSP", func(t *testing.T) {
+		counts, err := e.cc.GetIdentityCountsByMSP(e.as(e.admin))
+		checkErr(t, err, "")
+		want := map[string]int{"Org1MSP": 2, "Org2MSP": 2, "Org3MSP": 1}
+		if len(counts) != len(want) {
+			t.Fatalf("counts = %v, want %v", counts, want)
+		}
+		for msp, n := range want {
+			if counts[msp] != n {
+				t.Errorf("count for %s = %d, want %d", msp, counts[msp], n)
+			}
+		}
+		_, err = e.cc.GetIdentityCountsByMSP(e.as(e.farmer))
+		checkErr(t, err, "not authorized")
+	})
+}
+
+func TestRegisterIdentitiesBatch(t *testing.T) {
+	entry := func(name, enrollmentID string) identityRegistrationRequest {
+		return identityRegistrationRequest{FullID: "x509::CN=" + name + "::CN=ca.org1msp", ShortName: name, EnrollmentID: enrollmentID}
+	}
+	entries := func(n int) []identityRegistrationRequest {
+		reqs := make([]identityRegistrationRequest, n)
+		for i := range reqs {
+			reqs[i] = entry(fmt.Sprintf("member%03d", i), "")
+		}
+		return reqs
+	}
+	fullIDs := func(reqs ...identityRegistrationRequest) []string {
+		ids := []string{}
+		for _, req := range reqs {
+			ids = append(ids, req.FullID)
+		}
+		return ids
+	}
+	farmerUpdate := func(e *testEnv) identityRegistrationRequest {
+		return identityRegistrationRequest{FullID: e.farmer.id, ShortName: e.farmer.alias, EnrollmentID: "farmer-enroll"}
+	}
+
+	tests := []struct {
+		name        string
+		caller      func(e *testEnv) *testIdentity // defaults to the admin
+		enforced    bool                           // enrollment ID uniqueness
+		requests    func(e *testEnv) []identityRegistrationRequest
+		rawJSON     string // sent instead of requests when set
+		wantCreated []string
+		wantUpdated func(e *testEnv) []string
+		wantErr     string
+	}{
+		{
+			name: "new identities created",
+			requests: func(*testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", "c1"), entry("coop2", "c2")}
+			},
+			wantCreated: fullIDs(entry("coop1", ""), entry("coop2", "")),
+		},
+		{
+			name: "existing identity updated",
+			requests: func(e *testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", "c1"), farmerUpdate(e)}
+			},
+			wantCreated: fullIDs(entry("coop1", "")),
+			wantUpdated: func(e *testEnv) []string { return []string{e.farmer.id} },
+		},
+		{
+			name:        "batch at the maximum size",
+			requests:    func(*testEnv) []identityRegistrationRequest { return entries(maxIdentityBatchSize) },
+			wantCreated: fullIDs(entries(maxIdentityBatchSize)...),
+		},
+		{
+			name:     "batch over the maximum size rejected",
+			requests: func(*testEnv) []identityRegistrationRequest { return entries(maxIdentityBatchSize + 1) },
+			wantErr:  fmt.Sprintf("exceeds maximum of %d", maxIdentityBatchSize),
+		},
+		{name: "empty batch rejected", rawJSON: "[]", wantErr: "at least one identity"},
+		{name: "invalid JSON rejected", rawJSON: `{"fullId":"x"}`, wantErr: "invalid identitiesJSON"},
+		{
+			name:     "non-admin rejected",
+			caller:   func(e *testEnv) *testIdentity { return e.farmer },
+			requests: func(*testEnv) []identityRegistrationRequest { return []identityRegistrationRequest{entry("coop1", "")} },
+			wantErr:  "not authorized",
+		},
+		{
+			name: "invalid entry aborts the batch",
+			requests: func(*testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", ""), {ShortName: "nobody"}, entry("coop3", "")}
+			},
+			wantErr: "identities[1]",
+		},
+		{
+			name: "alias held by another identity aborts the batch",
+			requests: func(e *testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", ""), {FullID: "x509::CN=coop2::CN=ca.org1msp", ShortName: e.retailer.alias}}
+			},
+			wantErr: "identities[1]",
+		},
+		{
+			name: "repeated fullId rejected",
+			requests: func(*testEnv) []identityRegistrationRequest {
+				second := entry("coop1", "")
+				second.ShortName = "coop1b"
+				return []identityRegistrationRequest{entry("coop1", ""), second}
+			},
+			wantErr: "already registered by identities[0]",
+		},
+		{
+			name: "repeated alias ignoring case rejected",
+			requests: func(*testEnv) []identityRegistrationRequest {
+				second := entry("coop2", "")
+				second.ShortName = "COOP1"
+				return []identityRegistrationRequest{entry("coop1", ""), second}
+			},
+			wantErr: "already used by identities[0]",
+		},
+		{
+			name:     "repeated enrollment ID rejected when enforced",
+			enforced: true,
+			requests: func(*testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", "shared"), entry("coop2", "shared")}
+			},
+			wantErr: "enrollmentID 'shared' is already used by identities[0]",
+		},
+		{
+			name:     "enrollment ID held on the ledger rejected when enforced",
+			enforced: true,
+			requests: func(e *testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", e.farmer.alias)}
+			},
+			wantErr: "enrollmentID 'farmer1' is already in use",
+		},
+		{
+			name: "case variant of a ledger alias rejected",
+			requests: func(e *testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{{FullID: "x509::CN=coop1::CN=ca.org1msp", ShortName: "RETAILER1"}}
+			},
+			wantErr: "differs only in case",
+		},
+		{
+			name: "repeated enrollment ID allowed when not enforced",
+			requests: func(*testEnv) []identityRegistrationRequest {
+				return []identityRegistrationRequest{entry("coop1", "shared"), entry("coop2", "shared")}
+			},
+			wantCreated: fullIDs(entry("coop1", ""), entry("coop2", "")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			if tt.enforced {
+				e.must(e.cc.SetEnrollmentIDUniquenessEnforced(e.as(e.admin), true))
+			}
+			identitiesJSON := tt.rawJSON
+			if tt.requests != nil {
+				b, err := json.Marshal(tt.requests(e))
+				e.must(err)
+				identitiesJSON = string(b)
+			}
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			writesBefore := e.stub.writeCount()
+
+			summary, err := e.cc.RegisterIdentitiesBatch(e.as(caller), identitiesJSON)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				if writes := e.stub.writeCount(); writes != writesBefore {
+					t.Fatalf("failed batch wrote %d states, want none", writes-writesBefore)
+				}
+				return
+			}
+			wantUpdated := []string{}
+			if tt.wantUpdated != nil {
+				wantUpdated = tt.wantUpdated(e)
+			}
+			if !equalStrings(summary["created"].([]string), tt.wantCreated) || summary["createdCount"] != len(tt.wantCreated) {
+				t.Fatalf("created = %v (count %v), want %v", summary["created"], summary["createdCount"], tt.wantCreated)
+			}
+			if !equalStrings(summary["updated"].([]string), wantUpdated) || summary["updatedCount"] != len(wantUpdated) {
+				t.Fatalf("updated = %v (count %v), want %v", summary["updated"], summary["updatedCount"], wantUpdated)
+			}
+			im := NewIdentityManager(e.inTx(e.admin))
+			for _, id := range append(append([]string{}, tt.wantCreated...), wantUpdated...) {
+				if info, err := im.GetIdentityInfo(id); err != nil || info == nil {
+					t.Fatalf("identity %s not registered: %v", id, err)
+				}
+			}
+		})
+	}
+
+	t.Run("bootstrap caller may register before any admin exists", func(t *testing.T) {
+		e := newTestEnv(t)
+		b, err := json.Marshal([]identityRegistrationRequest{entry("coop1", ""), entry("coop2", "")})
+		e.must(err)
+		summary, err := e.cc.RegisterIdentitiesBatch(e.as(e.farmer), string(b))
+		checkErr(t, err, "")
+		if summary["createdCount"] != 2 || summary["updatedCount"] != 0 {
+			t.Fatalf("summary = %v, want 2 created", summary)
+		}
+	})
+}
+
+func TestAssignRolesBatch(t *testing.T) {
+	newcomer := newTestIdentity("newcomer1", "Org1MSP")
+	tooMany := make([]string, maxArrayElements+1)
+	for i := range tooMany {
+		tooMany[i] = "farmer"
+	}
+	tooManyJSON, _ := json.Marshal(tooMany)
+
+	tests := []struct {
+		name      string
+		caller    func(e *testEnv) *testIdentity // defaults to the admin
+		target    string
+		rolesJSON string
+		wantAdded []string
+		wantRoles []string // roles held afterwards, in order
+		wantErr   string
+	}{
+		{name: "all roles new", target: newcomer.alias, rolesJSON: `["farmer","retailer"]`, wantAdded: []string{"farmer", "retailer"}, wantRoles: []string{"farmer", "retailer"}},
+		{name: "target by full ID", target: newcomer.id, rolesJSON: `["processor"]`, wantAdded: []string{"processor"}, wantRoles: []string{"processor"}},
+		{name: "held roles skipped", target: "farmer1", rolesJSON: `["farmer","distributor"]`, wantAdded: []string{"distributor"}, wantRoles: []string{"farmer", "distributor"}},
+		{name: "repeats and case variants collapsed", target: newcomer.alias, rolesJSON: `["Farmer"," farmer ","RETAILER","retailer"]`, wantAdded: []string{"farmer", "retailer"}, wantRoles: []string{"farmer", "retailer"}},
+		{name: "all roles already held", target: "farmer1", rolesJSON: `["FARMER","farmer"]`, wantAdded: []string{}, wantRoles: []string{"farmer"}},
+		{name: "invalid role aborts", target: newcomer.alias, rolesJSON: `["farmer","chef"]`, wantErr: "invalid role: 'chef'"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, target: newcomer.alias, rolesJSON: `["farmer"]`, wantErr: "not authorized"},
+		{name: "unknown target", target: "nobody", rolesJSON: `["farmer"]`, wantErr: "failed to resolve target identity"},
+		{name: "empty list rejected", target: newcomer.alias, rolesJSON: `[]`, wantErr: "at least one role"},
+		{name: "invalid JSON rejected", target: newcomer.alias, rolesJSON: `"farmer"`, wantErr: "invalid rolesJSON"},
+		{name: "too many roles rejected", target: newcomer.alias, rolesJSON: string(tooManyJSON), wantErr: fmt.Sprintf("exceeds maximum of %d", maxArrayElements)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.register(newcomer, "")
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			writesBefore := e.stub.writeCount()
+
+			added, err := e.cc.AssignRolesBatch(e.as(caller), tt.target, tt.rolesJSON)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" || len(tt.wantAdded) == 0 {
+				if writes := e.stub.writeCount(); writes != writesBefore {
+					t.Fatalf("wrote %d states, want none", writes-writesBefore)
+				}
+				if len(e.stub.events) != 0 {
+					t.Fatalf("set %d events, want none", len(e.stub.events))
+				}
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if !equalStrings(added, tt.wantAdded) {
+				t.Fatalf("added = %v, want %v", added, tt.wantAdded)
+			}
+			info, err := NewIdentityManager(e.inTx(e.admin)).GetIdentityInfo(tt.target)
+			e.must(err)
+			if !equalStrings(info.Roles, tt.wantRoles) {
+				t.Fatalf("roles = %v, want %v", info.Roles, tt.wantRoles)
+			}
+			if len(tt.wantAdded) == 0 {
+				return
+			}
+			// One IdentityInfo write: Fabric does not show a transaction its own writes, so per-role writes would lose roles.
+			if writes := e.stub.writeCount() - writesBefore; writes != 1 {
+				t.Fatalf("wrote %d states, want one", writes)
+			}
+
+			if len(e.stub.events) != 1 {
+				t.Fatalf("set %d events, want one", len(e.stub.events))
+			}
+			name, payload := e.lastEvent()
+			if name != "RolesAssigned" || payload["fullId"] != info.FullID || payload["assignedBy"] != e.admin.id {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+			eventRoles := []string{}
+			for _, role := range payload["roles"].([]interface{}) {
+				eventRoles = append(eventRoles, role.(string))
+			}
+			if !equalStrings(eventRoles, tt.wantAdded) {
+				t.Fatalf("event roles = %v, want %v", eventRoles, tt.wantAdded)
+			}
+		})
+	}
+}
+
+func TestRegisterIdentityAllowedRegistrarMSPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   string // "" leaves the list unset
+		registrar *testIdentity
+		role      string // role assigned to the registrar
+		wantErr   string
+	}{
+		{name: "unrestricted by default", registrar: newTestIdentity("registrar1", "Org2MSP"), role: "registrar"},
+		{name: "empty list is unrestricted", allowed: `[]`, registrar: newTestIdentity("registrar1", "Org2MSP"), role: "registrar"},
+		{name: "allowed MSP", allowed: `["Org1MSP","Org2MSP"]`, registrar: newTestIdentity("registrar1", "Org2MSP"), role: "registrar"},
+		{name: "disallowed MSP", allowed: `["Org1MSP"]`, registrar: newTestIdentity("registrar1", "Org3MSP"), role: "registrar", wantErr: "caller MSP 'Org3MSP' is not permitted to register identities"},
+		{name: "MSP match is exact", allowed: `["org2msp"]`, registrar: newTestIdentity("registrar1", "Org2MSP"), role: "registrar", wantErr: "not permitted"},
+		{name: "allowed MSP without the registrar role", allowed: `["Org2MSP"]`, registrar: newTestIdentity("farmer9", "Org2MSP"), role: "farmer", wantErr: "not authorized to register identities"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.register(tt.registrar, tt.role)
+			if tt.allowed != "" {
+				e.must(e.cc.SetAllowedRegistrarMSPs(e.as(e.admin), tt.allowed))
+			}
+			newcomer := newTestIdentity("newcomer1", "Org1MSP")
+			err := e.cc.RegisterIdentity(e.as(tt.registrar), newcomer.id, newcomer.alias, newcomer.alias)
+			checkErr(t, err, tt.wantErr)
+			_, errInfo := NewIdentityManager(e.as(e.admin)).GetIdentityInfo(newcomer.id)
+			if registered := errInfo == nil; registered != (tt.wantErr == "") {
+				t.Fatalf("newcomer registered = %t after the call", registered)
+			}
+		})
+	}
+
+	t.Run("registrars cannot update existing identities", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		registrar := newTestIdentity("registrar1", "Org1MSP")
+		e.register(registrar, "registrar")
+		checkErr(t, e.cc.RegisterIdentity(e.as(registrar), e.farmer.id, "renamed", ""), "may only register new identities")
+		_, err := e.cc.RegisterIdentitiesBatch(e.as(registrar), `[{"fullId":"`+e.farmer.id+`","shortName":"renamed"}]`)
+		checkErr(t, err, "may only register new identities")
+		if info, _ := NewIdentityManager(e.as(e.admin)).GetIdentityInfo(e.farmer.id); info.ShortName != e.farmer.alias {
+			t.Fatalf("farmer alias = %q, want %q", info.ShortName, e.farmer.alias)
+		}
+	})
+
+	t.Run("admins are always allowed", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.must(e.cc.SetAllowedRegistrarMSPs(e.as(e.admin), `["Org3MSP"]`))
+		allowed, err := e.cc.GetAllowedRegistrarMSPs(e.as(e.admin))
+		checkErr(t, err, "")
+		if !equalStrings(allowed, []string{"Org3MSP"}) {
+			t.Fatalf("allowed registrar MSPs = %v", allowed)
+		}
+		e.register(newTestIdentity("newcomer1", "Org1MSP"), "")
+	})
+
+	t.Run("non-admin cannot change the list", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetAllowedRegistrarMSPs(e.as(e.farmer), `["Org1MSP"]`), "admin")
+	})
+}
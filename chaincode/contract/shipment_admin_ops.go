@@ -9,7 +9,9 @@ import (
 	"errors"
 	"fmt"
 	"foodtrace/model"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -57,10 +59,14 @@ func (s *FoodtraceSmartContract) BootstrapLedger(ctx contractapi.TransactionCont
 		OrganizationMSP: callerActorInfo.mspID,
 		Roles:           []string{},   // First admin has no other specific roles by default
 		IsAdmin:         true,         // Explicitly set to true
+		IsActive:        true,         // Written explicitly; IsAdmin denies inactive identities
 		RegisteredBy:    callerFullID, // Self-registered during bootstrap
 		RegisteredAt:    nowForBootstrap,
 		LastUpdatedAt:   nowForBootstrap,
 	}
+	if err := im.bindCallerCertFingerprint(&bootstrapAdminInfo); err != nil {
+		return fmt.Errorf("BootstrapLedger: %w", err)
+	}
 	identityKey, keyErr := im.createIdentityCompositeKey(callerFullID)
 	if keyErr != nil {
 		return fmt.Errorf("BootstrapLedger: failed to create identity key for bootstrap admin '%s': %w", callerFullID, keyErr)
@@ -83,8 +89,26 @@ func (s *FoodtraceSmartContract) BootstrapLedger(ctx contractapi.TransactionCont
 		// Consider cleanup if this fails after IdentityInfo is saved? For bootstrap, might be okay to error out.
 		return fmt.Errorf("BootstrapLedger: failed to save bootstrap admin alias mapping '%s' -> '%s': %w", bootstrapAdminAlias, callerFullID, err)
 	}
+	normAliasKey, normAliasKeyErr := im.createNormalizedAliasCompositeKey(bootstrapAdminAlias)
+	if normAliasKeyErr != nil {
+		return fmt.Errorf("BootstrapLedger: failed to create normalized alias key for bootstrap admin '%s': %w", bootstrapAdminAlias, normAliasKeyErr)
+	}
+	if err := ctx.GetStub().PutState(normAliasKey, []byte(callerFullID)); err != nil {
+		return fmt.Errorf("BootstrapLedger: failed to save bootstrap admin normalized alias mapping '%s' -> '%s': %w", bootstrapAdminAlias, callerFullID, err)
+	}
 	logger.Infof("BootstrapLedger: Bootstrap admin alias mapping for '%s' -> '%s' saved directly.", bootstrapAdminAlias, callerFullID)
 
+	// Index the enrollment ID as RegisterIdentity does, so uniqueness checks see the bootstrap admin.
+	if bootstrapAdminEnrollmentID != "" {
+		enrollmentKey, enrollmentKeyErr := im.createEnrollmentCompositeKey(bootstrapAdminEnrollmentID)
+		if enrollmentKeyErr != nil {
+			return fmt.Errorf("BootstrapLedger: failed to create enrollment key for bootstrap admin '%s': %w", bootstrapAdminEnrollmentID, enrollmentKeyErr)
+		}
+		if err := ctx.GetStub().PutState(enrollmentKey, []byte(callerFullID)); err != nil {
+			return fmt.Errorf("BootstrapLedger: failed to save bootstrap admin enrollment mapping '%s' -> '%s': %w", bootstrapAdminEnrollmentID, callerFullID, err)
+		}
+	}
+
 	// 3. Create and save the AdminFlag directly
 	adminFlagKey, flagKeyErr := im.createAdminFlagCompositeKey(callerFullID)
 	if flagKeyErr != nil {
@@ -134,24 +158,129 @@ func (s *FoodtraceSmartContract) ArchiveShipment(ctx contractapi.TransactionCont
 		return fmt.Errorf("ArchiveShipment: failed to get transaction timestamp: %w", err)
 	}
 
+	if err := s.saveArchivedShipment(ctx, shipment, archiveReason, now); err != nil {
+		return fmt.Errorf("ArchiveShipment: %w", err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentArchived", shipment, actor, map[string]interface{}{ // emitShipmentEvent is in shipment_helpers.go
+		"archiveReason": archiveReason, "archivedAt": now.Format(time.RFC3339),
+	})
+	logger.Infof("Shipment '%s' successfully archived by admin '%s'.", shipmentID, actor.alias)
+	return nil
+}
+
+// saveArchivedShipment marks a shipment archived, recording why and when, and writes it to the ledger.
+func (s *FoodtraceSmartContract) saveArchivedShipment(ctx contractapi.TransactionContextInterface, shipment *model.Shipment, archiveReason string, now time.Time) error {
 	shipment.IsArchived = true
-	shipment.LastUpdatedAt = now
-	// shipment.ArchiveReason = archiveReason // Add to model if persistent reason is needed
+	shipment.ArchiveReason = archiveReason
+	shipment.ArchivedAt = now
+	touchShipment(shipment, now)
 
-	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID) // createShipmentCompositeKey is in shipment_helpers.go
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipment.ID) // createShipmentCompositeKey is in shipment_helpers.go
 	shipmentBytes, errMarshal := json.Marshal(shipment)
 	if errMarshal != nil {
-		return fmt.Errorf("ArchiveShipment: failed to marshal shipment '%s': %w", shipmentID, errMarshal)
+		return fmt.Errorf("failed to marshal shipment '%s': %w", shipment.ID, errMarshal)
 	}
 	if errPut := ctx.GetStub().PutState(shipmentKey, shipmentBytes); errPut != nil {
-		return fmt.Errorf("ArchiveShipment: failed to save archived shipment '%s': %w", shipmentID, errPut)
+		return fmt.Errorf("failed to save archived shipment '%s': %w", shipment.ID, errPut)
 	}
-
-	s.emitShipmentEvent(ctx, "ShipmentArchived", shipment, actor, map[string]interface{}{"archiveReason": archiveReason}) // emitShipmentEvent is in shipment_helpers.go
-	logger.Infof("Shipment '%s' successfully archived by admin '%s'.", shipmentID, actor.alias)
 	return nil
 }
 
+// maxAutoArchivePerCall caps how many shipments AutoArchiveTerminalShipments archives in one transaction.
+const maxAutoArchivePerCall = 200
+
+// AutoArchiveTerminalShipments archives non-archived CONSUMED, CONSUMED_IN_PROCESSING and DESTROYED
+// shipments last updated more than olderThanDaysStr days ago, returning how many were archived. At most
+// maxAutoArchivePerCall are archived per call; call again until it returns 0. Admin only. Requires CouchDB
+// to reach eligible shipments beyond the first maxAggregateScan keys.
+func (s *FoodtraceSmartContract) AutoArchiveTerminalShipments(ctx contractapi.TransactionContextInterface, olderThanDaysStr string) (int, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("AutoArchiveTerminalShipments: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return 0, fmt.Errorf("AutoArchiveTerminalShipments: %w. Caller: %s", err, actor.alias)
+	}
+	olderThanDays, err := strconv.Atoi(strings.TrimSpace(olderThanDaysStr))
+	if err != nil || olderThanDays <= 0 {
+		return 0, fmt.Errorf("invalid olderThanDays '%s': must be a positive integer", olderThanDaysStr)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("AutoArchiveTerminalShipments: failed to get transaction timestamp: %w", err)
+	}
+	cutoff := now.AddDate(0, 0, -olderThanDays)
+	terminalStatuses := map[model.ShipmentStatus]bool{
+		model.StatusConsumed: true, model.StatusConsumedInProcessing: true, model.StatusDestroyed: true,
+	}
+
+	// Archived shipments drop out of the selector, so each call picks up where the previous one stopped.
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType":    shipmentObjectType,
+			"status":        map[string]interface{}{"$in": []model.ShipmentStatus{model.StatusConsumed, model.StatusConsumedInProcessing, model.StatusDestroyed}},
+			"isArchived":    false,
+			"lastUpdatedAt": map[string]interface{}{"$lt": cutoff.Format("2006-01-02T15:04:05")},
+		},
+		"use_index": "_design/indexObjectTypeStatusIsArchivedLastUpdatedDoc",
+		"limit":     maxAutoArchivePerCall,
+	}
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return 0, fmt.Errorf("AutoArchiveTerminalShipments: failed to marshal query: %w", err)
+	}
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		logger.Warningf("AutoArchiveTerminalShipments: CouchDB query failed: %v. Falling back to a key scan of the first %d shipments (SLOW).", err, maxAggregateScan)
+		resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
+		if err != nil {
+			return 0, fmt.Errorf("AutoArchiveTerminalShipments: failed to get shipments iterator: %w", err)
+		}
+	}
+	defer resultsIterator.Close()
+
+	archivedIDs := []string{}
+	var lastArchived *model.Shipment
+	scanned := 0
+	for resultsIterator.HasNext() && len(archivedIDs) < maxAutoArchivePerCall {
+		if scanned >= maxAggregateScan {
+			logger.Warningf("AutoArchiveTerminalShipments: stopped after scanning %d shipments; shipments beyond them are only reached with CouchDB", maxAggregateScan)
+			break
+		}
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			return 0, fmt.Errorf("AutoArchiveTerminalShipments: error iterating shipments: %w", iterErr)
+		}
+		scanned++
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("AutoArchiveTerminalShipments: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+			continue
+		}
+		if ship.IsArchived || !terminalStatuses[ship.Status] || !ship.LastUpdatedAt.Before(cutoff) {
+			continue
+		}
+		ensureShipmentSchemaCompliance(&ship)
+		reason := fmt.Sprintf("auto-archived: %s for more than %d days", ship.Status, olderThanDays)
+		if err := s.saveArchivedShipment(ctx, &ship, reason, now); err != nil {
+			return 0, fmt.Errorf("AutoArchiveTerminalShipments: %w", err)
+		}
+		archivedIDs = append(archivedIDs, ship.ID)
+		lastArchived = &ship
+	}
+
+	if lastArchived != nil {
+		s.emitShipmentEvent(ctx, "TerminalShipmentsArchived", lastArchived, actor, map[string]interface{}{
+			"archivedShipmentIDs": archivedIDs, "archivedCount": len(archivedIDs), "olderThanDays": olderThanDays,
+		})
+	}
+	logger.Infof("AutoArchiveTerminalShipments: admin '%s' archived %d terminal shipments older than %d days.", actor.alias, len(archivedIDs), olderThanDays)
+	return len(archivedIDs), nil
+}
+
 func (s *FoodtraceSmartContract) UnarchiveShipment(ctx contractapi.TransactionContextInterface, shipmentID string) error {
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
@@ -184,7 +313,9 @@ func (s *FoodtraceSmartContract) UnarchiveShipment(ctx contractapi.TransactionCo
 	}
 
 	shipment.IsArchived = false
-	shipment.LastUpdatedAt = now
+	shipment.ArchiveReason = ""
+	shipment.ArchivedAt = time.Time{}
+	touchShipment(shipment, now)
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
 	shipmentBytes, errMarshal := json.Marshal(shipment)
@@ -200,12 +331,94 @@ func (s *FoodtraceSmartContract) UnarchiveShipment(ctx contractapi.TransactionCo
 	return nil
 }
 
+// AdminReopenConsumed corrects a shipment marked CONSUMED in error by restoring it to DELIVERED and
+// recording the correction in its AdminOverrides log. CONSUMED_IN_PROCESSING shipments cannot be
+// reopened; their goods became other products.
+func (s *FoodtraceSmartContract) AdminReopenConsumed(ctx contractapi.TransactionContextInterface, shipmentID string, reason string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("AdminReopenConsumed: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return fmt.Errorf("AdminReopenConsumed: %w. Caller: %s", err, actor.alias)
+	}
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(reason, "reason", maxDescriptionLength); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("AdminReopenConsumed: failed to get shipment '%s': %w", shipmentID, err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("AdminReopenConsumed: %w", err)
+	}
+	if shipment.Status == model.StatusConsumedInProcessing {
+		return fmt.Errorf("shipment '%s' was consumed in processing and cannot be reopened", shipmentID)
+	}
+	if shipment.Status != model.StatusConsumed {
+		return fmt.Errorf("shipment '%s' cannot be reopened. Current status: '%s'. Expected '%s'", shipmentID, shipment.Status, model.StatusConsumed)
+	}
+	if len(shipment.RetailUnitIDs) > 0 {
+		return fmt.Errorf("shipment '%s' was split into retail units %v and cannot be reopened", shipmentID, shipment.RetailUnitIDs)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("AdminReopenConsumed: failed to get transaction timestamp: %w", err)
+	}
+	shipment.AdminOverrides = append(shipment.AdminOverrides, model.AdminOverride{
+		Action:         "REOPEN_CONSUMED",
+		Reason:         reason,
+		PreviousStatus: shipment.Status,
+		NewStatus:      model.StatusDelivered,
+		AdminID:        actor.fullID,
+		AdminAlias:     actor.alias,
+		Timestamp:      now,
+	})
+	shipment.Status = model.StatusDelivered
+	// Consumption recorded by MarkShipmentConsumed no longer applies once the shipment is back on the shelf.
+	shipment.RetailerData.ConsumedAt = nil
+	shipment.RetailerData.QuantitySold = nil
+	shipment.RetailerData.ConsumptionNotes = ""
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, errMarshal := json.Marshal(shipment)
+	if errMarshal != nil {
+		return fmt.Errorf("AdminReopenConsumed: failed to marshal shipment '%s': %w", shipmentID, errMarshal)
+	}
+	if errPut := ctx.GetStub().PutState(shipmentKey, shipmentBytes); errPut != nil {
+		return fmt.Errorf("AdminReopenConsumed: failed to save shipment '%s': %w", shipmentID, errPut)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentReopened", shipment, actor, map[string]interface{}{
+		"reason": reason, "previousStatus": model.StatusConsumed,
+	})
+	logger.Infof("Shipment '%s' reopened from %s to %s by admin '%s'.", shipmentID, model.StatusConsumed, model.StatusDelivered, actor.alias)
+	return nil
+}
+
 // --- Test Helper Functions ---
 // IMPORTANT: These functions are for testing/development purposes.
 // They should be removed or heavily guarded in a production environment.
 
 func (s *FoodtraceSmartContract) TestGetCallerIdentity(ctx contractapi.TransactionContextInterface) (map[string]string, error) {
 	logger.Warning("TESTING FUNCTION TestGetCallerIdentity called. This should NOT be used in production directly.")
+	if err := NewIdentityManager(ctx).requireTestFunctionsEnabled("TestGetCallerIdentity"); err != nil {
+		return nil, err
+	}
+	return s.GetCallerIdentity(ctx)
+}
+
+// GetCallerIdentity reports the caller's full ID, alias, enrollment ID and MSP ID. Unlike
+// TestGetCallerIdentity it is always available, so applications can look up a new user's full ID.
+func (s *FoodtraceSmartContract) GetCallerIdentity(ctx contractapi.TransactionContextInterface) (map[string]string, error) {
 	im := NewIdentityManager(ctx)
 	fullID, err := im.GetCurrentIdentityFullID()
 	if err != nil {
@@ -228,7 +441,7 @@ func (s *FoodtraceSmartContract) TestGetCallerIdentity(ctx contractapi.Transacti
 			enrollID = idInfo.EnrollmentID
 		}
 	} else if fullID != "" && !strings.HasPrefix(fullID, "ERROR") { // Only log if fullID was obtained and not an error itself
-		logger.Debugf("TestGetCallerIdentity: Could not get IdentityInfo for %s: %v", fullID, errInfo)
+		logger.Debugf("GetCallerIdentity: Could not get IdentityInfo for %s: %v", fullID, errInfo)
 	}
 	return map[string]string{"fullId": fullID, "alias": alias, "enrollmentId": enrollID, "mspId": mspID}, nil
 }
@@ -236,6 +449,9 @@ func (s *FoodtraceSmartContract) TestGetCallerIdentity(ctx contractapi.Transacti
 func (s *FoodtraceSmartContract) TestAssignRoleToSelf(ctx contractapi.TransactionContextInterface, role string) error {
 	logger.Warningf("TESTING FUNCTION TestAssignRoleToSelf called for role '%s'. This should NOT be used in production directly.", role)
 	im := NewIdentityManager(ctx)
+	if err := im.requireTestFunctionsEnabled("TestAssignRoleToSelf"); err != nil {
+		return err
+	}
 	actorInfoFromContract, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("TestAssignRoleToSelf: failed to get caller info: %w", err)
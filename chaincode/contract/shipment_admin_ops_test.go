@@ -0,0 +1,383 @@
+package contract
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestAdminReopenConsumed(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(e *testEnv) // leaves SHIP-1 in the state under test
+		caller  func(e *testEnv) *testIdentity
+		reason  string
+		wantErr string
+	}{
+		{
+			name: "consumed shipment reopened",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", `{"quantitySold": 80, "notes": "Sold out"}`))
+			},
+			reason: "Marked consumed by mistake",
+		},
+		{
+			name: "consumed in processing rejected",
+			setup: func(e *testEnv) {
+				e.createShipment("SHIP-1")
+				e.process("SHIP-1")
+				e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"SHIP-1"}]`,
+					`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`, e.processorData(nil), false))
+			},
+			reason:  "Marked consumed by mistake",
+			wantErr: "consumed in processing and cannot be reopened",
+		},
+		{
+			name: "split into retail units rejected",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				units, _ := json.Marshal([]model.NewProductDetail{{NewShipmentID: "UNIT-1", ProductName: "Punnet", Quantity: 100, UnitOfMeasure: "kg"}})
+				e.must(e.cc.SplitForRetail(e.as(e.retailer), "SHIP-1", string(units)))
+			},
+			reason:  "Marked consumed by mistake",
+			wantErr: "split into retail units",
+		},
+		{name: "delivered shipment rejected", setup: func(e *testEnv) { e.deliveredShipment("SHIP-1") }, reason: "Marked consumed by mistake", wantErr: "Expected 'CONSUMED'"},
+		{
+			name: "non-admin rejected",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", ""))
+			},
+			caller:  func(e *testEnv) *testIdentity { return e.retailer },
+			reason:  "Marked consumed by mistake",
+			wantErr: "admin",
+		},
+		{
+			name: "reason required",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", ""))
+			},
+			reason:  " ",
+			wantErr: "reason",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			tt.setup(e)
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1").Status
+
+			err := e.cc.AdminReopenConsumed(e.as(caller), "SHIP-1", tt.reason)
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != before || len(shipment.AdminOverrides) != 0 {
+					t.Fatalf("rejected reopen changed the shipment: status %s, %d overrides", shipment.Status, len(shipment.AdminOverrides))
+				}
+				return
+			}
+
+			if shipment.Status != model.StatusDelivered {
+				t.Fatalf("status = %s, want %s", shipment.Status, model.StatusDelivered)
+			}
+			if rd := shipment.RetailerData; rd.ConsumedAt != nil || rd.QuantitySold != nil || rd.ConsumptionNotes != "" {
+				t.Fatalf("consumption details kept after reopen: %+v", rd)
+			}
+			if len(shipment.AdminOverrides) != 1 {
+				t.Fatalf("%d admin overrides, want 1", len(shipment.AdminOverrides))
+			}
+			override := shipment.AdminOverrides[0]
+			if override.Action != "REOPEN_CONSUMED" || override.Reason != tt.reason || override.PreviousStatus != model.StatusConsumed ||
+				override.NewStatus != model.StatusDelivered || override.AdminID != e.admin.id || !override.Timestamp.Equal(e.now) {
+				t.Fatalf("override = %+v", override)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentReopened" || payload["reason"] != tt.reason || payload["previousStatus"] != string(model.StatusConsumed) {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+
+			// The reopened shipment is back on the shelf and can be consumed again.
+			e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", ""))
+		})
+	}
+}
+
+func TestTestFunctionsGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		adminExists bool
+		setting     string // "on" or "off" is stored by the admin; "" leaves the setting unset
+		wantErr     string
+	}{
+		{name: "unset before the first admin allowed"},
+		{name: "unset once an admin exists blocked", adminExists: true, wantErr: "test functions are disabled"},
+		{name: "enabled by admin allowed", adminExists: true, setting: "on"},
+		{name: "disabled by admin blocked", adminExists: true, setting: "off", wantErr: "test functions are disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEnv(t)
+			if tt.adminExists {
+				e = newSupplyChainEnv(t)
+			}
+			if tt.setting != "" {
+				e.must(e.cc.SetTestFunctionsEnabled(e.as(e.admin), tt.setting == "on"))
+			}
+			roles := func() []string {
+				info, err := NewIdentityManager(e.inTx(e.admin)).GetIdentityInfo(e.farmer.id)
+				if err != nil {
+					return nil
+				}
+				return info.Roles
+			}
+			before := roles()
+
+			caller, err := e.cc.TestGetCallerIdentity(e.as(e.farmer))
+			checkErr(t, err, tt.wantErr)
+			if err == nil && caller["fullId"] != e.farmer.id {
+				t.Fatalf("TestGetCallerIdentity fullId = %s, want %s", caller["fullId"], e.farmer.id)
+			}
+			checkErr(t, e.cc.TestAssignRoleToSelf(e.as(e.farmer), "retailer"), tt.wantErr)
+			checkErr(t, NewIdentityManager(e.as(e.farmer)).AssignRoleUncheckedForTest(e.farmer.alias, "certifier"), tt.wantErr)
+
+			got := roles()
+			if tt.wantErr != "" {
+				if !equalStrings(got, before) {
+					t.Fatalf("blocked helpers changed roles from %v to %v", before, got)
+				}
+				return
+			}
+			want := append(before, "retailer", "certifier")
+			if !equalStrings(got, want) {
+				t.Fatalf("roles = %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("blocked once the first admin is bootstrapped", func(t *testing.T) {
+		e := newTestEnv(t)
+		e.must(e.cc.TestAssignRoleToSelf(e.as(e.farmer), "farmer"))
+		e.must(e.cc.BootstrapLedger(e.as(e.admin)))
+		_, err := e.cc.TestGetCallerIdentity(e.as(e.farmer))
+		checkErr(t, err, "TestGetCallerIdentity is a test function")
+		checkErr(t, e.cc.TestAssignRoleToSelf(e.as(e.farmer), "retailer"), "TestAssignRoleToSelf is a test function")
+	})
+
+	t.Run("only admins toggle the setting", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetTestFunctionsEnabled(e.as(e.farmer), true), "admin")
+		_, err := e.cc.TestGetCallerIdentity(e.as(e.farmer))
+		checkErr(t, err, "test functions are disabled")
+	})
+}
+
+func TestAutoArchiveTerminalShipments(t *testing.T) {
+	consume := func(e *testEnv, id string) {
+		e.deliveredShipment(id)
+		e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), id, ""))
+	}
+	destroy := func(e *testEnv, id string) {
+		e.createShipment(id)
+		e.must(e.cc.InitiateRecall(e.as(e.farmer), id, "RC-"+id, "Listeria detected"))
+		e.must(e.cc.RecordDestruction(e.as(e.farmer), id, "Incineration", ""))
+	}
+	consumeInProcessing := func(e *testEnv, id string) {
+		e.createShipment(id)
+		e.process(id)
+		e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"`+id+`"}]`,
+			`[{"newShipmentId":"JAM-`+id+`","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`, e.processorData(nil), false))
+	}
+	shipments := []struct {
+		id           string
+		build        func(e *testEnv, id string)
+		recent       bool // last updated 15 days before the call instead of 45
+		wantArchived bool
+	}{
+		{id: "OLD-CONSUMED", build: consume, wantArchived: true},
+		{id: "OLD-DESTROYED", build: destroy, wantArchived: true},
+		{id: "OLD-CONSUMED-IN-PROCESSING", build: consumeInProcessing, wantArchived: true},
+		{id: "OLD-DELIVERED", build: func(e *testEnv, id string) { e.deliveredShipment(id) }},
+		{id: "RECENT-CONSUMED", build: consume, recent: true},
+		{id: "RECENT-DESTROYED", build: destroy, recent: true},
+	}
+
+	e := newSupplyChainEnv(t)
+	for _, recent := range []bool{false, true} {
+		if recent {
+			e.now = e.now.AddDate(0, 0, 30)
+		}
+		for _, s := range shipments {
+			if s.recent == recent {
+				s.build(e, s.id)
+			}
+		}
+	}
+	e.now = e.now.AddDate(0, 0, 15)
+
+	count, err := e.cc.AutoArchiveTerminalShipments(e.as(e.admin), "30")
+	e.must(err)
+	want := []string{}
+	for _, s := range shipments {
+		if s.wantArchived {
+			want = append(want, s.id)
+		}
+		if archived := e.shipment(s.id).IsArchived; archived != s.wantArchived {
+			t.Errorf("%s archived = %t, want %t", s.id, archived, s.wantArchived)
+		}
+	}
+	if count != len(want) {
+		t.Fatalf("count = %d, want %d", count, len(want))
+	}
+	name, payload := e.lastEvent()
+	gotIDs := []string{}
+	for _, id := range payload["archivedShipmentIDs"].([]interface{}) {
+		gotIDs = append(gotIDs, id.(string))
+	}
+	sort.Strings(gotIDs)
+	sort.Strings(want)
+	if name != "TerminalShipmentsArchived" || !equalStrings(gotIDs, want) {
+		t.Fatalf("event %s archived %v, want %v", name, gotIDs, want)
+	}
+
+	count, err = e.cc.AutoArchiveTerminalShipments(e.as(e.admin), "30")
+	e.must(err)
+	if count != 0 {
+		t.Fatalf("second call archived %d shipments, want 0", count)
+	}
+
+	t.Run("capped per call", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		consume(e, "SHIP-0")
+		base := e.shipment("SHIP-0")
+		for i := 1; i <= maxAutoArchivePerCall; i++ {
+			copied := *base
+			copied.ID = "SHIP-" + strconv.Itoa(i)
+			e.putShipment(&copied)
+		}
+		e.now = e.now.AddDate(0, 0, 45)
+		for _, want := range []int{maxAutoArchivePerCall, 1, 0} {
+			count, err := e.cc.AutoArchiveTerminalShipments(e.as(e.admin), "30")
+			checkErr(t, err, "")
+			if count != want {
+				t.Fatalf("count = %d, want %d", count, want)
+			}
+		}
+	})
+
+	rejections := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		days    string
+		wantErr string
+	}{
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.retailer }, days: "30", wantErr: "admin"},
+		{name: "zero days rejected", days: "0", wantErr: "invalid olderThanDays '0'"},
+		{name: "non-numeric days rejected", days: "month", wantErr: "invalid olderThanDays 'month'"},
+	}
+	for _, tt := range rejections {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			_, err := e.cc.AutoArchiveTerminalShipments(e.as(caller), tt.days)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestArchiveShipmentReason(t *testing.T) {
+	type step struct {
+		unarchive bool
+		reason    string
+	}
+	tests := []struct {
+		name         string
+		steps        []step
+		wantArchived bool
+		wantReason   string
+		wantAtStep   int // step whose transaction time ArchivedAt holds; -1 for zero
+	}{
+		{name: "archive with reason", steps: []step{{reason: "Duplicate entry"}}, wantArchived: true, wantReason: "Duplicate entry", wantAtStep: 0},
+		{name: "archive without reason", steps: []step{{}}, wantArchived: true, wantAtStep: 0},
+		{name: "archiving again keeps the original record", steps: []step{{reason: "First"}, {reason: "Second"}}, wantArchived: true, wantReason: "First", wantAtStep: 0},
+		{name: "unarchive clears the record", steps: []step{{reason: "First"}, {unarchive: true}}, wantAtStep: -1},
+		{name: "re-archive records the new reason", steps: []step{{reason: "First"}, {unarchive: true}, {reason: "Second"}}, wantArchived: true, wantReason: "Second", wantAtStep: 2},
+		{name: "unarchiving an active shipment", steps: []step{{unarchive: true}}, wantAtStep: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.deliveredShipment("SHIP-1")
+			var stepTimes []time.Time
+			for _, s := range tt.steps {
+				ctx := e.as(e.admin)
+				stepTimes = append(stepTimes, e.now)
+				if s.unarchive {
+					e.must(e.cc.UnarchiveShipment(ctx, "SHIP-1"))
+				} else {
+					e.must(e.cc.ArchiveShipment(ctx, "SHIP-1", s.reason))
+				}
+			}
+
+			shipment := e.shipment("SHIP-1")
+			wantAt := time.Time{}
+			if tt.wantAtStep >= 0 {
+				wantAt = stepTimes[tt.wantAtStep]
+			}
+			if shipment.IsArchived != tt.wantArchived || shipment.ArchiveReason != tt.wantReason || !shipment.ArchivedAt.Equal(wantAt) {
+				t.Fatalf("archived %t, reason %q, at %s; want %t, %q, %s", shipment.IsArchived, shipment.ArchiveReason, shipment.ArchivedAt, tt.wantArchived, tt.wantReason, wantAt)
+			}
+			last := tt.steps[len(tt.steps)-1]
+			if last.unarchive || tt.wantAtStep != len(tt.steps)-1 {
+				return // No ShipmentArchived event in the last transaction
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentArchived" || payload["archiveReason"] != tt.wantReason || payload["archivedAt"] != wantAt.Format(time.RFC3339) {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+
+	t.Run("rejections", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.deliveredShipment("SHIP-1")
+		checkErr(t, e.cc.ArchiveShipment(e.as(e.admin), "SHIP-1", strings.Repeat("r", maxDescriptionLength+1)), "archiveReason")
+		checkErr(t, e.cc.ArchiveShipment(e.as(e.retailer), "SHIP-1", "Duplicate entry"), "admin")
+		if shipment := e.shipment("SHIP-1"); shipment.IsArchived || shipment.ArchiveReason != "" || !shipment.ArchivedAt.IsZero() {
+			t.Fatalf("rejected archive left archived %t, reason %q, at %s", shipment.IsArchived, shipment.ArchiveReason, shipment.ArchivedAt)
+		}
+	})
+
+	t.Run("records without the fields read as zero", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		ctx := e.as(e.admin)
+		key, err := e.cc.createShipmentCompositeKey(ctx, "SHIP-1")
+		e.must(err)
+		stored, err := ctx.GetStub().GetState(key)
+		e.must(err)
+		var record map[string]interface{}
+		e.must(json.Unmarshal(stored, &record))
+		delete(record, "archiveReason")
+		delete(record, "archivedAt")
+		legacy, _ := json.Marshal(record)
+		e.must(ctx.GetStub().PutState(key, legacy))
+
+		if shipment := e.shipment("SHIP-1"); shipment.ArchiveReason != "" || !shipment.ArchivedAt.IsZero() {
+			t.Fatalf("legacy record read as reason %q, at %s", shipment.ArchiveReason, shipment.ArchivedAt)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// Author: Muhammad-Tameem Mughal
+// Last updated: Aug 15, 2025
+// Last modified by: Muhammad-Tameem Mughal
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"foodtrace/model"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// --- Attention Operations (investigation flags, holds) ---
+
+// FlagShipment marks a shipment for investigation without recalling it. The shipment keeps
+// transacting normally but shows up in GetShipmentsNeedingAttention. Callable by the current owner or an admin.
+func (s *FoodtraceSmartContract) FlagShipment(ctx contractapi.TransactionContextInterface, shipmentID string, reason string) error {
+	if err := s.validateRequiredString(reason, "reason", maxDescriptionLength); err != nil {
+		return err
+	}
+	shipment, actor, err := s.getShipmentForOwnerOrAdmin(ctx, shipmentID, "FlagShipment")
+	if err != nil {
+		return err
+	}
+
+	before := snapshotShipment(shipment)
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("FlagShipment: failed to get transaction timestamp: %w", err)
+	}
+	shipment.UnderInvestigation = true
+	shipment.InvestigationReason = reason
+	shipment.InvestigationFlaggedBy = actor.fullID
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("FlagShipment: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("FlagShipment: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentFlagged", before, shipment, actor, map[string]interface{}{"reason": reason})
+	logger.Infof("Shipment '%s' flagged for investigation by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// ClearInvestigation removes an investigation flag. Callable by the current owner or an admin.
+func (s *FoodtraceSmartContract) ClearInvestigation(ctx contractapi.TransactionContextInterface, shipmentID string) error {
+	shipment, actor, err := s.getShipmentForOwnerOrAdmin(ctx, shipmentID, "ClearInvestigation")
+	if err != nil {
+		return err
+	}
+	if !shipment.UnderInvestigation {
+		return fmt.Errorf("shipment '%s' is not under investigation", shipmentID)
+	}
+
+	before := snapshotShipment(shipment)
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("ClearInvestigation: failed to get transaction timestamp: %w", err)
+	}
+	previousReason := shipment.InvestigationReason
+	shipment.UnderInvestigation = false
+	shipment.InvestigationReason = ""
+	shipment.InvestigationFlaggedBy = ""
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("ClearInvestigation: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("ClearInvestigation: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentInvestigationCleared", before, shipment, actor, map[string]interface{}{"previousReason": previousReason})
+	logger.Infof("Investigation on shipment '%s' cleared by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// PlaceHold blocks lifecycle transitions on a shipment (e.g. pending paperwork) until ReleaseHold is called.
+// Callable by the current owner or an admin.
+func (s *FoodtraceSmartContract) PlaceHold(ctx contractapi.TransactionContextInterface, shipmentID string, reason string) error {
+	if err := s.validateRequiredString(reason, "reason", maxDescriptionLength); err != nil {
+		return err
+	}
+	shipment, actor, err := s.getShipmentForOwnerOrAdmin(ctx, shipmentID, "PlaceHold")
+	if err != nil {
+		return err
+	}
+	if shipment.OnHold {
+		return fmt.Errorf("shipment '%s' is already on hold (reason: %s)", shipmentID, shipment.HoldReason)
+	}
+
+	before := snapshotShipment(shipment)
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("PlaceHold: failed to get transaction timestamp: %w", err)
+	}
+	shipment.OnHold = true
+	shipment.HoldReason = reason
+	shipment.HoldPlacedBy = actor.fullID
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("PlaceHold: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("PlaceHold: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentHoldPlaced", before, shipment, actor, map[string]interface{}{"reason": reason})
+	logger.Infof("Hold placed on shipment '%s' by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// ReleaseHold clears a hold so the shipment can continue through its lifecycle. Callable by the current owner or an admin.
+func (s *FoodtraceSmartContract) ReleaseHold(ctx contractapi.TransactionContextInterface, shipmentID string) error {
+	shipment, actor, err := s.getShipmentForOwnerOrAdmin(ctx, shipmentID, "ReleaseHold")
+	if err != nil {
+		return err
+	}
+	if !shipment.OnHold {
+		return fmt.Errorf("shipment '%s' is not on hold", shipmentID)
+	}
+
+	before := snapshotShipment(shipment)
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("ReleaseHold: failed to get transaction timestamp: %w", err)
+	}
+	previousReason := shipment.HoldReason
+	shipment.OnHold = false
+	shipment.HoldReason = ""
+	shipment.HoldPlacedBy = ""
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("ReleaseHold: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("ReleaseHold: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentHoldReleased", before, shipment, actor, map[string]interface{}{"previousReason": previousReason})
+	logger.Infof("Hold on shipment '%s' released by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// GetShipmentsNeedingAttention is the attention feed: non-archived shipments currently flagged for investigation.
+func (s *FoodtraceSmartContract) GetShipmentsNeedingAttention(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	pageSize := parsePageSize(ctx, pageSizeStr)
+	logger.Infof("GetShipmentsNeedingAttention: Getting flagged shipments (pageSize: %d, bookmark: '%s')", pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":         shipmentObjectType,
+		"underInvestigation": true,
+		"isArchived":         false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsNeedingAttention", selector, "indexObjectTypeUnderInvestigationIsArchivedDoc", pageSize, bookmark)
+}
+
+// getShipmentForOwnerOrAdmin loads a non-archived shipment and verifies the caller is its current owner or an admin.
+func (s *FoodtraceSmartContract) getShipmentForOwnerOrAdmin(ctx contractapi.TransactionContextInterface, shipmentID, fnName string) (*model.Shipment, *actorInfo, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: failed to get actor info: %w", fnName, err)
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, nil, err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fnName, err)
+	}
+	isCallerAdmin, err := NewIdentityManager(ctx).IsCurrentUserAdmin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: failed to verify caller admin status: %w", fnName, err)
+	}
+	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
+		return nil, nil, fmt.Errorf("unauthorized: only current owner ('%s', alias '%s') or admin can perform %s on shipment '%s'", shipment.CurrentOwnerID, shipment.CurrentOwnerAlias, fnName, shipmentID)
+	}
+	if shipment.IsArchived {
+		return nil, nil, fmt.Errorf("%s: shipment '%s' is archived", fnName, shipmentID)
+	}
+	return shipment, actor, nil
+}
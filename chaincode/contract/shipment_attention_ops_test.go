@@ -0,0 +1,173 @@
+package contract
+
+import "testing"
+
+// attentionFeed returns the IDs in the attention feed.
+func (e *testEnv) attentionFeed() []string {
+	e.t.Helper()
+	resp, err := e.cc.GetShipmentsNeedingAttention(e.as(e.admin), "", "")
+	e.must(err)
+	return shipmentIDs(resp)
+}
+
+func TestFlagShipment(t *testing.T) {
+	tests := []struct {
+		name            string
+		caller          func(e *testEnv) *testIdentity
+		reason          string
+		adminCheckFails bool
+		wantErr         string
+	}{
+		{name: "owner flags", reason: "Customer complaint"},
+		{name: "admin flags", caller: func(e *testEnv) *testIdentity { return e.admin }, reason: "Audit sample"},
+		{name: "non-owner rejected", caller: func(e *testEnv) *testIdentity { return e.retailer }, reason: "Looks off", wantErr: "only current owner"},
+		{name: "missing reason", reason: "", wantErr: "reason cannot be empty"},
+		{name: "admin check error surfaced", reason: "Customer complaint", adminCheckFails: true, wantErr: "FlagShipment: failed to verify caller admin status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.createShipment("SHIP-2")
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			if tt.adminCheckFails {
+				e.stub.failGet = adminFlagObjectType
+			}
+			err := e.cc.FlagShipment(e.as(caller), "SHIP-1", tt.reason)
+			e.stub.failGet = ""
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.UnderInvestigation || len(e.attentionFeed()) != 0 {
+					t.Fatal("rejected flag was recorded")
+				}
+				return
+			}
+
+			if !shipment.UnderInvestigation || shipment.InvestigationReason != tt.reason || shipment.InvestigationFlaggedBy != caller.id {
+				t.Fatalf("investigation = %t, %q by %s", shipment.UnderInvestigation, shipment.InvestigationReason, shipment.InvestigationFlaggedBy)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentFieldChanged" || payload["action"] != "ShipmentFlagged" || payload["reason"] != tt.reason {
+				t.Fatalf("event %s with payload %v", name, payload)
+			}
+			if got := e.attentionFeed(); !equalStrings(got, []string{"SHIP-1"}) {
+				t.Fatalf("attention feed = %v, want [SHIP-1]", got)
+			}
+		})
+	}
+}
+
+func TestClearInvestigation(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-1")
+	e.createShipment("SHIP-2")
+	checkErr(t, e.cc.ClearInvestigation(e.as(e.farmer), "SHIP-1"), "not under investigation")
+
+	e.must(e.cc.FlagShipment(e.as(e.farmer), "SHIP-1", "Customer complaint"))
+	e.must(e.cc.FlagShipment(e.as(e.admin), "SHIP-2", "Audit sample"))
+	// Flagged shipments keep transacting and stay in the feed under their new owner.
+	e.process("SHIP-1")
+	if got := e.attentionFeed(); !equalStrings(got, []string{"SHIP-1", "SHIP-2"}) {
+		t.Fatalf("attention feed = %v, want [SHIP-1 SHIP-2]", got)
+	}
+
+	checkErr(t, e.cc.ClearInvestigation(e.as(e.farmer), "SHIP-1"), "only current owner")
+	e.must(e.cc.ClearInvestigation(e.as(e.processor), "SHIP-1"))
+	if name, payload := e.lastEvent(); name != "ShipmentFieldChanged" || payload["action"] != "ShipmentInvestigationCleared" || payload["previousReason"] != "Customer complaint" {
+		t.Fatalf("event %s with payload %v", name, payload)
+	}
+	shipment := e.shipment("SHIP-1")
+	if shipment.UnderInvestigation || shipment.InvestigationReason != "" || shipment.InvestigationFlaggedBy != "" {
+		t.Fatalf("investigation not cleared: %t, %q by %s", shipment.UnderInvestigation, shipment.InvestigationReason, shipment.InvestigationFlaggedBy)
+	}
+	if got := e.attentionFeed(); !equalStrings(got, []string{"SHIP-2"}) {
+		t.Fatalf("attention feed = %v, want [SHIP-2]", got)
+	}
+
+	requireIndexFields(t, "indexObjectTypeUnderInvestigationIsArchived", "objectType", "underInvestigation", "isArchived")
+}
+
+func TestShipmentHold(t *testing.T) {
+	transitions := []struct {
+		name    string
+		prepare func(e *testEnv) // brings SHIP-1 to the stage before the transition
+		holder  func(e *testEnv) *testIdentity
+		advance func(e *testEnv) error
+	}{
+		{
+			name:   "ProcessShipment",
+			holder: func(e *testEnv) *testIdentity { return e.farmer },
+			advance: func(e *testEnv) error {
+				return e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(nil), "")
+			},
+		},
+		{
+			name:    "SubmitForCertification",
+			holder:  func(e *testEnv) *testIdentity { return e.farmer },
+			advance: func(e *testEnv) error { return e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1") },
+		},
+		{
+			name:    "DistributeShipment",
+			prepare: func(e *testEnv) { e.process("SHIP-1") },
+			holder:  func(e *testEnv) *testIdentity { return e.processor },
+			advance: func(e *testEnv) error {
+				return e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(nil))
+			},
+		},
+		{
+			name:    "ReceiveShipment",
+			prepare: func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			holder:  func(e *testEnv) *testIdentity { return e.admin },
+			advance: func(e *testEnv) error { return e.cc.ReceiveShipment(e.as(e.retailer), "SHIP-1", e.retailerData(nil)) },
+		},
+	}
+	for _, tr := range transitions {
+		t.Run(tr.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tr.prepare != nil {
+				tr.prepare(e)
+			}
+			holder := tr.holder(e)
+			e.must(e.cc.PlaceHold(e.as(holder), "SHIP-1", "Awaiting phytosanitary certificate"))
+			before := e.shipment("SHIP-1").Status
+
+			checkErr(t, tr.advance(e), "is on hold (reason: Awaiting phytosanitary certificate)")
+			if status := e.shipment("SHIP-1").Status; status != before {
+				t.Fatalf("held shipment moved from %s to %s", before, status)
+			}
+			e.must(e.cc.ReleaseHold(e.as(holder), "SHIP-1"))
+			e.must(tr.advance(e))
+		})
+	}
+
+	t.Run("hold bookkeeping", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		checkErr(t, e.cc.ReleaseHold(e.as(e.farmer), "SHIP-1"), "is not on hold")
+		checkErr(t, e.cc.PlaceHold(e.as(e.retailer), "SHIP-1", "Paperwork"), "only current owner")
+		checkErr(t, e.cc.PlaceHold(e.as(e.farmer), "SHIP-1", " "), "reason cannot be empty")
+
+		e.must(e.cc.PlaceHold(e.as(e.farmer), "SHIP-1", "Paperwork"))
+		if name, payload := e.lastEvent(); name != "ShipmentFieldChanged" || payload["action"] != "ShipmentHoldPlaced" || payload["reason"] != "Paperwork" {
+			t.Fatalf("event %s with payload %v", name, payload)
+		}
+		shipment := e.shipment("SHIP-1")
+		if !shipment.OnHold || shipment.HoldReason != "Paperwork" || shipment.HoldPlacedBy != e.farmer.id {
+			t.Fatalf("hold = %t, %q by %s", shipment.OnHold, shipment.HoldReason, shipment.HoldPlacedBy)
+		}
+		checkErr(t, e.cc.PlaceHold(e.as(e.admin), "SHIP-1", "Again"), "already on hold (reason: Paperwork)")
+
+		e.must(e.cc.ReleaseHold(e.as(e.admin), "SHIP-1"))
+		if name, payload := e.lastEvent(); name != "ShipmentFieldChanged" || payload["action"] != "ShipmentHoldReleased" || payload["previousReason"] != "Paperwork" {
+			t.Fatalf("event %s with payload %v", name, payload)
+		}
+		if shipment := e.shipment("SHIP-1"); shipment.OnHold || shipment.HoldReason != "" || shipment.HoldPlacedBy != "" {
+			t.Fatalf("hold not released: %t, %q by %s", shipment.OnHold, shipment.HoldReason, shipment.HoldPlacedBy)
+		}
+	})
+}
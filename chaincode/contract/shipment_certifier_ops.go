@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"foodtrace/model"
+	"sort"
 	"strings"
 	"time"
 
@@ -30,6 +31,9 @@ func (s *FoodtraceSmartContract) SubmitForCertification(ctx contractapi.Transact
 	if err != nil {
 		return fmt.Errorf("SubmitForCertification: %w", err)
 	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("SubmitForCertification: %w", err)
+	}
 
 	isCallerAdmin, _ := im.IsCurrentUserAdmin()
 	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
@@ -45,12 +49,22 @@ func (s *FoodtraceSmartContract) SubmitForCertification(ctx contractapi.Transact
 	if shipment.RecallInfo.IsRecalled {
 		return fmt.Errorf("recalled shipment '%s' cannot be submitted for certification", shipmentID)
 	}
+	if shipment.Status == model.StatusVoided {
+		return fmt.Errorf("voided shipment '%s' cannot be submitted for certification", shipmentID)
+	}
 	if shipment.Status == model.StatusDistributed || shipment.Status == model.StatusDelivered || shipment.Status == model.StatusConsumed {
 		return fmt.Errorf("shipment '%s' is too far in the supply chain (Status: %s) to be submitted for certification", shipmentID, shipment.Status)
 	}
 	if shipment.Status != model.StatusCreated && shipment.Status != model.StatusProcessed {
 		logger.Warningf("Shipment '%s' is being submitted for certification from an unusual prior status: %s. Allowed.", shipmentID, shipment.Status)
 	}
+	requiredFields, err := getCertificationFarmerFields(ctx)
+	if err != nil {
+		return fmt.Errorf("SubmitForCertification: failed to read required farmer fields: %w", err)
+	}
+	if missing := missingFarmerFields(shipment.FarmerData, requiredFields); len(missing) > 0 {
+		return fmt.Errorf("shipment '%s' cannot be submitted for certification; missing farmer data: %s", shipmentID, strings.Join(missing, ", "))
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
@@ -58,7 +72,7 @@ func (s *FoodtraceSmartContract) SubmitForCertification(ctx contractapi.Transact
 	}
 
 	shipment.Status = model.StatusPendingCertification
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
 	shipmentBytes, err := json.Marshal(shipment)
@@ -74,6 +88,10 @@ func (s *FoodtraceSmartContract) SubmitForCertification(ctx contractapi.Transact
 	return nil
 }
 
+// inspectionDateClockSkew is the tolerance allowed between client clocks and the transaction
+// timestamp when validating inspection dates.
+const inspectionDateClockSkew = 5 * time.Minute
+
 func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.TransactionContextInterface,
 	shipmentID string, inspectionDateStr string, inspectionReportHash string,
 	certStatusStr string, comments string) error {
@@ -99,7 +117,11 @@ func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.Transaction
 	if err := s.validateOptionalString(inspectionReportHash, "inspectionReportHash", maxStringInputLength); err != nil {
 		return err
 	}
-	if err := s.validateOptionalString(comments, "comments", maxDescriptionLength); err != nil {
+	maxLen, err := fieldMaxLength(ctx, fieldLimitCertificationComments)
+	if err != nil {
+		return fmt.Errorf("RecordCertification: failed to read length limit: %w", err)
+	}
+	if err := s.validateOptionalString(comments, "comments", maxLen); err != nil {
 		return err
 	}
 
@@ -123,6 +145,9 @@ func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.Transaction
 	if err != nil {
 		return fmt.Errorf("RecordCertification: %w", err)
 	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("RecordCertification: %w", err)
+	}
 
 	if (certStatus == model.CertStatusApproved || certStatus == model.CertStatusRejected) && shipment.Status != model.StatusPendingCertification {
 		isCallerAdmin, _ := im.IsCurrentUserAdmin()
@@ -135,11 +160,31 @@ func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.Transaction
 	if shipment.RecallInfo.IsRecalled {
 		return fmt.Errorf("recalled shipment '%s' cannot have certification recorded", shipmentID)
 	}
+	if reportHash := strings.TrimSpace(inspectionReportHash); reportHash != "" {
+		for i, record := range shipment.CertificationRecords {
+			if strings.EqualFold(strings.TrimSpace(record.InspectionReportHash), reportHash) {
+				return fmt.Errorf("inspectionReportHash '%s' is already used by certification record %d of shipment '%s'", reportHash, i, shipmentID)
+			}
+		}
+	}
+	if shipment.DesignatedCertifierID != "" && shipment.DesignatedCertifierID != actor.fullID {
+		isCallerAdmin, _ := im.IsCurrentUserAdmin()
+		if !isCallerAdmin {
+			return fmt.Errorf("unauthorized: shipment '%s' is designated to certifier '%s'", shipmentID, shipment.DesignatedCertifierID)
+		}
+		logger.Warningf("Admin '%s' is recording certification on shipment '%s' designated to '%s'", actor.alias, shipmentID, shipment.DesignatedCertifierID)
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
 		return fmt.Errorf("RecordCertification: failed to get transaction timestamp: %w", err)
 	}
+	if inspectionDate.After(now.Add(inspectionDateClockSkew)) {
+		return fmt.Errorf("inspectionDate (%s) cannot be in the future (transaction time %s)", inspectionDate.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+	if !shipment.CreatedAt.IsZero() && inspectionDate.Before(shipment.CreatedAt.Add(-inspectionDateClockSkew)) {
+		return fmt.Errorf("inspectionDate (%s) cannot be before shipment '%s' was created (%s)", inspectionDate.Format(time.RFC3339), shipmentID, shipment.CreatedAt.Format(time.RFC3339))
+	}
 
 	newCertificationRecord := model.CertificationRecord{
 		CertifierID: actor.fullID, CertifierAlias: actor.alias, InspectionDate: inspectionDate,
@@ -157,7 +202,7 @@ func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.Transaction
 			shipment.Status = model.StatusPendingCertification
 		}
 	}
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
 	shipmentBytes, err := json.Marshal(shipment)
@@ -176,3 +221,338 @@ func (s *FoodtraceSmartContract) RecordCertification(ctx contractapi.Transaction
 	logger.Infof("Certification recorded for shipment '%s' by certifier '%s'. New overall status: '%s'", shipmentID, actor.alias, shipment.Status)
 	return nil
 }
+
+// AddCorrectiveAction lets the owner of a certification-rejected shipment record a remediation step.
+// At least one corrective action recorded after the latest rejection is required by RequestReinspection.
+func (s *FoodtraceSmartContract) AddCorrectiveAction(ctx contractapi.TransactionContextInterface, shipmentID string, actionDescription string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("AddCorrectiveAction: failed to get actor info: %w", err)
+	}
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	maxLen, err := fieldMaxLength(ctx, fieldLimitCorrectiveAction)
+	if err != nil {
+		return fmt.Errorf("AddCorrectiveAction: failed to read length limit: %w", err)
+	}
+	if err := s.validateRequiredString(actionDescription, "actionDescription", maxLen); err != nil {
+		return err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("AddCorrectiveAction: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("AddCorrectiveAction: %w", err)
+	}
+	if shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only the current owner can add corrective actions to shipment '%s'", shipmentID)
+	}
+	if shipment.Status != model.StatusCertificationRejected {
+		return fmt.Errorf("corrective actions can only be added to shipments in '%s' status (current: '%s')", model.StatusCertificationRejected, shipment.Status)
+	}
+	if len(shipment.CorrectiveActions) >= maxArrayElements {
+		return fmt.Errorf("shipment '%s' already has the maximum of %d corrective actions", shipmentID, maxArrayElements)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("AddCorrectiveAction: failed to get transaction timestamp: %w", err)
+	}
+	shipment.CorrectiveActions = append(shipment.CorrectiveActions, model.CorrectiveAction{
+		Description: actionDescription, ActorID: actor.fullID, ActorAlias: actor.alias, Timestamp: now,
+	})
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("AddCorrectiveAction: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("AddCorrectiveAction: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	eventPayload := map[string]interface{}{"description": actionDescription, "correctiveActionCount": len(shipment.CorrectiveActions)}
+	s.emitShipmentEvent(ctx, "ShipmentCorrectiveActionAdded", shipment, actor, eventPayload)
+	logger.Infof("Corrective action added to shipment '%s' by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// RequestReinspection returns a certification-rejected shipment to PENDING_CERTIFICATION. The owner
+// (or an admin) must first record at least one corrective action after the latest rejection.
+func (s *FoodtraceSmartContract) RequestReinspection(ctx contractapi.TransactionContextInterface, shipmentID string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("RequestReinspection: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("RequestReinspection: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("RequestReinspection: %w", err)
+	}
+
+	isCallerAdmin, _ := im.IsCurrentUserAdmin()
+	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only current owner or admin can request reinspection of shipment '%s'", shipmentID)
+	}
+	if shipment.Status != model.StatusCertificationRejected {
+		return fmt.Errorf("shipment '%s' is not in '%s' status (current: '%s')", shipmentID, model.StatusCertificationRejected, shipment.Status)
+	}
+	if shipment.RecallInfo.IsRecalled {
+		return fmt.Errorf("recalled shipment '%s' cannot be reinspected", shipmentID)
+	}
+
+	var rejectedAt time.Time
+	for _, rec := range shipment.CertificationRecords {
+		if rec.Status == model.CertStatusRejected && rec.CertifiedAt.After(rejectedAt) {
+			rejectedAt = rec.CertifiedAt
+		}
+	}
+	hasCorrectiveAction := false
+	for _, ca := range shipment.CorrectiveActions {
+		if !ca.Timestamp.Before(rejectedAt) {
+			hasCorrectiveAction = true
+			break
+		}
+	}
+	if !hasCorrectiveAction {
+		return fmt.Errorf("shipment '%s' requires at least one corrective action after its latest rejection before reinspection", shipmentID)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("RequestReinspection: failed to get transaction timestamp: %w", err)
+	}
+	if err := s.returnToPendingCertification(ctx, shipment, now); err != nil {
+		return fmt.Errorf("RequestReinspection: %w", err)
+	}
+
+	eventPayload := map[string]interface{}{"correctiveActionCount": len(shipment.CorrectiveActions)}
+	s.emitShipmentEvent(ctx, "ShipmentReinspectionRequested", shipment, actor, eventPayload)
+	logger.Infof("Reinspection requested for shipment '%s' by '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// returnToPendingCertification moves a certification-rejected shipment back to PENDING_CERTIFICATION and
+// saves it. Shared by RequestReinspection and ResubmitForCertification.
+func (s *FoodtraceSmartContract) returnToPendingCertification(ctx contractapi.TransactionContextInterface, shipment *model.Shipment, now time.Time) error {
+	shipment.Status = model.StatusPendingCertification
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipment.ID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shipment '%s': %w", shipment.ID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("failed to update shipment '%s' status to PendingCertification: %w", shipment.ID, err)
+	}
+	return nil
+}
+
+// resubmittableFarmerFields are the farmerData keys an owner may correct when resubmitting a rejected shipment.
+var resubmittableFarmerFields = map[string]bool{
+	"farmerName": true, "farmLocation": true, "farmCoordinates": true, "cropType": true, "plantingDate": true,
+	"fertilizerUsed": true, "certificationDocumentHash": true, "harvestDate": true, "harvestDates": true,
+	"farmingPractice": true, "bedType": true, "irrigationMethod": true, "organicSince": true, "bufferZoneMeters": true,
+}
+
+// ResubmitForCertification returns a rejected shipment to PENDING_CERTIFICATION. correctionNotesJSON is
+// {notes, farmerData}: notes is required and is recorded as a corrective action; farmerData optionally
+// overrides individual farmer fields, and the merged result is re-validated as in CreateShipment.
+func (s *FoodtraceSmartContract) ResubmitForCertification(ctx contractapi.TransactionContextInterface, shipmentID string, correctionNotesJSON string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("ResubmitForCertification: failed to get actor info: %w", err)
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	var correction struct {
+		Notes      string                     `json:"notes"`
+		FarmerData map[string]json.RawMessage `json:"farmerData"`
+	}
+	if err := json.Unmarshal([]byte(correctionNotesJSON), &correction); err != nil {
+		return fmt.Errorf("ResubmitForCertification: invalid correctionNotesJSON: %w", err)
+	}
+	maxLen, err := fieldMaxLength(ctx, fieldLimitCorrectiveAction)
+	if err != nil {
+		return fmt.Errorf("ResubmitForCertification: failed to read length limit: %w", err)
+	}
+	if err := s.validateRequiredString(correction.Notes, "correctionNotes.notes", maxLen); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("ResubmitForCertification: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("ResubmitForCertification: %w", err)
+	}
+	if shipment.RecallInfo.IsRecalled {
+		return fmt.Errorf("recalled shipment '%s' cannot be resubmitted for certification", shipmentID)
+	}
+	if shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only the current owner can resubmit shipment '%s' for certification", shipmentID)
+	}
+	if shipment.Status != model.StatusCertificationRejected {
+		return fmt.Errorf("shipment '%s' is not in '%s' status (current: '%s')", shipmentID, model.StatusCertificationRejected, shipment.Status)
+	}
+	if len(shipment.CorrectiveActions) >= maxArrayElements {
+		return fmt.Errorf("shipment '%s' already has the maximum of %d corrective actions", shipmentID, maxArrayElements)
+	}
+
+	updatedFields := []string{}
+	if len(correction.FarmerData) > 0 {
+		for field := range correction.FarmerData {
+			if !resubmittableFarmerFields[field] {
+				return fmt.Errorf("ResubmitForCertification: farmerData.%s cannot be changed on resubmission", field)
+			}
+			updatedFields = append(updatedFields, field)
+		}
+		sort.Strings(updatedFields)
+
+		// Overlay the corrections on the stored farmer data and re-validate the whole record.
+		existingBytes, err := json.Marshal(shipment.FarmerData)
+		if err != nil {
+			return fmt.Errorf("ResubmitForCertification: failed to marshal existing farmer data: %w", err)
+		}
+		merged := map[string]json.RawMessage{}
+		if err := json.Unmarshal(existingBytes, &merged); err != nil {
+			return fmt.Errorf("ResubmitForCertification: failed to read existing farmer data: %w", err)
+		}
+		if _, ok := correction.FarmerData["harvestDates"]; ok {
+			if _, ok := correction.FarmerData["harvestDate"]; !ok {
+				delete(merged, "harvestDate") // Derived from the new harvestDates
+			}
+		}
+		for field, value := range correction.FarmerData {
+			merged[field] = value
+		}
+		mergedBytes, err := json.Marshal(merged)
+		if err != nil {
+			return fmt.Errorf("ResubmitForCertification: failed to marshal corrected farmer data: %w", err)
+		}
+		fdArgs, err := s.validateFarmerDataArgs(ctx, string(mergedBytes), true)
+		if err != nil {
+			return fmt.Errorf("ResubmitForCertification: %w", err)
+		}
+
+		fd := shipment.FarmerData
+		fd.FarmerName = fdArgs.FarmerName
+		fd.FarmLocation = fdArgs.FarmLocation
+		fd.FarmCoordinates = fdArgs.FarmCoordinates
+		fd.CropType = fdArgs.CropType
+		fd.PlantingDate = fdArgs.PlantingDate
+		fd.FertilizerUsed = fdArgs.FertilizerUsed
+		fd.CertificationDocumentHash = fdArgs.CertificationDocumentHash
+		fd.HarvestDate = fdArgs.HarvestDate
+		fd.HarvestDates = fdArgs.HarvestDates
+		fd.FarmingPractice = fdArgs.FarmingPractice
+		fd.BedType = fdArgs.BedType
+		fd.IrrigationMethod = fdArgs.IrrigationMethod
+		fd.OrganicSince = fdArgs.OrganicSince
+		fd.BufferZoneMeters = fdArgs.BufferZoneMeters
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("ResubmitForCertification: failed to get transaction timestamp: %w", err)
+	}
+	shipment.CorrectiveActions = append(shipment.CorrectiveActions, model.CorrectiveAction{
+		Description: correction.Notes,
+		ActorID:     actor.fullID,
+		ActorAlias:  actor.alias,
+		Timestamp:   now,
+	})
+	if err := s.returnToPendingCertification(ctx, shipment, now); err != nil {
+		return fmt.Errorf("ResubmitForCertification: %w", err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentResubmittedForCertification", shipment, actor, map[string]interface{}{
+		"notes": correction.Notes, "updatedFarmerFields": updatedFields,
+	})
+	logger.Infof("Shipment '%s' resubmitted for certification by '%s' (%d farmer field(s) corrected)", shipmentID, actor.alias, len(updatedFields))
+	return nil
+}
+
+// DesignateCertifier restricts certification of a shipment to one certifier. It may be called by the
+// current owner or an admin before a certification decision is made. An empty certifierIdentityOrAlias
+// clears the designation so any certifier may act again.
+func (s *FoodtraceSmartContract) DesignateCertifier(ctx contractapi.TransactionContextInterface, shipmentID string, certifierIdentityOrAlias string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("DesignateCertifier: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateOptionalString(certifierIdentityOrAlias, "certifierIdentityOrAlias", maxStringInputLength); err != nil {
+		return err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("DesignateCertifier: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("DesignateCertifier: %w", err)
+	}
+
+	isCallerAdmin, _ := im.IsCurrentUserAdmin()
+	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only current owner or admin can designate a certifier for shipment '%s'", shipmentID)
+	}
+	if shipment.Status != model.StatusCreated && shipment.Status != model.StatusPendingCertification {
+		return fmt.Errorf("shipment '%s' cannot have a certifier designated in status '%s'. Expected '%s' or '%s'",
+			shipmentID, shipment.Status, model.StatusCreated, model.StatusPendingCertification)
+	}
+
+	certifierFullID := ""
+	if strings.TrimSpace(certifierIdentityOrAlias) != "" {
+		certifierFullID, err = im.ResolveIdentity(certifierIdentityOrAlias)
+		if err != nil {
+			return fmt.Errorf("DesignateCertifier: failed to resolve certifier '%s': %w", certifierIdentityOrAlias, err)
+		}
+		isCertifier, errRole := im.HasRole(certifierFullID, "certifier")
+		if errRole != nil {
+			return fmt.Errorf("DesignateCertifier: failed to check certifier role for '%s': %w", certifierIdentityOrAlias, errRole)
+		}
+		if !isCertifier {
+			return fmt.Errorf("DesignateCertifier: identity '%s' is not an active certifier", certifierIdentityOrAlias)
+		}
+	}
+
+	before := snapshotShipment(shipment)
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("DesignateCertifier: failed to get transaction timestamp: %w", err)
+	}
+	shipment.DesignatedCertifierID = certifierFullID
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("DesignateCertifier: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("DesignateCertifier: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentCertifierDesignated", before, shipment, actor, map[string]interface{}{"designatedCertifierId": certifierFullID})
+	logger.Infof("Shipment '%s' designated certifier set to '%s' by '%s'", shipmentID, certifierFullID, actor.alias)
+	return nil
+}
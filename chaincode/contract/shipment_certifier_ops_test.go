@@ -0,0 +1,489 @@
+package contract
+
+import (
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestRecordCertificationInspectionDate(t *testing.T) {
+	tests := []struct {
+		name    string
+		date    func(created, txTime time.Time) string
+		wantErr string
+	}{
+		{name: "at transaction time", date: func(_, txTime time.Time) string { return txTime.Format(time.RFC3339) }},
+		{name: "at creation", date: func(created, _ time.Time) string { return created.Format(time.RFC3339) }},
+		{name: "future within clock skew", date: func(_, txTime time.Time) string { return txTime.Add(4 * time.Minute).Format(time.RFC3339) }},
+		{
+			name:    "future beyond clock skew",
+			date:    func(_, txTime time.Time) string { return txTime.Add(6 * time.Minute).Format(time.RFC3339) },
+			wantErr: "cannot be in the future",
+		},
+		{name: "next day", date: func(_, txTime time.Time) string { return txTime.Add(24 * time.Hour).Format(time.RFC3339) }, wantErr: "cannot be in the future"},
+		{name: "before creation within clock skew", date: func(created, _ time.Time) string { return created.Add(-4 * time.Minute).Format(time.RFC3339) }},
+		{name: "before creation", date: func(created, _ time.Time) string { return created.Add(-time.Hour).Format(time.RFC3339) }, wantErr: "cannot be before shipment 'SHIP-1' was created"},
+		{name: "not RFC3339", date: func(_, txTime time.Time) string { return txTime.Format("2006-01-02") }, wantErr: "invalid format for inspectionDate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			created := e.shipment("SHIP-1").CreatedAt
+			e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1"))
+
+			ctx := e.as(e.certifier) // Starts the transaction, fixing its timestamp at e.now
+			err := e.cc.RecordCertification(ctx, "SHIP-1", tt.date(created, e.now), "", "APPROVED", "ok")
+			checkErr(t, err, tt.wantErr)
+			if records := e.shipment("SHIP-1").CertificationRecords; (len(records) == 1) != (tt.wantErr == "") {
+				t.Fatalf("%d certification records after the call", len(records))
+			}
+		})
+	}
+}
+
+func TestRecordCertificationOnArchivedShipment(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     func(e *testEnv) *testIdentity
+		submit     bool // false leaves the shipment CREATED, which only an admin may certify
+		archive    bool
+		unarchive  bool
+		certStatus string
+		wantErr    string
+	}{
+		{name: "certifier on archived pending shipment", submit: true, archive: true, certStatus: "APPROVED", wantErr: "is archived"},
+		{name: "certifier records pending note on archived shipment", submit: true, archive: true, certStatus: "PENDING", wantErr: "is archived"},
+		{name: "admin override still blocked by archive", caller: func(e *testEnv) *testIdentity { return e.admin }, archive: true, certStatus: "APPROVED", wantErr: "is archived"},
+		{name: "admin override on live shipment", caller: func(e *testEnv) *testIdentity { return e.admin }, certStatus: "APPROVED"},
+		{name: "certifier after unarchive", submit: true, archive: true, unarchive: true, certStatus: "APPROVED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.submit {
+				e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1"))
+			}
+			if tt.archive {
+				e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-1", "Duplicate entry"))
+			}
+			if tt.unarchive {
+				e.must(e.cc.UnarchiveShipment(e.as(e.admin), "SHIP-1"))
+			}
+			caller := e.certifier
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1").Status
+
+			err := e.cc.RecordCertification(e.as(caller), "SHIP-1", e.now.Format(time.RFC3339), "", tt.certStatus, "ok")
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if len(shipment.CertificationRecords) != 0 || shipment.Status != before {
+					t.Fatalf("rejected certification changed the shipment: %d records, status %s", len(shipment.CertificationRecords), shipment.Status)
+				}
+				return
+			}
+			if len(shipment.CertificationRecords) != 1 || shipment.Status != model.StatusCertified {
+				t.Fatalf("%d records, status %s; want one record and %s", len(shipment.CertificationRecords), shipment.Status, model.StatusCertified)
+			}
+		})
+	}
+}
+
+func TestSubmitForCertificationFarmerDataCompleteness(t *testing.T) {
+	clearFarmerField := map[string]func(fd *model.FarmerData){
+		"farmCoordinates":  func(fd *model.FarmerData) { fd.FarmCoordinates = nil },
+		"organicSince":     func(fd *model.FarmerData) { fd.OrganicSince = time.Time{} },
+		"bufferZoneMeters": func(fd *model.FarmerData) { fd.BufferZoneMeters = 0 },
+	}
+	tests := []struct {
+		name     string
+		required string // "" keeps the default required set
+		omit     []string
+		wantErr  string
+	}{
+		{name: "complete shipment passes"},
+		{name: "one field missing", omit: []string{"organicSince"}, wantErr: "missing farmer data: farmerData.organicSince"},
+		{
+			name:    "every missing field reported",
+			omit:    []string{"bufferZoneMeters", "farmCoordinates", "organicSince"},
+			wantErr: "missing farmer data: farmerData.farmCoordinates, farmerData.organicSince, farmerData.bufferZoneMeters",
+		},
+		{name: "configured set", required: `["fertilizerUsed","bedType"]`, omit: []string{"organicSince"}, wantErr: "missing farmer data: farmerData.fertilizerUsed"},
+		{name: "configured set satisfied", required: `["bedType"]`, omit: []string{"organicSince", "bufferZoneMeters"}},
+		{name: "emptied set", required: `[]`, omit: []string{"organicSince", "farmCoordinates"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			if tt.required != "" {
+				e.must(e.cc.SetCertificationFarmerFields(e.as(e.admin), tt.required))
+			}
+			e.createShipment("SHIP-1")
+			// CreateShipment requires most of these fields, so records lacking them predate that validation.
+			shipment := e.shipment("SHIP-1")
+			for _, field := range tt.omit {
+				clearFarmerField[field](shipment.FarmerData)
+			}
+			e.putShipment(shipment)
+
+			err := e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1")
+			checkErr(t, err, tt.wantErr)
+			wantStatus := model.StatusPendingCertification
+			if tt.wantErr != "" {
+				wantStatus = model.StatusCreated
+			}
+			if status := e.shipment("SHIP-1").Status; status != wantStatus {
+				t.Fatalf("status = %s, want %s", status, wantStatus)
+			}
+		})
+	}
+
+	t.Run("unknown field rejected", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetCertificationFarmerFields(e.as(e.admin), `["cropType","soilColour"]`), "unknown farmerData field 'soilColour'")
+		fields, err := e.cc.GetCertificationFarmerFields(e.as(e.admin))
+		checkErr(t, err, "")
+		if !equalStrings(fields, defaultCertificationFarmerFields) {
+			t.Fatalf("fields after rejection = %v, want the defaults", fields)
+		}
+	})
+}
+
+// rejectCertification submits a created shipment and has the certifier reject it.
+func (e *testEnv) rejectCertification(id string) {
+	e.t.Helper()
+	e.must(e.cc.SubmitForCertification(e.as(e.farmer), id))
+	e.must(e.cc.RecordCertification(e.as(e.certifier), id, e.now.Format(time.RFC3339), "", "REJECTED", "Pest residue"))
+}
+
+func TestAddCorrectiveAction(t *testing.T) {
+	tests := []struct {
+		name        string
+		reject      bool
+		caller      func(e *testEnv) *testIdentity
+		description string
+		wantErr     string
+	}{
+		{name: "owner adds action to rejected shipment", reject: true, description: "Replaced irrigation filter"},
+		{name: "non-owner rejected", reject: true, caller: func(e *testEnv) *testIdentity { return e.admin }, description: "Replaced irrigation filter", wantErr: "only the current owner"},
+		{name: "shipment not rejected", description: "Replaced irrigation filter", wantErr: "only be added to shipments in 'CERTIFICATION_REJECTED' status"},
+		{name: "empty description", reject: true, description: " ", wantErr: "actionDescription"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.reject {
+				e.rejectCertification("SHIP-1")
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+
+			err := e.cc.AddCorrectiveAction(e.as(caller), "SHIP-1", tt.description)
+			checkErr(t, err, tt.wantErr)
+			actions := e.shipment("SHIP-1").CorrectiveActions
+			if tt.wantErr != "" {
+				if len(actions) != 0 {
+					t.Fatalf("rejected call recorded %d corrective actions", len(actions))
+				}
+				return
+			}
+			if len(actions) != 1 || actions[0].Description != tt.description || actions[0].ActorID != e.farmer.id || !actions[0].Timestamp.Equal(e.now) {
+				t.Fatalf("corrective actions = %+v", actions)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentCorrectiveActionAdded" || payload["correctiveActionCount"] != 1.0 {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+}
+
+func TestRequestReinspectionRequiresCorrectiveAction(t *testing.T) {
+	addAction := func(e *testEnv) {
+		e.must(e.cc.AddCorrectiveAction(e.as(e.farmer), "SHIP-1", "Replaced irrigation filter"))
+	}
+	tests := []struct {
+		name    string
+		setup   func(e *testEnv) // runs after the first rejection
+		caller  func(e *testEnv) *testIdentity
+		wantErr string
+	}{
+		{name: "no corrective action", wantErr: "requires at least one corrective action"},
+		{name: "admin without corrective action", caller: func(e *testEnv) *testIdentity { return e.admin }, wantErr: "requires at least one corrective action"},
+		{name: "after a corrective action", setup: addAction},
+		{
+			name: "action predates the latest rejection",
+			setup: func(e *testEnv) {
+				addAction(e)
+				e.must(e.cc.RequestReinspection(e.as(e.farmer), "SHIP-1"))
+				e.must(e.cc.RecordCertification(e.as(e.certifier), "SHIP-1", e.now.Format(time.RFC3339), "", "REJECTED", "Still present"))
+			},
+			wantErr: "requires at least one corrective action after its latest rejection",
+		},
+		{
+			name: "new action after the latest rejection",
+			setup: func(e *testEnv) {
+				addAction(e)
+				e.must(e.cc.RequestReinspection(e.as(e.farmer), "SHIP-1"))
+				e.must(e.cc.RecordCertification(e.as(e.certifier), "SHIP-1", e.now.Format(time.RFC3339), "", "REJECTED", "Still present"))
+				e.must(e.cc.AddCorrectiveAction(e.as(e.farmer), "SHIP-1", "Flushed irrigation lines"))
+			},
+		},
+		{name: "non-owner rejected", setup: addAction, caller: func(e *testEnv) *testIdentity { return e.retailer }, wantErr: "only current owner or admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.rejectCertification("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+
+			err := e.cc.RequestReinspection(e.as(caller), "SHIP-1")
+			checkErr(t, err, tt.wantErr)
+			wantStatus := model.StatusPendingCertification
+			if tt.wantErr != "" {
+				wantStatus = model.StatusCertificationRejected
+			}
+			if status := e.shipment("SHIP-1").Status; status != wantStatus {
+				t.Fatalf("status = %s, want %s", status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestRecordCertificationReportHashUnique(t *testing.T) {
+	tests := []struct {
+		name       string
+		firstHash  string // recorded as a PENDING inspection of SHIP-1
+		shipmentID string // target of the second record
+		hash       string
+		wantErr    string
+	}{
+		{name: "distinct hash", firstHash: "sha256:aaa", shipmentID: "SHIP-1", hash: "sha256:bbb"},
+		{name: "duplicate hash rejected", firstHash: "sha256:aaa", shipmentID: "SHIP-1", hash: "sha256:aaa", wantErr: "inspectionReportHash 'sha256:aaa' is already used by certification record 0 of shipment 'SHIP-1'"},
+		{name: "duplicate differing in case and padding rejected", firstHash: "sha256:aaa", shipmentID: "SHIP-1", hash: " SHA256:AAA ", wantErr: "is already used by certification record 0"},
+		{name: "same hash on another shipment", firstHash: "sha256:aaa", shipmentID: "SHIP-2", hash: "sha256:aaa"},
+		{name: "empty hashes are not compared", shipmentID: "SHIP-1"},
+		{name: "hash after a record without one", shipmentID: "SHIP-1", hash: "sha256:aaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, id := range []string{"SHIP-1", "SHIP-2"} {
+				e.createShipment(id)
+				e.must(e.cc.SubmitForCertification(e.as(e.farmer), id))
+			}
+			e.must(e.cc.RecordCertification(e.as(e.certifier), "SHIP-1", e.now.Format(time.RFC3339), tt.firstHash, "PENDING", "Samples sent to lab"))
+			before := len(e.shipment(tt.shipmentID).CertificationRecords)
+
+			err := e.cc.RecordCertification(e.as(e.certifier), tt.shipmentID, e.now.Format(time.RFC3339), tt.hash, "APPROVED", "Lab results clean")
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment(tt.shipmentID)
+			want, wantStatus := before+1, model.StatusCertified
+			if tt.wantErr != "" {
+				want, wantStatus = before, model.StatusPendingCertification
+			}
+			if len(shipment.CertificationRecords) != want || shipment.Status != wantStatus {
+				t.Fatalf("%d certification records with status %s, want %d with %s", len(shipment.CertificationRecords), shipment.Status, want, wantStatus)
+			}
+		})
+	}
+}
+
+func TestResubmitForCertification(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(e *testEnv) // SHIP-1 is created; rejects it unless set
+		caller      func(e *testEnv) *testIdentity
+		correction  string
+		wantErr     string
+		wantFields  []string
+		wantFarmLoc string
+	}{
+		{name: "notes only", correction: `{"notes":"Replaced irrigation filter"}`, wantFields: []string{}, wantFarmLoc: "Valley Farm"},
+		{
+			name:        "with farmer data corrections",
+			correction:  `{"notes":"Corrected farm records","farmerData":{"farmLocation":"North Field","fertilizerUsed":"Compost"}}`,
+			wantFields:  []string{"farmLocation", "fertilizerUsed"},
+			wantFarmLoc: "North Field",
+		},
+		{name: "corrections re-validated", correction: `{"notes":"Fix","farmerData":{"cropType":""}}`, wantErr: "cropType"},
+		{name: "non-correctable field rejected", correction: `{"notes":"Fix","farmerData":{"destinationProcessorId":"retailer1"}}`, wantErr: "farmerData.destinationProcessorId cannot be changed on resubmission"},
+		{name: "missing notes rejected", correction: `{"farmerData":{"farmLocation":"North Field"}}`, wantErr: "correctionNotes.notes"},
+		{name: "invalid JSON rejected", correction: `notes`, wantErr: "invalid correctionNotesJSON"},
+		{
+			name:       "non-owner rejected",
+			caller:     func(e *testEnv) *testIdentity { return e.admin },
+			correction: `{"notes":"Fix"}`,
+			wantErr:    "only the current owner can resubmit shipment 'SHIP-1'",
+		},
+		{name: "shipment not rejected", setup: func(e *testEnv) {}, correction: `{"notes":"Fix"}`, wantErr: "is not in 'CERTIFICATION_REJECTED' status (current: 'CREATED')"},
+		{
+			name: "recalled shipment rejected",
+			setup: func(e *testEnv) {
+				e.rejectCertification("SHIP-1")
+				shipment := e.shipment("SHIP-1")
+				shipment.RecallInfo.IsRecalled = true
+				e.putShipment(shipment)
+			},
+			correction: `{"notes":"Fix"}`,
+			wantErr:    "recalled shipment 'SHIP-1' cannot be resubmitted",
+		},
+		{
+			name: "archived shipment rejected",
+			setup: func(e *testEnv) {
+				e.rejectCertification("SHIP-1")
+				shipment := e.shipment("SHIP-1")
+				shipment.IsArchived = true
+				e.putShipment(shipment)
+			},
+			correction: `{"notes":"Fix"}`,
+			wantErr:    "shipment 'SHIP-1' is archived",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			} else {
+				e.rejectCertification("SHIP-1")
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1")
+
+			checkErr(t, e.cc.ResubmitForCertification(e.as(caller), "SHIP-1", tt.correction), tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != before.Status || len(shipment.CorrectiveActions) != 0 || shipment.FarmerData.FarmLocation != before.FarmerData.FarmLocation {
+					t.Fatalf("rejected resubmission changed the shipment: status %s, %d corrective actions, farm %q",
+						shipment.Status, len(shipment.CorrectiveActions), shipment.FarmerData.FarmLocation)
+				}
+				return
+			}
+
+			if shipment.Status != model.StatusPendingCertification || shipment.FarmerData.FarmLocation != tt.wantFarmLoc {
+				t.Fatalf("status %s, farm %q", shipment.Status, shipment.FarmerData.FarmLocation)
+			}
+			if actions := shipment.CorrectiveActions; len(actions) != 1 || actions[0].ActorID != e.farmer.id || !actions[0].Timestamp.Equal(e.now) {
+				t.Fatalf("corrective actions = %+v", actions)
+			}
+			name, payload := e.lastEvent()
+			fields := []string{}
+			for _, f := range payload["updatedFarmerFields"].([]interface{}) {
+				fields = append(fields, f.(string))
+			}
+			if name != "ShipmentResubmittedForCertification" || payload["notes"] != shipment.CorrectiveActions[0].Description || !equalStrings(fields, tt.wantFields) {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+
+			// The resubmitted shipment goes through certification again.
+			e.must(e.cc.RecordCertification(e.as(e.certifier), "SHIP-1", e.now.Format(time.RFC3339), "", "APPROVED", "Resolved"))
+			if status := e.shipment("SHIP-1").Status; status != model.StatusCertified {
+				t.Fatalf("status after approval = %s", status)
+			}
+		})
+	}
+}
+
+func TestDesignateCertifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(e *testEnv)
+		caller    func(e *testEnv) *testIdentity
+		certifier func(e *testEnv) string
+		want      func(e *testEnv) string // designated certifier afterwards
+		wantErr   string
+	}{
+		{name: "owner designates", certifier: func(e *testEnv) string { return e.certifier.alias }, want: func(e *testEnv) string { return e.certifier.id }},
+		{
+			name:      "admin designates",
+			caller:    func(e *testEnv) *testIdentity { return e.admin },
+			certifier: func(e *testEnv) string { return e.certifier.alias }, want: func(e *testEnv) string { return e.certifier.id },
+		},
+		{
+			name:      "empty certifier clears the designation",
+			setup:     func(e *testEnv) { e.must(e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias)) },
+			certifier: func(*testEnv) string { return "" }, want: func(*testEnv) string { return "" },
+		},
+		{
+			name:      "non-owner rejected",
+			caller:    func(e *testEnv) *testIdentity { return e.retailer },
+			certifier: func(e *testEnv) string { return e.certifier.alias }, wantErr: "only current owner or admin",
+		},
+		{name: "identity without certifier role", certifier: func(e *testEnv) string { return e.retailer.alias }, wantErr: "is not an active certifier"},
+		{
+			name:      "after a certification decision",
+			setup:     func(e *testEnv) { e.certify("SHIP-1") },
+			certifier: func(e *testEnv) string { return e.certifier.alias }, wantErr: "cannot have a certifier designated",
+		},
+		{
+			name:      "on hold",
+			setup:     func(e *testEnv) { e.must(e.cc.PlaceHold(e.as(e.farmer), "SHIP-1", "Paperwork")) },
+			certifier: func(e *testEnv) string { return e.certifier.alias }, wantErr: "is on hold",
+		},
+		{
+			name: "archived",
+			setup: func(e *testEnv) {
+				archived := e.shipment("SHIP-1")
+				archived.IsArchived = true
+				e.putShipment(archived)
+			},
+			certifier: func(e *testEnv) string { return e.certifier.alias }, wantErr: "is archived",
+		},
+		{
+			name: "destroyed",
+			setup: func(e *testEnv) {
+				destroyed := e.shipment("SHIP-1")
+				destroyed.Status = model.StatusDestroyed
+				e.putShipment(destroyed)
+			},
+			certifier: func(e *testEnv) string { return e.certifier.alias }, wantErr: "has been destroyed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1").DesignatedCertifierID
+
+			err := e.cc.DesignateCertifier(e.as(caller), "SHIP-1", tt.certifier(e))
+			checkErr(t, err, tt.wantErr)
+			got := e.shipment("SHIP-1").DesignatedCertifierID
+			if tt.wantErr != "" {
+				if got != before {
+					t.Fatalf("rejected designation changed the certifier from %q to %q", before, got)
+				}
+				return
+			}
+			if want := tt.want(e); got != want {
+				t.Fatalf("designated certifier = %q, want %q", got, want)
+			}
+		})
+	}
+}
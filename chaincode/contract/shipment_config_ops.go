@@ -0,0 +1,558 @@
+// Author: Muhammad-Tameem Mughal
+// Last updated: Aug 15, 2025
+// Last modified by: Muhammad-Tameem Mughal
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// configObjectType is used for composite keys holding admin-managed contract settings.
+// Attribute for composite key: setting name. Values are stored as JSON.
+const configObjectType = "ContractConfig"
+
+// Setting names for admin-managed configuration.
+const (
+	configColdChainSamplingIntervalHours = "coldChainSamplingIntervalHours" // float64, 0 disables gap checks
+	configEnforceUniqueEnrollmentIDs     = "enforceUniqueEnrollmentIds"     // bool, default false
+	configTransferReasonRequired         = "transferReasonRequired"         // bool, default false
+	configAuditAdminReads                = "auditAdminReads"                // bool, default false
+	configAllowedUnitsOfMeasure          = "allowedUnitsOfMeasure"          // []string, empty allows free text
+	configExpiryRequiredCropTypes        = "expiryRequiredCropTypes"        // []string, crop types that must carry an expiry date
+	configCoolingTargets                 = "coolingTargets"                 // map[string]float64, max cooling end temp by processing/crop type
+	configBlockDistributionOnQualityFail = "blockDistributionOnQualityFail" // bool, default false
+	configAutoGenerateAliases            = "autoGenerateAliases"            // bool, default false
+	configTransportEmissionFactor        = "transportEmissionFactor"        // float64, kg CO2e per km, default 0
+	configMaxHistoryEntries              = "maxHistoryEntries"              // int, history entries returned by GetShipmentPublicDetails
+	configRoleDefaultPageSizes           = "roleDefaultPageSizes"           // map[string]int, default page size by role
+	configProcessingCoordinatesRequired  = "processingCoordinatesRequired"  // bool, default true
+	configCertificationFarmerFields      = "certificationFarmerFields"      // []string, farmerData fields required to submit for certification
+	configVerifyTransformDestinationRole = "verifyTransformDestinationRole" // bool, default false
+	configRequireRegisteredDestinations  = "requireRegisteredDestinations"  // bool, default false
+	configDuplicateSensorReadings        = "duplicateSensorReadings"        // string, one of the duplicateReading* policies
+	configAllowedRegistrarMSPs           = "allowedRegistrarMsps"           // []string, empty lets registrars from any MSP register identities
+	configColdChainCropTypes             = "coldChainCropTypes"             // []string, crop types that must carry storage temperatures when distributed
+	configTestFunctionsEnabled           = "testFunctionsEnabled"           // bool, unset means enabled only until the first admin exists
+	configFieldLengthLimits              = "fieldLengthLimits"              // map[string]int, max length per configurable field
+)
+
+// defaultMaxHistoryEntries caps GetShipmentPublicDetails history until an admin sets configMaxHistoryEntries.
+const defaultMaxHistoryEntries = 500
+
+// Page size bounds for paginated queries. fallbackPageSize applies when pageSize is omitted and
+// none of the caller's roles has a default.
+const (
+	fallbackPageSize = 10
+	maxPageSize      = 100
+)
+
+// defaultCertificationFarmerFields is used until an admin sets configCertificationFarmerFields.
+var defaultCertificationFarmerFields = []string{"farmCoordinates", "cropType", "harvestDate", "organicSince", "bufferZoneMeters"}
+
+// Policies for sensor readings whose timestamp matches an existing reading on the same shipment.
+const (
+	duplicateReadingReject = "reject" // Default: fail the transaction
+	duplicateReadingSkip   = "skip"   // Succeed without recording the reading again
+	duplicateReadingAllow  = "allow"  // Record it anyway
+)
+
+// Fields whose maximum length admins may override with SetFieldLengthLimits.
+const (
+	fieldLimitRecallReason          = "recallReason"
+	fieldLimitCertificationComments = "certificationComments"
+	fieldLimitShipmentDescription   = "shipmentDescription"
+	fieldLimitCorrectiveAction      = "correctiveAction"
+)
+
+// defaultFieldLengthLimits holds the built-in limit for each configurable field.
+var defaultFieldLengthLimits = map[string]int{
+	fieldLimitRecallReason:          maxRecallReasonLength,
+	fieldLimitCertificationComments: maxDescriptionLength,
+	fieldLimitShipmentDescription:   maxDescriptionLength,
+	fieldLimitCorrectiveAction:      maxDescriptionLength,
+}
+
+// maxConfigurableFieldLength bounds overrides so a misconfiguration cannot admit unbounded input.
+const maxConfigurableFieldLength = 16384
+
+// defaultRoleDefaultPageSizes is used until an admin sets configRoleDefaultPageSizes.
+var defaultRoleDefaultPageSizes = map[string]int{"certifier": 25}
+
+// --- Configuration Helpers ---
+
+// loadConfigValue reads a setting into target. Returns false if the setting has never been set.
+// It is a plain function so IdentityManager can consult settings as well.
+func loadConfigValue(ctx contractapi.TransactionContextInterface, name string, target interface{}) (bool, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return false, fmt.Errorf("failed to create config key for '%s': %w", name, err)
+	}
+	valueBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read config '%s' from ledger: %w", name, err)
+	}
+	if valueBytes == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(valueBytes, target); err != nil {
+		return false, fmt.Errorf("failed to unmarshal config '%s': %w", name, err)
+	}
+	return true, nil
+}
+
+// storeConfigValue writes a setting after verifying the caller is an admin.
+func (s *FoodtraceSmartContract) storeConfigValue(ctx contractapi.TransactionContextInterface, name string, value interface{}) error {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return fmt.Errorf("cannot update config '%s': %w", name, err)
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{name})
+	if err != nil {
+		return fmt.Errorf("failed to create config key for '%s': %w", name, err)
+	}
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config '%s': %w", name, err)
+	}
+	if err := ctx.GetStub().PutState(key, valueBytes); err != nil {
+		return fmt.Errorf("failed to save config '%s': %w", name, err)
+	}
+	logger.Infof("Config '%s' set to %s by admin '%s'", name, string(valueBytes), MustGetCallerFullID(ctx))
+	return nil
+}
+
+// getConfigFloat returns a numeric setting, or defaultValue if unset.
+func getConfigFloat(ctx contractapi.TransactionContextInterface, name string, defaultValue float64) (float64, error) {
+	var value float64
+	found, err := loadConfigValue(ctx, name, &value)
+	if err != nil || !found {
+		return defaultValue, err
+	}
+	return value, nil
+}
+
+// getConfigBool returns a boolean setting, or defaultValue if unset.
+func getConfigBool(ctx contractapi.TransactionContextInterface, name string, defaultValue bool) (bool, error) {
+	var value bool
+	found, err := loadConfigValue(ctx, name, &value)
+	if err != nil || !found {
+		return defaultValue, err
+	}
+	return value, nil
+}
+
+// getConfigStringList returns a list setting, or an empty list if unset.
+func getConfigStringList(ctx contractapi.TransactionContextInterface, name string) ([]string, error) {
+	values := []string{}
+	if _, err := loadConfigValue(ctx, name, &values); err != nil {
+		return []string{}, err
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return values, nil
+}
+
+// getConfigString returns a string setting, or defaultValue if unset.
+func getConfigString(ctx contractapi.TransactionContextInterface, name string, defaultValue string) (string, error) {
+	var value string
+	found, err := loadConfigValue(ctx, name, &value)
+	if err != nil || !found {
+		return defaultValue, err
+	}
+	return value, nil
+}
+
+// validateUnitOfMeasure checks unit against the admin-managed allowed list (case-insensitive).
+// When no list is configured any unit is accepted.
+func validateUnitOfMeasure(ctx contractapi.TransactionContextInterface, unit, field string) error {
+	allowed, err := getConfigStringList(ctx, configAllowedUnitsOfMeasure)
+	if err != nil {
+		return fmt.Errorf("failed to read allowed units of measure: %w", err)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSpace(unit), a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s '%s' is not an allowed unit of measure. Allowed: %v", field, unit, allowed)
+}
+
+// getCoolingTarget returns the maximum allowed cooling end temperature for a shipment, looking up
+// its processing type first and then its crop type (case-insensitive; SetCoolingTargets stores lowercase
+// keys). ok is false if neither is configured.
+func getCoolingTarget(ctx contractapi.TransactionContextInterface, processingType, cropType string) (target float64, key string, ok bool, err error) {
+	targets := map[string]float64{}
+	if _, err := loadConfigValue(ctx, configCoolingTargets, &targets); err != nil {
+		return 0, "", false, err
+	}
+	for _, candidate := range []string{processingType, cropType} {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if candidate == "" {
+			continue
+		}
+		if v, found := targets[candidate]; found {
+			return v, candidate, true, nil
+		}
+	}
+	return 0, "", false, nil
+}
+
+// getRoleDefaultPageSizes returns the default page size per role (lowercase role names).
+func getRoleDefaultPageSizes(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	sizes := map[string]int{}
+	found, err := loadConfigValue(ctx, configRoleDefaultPageSizes, &sizes)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return defaultRoleDefaultPageSizes, nil
+	}
+	return sizes, nil
+}
+
+// fieldMaxLength returns the admin-configured maximum length for a configurable field, or its built-in default.
+func fieldMaxLength(ctx contractapi.TransactionContextInterface, limitName string) (int, error) {
+	limits := map[string]int{}
+	found, err := loadConfigValue(ctx, configFieldLengthLimits, &limits)
+	if err != nil {
+		return 0, err
+	}
+	if limit, ok := limits[limitName]; found && ok {
+		return limit, nil
+	}
+	return defaultFieldLengthLimits[limitName], nil
+}
+
+// getCertificationFarmerFields returns the farmerData fields that must be present before a shipment
+// can be submitted for certification.
+func getCertificationFarmerFields(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	fields := []string{}
+	found, err := loadConfigValue(ctx, configCertificationFarmerFields, &fields)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return defaultCertificationFarmerFields, nil
+	}
+	return fields, nil
+}
+
+// configListContains reports whether value matches an entry of a list setting (case-insensitive).
+func configListContains(ctx contractapi.TransactionContextInterface, name, value string) (bool, error) {
+	values, err := getConfigStringList(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(value), v) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseConfigStringList decodes a JSON array of strings for a list setting, trimming entries
+// and dropping case-insensitive duplicates.
+func (s *FoodtraceSmartContract) parseConfigStringList(listJSON, field string) ([]string, error) {
+	var values []string
+	if err := json.Unmarshal([]byte(listJSON), &values); err != nil {
+		return nil, fmt.Errorf("invalid %s JSON: %w", field, err)
+	}
+	if err := s.validateStringArray(values, field, maxArrayElements, maxStringInputLength); err != nil {
+		return nil, err
+	}
+	cleaned := []string{}
+	seen := make(map[string]bool)
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, fmt.Errorf("%s[%d] cannot be empty", field, i)
+		}
+		if seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		cleaned = append(cleaned, v)
+	}
+	return cleaned, nil
+}
+
+// --- Admin Configuration Functions ---
+
+// SetColdChainSamplingInterval sets the maximum allowed gap (in hours) between consecutive sensor readings.
+// A value of 0 disables gap enforcement.
+func (s *FoodtraceSmartContract) SetColdChainSamplingInterval(ctx contractapi.TransactionContextInterface, intervalHoursStr string) error {
+	intervalHours, err := strconv.ParseFloat(strings.TrimSpace(intervalHoursStr), 64)
+	if err != nil || intervalHours < 0 {
+		return fmt.Errorf("invalid intervalHours '%s': must be a non-negative number", intervalHoursStr)
+	}
+	return s.storeConfigValue(ctx, configColdChainSamplingIntervalHours, intervalHours)
+}
+
+// SetTransportEmissionFactor sets the kg CO2e per km used by GetTransportCarbonEstimate.
+func (s *FoodtraceSmartContract) SetTransportEmissionFactor(ctx contractapi.TransactionContextInterface, kgPerKmStr string) error {
+	kgPerKm, err := strconv.ParseFloat(strings.TrimSpace(kgPerKmStr), 64)
+	if err != nil || kgPerKm < 0 || math.IsNaN(kgPerKm) || math.IsInf(kgPerKm, 0) {
+		return fmt.Errorf("invalid kgPerKm '%s': must be a non-negative number", kgPerKmStr)
+	}
+	return s.storeConfigValue(ctx, configTransportEmissionFactor, kgPerKm)
+}
+
+// SetMaxHistoryEntries sets how many of the most recent history entries GetShipmentPublicDetails returns.
+func (s *FoodtraceSmartContract) SetMaxHistoryEntries(ctx contractapi.TransactionContextInterface, maxEntriesStr string) error {
+	maxEntries, err := strconv.Atoi(strings.TrimSpace(maxEntriesStr))
+	if err != nil || maxEntries <= 0 {
+		return fmt.Errorf("invalid maxEntries '%s': must be a positive integer", maxEntriesStr)
+	}
+	return s.storeConfigValue(ctx, configMaxHistoryEntries, maxEntries)
+}
+
+// SetEnrollmentIDUniquenessEnforced toggles rejection of RegisterIdentity calls that reuse
+// an enrollment ID already held by a different identity.
+func (s *FoodtraceSmartContract) SetEnrollmentIDUniquenessEnforced(ctx contractapi.TransactionContextInterface, enforced bool) error {
+	return s.storeConfigValue(ctx, configEnforceUniqueEnrollmentIDs, enforced)
+}
+
+// SetAliasAutoGenerationEnabled toggles deriving an alias from the enrollment ID (or a hash of the
+// full ID) when RegisterIdentity is called with an empty shortName. Disabled, empty names are rejected.
+func (s *FoodtraceSmartContract) SetAliasAutoGenerationEnabled(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	return s.storeConfigValue(ctx, configAutoGenerateAliases, enabled)
+}
+
+// SetTransferReasonRequired toggles rejection of ownership-changing stage transitions (ProcessShipment,
+// DistributeShipment, ReceiveShipment) whose data JSON carries no "transferReason".
+func (s *FoodtraceSmartContract) SetTransferReasonRequired(ctx contractapi.TransactionContextInterface, required bool) error {
+	return s.storeConfigValue(ctx, configTransferReasonRequired, required)
+}
+
+// SetTestFunctionsEnabled toggles the test-only helpers: the TestGetCallerIdentity and TestAssignRoleToSelf
+// transactions and IdentityManager.AssignRoleUncheckedForTest, which TestAssignRoleToSelf calls.
+// Until set, they are available only while no admin exists.
+func (s *FoodtraceSmartContract) SetTestFunctionsEnabled(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	return s.storeConfigValue(ctx, configTestFunctionsEnabled, enabled)
+}
+
+// SetAdminReadAuditEnabled toggles the AdminDataAccessed event on admin-only identity listings.
+// Events are only delivered when the read is submitted as a transaction; evaluated queries are not committed.
+func (s *FoodtraceSmartContract) SetAdminReadAuditEnabled(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	return s.storeConfigValue(ctx, configAuditAdminReads, enabled)
+}
+
+// SetAllowedUnitsOfMeasure replaces the list of accepted unitOfMeasure values with a JSON array
+// of strings. An empty array ("[]") allows free text again.
+func (s *FoodtraceSmartContract) SetAllowedUnitsOfMeasure(ctx contractapi.TransactionContextInterface, unitsJSON string) error {
+	units, err := s.parseConfigStringList(unitsJSON, "units")
+	if err != nil {
+		return err
+	}
+	return s.storeConfigValue(ctx, configAllowedUnitsOfMeasure, units)
+}
+
+// GetAllowedUnitsOfMeasure returns the configured unit list; empty means free text is allowed.
+func (s *FoodtraceSmartContract) GetAllowedUnitsOfMeasure(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getConfigStringList(ctx, configAllowedUnitsOfMeasure)
+}
+
+// SetAllowedRegistrarMSPs replaces the list of MSP IDs whose "registrar" role holders may call RegisterIdentity
+// with a JSON array of strings. Admins are always allowed. "[]" lets registrars from any MSP register.
+func (s *FoodtraceSmartContract) SetAllowedRegistrarMSPs(ctx contractapi.TransactionContextInterface, mspIDsJSON string) error {
+	mspIDs, err := s.parseConfigStringList(mspIDsJSON, "mspIDs")
+	if err != nil {
+		return err
+	}
+	return s.storeConfigValue(ctx, configAllowedRegistrarMSPs, mspIDs)
+}
+
+// GetAllowedRegistrarMSPs returns the MSPs whose registrars may register identities; empty means any MSP.
+func (s *FoodtraceSmartContract) GetAllowedRegistrarMSPs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getConfigStringList(ctx, configAllowedRegistrarMSPs)
+}
+
+// SetExpiryRequiredCropTypes replaces the list of (perishable) crop types whose shipments must
+// carry processorData.expiryDate when processed. "[]" makes expiry optional for all crops.
+func (s *FoodtraceSmartContract) SetExpiryRequiredCropTypes(ctx contractapi.TransactionContextInterface, cropTypesJSON string) error {
+	cropTypes, err := s.parseConfigStringList(cropTypesJSON, "cropTypes")
+	if err != nil {
+		return err
+	}
+	return s.storeConfigValue(ctx, configExpiryRequiredCropTypes, cropTypes)
+}
+
+// GetExpiryRequiredCropTypes returns the crop types that require an expiry date on processing.
+func (s *FoodtraceSmartContract) GetExpiryRequiredCropTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getConfigStringList(ctx, configExpiryRequiredCropTypes)
+}
+
+// SetColdChainCropTypes replaces the list of crop types requiring refrigerated transport. Their shipments
+// must carry distributorData.storageTemperatures when distributed. "[]" makes storage temperatures optional.
+func (s *FoodtraceSmartContract) SetColdChainCropTypes(ctx contractapi.TransactionContextInterface, cropTypesJSON string) error {
+	cropTypes, err := s.parseConfigStringList(cropTypesJSON, "cropTypes")
+	if err != nil {
+		return err
+	}
+	return s.storeConfigValue(ctx, configColdChainCropTypes, cropTypes)
+}
+
+// GetColdChainCropTypes returns the crop types that require storage temperatures on distribution.
+func (s *FoodtraceSmartContract) GetColdChainCropTypes(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getConfigStringList(ctx, configColdChainCropTypes)
+}
+
+// SetCertificationFarmerFields replaces the farmerData fields required by SubmitForCertification with a
+// JSON array of field names, e.g. ["cropType", "harvestDate"]. "[]" disables the completeness check.
+func (s *FoodtraceSmartContract) SetCertificationFarmerFields(ctx contractapi.TransactionContextInterface, fieldsJSON string) error {
+	fields, err := s.parseConfigStringList(fieldsJSON, "fields")
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if _, known := farmerFieldPresent[field]; !known {
+			return fmt.Errorf("unknown farmerData field '%s'", field)
+		}
+	}
+	return s.storeConfigValue(ctx, configCertificationFarmerFields, fields)
+}
+
+// GetCertificationFarmerFields returns the farmerData fields required by SubmitForCertification.
+func (s *FoodtraceSmartContract) GetCertificationFarmerFields(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return getCertificationFarmerFields(ctx)
+}
+
+// SetCoolingTargets replaces the cooling targets with a JSON object mapping a processing type or
+// crop type to the maximum allowed processorData.coolingEndTemp in °C, e.g. {"frozen": -18, "strawberry": 4}.
+// Keys are matched case-insensitively, so keys differing only in case are rejected.
+// "{}" removes all targets so cooling temperatures are only recorded.
+func (s *FoodtraceSmartContract) SetCoolingTargets(ctx contractapi.TransactionContextInterface, targetsJSON string) error {
+	var targets map[string]float64
+	if err := json.Unmarshal([]byte(targetsJSON), &targets); err != nil {
+		return fmt.Errorf("invalid targetsJSON: %w", err)
+	}
+	if len(targets) > maxArrayElements {
+		return fmt.Errorf("targetsJSON has %d entries, exceeding maximum of %d", len(targets), maxArrayElements)
+	}
+	normalized := make(map[string]float64, len(targets))
+	for k, v := range targets {
+		if err := s.validateRequiredString(k, "targetsJSON key", maxStringInputLength); err != nil {
+			return err
+		}
+		target := v
+		if err := validatePlausibleTemperature(&target, fmt.Sprintf("targetsJSON['%s']", k)); err != nil {
+			return err
+		}
+		keyLower := strings.ToLower(strings.TrimSpace(k))
+		if _, dup := normalized[keyLower]; dup {
+			return fmt.Errorf("targetsJSON has more than one entry for '%s' (keys are case-insensitive)", keyLower)
+		}
+		normalized[keyLower] = target
+	}
+	return s.storeConfigValue(ctx, configCoolingTargets, normalized)
+}
+
+// SetRoleDefaultPageSizes replaces the default page sizes used when a paginated query is called
+// without pageSize, as a JSON object mapping role to size, e.g. {"certifier": 25, "distributor": 50}.
+// "{}" makes every role fall back to 10.
+func (s *FoodtraceSmartContract) SetRoleDefaultPageSizes(ctx contractapi.TransactionContextInterface, sizesJSON string) error {
+	var sizes map[string]int
+	if err := json.Unmarshal([]byte(sizesJSON), &sizes); err != nil {
+		return fmt.Errorf("invalid sizesJSON: %w", err)
+	}
+	normalized := make(map[string]int, len(sizes))
+	for role, size := range sizes {
+		roleLower := strings.ToLower(strings.TrimSpace(role))
+		if !ValidRoles[roleLower] {
+			return fmt.Errorf("invalid role '%s' in sizesJSON", role)
+		}
+		if size <= 0 || size > maxPageSize {
+			return fmt.Errorf("sizesJSON['%s'] must be between 1 and %d", role, maxPageSize)
+		}
+		normalized[roleLower] = size
+	}
+	return s.storeConfigValue(ctx, configRoleDefaultPageSizes, normalized)
+}
+
+// GetRoleDefaultPageSizes returns the default page size per role.
+func (s *FoodtraceSmartContract) GetRoleDefaultPageSizes(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	return getRoleDefaultPageSizes(ctx)
+}
+
+// SetFieldLengthLimits replaces the per-field length overrides with a JSON object mapping field names
+// (recallReason, certificationComments, shipmentDescription, correctiveAction) to maximum lengths.
+// Fields left out use their built-in limit; "{}" restores all defaults.
+func (s *FoodtraceSmartContract) SetFieldLengthLimits(ctx contractapi.TransactionContextInterface, limitsJSON string) error {
+	var limits map[string]int
+	if err := json.Unmarshal([]byte(limitsJSON), &limits); err != nil {
+		return fmt.Errorf("invalid limitsJSON: %w", err)
+	}
+	for field, limit := range limits {
+		if _, ok := defaultFieldLengthLimits[field]; !ok {
+			return fmt.Errorf("field '%s' does not have a configurable length limit", field)
+		}
+		if limit <= 0 || limit > maxConfigurableFieldLength {
+			return fmt.Errorf("limitsJSON['%s'] must be between 1 and %d", field, maxConfigurableFieldLength)
+		}
+	}
+	return s.storeConfigValue(ctx, configFieldLengthLimits, limits)
+}
+
+// GetFieldLengthLimits returns the effective maximum length of every configurable field.
+func (s *FoodtraceSmartContract) GetFieldLengthLimits(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	effective := make(map[string]int, len(defaultFieldLengthLimits))
+	for field := range defaultFieldLengthLimits {
+		limit, err := fieldMaxLength(ctx, field)
+		if err != nil {
+			return nil, err
+		}
+		effective[field] = limit
+	}
+	return effective, nil
+}
+
+// SetProcessingCoordinatesRequired toggles whether processorData.processingCoordinates must be
+// provided. When optional and absent, coordinate validation is skipped.
+func (s *FoodtraceSmartContract) SetProcessingCoordinatesRequired(ctx contractapi.TransactionContextInterface, required bool) error {
+	return s.storeConfigValue(ctx, configProcessingCoordinatesRequired, required)
+}
+
+// SetTransformDestinationRoleCheck toggles verifying that the destination distributor given to
+// TransformAndCreateProducts holds the "distributor" role. It has no effect while enforceDestinationRoles
+// is on, as the role is then always checked.
+func (s *FoodtraceSmartContract) SetTransformDestinationRoleCheck(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	return s.storeConfigValue(ctx, configVerifyTransformDestinationRole, enabled)
+}
+
+// SetRegisteredDestinationsRequired toggles rejecting CreateShipment when the destination processor
+// resolves to an X.509 ID with no IdentityInfo record. Leave it off for test flows with unregistered IDs.
+// While enforceDestinationRoles is on, an unregistered destination already fails the processor role check,
+// so the flag only makes the error name the missing registration.
+func (s *FoodtraceSmartContract) SetRegisteredDestinationsRequired(ctx contractapi.TransactionContextInterface, required bool) error {
+	return s.storeConfigValue(ctx, configRequireRegisteredDestinations, required)
+}
+
+// SetDuplicateSensorReadingPolicy chooses how AddDistributorSensorLog treats a reading whose timestamp
+// exactly matches an existing one: "reject" (default), "skip" or "allow".
+func (s *FoodtraceSmartContract) SetDuplicateSensorReadingPolicy(ctx contractapi.TransactionContextInterface, policy string) error {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	switch policy {
+	case duplicateReadingReject, duplicateReadingSkip, duplicateReadingAllow:
+	default:
+		return fmt.Errorf("invalid policy '%s'. Must be one of: %s, %s, %s", policy, duplicateReadingReject, duplicateReadingSkip, duplicateReadingAllow)
+	}
+	return s.storeConfigValue(ctx, configDuplicateSensorReadings, policy)
+}
+
+// SetDistributionBlockedOnQualityFailure toggles rejection of DistributeShipment for shipments with an
+// upstream quality failure (failed contamination check or missed cooling target).
+func (s *FoodtraceSmartContract) SetDistributionBlockedOnQualityFailure(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	return s.storeConfigValue(ctx, configBlockDistributionOnQualityFail, enabled)
+}
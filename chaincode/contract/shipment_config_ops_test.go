@@ -0,0 +1,190 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestAllowedUnitsOfMeasure(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string // "" leaves the list unconfigured
+		unit    string
+		wantErr string
+	}{
+		{name: "unconfigured accepts free text", unit: "kilograms"},
+		{name: "emptied list accepts free text", allowed: `[]`, unit: "kilograms"},
+		{name: "allowed unit", allowed: `["kg","lb"]`, unit: "kg"},
+		{name: "allowed unit in other case", allowed: `["kg","lb"]`, unit: "LB"},
+		{name: "disallowed unit", allowed: `["kg","lb"]`, unit: "kilograms", wantErr: "not an allowed unit of measure. Allowed: [kg lb]"},
+	}
+	creators := []struct {
+		name   string
+		input  func(e *testEnv, id string) // prepares an input shipment before the list is configured
+		create func(e *testEnv, unit string) error
+	}{
+		{name: "CreateShipment", create: func(e *testEnv, unit string) error {
+			return e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, unit, e.farmerData(nil))
+		}},
+		{name: "TransformAndCreateProducts", input: func(e *testEnv, id string) { e.createShipment(id); e.process(id) }, create: func(e *testEnv, unit string) error {
+			products, _ := json.Marshal([]model.NewProductDetail{{NewShipmentID: "SHIP-1", ProductName: "Jam", Quantity: 40, UnitOfMeasure: unit}})
+			return e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"INPUT-1"}]`, string(products), e.processorData(nil), false)
+		}},
+		{name: "ProcessShipment byproduct", input: (*testEnv).createShipment, create: func(e *testEnv, unit string) error {
+			byproducts, _ := json.Marshal([]model.NewProductDetail{{NewShipmentID: "SHIP-1", ProductName: "Pulp", Quantity: 5, UnitOfMeasure: unit}})
+			return e.cc.ProcessShipment(e.as(e.processor), "INPUT-1", e.processorData(nil), string(byproducts))
+		}},
+	}
+	for _, c := range creators {
+		for _, tt := range tests {
+			t.Run(c.name+"/"+tt.name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				if c.input != nil {
+					c.input(e, "INPUT-1")
+				}
+				if tt.allowed != "" {
+					e.must(e.cc.SetAllowedUnitsOfMeasure(e.as(e.admin), tt.allowed))
+				}
+				checkErr(t, c.create(e, tt.unit), tt.wantErr)
+				if tt.wantErr == "" {
+					if unit := e.shipment("SHIP-1").UnitOfMeasure; unit != tt.unit {
+						t.Fatalf("stored unit = %q, want %q", unit, tt.unit)
+					}
+				}
+			})
+		}
+	}
+
+	t.Run("list is normalized", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.must(e.cc.SetAllowedUnitsOfMeasure(e.as(e.admin), `[" kg ","KG","lb"]`))
+		units, err := e.cc.GetAllowedUnitsOfMeasure(e.as(e.farmer))
+		e.must(err)
+		if !equalStrings(units, []string{"kg", "lb"}) {
+			t.Fatalf("allowed units = %v, want [kg lb]", units)
+		}
+		checkErr(t, e.cc.SetAllowedUnitsOfMeasure(e.as(e.admin), `["kg",""]`), "cannot be empty")
+	})
+
+	t.Run("only admins configure the list", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetAllowedUnitsOfMeasure(e.as(e.farmer), `["kg"]`), "cannot update config")
+	})
+}
+
+func TestFieldLengthLimits(t *testing.T) {
+	fields := []struct {
+		name       string
+		defaultMax int
+		errField   string
+		other      string                              // another configurable field, whose override must not apply
+		submit     func(e *testEnv, text string) error // SHIP-1 has been created
+	}{
+		{
+			name: fieldLimitRecallReason, defaultMax: maxRecallReasonLength, errField: "reason", other: fieldLimitCorrectiveAction,
+			submit: func(e *testEnv, text string) error {
+				return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", text)
+			},
+		},
+		{
+			name: fieldLimitCertificationComments, defaultMax: maxDescriptionLength, errField: "comments", other: fieldLimitRecallReason,
+			submit: func(e *testEnv, text string) error {
+				e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1"))
+				return e.cc.RecordCertification(e.as(e.certifier), "SHIP-1", e.now.Format(time.RFC3339), "", "APPROVED", text)
+			},
+		},
+		{
+			name: fieldLimitShipmentDescription, defaultMax: maxDescriptionLength, errField: "description", other: fieldLimitRecallReason,
+			submit: func(e *testEnv, text string) error {
+				return e.cc.CreateShipment(e.as(e.farmer), "SHIP-2", "Strawberries", text, 100, "kg", e.farmerData(nil))
+			},
+		},
+		{
+			name: fieldLimitCorrectiveAction, defaultMax: maxDescriptionLength, errField: "actionDescription", other: fieldLimitRecallReason,
+			submit: func(e *testEnv, text string) error {
+				e.rejectCertification("SHIP-1")
+				return e.cc.AddCorrectiveAction(e.as(e.farmer), "SHIP-1", text)
+			},
+		},
+	}
+	for _, field := range fields {
+		tests := []struct {
+			name    string
+			limits  string // "" leaves the limits unset
+			length  int
+			wantErr string
+		}{
+			{name: "default limit accepts", length: field.defaultMax},
+			{name: "default limit rejects", length: field.defaultMax + 1, wantErr: fmt.Sprintf("%s exceeds max length %d", field.errField, field.defaultMax)},
+			{name: "custom limit accepts", limits: `{"` + field.name + `": 20}`, length: 20},
+			{name: "custom limit rejects", limits: `{"` + field.name + `": 20}`, length: 21, wantErr: field.errField + " exceeds max length 20"},
+			{name: "limit for another field ignored", limits: `{"` + field.other + `": 20}`, length: field.defaultMax},
+		}
+		for _, tt := range tests {
+			t.Run(field.name+"/"+tt.name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				e.createShipment("SHIP-1")
+				if tt.limits != "" {
+					e.must(e.cc.SetFieldLengthLimits(e.as(e.admin), tt.limits))
+				}
+				checkErr(t, field.submit(e, strings.Repeat("x", tt.length)), tt.wantErr)
+			})
+		}
+	}
+}
+
+func TestSetFieldLengthLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		limits  string
+		want    map[string]int
+		wantErr string
+	}{
+		{
+			name: "override merged with defaults", limits: `{"recallReason": 64}`,
+			want: map[string]int{fieldLimitRecallReason: 64, fieldLimitCertificationComments: maxDescriptionLength, fieldLimitShipmentDescription: maxDescriptionLength, fieldLimitCorrectiveAction: maxDescriptionLength},
+		},
+		{
+			name: "empty object restores defaults", limits: `{}`,
+			want: map[string]int{fieldLimitRecallReason: maxRecallReasonLength, fieldLimitCertificationComments: maxDescriptionLength, fieldLimitShipmentDescription: maxDescriptionLength, fieldLimitCorrectiveAction: maxDescriptionLength},
+		},
+		{name: "unknown field rejected", limits: `{"farmLocation": 64}`, wantErr: "field 'farmLocation' does not have a configurable length limit"},
+		{name: "zero limit rejected", limits: `{"recallReason": 0}`, wantErr: "must be between 1 and 16384"},
+		{name: "oversized limit rejected", limits: `{"recallReason": 16385}`, wantErr: "must be between 1 and 16384"},
+		{name: "invalid JSON rejected", limits: `{"recallReason":`, wantErr: "invalid limitsJSON"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, limits: `{"recallReason": 64}`, wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.must(e.cc.SetFieldLengthLimits(e.as(e.admin), `{"recallReason": 32}`))
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			checkErr(t, e.cc.SetFieldLengthLimits(e.as(caller), tt.limits), tt.wantErr)
+			got, err := e.cc.GetFieldLengthLimits(e.as(e.farmer))
+			e.must(err)
+			if tt.wantErr != "" {
+				if got[fieldLimitRecallReason] != 32 {
+					t.Fatalf("rejected update changed recallReason limit to %d", got[fieldLimitRecallReason])
+				}
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("limits = %v, want %v", got, tt.want)
+			}
+			for field, limit := range tt.want {
+				if got[field] != limit {
+					t.Errorf("limit for %s = %d, want %d", field, got[field], limit)
+				}
+			}
+		})
+	}
+}
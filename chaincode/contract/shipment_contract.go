@@ -26,10 +26,19 @@ const (
 	maxStringInputLength    = 256
 	maxDescriptionLength    = 1024
 	maxRecallReasonLength   = 512
-	defaultRecallQueryHours = 72 // Default time window (+/- hours) for related shipment query
-	maxArrayElements        = 50 // Arbitrary limit for arrays like QualityCertifications, TransitLocationLog
+	defaultRecallQueryHours = 72   // Default time window (+/- hours) for related shipment query
+	maxArrayElements        = 50   // Arbitrary limit for arrays like QualityCertifications, TransitLocationLog
+	maxIdentityBatchSize    = 100  // Entries accepted by RegisterIdentitiesBatch
+	maxAggregateScan        = 5000 // Upper bound on shipments read by non-paginated aggregate scans
+	maxTimeSeriesDays       = 366  // Longest range accepted by time series queries
+	maxLineageDepth         = 10   // Deepest chain of input shipments followed by lineage queries
 )
 
+// enforceDestinationRoles makes CreateShipment, ProcessShipment, TransformAndCreateProducts and DistributeShipment
+// reject a designated next actor that lacks the matching role. Turn it off only for fixtures that use unregistered
+// destinations; the per-function admin flags then apply.
+const enforceDestinationRoles = true
+
 // FoodtraceSmartContract provides functions for managing food shipments.
 // @contract:FoodtraceSmartContract
 type FoodtraceSmartContract struct {
@@ -60,16 +69,89 @@ func (s *FoodtraceSmartContract) RegisterIdentity(ctx contractapi.TransactionCon
 	return NewIdentityManager(ctx).RegisterIdentity(targetFullID, shortName, enrollmentID)
 }
 
+// RegisterIdentitiesBatch registers a JSON array of {fullId, shortName, enrollmentId} entries in one
+// transaction, with the same authorization as RegisterIdentity. Any invalid entry aborts the whole batch.
+// Returns how many identities were newly created versus updated.
+func (s *FoodtraceSmartContract) RegisterIdentitiesBatch(ctx contractapi.TransactionContextInterface, identitiesJSON string) (map[string]interface{}, error) {
+	logger.Info("Chaincode Call: RegisterIdentitiesBatch")
+	var requests []identityRegistrationRequest
+	if err := json.Unmarshal([]byte(identitiesJSON), &requests); err != nil {
+		return nil, fmt.Errorf("RegisterIdentitiesBatch: invalid identitiesJSON: %w", err)
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("RegisterIdentitiesBatch: at least one identity must be specified")
+	}
+	if len(requests) > maxIdentityBatchSize {
+		return nil, fmt.Errorf("RegisterIdentitiesBatch: %d identities exceeds maximum of %d", len(requests), maxIdentityBatchSize)
+	}
+	return NewIdentityManager(ctx).RegisterIdentitiesBatch(requests)
+}
+
+// BackfillIdentityIndexes indexes identities registered before the identity lookup indexes existed (admin only).
+// Run it once after upgrading the chaincode.
+func (s *FoodtraceSmartContract) BackfillIdentityIndexes(ctx contractapi.TransactionContextInterface) (map[string]interface{}, error) {
+	logger.Info("Chaincode Call: BackfillIdentityIndexes")
+	return NewIdentityManager(ctx).BackfillIdentityIndexes()
+}
+
+// RenameAlias changes the shortName of an identity (admin only).
+func (s *FoodtraceSmartContract) RenameAlias(ctx contractapi.TransactionContextInterface, identityOrAlias, newShortName string) error {
+	logger.Infof("Chaincode Call: RenameAlias '%s' to '%s'", identityOrAlias, newShortName)
+	return NewIdentityManager(ctx).RenameAlias(identityOrAlias, newShortName)
+}
+
 func (s *FoodtraceSmartContract) AssignRoleToIdentity(ctx contractapi.TransactionContextInterface, identityOrAlias, role string) error {
 	logger.Infof("Chaincode Call: AssignRole '%s' to '%s'", role, identityOrAlias)
 	return NewIdentityManager(ctx).AssignRole(identityOrAlias, role)
 }
 
+// AssignRoleToIdentities assigns a role to a JSON array of identities or aliases and
+// summarizes which were newly assigned, already held the role, or failed (admin only).
+// With dryRun set nothing is written; the summary previews what would be applied.
+func (s *FoodtraceSmartContract) AssignRoleToIdentities(ctx contractapi.TransactionContextInterface, identitiesJSON string, role string, dryRun bool) (map[string]interface{}, error) {
+	logger.Infof("Chaincode Call: AssignRoleToIdentities '%s' (dryRun: %t)", role, dryRun)
+	var targets []string
+	if err := json.Unmarshal([]byte(identitiesJSON), &targets); err != nil {
+		return nil, fmt.Errorf("AssignRoleToIdentities: invalid identitiesJSON: %w", err)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("AssignRoleToIdentities: at least one identity must be specified")
+	}
+	if len(targets) > maxArrayElements {
+		return nil, fmt.Errorf("AssignRoleToIdentities: %d identities exceeds maximum of %d", len(targets), maxArrayElements)
+	}
+	return NewIdentityManager(ctx).AssignRoleToIdentities(targets, role, dryRun)
+}
+
+// AssignRolesBatch adds a JSON array of roles to one identity in a single update (admin only)
+// and returns the roles that were newly added.
+func (s *FoodtraceSmartContract) AssignRolesBatch(ctx contractapi.TransactionContextInterface, identityOrAlias string, rolesJSON string) ([]string, error) {
+	logger.Infof("Chaincode Call: AssignRolesBatch for '%s'", identityOrAlias)
+	var roles []string
+	if err := json.Unmarshal([]byte(rolesJSON), &roles); err != nil {
+		return nil, fmt.Errorf("AssignRolesBatch: invalid rolesJSON: %w", err)
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("AssignRolesBatch: at least one role must be specified")
+	}
+	if len(roles) > maxArrayElements {
+		return nil, fmt.Errorf("AssignRolesBatch: %d roles exceeds maximum of %d", len(roles), maxArrayElements)
+	}
+	return NewIdentityManager(ctx).AssignRolesBatch(identityOrAlias, roles)
+}
+
 func (s *FoodtraceSmartContract) RemoveRoleFromIdentity(ctx contractapi.TransactionContextInterface, identityOrAlias, role string) error {
 	logger.Infof("Chaincode Call: RemoveRole '%s' from '%s'", role, identityOrAlias)
 	return NewIdentityManager(ctx).RemoveRole(identityOrAlias, role)
 }
 
+// RemoveRoleFromIdentityWithForce removes a role, overriding the check that blocks removing
+// "certifier" from an identity still designated on shipments awaiting certification (admin only).
+func (s *FoodtraceSmartContract) RemoveRoleFromIdentityWithForce(ctx contractapi.TransactionContextInterface, identityOrAlias, role string, force bool) error {
+	logger.Infof("Chaincode Call: RemoveRole '%s' from '%s' (force: %t)", role, identityOrAlias, force)
+	return NewIdentityManager(ctx).RemoveRoleWithForce(identityOrAlias, role, force)
+}
+
 func (s *FoodtraceSmartContract) MakeIdentityAdmin(ctx contractapi.TransactionContextInterface, identityOrAlias string) error {
 	logger.Infof("Chaincode Call: MakeAdmin for '%s'", identityOrAlias)
 	return NewIdentityManager(ctx).MakeAdmin(identityOrAlias)
@@ -80,6 +162,12 @@ func (s *FoodtraceSmartContract) RemoveIdentityAdmin(ctx contractapi.Transaction
 	return NewIdentityManager(ctx).RemoveAdmin(identityOrAlias)
 }
 
+// GetIdentityByFingerprint looks up an identity by the SHA-256 fingerprint of its X.509 certificate.
+func (s *FoodtraceSmartContract) GetIdentityByFingerprint(ctx contractapi.TransactionContextInterface, fingerprint string) (*model.IdentityInfo, error) {
+	logger.Debugf("Chaincode Call: GetIdentityByFingerprint for '%s'", fingerprint)
+	return NewIdentityManager(ctx).GetIdentityByFingerprint(fingerprint)
+}
+
 func (s *FoodtraceSmartContract) GetIdentityDetails(ctx contractapi.TransactionContextInterface, identityOrAlias string) (*model.IdentityInfo, error) {
 	logger.Debugf("Chaincode Call: GetIdentityDetails for '%s'", identityOrAlias)
 	im := NewIdentityManager(ctx)
@@ -114,6 +202,49 @@ func (s *FoodtraceSmartContract) GetAllIdentities(ctx contractapi.TransactionCon
 	return NewIdentityManager(ctx).GetAllRegisteredIdentities()
 }
 
+// GetIdentitiesRegisteredBy lists the identities onboarded by a given registrar (admin only).
+func (s *FoodtraceSmartContract) GetIdentitiesRegisteredBy(ctx contractapi.TransactionContextInterface, registrarIdentityOrAlias string) ([]model.IdentityInfo, error) {
+	logger.Debugf("Chaincode Call: GetIdentitiesRegisteredBy for '%s'", registrarIdentityOrAlias)
+	return NewIdentityManager(ctx).GetIdentitiesRegisteredBy(registrarIdentityOrAlias)
+}
+
+// DeactivateIdentity suspends an identity so it no longer passes role checks (admin only).
+func (s *FoodtraceSmartContract) DeactivateIdentity(ctx contractapi.TransactionContextInterface, identityOrAlias string) error {
+	logger.Infof("Chaincode Call: DeactivateIdentity for '%s'", identityOrAlias)
+	return NewIdentityManager(ctx).DeactivateIdentity(identityOrAlias)
+}
+
+// DeactivateIdentityWithForce deactivates an identity, overriding the check that blocks deactivating a
+// certifier still designated on shipments awaiting certification (admin only).
+func (s *FoodtraceSmartContract) DeactivateIdentityWithForce(ctx contractapi.TransactionContextInterface, identityOrAlias string, force bool) error {
+	logger.Infof("Chaincode Call: DeactivateIdentity for '%s' (force: %t)", identityOrAlias, force)
+	return NewIdentityManager(ctx).DeactivateIdentityWithForce(identityOrAlias, force)
+}
+
+// ReactivateIdentity restores a deactivated identity (admin only).
+func (s *FoodtraceSmartContract) ReactivateIdentity(ctx contractapi.TransactionContextInterface, identityOrAlias string) error {
+	logger.Infof("Chaincode Call: ReactivateIdentity for '%s'", identityOrAlias)
+	return NewIdentityManager(ctx).ReactivateIdentity(identityOrAlias)
+}
+
+// GetInactiveIdentities lists identities that have been deactivated (admin only).
+func (s *FoodtraceSmartContract) GetInactiveIdentities(ctx contractapi.TransactionContextInterface) ([]model.IdentityInfo, error) {
+	logger.Debug("Chaincode Call: GetInactiveIdentities")
+	return NewIdentityManager(ctx).GetInactiveIdentities()
+}
+
+// GetIdentitiesByMSP lists the identities belonging to an organization MSP, matched case-insensitively (admin only).
+func (s *FoodtraceSmartContract) GetIdentitiesByMSP(ctx contractapi.TransactionContextInterface, mspID string) ([]model.IdentityInfo, error) {
+	logger.Debugf("Chaincode Call: GetIdentitiesByMSP for '%s'", mspID)
+	return NewIdentityManager(ctx).GetIdentitiesByMSP(mspID)
+}
+
+// GetIdentityCountsByMSP returns the number of registered identities per organization MSP (admin only).
+func (s *FoodtraceSmartContract) GetIdentityCountsByMSP(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	logger.Debug("Chaincode Call: GetIdentityCountsByMSP")
+	return NewIdentityManager(ctx).GetIdentityCountsByMSP()
+}
+
 // GetAllAliases returns a list of all registered aliases (shortNames) in the system.
 // This is a public function that doesn't require admin privileges.
 func (s *FoodtraceSmartContract) GetAllAliases(ctx contractapi.TransactionContextInterface) ([]string, error) {
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"foodtrace/model"
+	"math"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -39,11 +40,39 @@ func (s *FoodtraceSmartContract) DistributeShipment(ctx contractapi.TransactionC
 	if err != nil {
 		return fmt.Errorf("DistributeShipment: %w", err)
 	}
+	blockOnQualityFailure, err := getConfigBool(ctx, configBlockDistributionOnQualityFail, false)
+	if err != nil {
+		return fmt.Errorf("DistributeShipment: failed to read quality failure setting: %w", err)
+	}
+	if blockOnQualityFailure {
+		failure, errQuality := upstreamQualityFailure(ctx, shipment)
+		if errQuality != nil {
+			return fmt.Errorf("DistributeShipment: failed to check upstream quality: %w", errQuality)
+		}
+		if failure != "" {
+			return fmt.Errorf("shipment '%s' cannot be distributed: upstream quality failure recorded (%s)", shipmentID, failure)
+		}
+	}
+	if len(ddArgs.StorageTemperatures) == 0 && shipment.FarmerData != nil && shipment.FarmerData.CropType != "" {
+		coldChainRequired, errCfg := configListContains(ctx, configColdChainCropTypes, shipment.FarmerData.CropType)
+		if errCfg != nil {
+			return fmt.Errorf("DistributeShipment: failed to read cold-chain crop types: %w", errCfg)
+		}
+		if coldChainRequired {
+			return fmt.Errorf("distributorData.storageTemperatures is required for refrigerated crop type '%s'", shipment.FarmerData.CropType)
+		}
+	}
 
 	destRetFullID, err := im.ResolveIdentity(ddArgs.DestinationRetailerID)
 	if err != nil {
 		return fmt.Errorf("DistributeShipment: failed to resolve distributorData.destinationRetailerId '%s': %w", ddArgs.DestinationRetailerID, err)
 	}
+	if destRetFullID == actor.fullID {
+		return fmt.Errorf("DistributeShipment: distributor '%s' cannot designate themselves as the destination retailer", actor.alias)
+	}
+	if err := requireDestinationRole(im, "DistributeShipment", destRetFullID, ddArgs.DestinationRetailerID, "retailer"); err != nil {
+		return err
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
@@ -64,10 +93,14 @@ func (s *FoodtraceSmartContract) DistributeShipment(ctx contractapi.TransactionC
                 DistributionCenter:    ddArgs.DistributionCenter,
                 DestinationRetailerID: destRetFullID,
         }
+	transferReason := transferReasonFromJSON(distributorDataJSON)
+	if err := s.recordCustodyTransfer(ctx, shipment, actor, model.StatusDistributed, transferReason, now); err != nil {
+		return fmt.Errorf("DistributeShipment: %w", err)
+	}
 	shipment.Status = model.StatusDistributed
 	shipment.CurrentOwnerID = actor.fullID
 	shipment.CurrentOwnerAlias = actor.alias
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 	ensureShipmentSchemaCompliance(shipment) // Ensure sub-fields are initialized
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
@@ -86,7 +119,174 @@ func (s *FoodtraceSmartContract) DistributeShipment(ctx contractapi.TransactionC
 	if !ddArgs.DeliveryDateTime.IsZero() {
 		eventPayload["deliveryDateTime"] = ddArgs.DeliveryDateTime.Format(time.RFC3339)
 	}
+	if transferReason != "" {
+		eventPayload["transferReason"] = transferReason
+	}
 	s.emitShipmentEvent(ctx, "ShipmentDistributed", shipment, actor, eventPayload)
 	logger.Infof("Shipment '%s' distributed by '%s'", shipmentID, actor.alias)
 	return nil
 }
+
+// AddDistributionLeg appends a hop between distribution centers to a distributed shipment.
+// Only the distributor holding the shipment may add legs, and each leg must start no earlier
+// than the previous leg's dropoff.
+func (s *FoodtraceSmartContract) AddDistributionLeg(ctx contractapi.TransactionContextInterface, shipmentID string, legDataJSON string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("AddDistributionLeg: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("distributor"); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+
+	var input struct {
+		FromCenter          string `json:"fromCenter"`
+		ToCenter            string `json:"toCenter"`
+		PickupDateTime      string `json:"pickupDateTime"`
+		DropoffDateTime     string `json:"dropoffDateTime"`
+		TransportConditions string `json:"transportConditions"`
+	}
+	if err := json.Unmarshal([]byte(legDataJSON), &input); err != nil {
+		return fmt.Errorf("AddDistributionLeg: invalid legDataJSON: %w", err)
+	}
+	if err := s.validateRequiredString(input.FromCenter, "legData.fromCenter", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(input.ToCenter, "legData.toCenter", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateOptionalString(input.TransportConditions, "legData.transportConditions", maxDescriptionLength); err != nil {
+		return err
+	}
+	pickup, err := parseDateString(input.PickupDateTime, "legData.pickupDateTime", true)
+	if err != nil {
+		return err
+	}
+	dropoff, err := parseDateString(input.DropoffDateTime, "legData.dropoffDateTime", true)
+	if err != nil {
+		return err
+	}
+	if dropoff.Before(pickup) {
+		return fmt.Errorf("legData.dropoffDateTime (%s) cannot be before legData.pickupDateTime (%s)", dropoff.Format(time.RFC3339), pickup.Format(time.RFC3339))
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("AddDistributionLeg: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("AddDistributionLeg: %w", err)
+	}
+	if shipment.Status != model.StatusDistributed {
+		return fmt.Errorf("AddDistributionLeg: shipment '%s' status '%s' does not accept distribution legs. Expected '%s'", shipmentID, shipment.Status, model.StatusDistributed)
+	}
+	if shipment.DistributorData == nil || shipment.DistributorData.DistributorID != actor.fullID || shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("AddDistributionLeg: distributor '%s' is not the current distributor of shipment '%s'", actor.alias, shipmentID)
+	}
+	if shipment.RecallInfo.IsRecalled {
+		return fmt.Errorf("AddDistributionLeg: recalled shipment '%s' cannot be updated", shipmentID)
+	}
+
+	legs := shipment.DistributorData.Legs
+	if len(legs) >= maxArrayElements {
+		return fmt.Errorf("AddDistributionLeg: shipment '%s' already has the maximum of %d legs", shipmentID, maxArrayElements)
+	}
+	if len(legs) > 0 {
+		prev := legs[len(legs)-1]
+		if pickup.Before(prev.DropoffDateTime) {
+			return fmt.Errorf("AddDistributionLeg: leg pickup (%s) is before the previous leg's dropoff (%s)", pickup.Format(time.RFC3339), prev.DropoffDateTime.Format(time.RFC3339))
+		}
+	} else if !shipment.DistributorData.PickupDateTime.IsZero() && pickup.Before(shipment.DistributorData.PickupDateTime) {
+		return fmt.Errorf("AddDistributionLeg: leg pickup (%s) is before the shipment's distribution pickup (%s)", pickup.Format(time.RFC3339), shipment.DistributorData.PickupDateTime.Format(time.RFC3339))
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("AddDistributionLeg: failed to get transaction timestamp: %w", err)
+	}
+	leg := model.DistributionLeg{
+		FromCenter:          input.FromCenter,
+		ToCenter:            input.ToCenter,
+		PickupDateTime:      pickup,
+		DropoffDateTime:     dropoff,
+		TransportConditions: input.TransportConditions,
+		RecordedAt:          now,
+	}
+	shipment.DistributorData.Legs = append(legs, leg)
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("AddDistributionLeg: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("AddDistributionLeg: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentEvent(ctx, "DistributionLegAdded", shipment, actor, map[string]interface{}{
+		"legIndex": len(shipment.DistributorData.Legs) - 1, "fromCenter": leg.FromCenter, "toCenter": leg.ToCenter,
+	})
+	logger.Infof("Distribution leg %s -> %s added to shipment '%s' by '%s'", leg.FromCenter, leg.ToCenter, shipmentID, actor.alias)
+	return nil
+}
+
+// GetDistributionLegs returns the recorded distribution legs of a shipment in chronological order.
+func (s *FoodtraceSmartContract) GetDistributionLegs(ctx contractapi.TransactionContextInterface, shipmentID string) ([]model.DistributionLeg, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDistributionLegs: %w", err)
+	}
+	return shipment.DistributorData.Legs, nil // getShipmentByID guarantees a non-nil slice
+}
+
+// earthRadiusKm is the mean Earth radius used for great-circle distances.
+const earthRadiusKm = 6371.0
+
+// GetTransportCarbonEstimate gives a rough transport emissions figure for a shipment. It sums the
+// haversine distance between consecutive distributorData.transitGpsLog points (distribution legs
+// carry center names, not coordinates) and multiplies it by the admin-set emission factor in
+// kg CO2e per km. Fewer than two points yields zero.
+func (s *FoodtraceSmartContract) GetTransportCarbonEstimate(ctx contractapi.TransactionContextInterface, shipmentID string) (map[string]interface{}, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetTransportCarbonEstimate: %w", err)
+	}
+	emissionFactor, err := getConfigFloat(ctx, configTransportEmissionFactor, 0)
+	if err != nil {
+		return nil, fmt.Errorf("GetTransportCarbonEstimate: %w", err)
+	}
+
+	points := shipment.DistributorData.TransitGPSLog // getShipmentByID guarantees non-nil DistributorData
+	distanceKm := 0.0
+	for i := 1; i < len(points); i++ {
+		distanceKm += haversineKm(points[i-1], points[i])
+	}
+	return map[string]interface{}{
+		"shipmentId":      shipmentID,
+		"pointCount":      len(points),
+		"totalDistanceKm": distanceKm,
+		"emissionFactor":  emissionFactor,
+		"estimatedKgCO2e": distanceKm * emissionFactor,
+	}, nil
+}
+
+// haversineKm returns the great-circle distance between two points in kilometres.
+func haversineKm(a, b model.GeoPoint) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLon := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
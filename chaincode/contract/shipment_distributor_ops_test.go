@@ -0,0 +1,293 @@
+package contract
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+// legData builds distribution leg JSON with pickup and dropoff given as hours after testEpoch.
+func legData(from, to string, pickupHours, dropoffHours int) string {
+	return encodeTestJSON(map[string]interface{}{
+		"fromCenter": from, "toCenter": to, "transportConditions": "Reefer at 2C",
+		"pickupDateTime":  testEpoch.Add(time.Duration(pickupHours) * time.Hour).Format(time.RFC3339),
+		"dropoffDateTime": testEpoch.Add(time.Duration(dropoffHours) * time.Hour).Format(time.RFC3339),
+	}, nil)
+}
+
+func TestAddDistributionLeg(t *testing.T) {
+	tests := []struct {
+		name     string
+		legs     []string // all but the last must succeed
+		wantErr  string   // expected from the last leg
+		wantLegs []string // toCenter of each stored leg
+	}{
+		{name: "single leg", legs: []string{legData("DC North", "DC Central", 1, 5)}, wantLegs: []string{"DC Central"}},
+		{
+			name:     "sequential legs",
+			legs:     []string{legData("DC North", "DC Central", 1, 5), legData("DC Central", "DC South", 5, 9), legData("DC South", "Store Hub", 12, 14)},
+			wantLegs: []string{"DC Central", "DC South", "Store Hub"},
+		},
+		{
+			name:     "pickup before previous dropoff",
+			legs:     []string{legData("DC North", "DC Central", 1, 5), legData("DC Central", "DC South", 4, 9)},
+			wantErr:  "before the previous leg's dropoff",
+			wantLegs: []string{"DC Central"},
+		},
+		{name: "pickup before distribution pickup", legs: []string{legData("DC North", "DC Central", -1, 5)}, wantErr: "before the shipment's distribution pickup", wantLegs: []string{}},
+		{name: "dropoff before pickup", legs: []string{legData("DC North", "DC Central", 5, 1)}, wantErr: "cannot be before", wantLegs: []string{}},
+		{name: "missing center", legs: []string{legData("DC North", "", 1, 5)}, wantErr: "toCenter cannot be empty", wantLegs: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.distribute("SHIP-1")
+
+			for i, leg := range tt.legs {
+				err := e.cc.AddDistributionLeg(e.as(e.distributor), "SHIP-1", leg)
+				if i < len(tt.legs)-1 {
+					e.must(err)
+					continue
+				}
+				checkErr(t, err, tt.wantErr)
+			}
+
+			legs, err := e.cc.GetDistributionLegs(e.as(e.retailer), "SHIP-1")
+			e.must(err)
+			got := []string{}
+			for i, leg := range legs {
+				got = append(got, leg.ToCenter)
+				if i > 0 && leg.PickupDateTime.Before(legs[i-1].DropoffDateTime) {
+					t.Errorf("leg %d picks up before leg %d drops off", i, i-1)
+				}
+			}
+			if !equalStrings(got, tt.wantLegs) {
+				t.Fatalf("legs = %v, want %v", got, tt.wantLegs)
+			}
+		})
+	}
+
+	t.Run("only the current distributor", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		e.distribute("SHIP-1")
+		other := newTestIdentity("distributor2", "Org3MSP")
+		e.register(other, "distributor")
+		checkErr(t, e.cc.AddDistributionLeg(e.as(other), "SHIP-1", legData("DC North", "DC Central", 1, 5)), "not the current distributor")
+	})
+
+	t.Run("shipment not in distribution", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		checkErr(t, e.cc.AddDistributionLeg(e.as(e.distributor), "SHIP-1", legData("DC North", "DC Central", 1, 5)), "does not accept distribution legs")
+	})
+}
+
+func TestDistributeShipmentBlockedOnQualityFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		blocked       bool
+		processorData map[string]interface{}
+		storedCheck   string // overwrites the recorded contamination check, as on records written before normalization
+		targets       string // cooling targets configured after processing
+		rejectedCert  bool   // records a REJECTED certification, e.g. for paperwork, before distribution
+		wantErr       string
+	}{
+		{name: "failed contamination check blocked", blocked: true, processorData: map[string]interface{}{"contaminationCheck": "failed"}, wantErr: "contamination check failed"},
+		{name: "padded contamination check blocked", blocked: true, processorData: map[string]interface{}{"contaminationCheck": " Failed\t"}, wantErr: "contamination check failed"},
+		{name: "unnormalized stored check blocked", blocked: true, storedCheck: " failed ", wantErr: "contamination check failed"},
+		{name: "non-failure check value allowed", blocked: true, processorData: map[string]interface{}{"contaminationCheck": "REJECTED"}},
+		{name: "rejected certification with a passed check allowed", blocked: true, rejectedCert: true},
+		{
+			name: "missed cooling target blocked", blocked: true,
+			processorData: map[string]interface{}{"coolingEndTemp": 6.0}, targets: `{"strawberry": 4}`,
+			wantErr: "cooling end temperature 6.0 °C missed the 4.0 °C target",
+		},
+		{name: "met cooling target allowed", blocked: true, processorData: map[string]interface{}{"coolingEndTemp": 3.0}, targets: `{"strawberry": 4}`},
+		{name: "clean shipment allowed", blocked: true},
+		{name: "failure allowed when not blocking", processorData: map[string]interface{}{"contaminationCheck": "FAILED"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.must(e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(tt.processorData), ""))
+			if check, ok := tt.processorData["contaminationCheck"].(string); ok {
+				if stored := e.shipment("SHIP-1").ProcessorData.ContaminationCheck; stored != strings.ToUpper(strings.TrimSpace(check)) {
+					t.Fatalf("stored contamination check = %q, want it normalized", stored)
+				}
+			}
+			if tt.storedCheck != "" {
+				shipment := e.shipment("SHIP-1")
+				shipment.ProcessorData.ContaminationCheck = tt.storedCheck
+				e.putShipment(shipment)
+			}
+			if tt.rejectedCert {
+				shipment := e.shipment("SHIP-1")
+				shipment.CertificationRecords = append(shipment.CertificationRecords, model.CertificationRecord{
+					CertifierID: e.certifier.id, Status: model.CertStatusRejected, Comments: "Missing paperwork", CertifiedAt: e.now,
+				})
+				e.putShipment(shipment)
+			}
+			if tt.targets != "" {
+				e.must(e.cc.SetCoolingTargets(e.as(e.admin), tt.targets))
+			}
+			if tt.blocked {
+				e.must(e.cc.SetDistributionBlockedOnQualityFailure(e.as(e.admin), true))
+			}
+
+			err := e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(nil))
+			checkErr(t, err, tt.wantErr)
+			want := model.StatusDistributed
+			if tt.wantErr != "" {
+				want = model.StatusProcessed
+			}
+			if status := e.shipment("SHIP-1").Status; status != want {
+				t.Fatalf("status = %s, want %s", status, want)
+			}
+		})
+	}
+}
+
+func TestDistributeShipmentColdChainStorageTemperatures(t *testing.T) {
+	tests := []struct {
+		name         string
+		coldChain    string // cold-chain crop types; "" leaves the setting unset
+		temperatures interface{}
+		wantErr      string
+	}{
+		{name: "cold-chain crop without temperatures rejected", coldChain: `["Strawberry"]`, wantErr: "storageTemperatures is required for refrigerated crop type 'strawberry'"},
+		{name: "cold-chain crop with empty temperatures rejected", coldChain: `["strawberry"]`, temperatures: []float64{}, wantErr: "storageTemperatures is required"},
+		{name: "cold-chain crop with temperatures accepted", coldChain: `["strawberry"]`, temperatures: []float64{2.5, 3.0}},
+		{name: "other crop without temperatures accepted", coldChain: `["lettuce"]`},
+		{name: "cleared list makes temperatures optional", coldChain: `[]`},
+		{name: "unset list makes temperatures optional"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			if tt.coldChain != "" {
+				e.must(e.cc.SetColdChainCropTypes(e.as(e.admin), tt.coldChain))
+			}
+
+			err := e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(map[string]interface{}{"storageTemperatures": tt.temperatures}))
+			checkErr(t, err, tt.wantErr)
+			want := model.StatusDistributed
+			if tt.wantErr != "" {
+				want = model.StatusProcessed
+			}
+			if status := e.shipment("SHIP-1").Status; status != want {
+				t.Fatalf("status = %s, want %s", status, want)
+			}
+		})
+	}
+}
+
+func TestDistributeShipmentSelfDestination(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination func(e *testEnv) string
+		wantErr     string
+	}{
+		{name: "distinct retailer accepted", destination: func(e *testEnv) string { return e.retailer.alias }},
+		{
+			name:        "own alias rejected",
+			destination: func(e *testEnv) string { return e.distributor.alias },
+			wantErr:     "distributor 'distributor1' cannot designate themselves as the destination retailer",
+		},
+		{name: "own full ID rejected", destination: func(e *testEnv) string { return e.distributor.id }, wantErr: "cannot designate themselves"},
+		{name: "non-retailer rejected", destination: func(e *testEnv) string { return e.processor.alias }, wantErr: "does not have 'retailer' role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.must(e.cc.AssignRoleToIdentity(e.as(e.admin), e.distributor.alias, "retailer")) // Holds both roles
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+
+			err := e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(map[string]interface{}{"destinationRetailerId": tt.destination(e)}))
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != model.StatusProcessed {
+					t.Fatalf("status = %s, want %s", shipment.Status, model.StatusProcessed)
+				}
+				return
+			}
+			if shipment.Status != model.StatusDistributed || shipment.DistributorData.DestinationRetailerID != e.retailer.id {
+				t.Fatalf("status %s, destination retailer %s", shipment.Status, shipment.DistributorData.DestinationRetailerID)
+			}
+		})
+	}
+}
+
+func TestGetTransportCarbonEstimate(t *testing.T) {
+	oneDegreeKm := earthRadiusKm * math.Pi / 180 // Great-circle length of one degree of arc
+	point := func(lat, lon float64) map[string]float64 {
+		return map[string]float64{"latitude": lat, "longitude": lon}
+	}
+	tests := []struct {
+		name         string
+		gpsLog       []map[string]float64
+		factor       string // "" leaves the emission factor unset
+		wantKm       float64
+		toleranceKm  float64
+		wantEmission float64
+	}{
+		{name: "no points", factor: "0.1"},
+		{name: "single point", gpsLog: []map[string]float64{point(36.8, -119.8)}, factor: "0.1"},
+		{name: "one degree along the equator", gpsLog: []map[string]float64{point(0, 0), point(0, 1)}, factor: "0.1", wantKm: oneDegreeKm, wantEmission: oneDegreeKm * 0.1},
+		{
+			name:   "consecutive segments are summed",
+			gpsLog: []map[string]float64{point(0, 0), point(0, 1), point(1, 1)}, factor: "0.25",
+			wantKm: 2 * oneDegreeKm, wantEmission: 2 * oneDegreeKm * 0.25,
+		},
+		{name: "quarter meridian", gpsLog: []map[string]float64{point(0, 0), point(90, 0)}, factor: "1", wantKm: earthRadiusKm * math.Pi / 2, wantEmission: earthRadiusKm * math.Pi / 2},
+		{name: "London to Paris", gpsLog: []map[string]float64{point(51.5074, -0.1278), point(48.8566, 2.3522)}, wantKm: 343.5, toleranceKm: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			overrides := map[string]interface{}{}
+			if tt.gpsLog != nil {
+				overrides["transitGpsLog"] = tt.gpsLog
+			}
+			e.must(e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(overrides)))
+			if tt.factor != "" {
+				e.must(e.cc.SetTransportEmissionFactor(e.as(e.admin), tt.factor))
+			}
+
+			estimate, err := e.cc.GetTransportCarbonEstimate(e.as(e.retailer), "SHIP-1")
+			e.must(err)
+			if estimate["pointCount"] != len(tt.gpsLog) {
+				t.Fatalf("pointCount = %v, want %d", estimate["pointCount"], len(tt.gpsLog))
+			}
+			tolerance := tt.toleranceKm
+			if tolerance == 0 {
+				tolerance = 1e-9
+			}
+			if km := estimate["totalDistanceKm"].(float64); math.Abs(km-tt.wantKm) > tolerance {
+				t.Fatalf("totalDistanceKm = %v, want %v", km, tt.wantKm)
+			}
+			if kg := estimate["estimatedKgCO2e"].(float64); math.Abs(kg-tt.wantEmission) > 1e-9 {
+				t.Fatalf("estimatedKgCO2e = %v, want %v", kg, tt.wantEmission)
+			}
+		})
+	}
+
+	t.Run("invalid emission factor", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetTransportEmissionFactor(e.as(e.admin), "-0.1"), "must be a non-negative number")
+	})
+}
@@ -0,0 +1,129 @@
+// Author: Muhammad-Tameem Mughal
+// Last updated: Aug 15, 2025
+// Last modified by: Muhammad-Tameem Mughal
+
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"foodtrace/model"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ValidDocumentTypes defines the document categories that may be attached to a shipment.
+var ValidDocumentTypes = map[string]bool{
+	"CERTIFICATE":       true,
+	"INSPECTION_REPORT": true,
+	"LAB_RESULT":        true,
+	"INVOICE":           true,
+	"BILL_OF_LADING":    true,
+	"PHOTO":             true,
+	"OTHER":             true,
+}
+
+// --- Document Operations (any stage) ---
+
+// AttachDocument appends a hashed document reference to a shipment. Callable by the current owner or an admin.
+func (s *FoodtraceSmartContract) AttachDocument(ctx contractapi.TransactionContextInterface, shipmentID, docType, docHash, docURL string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("AttachDocument: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	docTypeUpper := strings.ToUpper(strings.TrimSpace(docType))
+	if !ValidDocumentTypes[docTypeUpper] {
+		validTypes := make([]string, 0, len(ValidDocumentTypes))
+		for t := range ValidDocumentTypes {
+			validTypes = append(validTypes, t)
+		}
+		sort.Strings(validTypes)
+		return fmt.Errorf("invalid docType '%s'. Valid types are: %v", docType, validTypes)
+	}
+	if err := s.validateDocumentHash(docHash, "docHash"); err != nil {
+		return err
+	}
+	if err := s.validateDocumentURL(docURL, "docURL"); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("AttachDocument: %w", err)
+	}
+
+	isCallerAdmin, _ := im.IsCurrentUserAdmin()
+	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only current owner ('%s', alias '%s') or admin can attach documents to shipment '%s'", shipment.CurrentOwnerID, shipment.CurrentOwnerAlias, shipmentID)
+	}
+	if shipment.IsArchived {
+		return fmt.Errorf("archived shipment '%s' cannot have documents attached", shipmentID)
+	}
+	if len(shipment.Documents) >= maxArrayElements {
+		return fmt.Errorf("shipment '%s' already has the maximum of %d attached documents", shipmentID, maxArrayElements)
+	}
+	normalizedHash := strings.ToLower(strings.TrimSpace(docHash))
+	for _, existing := range shipment.Documents {
+		if existing.DocType == docTypeUpper && existing.DocHash == normalizedHash {
+			return fmt.Errorf("document of type '%s' with hash '%s' is already attached to shipment '%s'", docTypeUpper, normalizedHash, shipmentID)
+		}
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("AttachDocument: failed to get transaction timestamp: %w", err)
+	}
+
+	shipment.Documents = append(shipment.Documents, model.AttachedDocument{
+		DocType:         docTypeUpper,
+		DocHash:         normalizedHash,
+		DocURL:          strings.TrimSpace(docURL),
+		AttachedBy:      actor.fullID,
+		AttachedByAlias: actor.alias,
+		AttachedAt:      now,
+		StageStatus:     shipment.Status,
+	})
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("AttachDocument: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("AttachDocument: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentDocumentAttached", shipment, actor, map[string]interface{}{
+		"docType": docTypeUpper, "docHash": normalizedHash, "docURL": strings.TrimSpace(docURL),
+	})
+	logger.Infof("Document '%s' (%s) attached to shipment '%s' by '%s'", normalizedHash, docTypeUpper, shipmentID, actor.alias)
+	return nil
+}
+
+// GetShipmentDocuments returns all documents attached to a shipment.
+func (s *FoodtraceSmartContract) GetShipmentDocuments(ctx contractapi.TransactionContextInterface, shipmentID string) ([]model.AttachedDocument, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentDocuments: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	for i := range shipment.Documents {
+		if shipment.Documents[i].AttachedByAlias == "" && shipment.Documents[i].AttachedBy != "" {
+			if info, errInfo := im.GetIdentityInfo(shipment.Documents[i].AttachedBy); errInfo == nil && info != nil {
+				shipment.Documents[i].AttachedByAlias = info.ShortName
+			}
+		}
+	}
+	return shipment.Documents, nil // Will be [] if empty, not null
+}
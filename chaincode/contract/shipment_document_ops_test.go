@@ -0,0 +1,82 @@
+package contract
+
+import (
+	"strings"
+	"testing"
+
+	"foodtrace/model"
+)
+
+func TestAttachDocument(t *testing.T) {
+	sha256Hex := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		docType string
+		docHash string
+		docURL  string
+		wantErr string
+	}{
+		{name: "owner attaches certificate", docType: "certificate", docHash: sha256Hex, docURL: "https://docs.example.com/cert.pdf"},
+		{name: "admin attaches without URL", caller: func(e *testEnv) *testIdentity { return e.admin }, docType: "LAB_RESULT", docHash: sha256Hex},
+		{name: "ipfs URL", docType: "PHOTO", docHash: sha256Hex, docURL: "ipfs://QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"},
+		{name: "non-owner rejected", caller: func(e *testEnv) *testIdentity { return e.retailer }, docType: "INVOICE", docHash: sha256Hex, wantErr: "only current owner"},
+		{name: "unknown docType", docType: "RECEIPT", docHash: sha256Hex, wantErr: "invalid docType 'RECEIPT'. Valid types are: [BILL_OF_LADING CERTIFICATE INSPECTION_REPORT INVOICE LAB_RESULT OTHER PHOTO]"},
+		{name: "hash too short", docType: "INVOICE", docHash: "abcd", wantErr: "between 32 and 128"},
+		{name: "hash not hex", docType: "INVOICE", docHash: strings.Repeat("zz", 32), wantErr: "hex-encoded"},
+		{name: "unsupported URL scheme", docType: "INVOICE", docHash: sha256Hex, docURL: "ftp://files.example.com/x", wantErr: "http, https or ipfs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			err := e.cc.AttachDocument(e.as(caller), "SHIP-1", tt.docType, tt.docHash, tt.docURL)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" {
+				if name, _ := e.lastEvent(); name != "ShipmentDocumentAttached" {
+					t.Fatalf("event = %s, want ShipmentDocumentAttached", name)
+				}
+			}
+		})
+	}
+}
+
+func TestGetShipmentDocumentsReturnsAllAttachments(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-1")
+	e.must(e.cc.AttachDocument(e.as(e.farmer), "SHIP-1", "CERTIFICATE", strings.Repeat("AB", 32), ""))
+	e.process("SHIP-1")
+	e.must(e.cc.AttachDocument(e.as(e.processor), "SHIP-1", "LAB_RESULT", strings.Repeat("cd", 16), "https://lab.example.com/r/1"))
+
+	// The same digest may back documents of different types, but not the same type twice.
+	e.must(e.cc.AttachDocument(e.as(e.processor), "SHIP-1", "INVOICE", strings.Repeat("ab", 32), ""))
+	err := e.cc.AttachDocument(e.as(e.processor), "SHIP-1", "LAB_RESULT", strings.Repeat("CD", 16), "")
+	checkErr(t, err, "already attached")
+
+	docs, err := e.cc.GetShipmentDocuments(e.as(e.retailer), "SHIP-1")
+	e.must(err)
+	want := []struct {
+		docType string
+		by      string
+		stage   model.ShipmentStatus
+	}{
+		{"CERTIFICATE", e.farmer.alias, model.StatusCreated},
+		{"LAB_RESULT", e.processor.alias, model.StatusProcessed},
+		{"INVOICE", e.processor.alias, model.StatusProcessed},
+	}
+	if len(docs) != len(want) {
+		t.Fatalf("got %d documents, want %d", len(docs), len(want))
+	}
+	for i, w := range want {
+		if docs[i].DocType != w.docType || docs[i].AttachedByAlias != w.by || docs[i].StageStatus != w.stage {
+			t.Errorf("document %d = %s by %s at %s, want %s by %s at %s", i, docs[i].DocType, docs[i].AttachedByAlias, docs[i].StageStatus, w.docType, w.by, w.stage)
+		}
+	}
+	if docs[0].DocHash != strings.Repeat("ab", 32) {
+		t.Errorf("hash not normalized to lower case: %s", docs[0].DocHash)
+	}
+}
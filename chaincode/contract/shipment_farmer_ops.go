@@ -31,13 +31,17 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 
 	logger.Infof("Farmer '%s' (alias: '%s') creating shipment '%s': %s", actor.fullID, actor.alias, shipmentID, productName)
 
-	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+	if err := s.validateShipmentID(shipmentID, "shipmentID"); err != nil {
 		return err
 	}
 	if err := s.validateRequiredString(productName, "productName", maxStringInputLength); err != nil {
 		return err
 	}
-	if err := s.validateOptionalString(description, "description", maxDescriptionLength); err != nil {
+	maxLen, err := fieldMaxLength(ctx, fieldLimitShipmentDescription)
+	if err != nil {
+		return fmt.Errorf("CreateShipment: failed to read length limit: %w", err)
+	}
+	if err := s.validateOptionalString(description, "description", maxLen); err != nil {
 		return err
 	}
 	if quantity <= 0 {
@@ -46,6 +50,9 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 	if err := s.validateRequiredString(unitOfMeasure, "unitOfMeasure", maxStringInputLength); err != nil {
 		return err
 	}
+	if err := validateUnitOfMeasure(ctx, unitOfMeasure, "unitOfMeasure"); err != nil {
+		return err
+	}
 
 	shipmentKey, err := s.createShipmentCompositeKey(ctx, shipmentID)
 	if err != nil {
@@ -59,7 +66,7 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 		return fmt.Errorf("shipment with ID '%s' already exists", shipmentID)
 	}
 
-	fdArgs, err := s.validateFarmerDataArgs(ctx, farmerDataJSON) // Using dedicated validator
+	fdArgs, err := s.validateFarmerDataArgs(ctx, farmerDataJSON, false) // Using dedicated validator
 	if err != nil {
 		return fmt.Errorf("CreateShipment: invalid farmerDataJSON: %w", err)
 	}
@@ -68,10 +75,18 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 	if err != nil {
 		return fmt.Errorf("CreateShipment: failed to resolve destinationProcessorId '%s': %w", fdArgs.DestinationProcessorID, err)
 	}
-	// Optional: Stronger check for DestinationProcessor's role
-	// hasRole, roleErr := im.HasRole(destProcFullID, "processor")
-	// if roleErr != nil { return fmt.Errorf("CreateShipment: error checking role for destination processor '%s': %w", destProcFullID, roleErr) }
-	// if !hasRole { return fmt.Errorf("CreateShipment: destination identity '%s' (alias: %s) does not have 'processor' role", destProcFullID, fdArgs.DestinationProcessorID) }
+	requireRegistered, err := getConfigBool(ctx, configRequireRegisteredDestinations, false)
+	if err != nil {
+		return fmt.Errorf("CreateShipment: failed to read registered destination setting: %w", err)
+	}
+	if requireRegistered {
+		if _, errInfo := im.GetIdentityInfo(destProcFullID); errInfo != nil {
+			return fmt.Errorf("CreateShipment: destinationProcessorId '%s' is not a registered identity: %w", fdArgs.DestinationProcessorID, errInfo)
+		}
+	}
+	if err := requireDestinationRole(im, "CreateShipment", destProcFullID, fdArgs.DestinationProcessorID, "processor"); err != nil {
+		return err
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
@@ -81,7 +96,7 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 	shipment := model.Shipment{
 		ObjectType: shipmentObjectType, ID: shipmentID, ProductName: productName, Description: description,
 		Quantity: quantity, UnitOfMeasure: unitOfMeasure, CurrentOwnerID: actor.fullID, CurrentOwnerAlias: actor.alias,
-		Status: model.StatusCreated, CreatedAt: now, LastUpdatedAt: now,
+		Status: model.StatusCreated, CreatedAt: now, LastUpdatedAt: now, CreatedByMSP: actor.mspID,
 		FarmerData: &model.FarmerData{ // Directly use validated and parsed fdArgs
 			FarmerID:                  actor.fullID,
 			FarmerAlias:               actor.alias,
@@ -93,6 +108,7 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 			FertilizerUsed:            fdArgs.FertilizerUsed,
 			CertificationDocumentHash: fdArgs.CertificationDocumentHash,
 			HarvestDate:               fdArgs.HarvestDate,
+			HarvestDates:              fdArgs.HarvestDates,
 			FarmingPractice:           fdArgs.FarmingPractice,
 			BedType:                   fdArgs.BedType,
 			IrrigationMethod:          fdArgs.IrrigationMethod,
@@ -122,3 +138,75 @@ func (s *FoodtraceSmartContract) CreateShipment(ctx contractapi.TransactionConte
 	logger.Infof("Shipment '%s' created successfully by farmer '%s'", shipmentID, actor.alias)
 	return nil
 }
+
+// VoidShipment lets the creating farmer void a shipment created in error, as long as no
+// downstream action (certification submission, processing) has happened yet.
+func (s *FoodtraceSmartContract) VoidShipment(ctx contractapi.TransactionContextInterface, shipmentID string, reason string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("VoidShipment: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("farmer"); err != nil {
+		return err
+	}
+
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(reason, "reason", maxDescriptionLength); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("VoidShipment: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("VoidShipment: %w", err)
+	}
+	if shipment.FarmerData == nil || shipment.FarmerData.FarmerID != actor.fullID || shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only the farmer who created shipment '%s' can void it", shipmentID)
+	}
+	if shipment.Status != model.StatusCreated {
+		return fmt.Errorf("shipment '%s' cannot be voided. Current status: '%s'. Expected '%s'", shipmentID, shipment.Status, model.StatusCreated)
+	}
+	if shipment.RecallInfo.IsRecalled {
+		return fmt.Errorf("recalled shipment '%s' cannot be voided", shipmentID)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("VoidShipment: failed to get transaction timestamp: %w", err)
+	}
+	shipment.Status = model.StatusVoided
+	shipment.VoidInfo = &model.VoidInfo{
+		Reason:        reason,
+		VoidedBy:      actor.fullID,
+		VoidedByAlias: actor.alias,
+		VoidedAt:      now,
+	}
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("VoidShipment: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("VoidShipment: failed to save shipment '%s': %w", shipmentID, err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentVoided", shipment, actor, map[string]interface{}{"reason": reason})
+	logger.Infof("Shipment '%s' voided by farmer '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// ValidateFarmerData checks farmerDataJSON as CreateShipment would, but reports every invalid field in one
+// combined error instead of stopping at the first. It writes nothing.
+func (s *FoodtraceSmartContract) ValidateFarmerData(ctx contractapi.TransactionContextInterface, farmerDataJSON string) error {
+	if _, err := s.validateFarmerDataArgs(ctx, farmerDataJSON, true); err != nil {
+		return fmt.Errorf("invalid farmerDataJSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package contract
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestVoidShipment(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(e *testEnv)
+		caller  func(e *testEnv) *testIdentity
+		reason  string
+		wantErr string
+	}{
+		{name: "creating farmer voids", reason: "Wrong quantity entered"},
+		{name: "already processed", setup: func(e *testEnv) { e.process("SHIP-1") }, reason: "Too late", wantErr: "only the farmer who created"}, // Ownership has moved on
+		{
+			name:   "already submitted for certification",
+			setup:  func(e *testEnv) { e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1")) },
+			reason: "Too late", wantErr: "cannot be voided",
+		},
+		{
+			name: "another farmer",
+			caller: func(e *testEnv) *testIdentity {
+				other := newTestIdentity("farmer2", "Org1MSP")
+				e.register(other, "farmer")
+				return other
+			},
+			reason: "Not mine", wantErr: "only the farmer who created",
+		},
+		{name: "non-farmer", caller: func(e *testEnv) *testIdentity { return e.retailer }, reason: "Cleanup", wantErr: "role"},
+		{name: "missing reason", reason: "  ", wantErr: "reason cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1").Status
+
+			err := e.cc.VoidShipment(e.as(caller), "SHIP-1", tt.reason)
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != before || shipment.VoidInfo != nil {
+					t.Fatalf("rejected void changed the shipment: status %s, void info %+v", shipment.Status, shipment.VoidInfo)
+				}
+				return
+			}
+
+			if shipment.Status != model.StatusVoided || shipment.VoidInfo == nil {
+				t.Fatalf("status %s, void info %+v", shipment.Status, shipment.VoidInfo)
+			}
+			if shipment.VoidInfo.Reason != tt.reason || shipment.VoidInfo.VoidedBy != e.farmer.id || !shipment.VoidInfo.VoidedAt.Equal(e.now) {
+				t.Fatalf("void info = %+v", shipment.VoidInfo)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentVoided" || payload["reason"] != tt.reason {
+				t.Fatalf("event %s with payload %v", name, payload)
+			}
+		})
+	}
+
+	t.Run("voided shipment is no longer actionable", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.createShipment("SHIP-2")
+		e.must(e.cc.VoidShipment(e.as(e.farmer), "SHIP-1", "Duplicate entry"))
+
+		resp, err := e.cc.GetMyShipments(e.as(e.farmer), "", "")
+		e.must(err)
+		if got := shipmentIDs(resp); !equalStrings(got, []string{"SHIP-2"}) {
+			t.Fatalf("GetMyShipments = %v, want [SHIP-2]", got)
+		}
+		checkErr(t, e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1"), "voided")
+		if err := e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(nil), ""); err == nil {
+			t.Fatal("voided shipment was processed")
+		}
+		checkErr(t, e.cc.VoidShipment(e.as(e.farmer), "SHIP-1", "Again"), "cannot be voided")
+	})
+
+	t.Run("voided shipment is excluded from selector queries", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.createShipment("SHIP-2")
+		e.must(e.cc.VoidShipment(e.as(e.farmer), "SHIP-1", "Duplicate entry"))
+
+		queries := []struct {
+			name  string
+			query func() (*model.PaginatedShipmentResponse, error)
+			want  []string
+		}{
+			{name: "selector without status", want: []string{"SHIP-2"}, query: func() (*model.PaginatedShipmentResponse, error) {
+				selector := map[string]interface{}{"objectType": shipmentObjectType}
+				return e.cc.queryShipmentsWithPagination(e.as(e.admin), "test", selector, "indexObjectTypeDoc", 10, "")
+			}},
+			{name: "selector with a status condition", want: []string{"SHIP-2"}, query: func() (*model.PaginatedShipmentResponse, error) {
+				return e.cc.GetMyShipmentsByStatus(e.as(e.farmer), string(model.StatusCreated), "", "")
+			}},
+			{name: "selector asking for voided shipments", want: []string{}, query: func() (*model.PaginatedShipmentResponse, error) {
+				return e.cc.GetMyShipmentsByStatus(e.as(e.farmer), string(model.StatusVoided), "", "")
+			}},
+		}
+		for _, q := range queries {
+			resp, err := q.query()
+			e.must(err)
+			if got := shipmentIDs(resp); !equalStrings(got, q.want) {
+				t.Fatalf("%s = %v, want %v", q.name, got, q.want)
+			}
+		}
+	})
+}
+
+func TestCreateShipmentRegisteredDestination(t *testing.T) {
+	unregistered := newTestIdentity("procesor1", "Org2MSP") // A typo of processor1 that resolves as a full ID
+	tests := []struct {
+		name        string
+		required    bool
+		destination func(e *testEnv) string
+		wantErr     string
+	}{
+		{name: "registered destination by alias", required: true, destination: func(e *testEnv) string { return e.processor.alias }},
+		{name: "registered destination by full ID", required: true, destination: func(e *testEnv) string { return e.processor.id }},
+		{name: "unregistered destination", required: true, destination: func(*testEnv) string { return unregistered.id }, wantErr: "is not a registered identity"},
+		// With the flag off only destination role enforcement stands between a typo and the ledger.
+		{name: "unregistered destination falls to the role check", required: false, destination: func(*testEnv) string { return unregistered.id }, wantErr: "does not have 'processor' role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.must(e.cc.SetRegisteredDestinationsRequired(e.as(e.admin), tt.required))
+			err := e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, "kg",
+				e.farmerData(map[string]interface{}{"destinationProcessorId": tt.destination(e)}))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := e.shipment("SHIP-1").FarmerData.DestinationProcessorID; got != e.processor.id {
+				t.Fatalf("destinationProcessorId = %q, want %q", got, e.processor.id)
+			}
+		})
+	}
+}
+
+func TestFarmerDataValidationErrorAggregation(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		wantErrs  []string // in validation order; fail-fast reports only the first
+	}{
+		{name: "valid data"},
+		{name: "single error", overrides: map[string]interface{}{"bufferZoneMeters": 5.0}, wantErrs: []string{"buffer zones must be at least 8 meters"}},
+		{
+			name: "several errors",
+			overrides: map[string]interface{}{
+				"farmerName": "", "plantingDate": "last spring", "organicSince": "2024-01-01T00:00:00Z", "bufferZoneMeters": 2.0,
+			},
+			wantErrs: []string{"farmerData.farmerName", "farmerData.plantingDate", "farm must be organic for at least 3 years", "buffer zones must be at least 8 meters"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			data := e.farmerData(tt.overrides)
+
+			collected := e.cc.ValidateFarmerData(e.as(e.farmer), data)
+			failFast := e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, "kg", data)
+			if len(tt.wantErrs) == 0 {
+				checkErr(t, collected, "")
+				checkErr(t, failFast, "")
+				return
+			}
+
+			for _, want := range tt.wantErrs {
+				checkErr(t, collected, want)
+			}
+			if len(tt.wantErrs) > 1 {
+				checkErr(t, collected, fmt.Sprintf("%d validation errors: ", len(tt.wantErrs)))
+			} else if strings.Contains(collected.Error(), "validation errors") {
+				t.Fatalf("single error reported as a list: %v", collected)
+			}
+			checkErr(t, failFast, tt.wantErrs[0])
+			for _, other := range tt.wantErrs[1:] {
+				if strings.Contains(failFast.Error(), other) {
+					t.Fatalf("fail-fast error %q also reports %q", failFast, other)
+				}
+			}
+		})
+	}
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.ValidateFarmerData(e.as(e.farmer), `{"farmerName":`), "invalid farmerDataJSON")
+	})
+}
+
+func TestCreateShipmentStaggeredHarvestDates(t *testing.T) {
+	// farmerData plants on 2025-01-10 and harvests on 2025-05-20; the shipment is created on testEpoch.
+	tests := []struct {
+		name         string
+		harvestDate  interface{} // nil omits harvestDate
+		harvestDates []string
+		wantHarvest  string
+		wantDates    []string
+		wantErr      string
+	}{
+		{name: "single harvest date", harvestDate: "2025-05-20T00:00:00Z", wantHarvest: "2025-05-20T00:00:00Z"},
+		{
+			name:         "staggered dates sorted with earliest canonical",
+			harvestDates: []string{"2025-05-22T00:00:00Z", "2025-05-18T00:00:00Z", "2025-05-20T00:00:00Z"},
+			wantHarvest:  "2025-05-18T00:00:00Z",
+			wantDates:    []string{"2025-05-18T00:00:00Z", "2025-05-20T00:00:00Z", "2025-05-22T00:00:00Z"},
+		},
+		{
+			name: "harvest date equal to the earliest", harvestDate: "2025-05-18T00:00:00Z",
+			harvestDates: []string{"2025-05-22T00:00:00Z", "2025-05-18T00:00:00Z"},
+			wantHarvest:  "2025-05-18T00:00:00Z", wantDates: []string{"2025-05-18T00:00:00Z", "2025-05-22T00:00:00Z"},
+		},
+		{
+			name: "harvest date other than the earliest rejected", harvestDate: "2025-05-22T00:00:00Z",
+			harvestDates: []string{"2025-05-22T00:00:00Z", "2025-05-18T00:00:00Z"},
+			wantErr:      "farmerData.harvestDate must equal the earliest of farmerData.harvestDates (2025-05-18T00:00:00Z)",
+		},
+		{name: "date before planting rejected", harvestDates: []string{"2025-05-18T00:00:00Z", "2025-01-01T00:00:00Z"}, wantErr: "farmerData.harvestDates[1] (2025-01-01T00:00:00Z) must be after farmerData.plantingDate"},
+		{name: "future date rejected", harvestDates: []string{"2025-05-18T00:00:00Z", "2025-06-02T00:00:00Z"}, wantErr: "farmerData.harvestDates[1] (2025-06-02T00:00:00Z) cannot be in the future"},
+		{name: "duplicate date rejected", harvestDates: []string{"2025-05-18T00:00:00Z", "2025-05-18T00:00:00Z"}, wantErr: "contains 2025-05-18T00:00:00Z more than once"},
+		{name: "invalid date rejected", harvestDates: []string{"2025-05-18T00:00:00Z", "mid-May"}, wantErr: "farmerData.harvestDates[1]"},
+		{name: "no harvest date at all rejected", wantErr: "farmerData.harvestDate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			overrides := map[string]interface{}{"harvestDate": tt.harvestDate}
+			if tt.harvestDates != nil {
+				overrides["harvestDates"] = tt.harvestDates
+			}
+			err := e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, "kg", e.farmerData(overrides))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+
+			fd := e.shipment("SHIP-1").FarmerData
+			if got := fd.HarvestDate.Format(time.RFC3339); got != tt.wantHarvest {
+				t.Fatalf("harvestDate = %s, want %s", got, tt.wantHarvest)
+			}
+			gotDates := []string{}
+			for _, d := range fd.HarvestDates {
+				gotDates = append(gotDates, d.Format(time.RFC3339))
+			}
+			if !equalStrings(gotDates, tt.wantDates) {
+				t.Fatalf("harvestDates = %v, want %v", gotDates, tt.wantDates)
+			}
+		})
+	}
+}
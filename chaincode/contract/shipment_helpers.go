@@ -5,10 +5,15 @@
 package contract
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"foodtrace/model"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -98,6 +103,29 @@ func (s *FoodtraceSmartContract) validateRequiredString(input, field string, max
 	return nil
 }
 
+// shipmentIDPattern restricts new shipment IDs to characters that are safe in composite keys and CouchDB selectors.
+var shipmentIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// reservedShipmentIDPrefixes are reserved for IDs generated by the contract itself.
+var reservedShipmentIDPrefixes = []string{"GEN_"}
+
+// validateShipmentID checks a caller-supplied ID for a shipment that is about to be created.
+func (s *FoodtraceSmartContract) validateShipmentID(shipmentID, field string) error {
+	if err := s.validateRequiredString(shipmentID, field, maxStringInputLength); err != nil {
+		return err
+	}
+	if !shipmentIDPattern.MatchString(shipmentID) {
+		return fmt.Errorf("%s '%s' contains invalid characters: must start with a letter or digit and contain only letters, digits, '.', '_' or '-'", field, shipmentID)
+	}
+	upperID := strings.ToUpper(shipmentID)
+	for _, prefix := range reservedShipmentIDPrefixes {
+		if strings.HasPrefix(upperID, prefix) {
+			return fmt.Errorf("%s '%s' uses reserved prefix '%s'", field, shipmentID, prefix)
+		}
+	}
+	return nil
+}
+
 func (s *FoodtraceSmartContract) validateOptionalString(input, field string, max int) error {
 	if input != "" && len(input) > max {
 		return fmt.Errorf("%s exceeds max length %d", field, max)
@@ -162,6 +190,44 @@ func (s *FoodtraceSmartContract) validateFloatArray(nums []float64, field string
 	return nil
 }
 
+// validateDocumentHash checks that a hash is a hex digest of a common length (MD5 through SHA-512).
+func (s *FoodtraceSmartContract) validateDocumentHash(hash, field string) error {
+	h := strings.TrimSpace(hash)
+	if h == "" {
+		return fmt.Errorf("%s cannot be empty", field)
+	}
+	if len(h) < 32 || len(h) > 128 {
+		return fmt.Errorf("%s must be a hex digest between 32 and 128 characters, got %d", field, len(h))
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return fmt.Errorf("%s must be a hex-encoded digest: %w", field, err)
+	}
+	return nil
+}
+
+// validateDocumentURL checks that an optional URL is absolute and uses a supported scheme.
+func (s *FoodtraceSmartContract) validateDocumentURL(rawURL, field string) error {
+	if strings.TrimSpace(rawURL) == "" {
+		return nil
+	}
+	if err := s.validateOptionalString(rawURL, field, maxStringInputLength*2); err != nil {
+		return err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", field, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "ipfs":
+	default:
+		return fmt.Errorf("%s must use http, https or ipfs scheme", field)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s must include a host", field)
+	}
+	return nil
+}
+
 func parseDateString(str, field string, required bool) (time.Time, error) {
 	sTrimmed := strings.TrimSpace(str)
 	if sTrimmed == "" {
@@ -187,6 +253,7 @@ type ValidatedFarmerData struct { // To return parsed dates
 	FertilizerUsed            string `json:"fertilizerUsed"`
 	CertificationDocumentHash string `json:"certificationDocumentHash"`
 	HarvestDate               time.Time
+	HarvestDates              []time.Time
 	FarmingPractice           string `json:"farmingPractice"`
 	BedType                   string `json:"bedType"`
 	IrrigationMethod          string `json:"irrigationMethod"`
@@ -195,7 +262,69 @@ type ValidatedFarmerData struct { // To return parsed dates
 	DestinationProcessorID    string  `json:"destinationProcessorId"`
 }
 
-func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.TransactionContextInterface, farmerDataJSON string) (*ValidatedFarmerData, error) {
+// validateHarvestDates parses the optional staggered harvest dates, requiring each to fall after plantingDate
+// and not after now, with no duplicates. The dates are returned in chronological order.
+func validateHarvestDates(dateStrs []string, plantingDate, now time.Time) ([]time.Time, error) {
+	if len(dateStrs) == 0 {
+		return nil, nil
+	}
+	if len(dateStrs) > maxArrayElements {
+		return nil, fmt.Errorf("farmerData.harvestDates has %d entries, exceeding maximum of %d", len(dateStrs), maxArrayElements)
+	}
+	dates := make([]time.Time, 0, len(dateStrs))
+	for i, dateStr := range dateStrs {
+		field := fmt.Sprintf("farmerData.harvestDates[%d]", i)
+		date, err := parseDateString(dateStr, field, true)
+		if err != nil {
+			return nil, err
+		}
+		if !plantingDate.IsZero() && !date.After(plantingDate) {
+			return nil, fmt.Errorf("%s (%s) must be after farmerData.plantingDate (%s)", field, date.Format(time.RFC3339), plantingDate.Format(time.RFC3339))
+		}
+		if date.After(now) {
+			return nil, fmt.Errorf("%s (%s) cannot be in the future", field, date.Format(time.RFC3339))
+		}
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	for i := 1; i < len(dates); i++ {
+		if dates[i].Equal(dates[i-1]) {
+			return nil, fmt.Errorf("farmerData.harvestDates contains %s more than once", dates[i].Format(time.RFC3339))
+		}
+	}
+	return dates, nil
+}
+
+// validationCollector either fails fast on the first validation error (the default) or, with collectAll,
+// accumulates them so a client can fix every problem in one round-trip.
+type validationCollector struct {
+	collectAll bool
+	msgs       []string
+}
+
+// add returns err unchanged in fail-fast mode; in collect mode it records err and returns nil.
+func (v *validationCollector) add(err error) error {
+	if err == nil || !v.collectAll {
+		return err
+	}
+	v.msgs = append(v.msgs, err.Error())
+	return nil
+}
+
+// combined returns the collected errors as one error, or nil if there were none.
+func (v *validationCollector) combined() error {
+	switch len(v.msgs) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(v.msgs[0])
+	}
+	return fmt.Errorf("%d validation errors: %s", len(v.msgs), strings.Join(v.msgs, "; "))
+}
+
+// validateFarmerDataArgs validates farmerDataJSON for CreateShipment. It fails on the first invalid field
+// unless collectAll is set, in which case every field is checked and all problems are reported together.
+func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.TransactionContextInterface, farmerDataJSON string, collectAll bool) (*ValidatedFarmerData, error) {
 	var fdArg struct { // Temporary struct for unmarshalling string dates
 		FarmerName                string          `json:"farmerName"`
 		FarmLocation              string          `json:"farmLocation"`
@@ -205,6 +334,7 @@ func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.Transact
 		FertilizerUsed            string          `json:"fertilizerUsed"`
 		CertificationDocumentHash string          `json:"certificationDocumentHash"`
 		HarvestDateStr            string          `json:"harvestDate"`
+		HarvestDateStrs           []string        `json:"harvestDates"`
 		FarmingPractice           string          `json:"farmingPractice"`
 		BedType                   string          `json:"bedType"`
 		IrrigationMethod          string          `json:"irrigationMethod"`
@@ -215,61 +345,84 @@ func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.Transact
 	if err := json.Unmarshal([]byte(farmerDataJSON), &fdArg); err != nil {
 		return nil, fmt.Errorf("invalid farmerDataJSON: %w. Ensure the JSON structure and all required fields are correct", err)
 	}
+	v := &validationCollector{collectAll: collectAll}
 
-	if err := s.validateRequiredString(fdArg.FarmerName, "farmerData.farmerName", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.FarmerName, "farmerData.farmerName", maxStringInputLength)); err != nil {
 		return nil, err
 	}
-	if err := s.validateRequiredString(fdArg.FarmLocation, "farmerData.farmLocation", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.FarmLocation, "farmerData.farmLocation", maxStringInputLength)); err != nil {
 		return nil, err
 	}
-	if err := s.validateGeoPoint(fdArg.FarmCoordinates, "farmerData.farmCoordinates", true); err != nil {
+	if err := v.add(s.validateGeoPoint(fdArg.FarmCoordinates, "farmerData.farmCoordinates", true)); err != nil {
 		return nil, err
 	}
-	if err := s.validateRequiredString(fdArg.CropType, "farmerData.cropType", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.CropType, "farmerData.cropType", maxStringInputLength)); err != nil {
 		return nil, err
 	}
 	plantingDate, err := parseDateString(fdArg.PlantingDateStr, "farmerData.plantingDate", true)
-	if err != nil {
+	if err := v.add(err); err != nil {
 		return nil, err
 	}
-	if err := s.validateOptionalString(fdArg.FertilizerUsed, "farmerData.fertilizerUsed", maxStringInputLength); err != nil {
+	if err := v.add(s.validateOptionalString(fdArg.FertilizerUsed, "farmerData.fertilizerUsed", maxStringInputLength)); err != nil {
 		return nil, err
 	}
-	if err := s.validateOptionalString(fdArg.CertificationDocumentHash, "farmerData.certificationDocumentHash", maxStringInputLength); err != nil {
+	if err := v.add(s.validateOptionalString(fdArg.CertificationDocumentHash, "farmerData.certificationDocumentHash", maxStringInputLength)); err != nil {
 		return nil, err
 	} // Hash can be long
-	harvestDate, err := parseDateString(fdArg.HarvestDateStr, "farmerData.harvestDate", true)
-	if err != nil {
+	harvestDate, err := parseDateString(fdArg.HarvestDateStr, "farmerData.harvestDate", len(fdArg.HarvestDateStrs) == 0)
+	if err := v.add(err); err != nil {
 		return nil, err
 	}
-	if err := s.validateRequiredString(fdArg.FarmingPractice, "farmerData.farmingPractice", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.FarmingPractice, "farmerData.farmingPractice", maxStringInputLength)); err != nil {
 		return nil, err
 	}
-	if err := s.validateRequiredString(fdArg.BedType, "farmerData.bedType", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.BedType, "farmerData.bedType", maxStringInputLength)); err != nil {
 		return nil, err
 	}
-	if err := s.validateRequiredString(fdArg.IrrigationMethod, "farmerData.irrigationMethod", maxStringInputLength); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.IrrigationMethod, "farmerData.irrigationMethod", maxStringInputLength)); err != nil {
 		return nil, err
 	}
 	organicSince, err := parseDateString(fdArg.OrganicSinceStr, "farmerData.organicSince", true)
-	if err != nil {
+	if err := v.add(err); err != nil {
 		return nil, err
 	}
-	// Enforce organic period >= 3 years
+	// Staggered harvest dates: the earliest doubles as farmerData.harvestDate
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if organicSince.AddDate(3, 0, 0).After(now) {
-		return nil, fmt.Errorf("farm must be organic for at least 3 years")
+	harvestDates, err := validateHarvestDates(fdArg.HarvestDateStrs, plantingDate, now)
+	if err := v.add(err); err != nil {
+		return nil, err
+	}
+	if len(harvestDates) > 0 {
+		if harvestDate.IsZero() {
+			harvestDate = harvestDates[0]
+		} else if !harvestDate.Equal(harvestDates[0]) {
+			if err := v.add(fmt.Errorf("farmerData.harvestDate must equal the earliest of farmerData.harvestDates (%s)", harvestDates[0].Format(time.RFC3339))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Enforce organic period >= 3 years
+	if !organicSince.IsZero() && organicSince.AddDate(3, 0, 0).After(now) {
+		if err := v.add(fmt.Errorf("farm must be organic for at least 3 years")); err != nil {
+			return nil, err
+		}
 	}
 	if fdArg.BufferZoneMeters < 8 {
-		return nil, fmt.Errorf("buffer zones must be at least 8 meters")
+		if err := v.add(fmt.Errorf("buffer zones must be at least 8 meters")); err != nil {
+			return nil, err
+		}
 	}
-	if err := s.validateRequiredString(fdArg.DestinationProcessorID, "farmerData.destinationProcessorId", maxStringInputLength*2); err != nil {
+	if err := v.add(s.validateRequiredString(fdArg.DestinationProcessorID, "farmerData.destinationProcessorId", maxStringInputLength*2)); err != nil {
 		return nil, err
 	} // Full IDs can be long
 
+	if err := v.combined(); err != nil {
+		return nil, err
+	}
+
 	return &ValidatedFarmerData{
 		FarmerName:                fdArg.FarmerName,
 		FarmLocation:              fdArg.FarmLocation,
@@ -279,6 +432,7 @@ func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.Transact
 		FertilizerUsed:            fdArg.FertilizerUsed,
 		CertificationDocumentHash: fdArg.CertificationDocumentHash,
 		HarvestDate:               harvestDate,
+		HarvestDates:              harvestDates,
 		FarmingPractice:           fdArg.FarmingPractice,
 		BedType:                   fdArg.BedType,
 		IrrigationMethod:          fdArg.IrrigationMethod,
@@ -288,7 +442,11 @@ func (s *FoodtraceSmartContract) validateFarmerDataArgs(ctx contractapi.Transact
 	}, nil
 }
 
-func (s *FoodtraceSmartContract) validateProcessorDataArgs(pdJSON string) (*model.ProcessorData, error) {
+// processingDateClockSkew is the tolerance allowed between client clocks and the transaction
+// timestamp when rejecting future processing dates.
+const processingDateClockSkew = 5 * time.Minute
+
+func (s *FoodtraceSmartContract) validateProcessorDataArgs(ctx contractapi.TransactionContextInterface, pdJSON string) (*model.ProcessorData, error) {
 	var pdArgRaw struct { // Use raw struct for unmarshalling string dates
 		DateProcessedStr         string          `json:"dateProcessed"`
 		ProcessingType           string          `json:"processingType"`
@@ -300,6 +458,8 @@ func (s *FoodtraceSmartContract) validateProcessorDataArgs(pdJSON string) (*mode
 		ExpiryDateStr            string          `json:"expiryDate"`
 		QualityCertifications    []string        `json:"qualityCertifications"`
 		DestinationDistributorID string          `json:"destinationDistributorId"`
+		CoolingStartTemp         *float64        `json:"coolingStartTemp"`
+		CoolingEndTemp           *float64        `json:"coolingEndTemp"`
 	}
 	if err := json.Unmarshal([]byte(pdJSON), &pdArgRaw); err != nil {
 		return nil, fmt.Errorf("invalid processorDataJSON: %w", err)
@@ -309,6 +469,13 @@ func (s *FoodtraceSmartContract) validateProcessorDataArgs(pdJSON string) (*mode
 	if err != nil {
 		return nil, err
 	}
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction timestamp: %w", err)
+	}
+	if dateProcessed.After(now.Add(processingDateClockSkew)) {
+		return nil, fmt.Errorf("processorData.dateProcessed (%s) cannot be in the future (transaction time %s)", dateProcessed.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
 	if err := s.validateRequiredString(pdArgRaw.ProcessingType, "processorData.processingType", maxStringInputLength); err != nil {
 		return nil, err
 	}
@@ -318,7 +485,11 @@ func (s *FoodtraceSmartContract) validateProcessorDataArgs(pdJSON string) (*mode
 	if err := s.validateRequiredString(pdArgRaw.ProcessingLocation, "processorData.processingLocation", maxStringInputLength); err != nil {
 		return nil, err
 	}
-	if err := s.validateGeoPoint(pdArgRaw.ProcessingCoordinates, "processorData.processingCoordinates", true); err != nil {
+	coordinatesRequired, err := getConfigBool(ctx, configProcessingCoordinatesRequired, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processing coordinates setting: %w", err)
+	}
+	if err := s.validateGeoPoint(pdArgRaw.ProcessingCoordinates, "processorData.processingCoordinates", coordinatesRequired); err != nil {
 		return nil, err
 	}
 	if err := s.validateRequiredString(pdArgRaw.ContaminationCheck, "processorData.contaminationCheck", maxStringInputLength); err != nil {
@@ -337,15 +508,74 @@ func (s *FoodtraceSmartContract) validateProcessorDataArgs(pdJSON string) (*mode
 	if err := s.validateRequiredString(pdArgRaw.DestinationDistributorID, "processorData.destinationDistributorId", maxStringInputLength*2); err != nil {
 		return nil, err
 	}
+	if err := validatePlausibleTemperature(pdArgRaw.CoolingStartTemp, "processorData.coolingStartTemp"); err != nil {
+		return nil, err
+	}
+	if err := validatePlausibleTemperature(pdArgRaw.CoolingEndTemp, "processorData.coolingEndTemp"); err != nil {
+		return nil, err
+	}
 
 	return &model.ProcessorData{ // Return model.ProcessorData with parsed dates
 		DateProcessed: dateProcessed, ProcessingType: pdArgRaw.ProcessingType, ProcessingLineID: pdArgRaw.ProcessingLineID,
 		ProcessingLocation: pdArgRaw.ProcessingLocation, ProcessingCoordinates: pdArgRaw.ProcessingCoordinates,
-		ContaminationCheck: pdArgRaw.ContaminationCheck, OutputBatchID: pdArgRaw.OutputBatchID,
+		ContaminationCheck: normalizeContaminationCheck(pdArgRaw.ContaminationCheck), OutputBatchID: pdArgRaw.OutputBatchID,
 		ExpiryDate: expiryDate, QualityCertifications: pdArgRaw.QualityCertifications, DestinationDistributorID: pdArgRaw.DestinationDistributorID,
+		CoolingStartTemp: pdArgRaw.CoolingStartTemp, CoolingEndTemp: pdArgRaw.CoolingEndTemp,
 	}, nil
 }
 
+// normalizeContaminationCheck trims and upper-cases a contamination check result, so that results such as
+// " failed" are stored and compared as model.ContaminationCheckFailed.
+func normalizeContaminationCheck(check string) string {
+	return strings.ToUpper(strings.TrimSpace(check))
+}
+
+// upstreamQualityFailure describes a quality failure recorded during processing, or returns "" if none.
+// The contamination check counts as failed only when recorded as model.ContaminationCheckFailed, compared
+// after normalizeContaminationCheck so that records written before results were normalized also match;
+// certification decisions are not quality results. The cooling target is checked against the targets configured now.
+func upstreamQualityFailure(ctx contractapi.TransactionContextInterface, shipment *model.Shipment) (string, error) {
+	pd := shipment.ProcessorData
+	if pd == nil {
+		return "", nil
+	}
+	check := normalizeContaminationCheck(pd.ContaminationCheck)
+	if check == model.ContaminationCheckFailed {
+		return "contamination check failed", nil
+	}
+	if pd.CoolingEndTemp != nil {
+		cropType := ""
+		if shipment.FarmerData != nil {
+			cropType = shipment.FarmerData.CropType
+		}
+		target, key, ok, err := getCoolingTarget(ctx, pd.ProcessingType, cropType)
+		if err != nil {
+			return "", err
+		}
+		if ok && *pd.CoolingEndTemp > target {
+			return fmt.Sprintf("cooling end temperature %.1f °C missed the %.1f °C target for '%s'", *pd.CoolingEndTemp, target, key), nil
+		}
+	}
+	return "", nil
+}
+
+// Plausible range (°C) for temperatures recorded during food handling.
+const (
+	minPlausibleTempC = -60.0
+	maxPlausibleTempC = 60.0
+)
+
+// validatePlausibleTemperature accepts an absent reading or one within the plausible range.
+func validatePlausibleTemperature(temp *float64, field string) error {
+	if temp == nil {
+		return nil
+	}
+	if math.IsNaN(*temp) || *temp < minPlausibleTempC || *temp > maxPlausibleTempC {
+		return fmt.Errorf("%s %.2f is outside the plausible range %.0f to %.0f °C", field, *temp, minPlausibleTempC, maxPlausibleTempC)
+	}
+	return nil
+}
+
 // FIXED: Complete validation for distributor data
 func (s *FoodtraceSmartContract) validateDistributorDataArgs(ddJSON string) (*model.DistributorData, error) {
 	var ddArgRaw struct {
@@ -498,6 +728,9 @@ func ensureShipmentSchemaCompliance(shipment *model.Shipment) {
 	if shipment.History == nil {
 		shipment.History = []model.HistoryEntry{}
 	}
+	if shipment.Documents == nil {
+		shipment.Documents = []model.AttachedDocument{}
+	}
 
 	// Initialize FarmerData if nil and ensure it has no nil slices
 	if shipment.FarmerData == nil {
@@ -523,6 +756,7 @@ func ensureShipmentSchemaCompliance(shipment *model.Shipment) {
 			TransitGPSLog:       []model.GeoPoint{},
 			SensorLogs:          []model.ColdChainLog{},
 			StorageTemperatures: []float64{},
+			Legs:                []model.DistributionLeg{},
 		}
 	} else {
 		// Ensure nested slice is not nil
@@ -538,6 +772,9 @@ func ensureShipmentSchemaCompliance(shipment *model.Shipment) {
 		if shipment.DistributorData.StorageTemperatures == nil {
 			shipment.DistributorData.StorageTemperatures = []float64{}
 		}
+		if shipment.DistributorData.Legs == nil {
+			shipment.DistributorData.Legs = []model.DistributionLeg{}
+		}
 	}
 
 	// Initialize RetailerData if nil
@@ -571,6 +808,111 @@ func ensureIdentityInfoSchemaCompliance(idInfo *model.IdentityInfo) {
 	}
 }
 
+// farmerFieldPresent reports, per farmerData JSON field name, whether a value has been recorded.
+// Only fields listed here can be required for certification submission.
+var farmerFieldPresent = map[string]func(*model.FarmerData) bool{
+	"farmerName":                func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.FarmerName) != "" },
+	"farmLocation":              func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.FarmLocation) != "" },
+	"farmCoordinates":           func(fd *model.FarmerData) bool { return fd.FarmCoordinates != nil },
+	"cropType":                  func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.CropType) != "" },
+	"plantingDate":              func(fd *model.FarmerData) bool { return !fd.PlantingDate.IsZero() },
+	"fertilizerUsed":            func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.FertilizerUsed) != "" },
+	"certificationDocumentHash": func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.CertificationDocumentHash) != "" },
+	"harvestDate":               func(fd *model.FarmerData) bool { return !fd.HarvestDate.IsZero() },
+	"farmingPractice":           func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.FarmingPractice) != "" },
+	"bedType":                   func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.BedType) != "" },
+	"irrigationMethod":          func(fd *model.FarmerData) bool { return strings.TrimSpace(fd.IrrigationMethod) != "" },
+	"organicSince":              func(fd *model.FarmerData) bool { return !fd.OrganicSince.IsZero() },
+	"bufferZoneMeters":          func(fd *model.FarmerData) bool { return fd.BufferZoneMeters > 0 },
+}
+
+// missingFarmerFields returns the required farmerData fields (prefixed "farmerData.") with no recorded value.
+func missingFarmerFields(fd *model.FarmerData, required []string) []string {
+	missing := []string{}
+	for _, field := range required {
+		present, known := farmerFieldPresent[field]
+		if !known {
+			continue
+		}
+		if fd == nil || !present(fd) {
+			missing = append(missing, "farmerData."+field)
+		}
+	}
+	return missing
+}
+
+// missingDestinationField names the next-stage destination field a shipment should carry in its
+// current status but does not, or returns "" if none is missing or the status has no next stage.
+// Certification statuses follow processing when the shipment has already been processed.
+func missingDestinationField(shipment *model.Shipment) string {
+	switch shipment.Status {
+	case model.StatusCreated, model.StatusPendingCertification, model.StatusCertified, model.StatusCertificationRejected:
+		if shipment.ProcessorData.ProcessorID != "" {
+			if shipment.ProcessorData.DestinationDistributorID == "" {
+				return "processorData.destinationDistributorId"
+			}
+		} else if !shipment.IsDerivedProduct && shipment.FarmerData.DestinationProcessorID == "" {
+			return "farmerData.destinationProcessorId"
+		}
+	case model.StatusProcessed:
+		if shipment.ProcessorData.DestinationDistributorID == "" {
+			return "processorData.destinationDistributorId"
+		}
+	case model.StatusDistributed:
+		if shipment.DistributorData.DestinationRetailerID == "" {
+			return "distributorData.destinationRetailerId"
+		}
+	}
+	return ""
+}
+
+// touchShipment sets LastUpdatedAt to now unless that would move it backwards. Fabric transaction
+// timestamps are client-supplied and can regress across submitters; the later time is kept.
+func touchShipment(shipment *model.Shipment, now time.Time) {
+	if now.Before(shipment.LastUpdatedAt) {
+		logger.Warningf("Shipment '%s': transaction timestamp %s precedes lastUpdatedAt %s; keeping the later time",
+			shipment.ID, now.Format(time.RFC3339Nano), shipment.LastUpdatedAt.Format(time.RFC3339Nano))
+		return
+	}
+	shipment.LastUpdatedAt = now
+}
+
+// ensureShipmentMutable is the central guard for lifecycle transitions. It rejects shipments whose
+// state must not advance regardless of the transition being attempted.
+func ensureShipmentMutable(shipment *model.Shipment) error {
+	if shipment.IsArchived {
+		return fmt.Errorf("shipment '%s' is archived – unarchive it before further processing", shipment.ID)
+	}
+	if shipment.Status == model.StatusDestroyed {
+		return fmt.Errorf("shipment '%s' has been destroyed and cannot change further", shipment.ID)
+	}
+	if shipment.OnHold {
+		return fmt.Errorf("shipment '%s' is on hold (reason: %s) – release the hold before further processing", shipment.ID, shipment.HoldReason)
+	}
+	return nil
+}
+
+// requireDestinationRole verifies that a resolved destination identity holds the role it is designated for.
+// It is a no-op when enforceDestinationRoles is off.
+func requireDestinationRole(im *IdentityManager, fnName, destFullID, destInput, role string) error {
+	if !enforceDestinationRoles {
+		return nil
+	}
+	return checkDestinationRole(im, fnName, destFullID, destInput, role)
+}
+
+// checkDestinationRole verifies that a resolved destination identity holds role, regardless of enforceDestinationRoles.
+func checkDestinationRole(im *IdentityManager, fnName, destFullID, destInput, role string) error {
+	hasRole, err := im.HasRole(destFullID, role)
+	if err != nil {
+		return fmt.Errorf("%s: error checking role for destination %s '%s': %w", fnName, role, destFullID, err)
+	}
+	if !hasRole {
+		return fmt.Errorf("%s: destination identity '%s' (alias: %s) does not have '%s' role", fnName, destFullID, destInput, role)
+	}
+	return nil
+}
+
 // getShipmentAndVerifyStage fetches a shipment and verifies its status and designee.
 func (s *FoodtraceSmartContract) getShipmentAndVerifyStage(ctx contractapi.TransactionContextInterface, shipmentID string, expectedStatus model.ShipmentStatus, actorFullID string) (*model.Shipment, error) {
 	shipment, err := s.getShipmentByID(ctx, shipmentID) // Uses query_ops internal helper
@@ -578,6 +920,9 @@ func (s *FoodtraceSmartContract) getShipmentAndVerifyStage(ctx contractapi.Trans
 		return nil, err
 	}
 
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return nil, err
+	}
 	if shipment.RecallInfo != nil && shipment.RecallInfo.IsRecalled && expectedStatus != model.StatusRecalled {
 		return nil, fmt.Errorf("shipment '%s' is recalled – no further processing", shipmentID)
 	}
@@ -676,6 +1021,38 @@ func (s *FoodtraceSmartContract) enrichShipmentAliases(im *IdentityManager, ship
 	}
 }
 
+// transferReasonFromJSON reads the optional "transferReason" carried alongside a stage's data JSON.
+func transferReasonFromJSON(dataJSON string) string {
+	var arg struct {
+		TransferReason string `json:"transferReason"`
+	}
+	_ = json.Unmarshal([]byte(dataJSON), &arg) // The stage validator has already rejected malformed JSON
+	return strings.TrimSpace(arg.TransferReason)
+}
+
+// recordCustodyTransfer appends a custody log entry for the shipment passing from its current owner to actor
+// at the given stage. An empty reason is rejected while admins require transfer reasons.
+func (s *FoodtraceSmartContract) recordCustodyTransfer(ctx contractapi.TransactionContextInterface, shipment *model.Shipment, actor *actorInfo, stage model.ShipmentStatus, reason string, now time.Time) error {
+	if reason == "" {
+		required, err := getConfigBool(ctx, configTransferReasonRequired, false)
+		if err != nil {
+			return fmt.Errorf("failed to read transfer reason setting: %w", err)
+		}
+		if required {
+			return errors.New("transferReason is required for ownership transfers")
+		}
+	}
+	if err := s.validateOptionalString(reason, "transferReason", maxDescriptionLength); err != nil {
+		return err
+	}
+	shipment.CustodyLog = append(shipment.CustodyLog, model.CustodyTransfer{
+		FromID: shipment.CurrentOwnerID, FromAlias: shipment.CurrentOwnerAlias,
+		ToID: actor.fullID, ToAlias: actor.alias,
+		Stage: stage, Reason: reason, TransferredAt: now,
+	})
+	return nil
+}
+
 // emitShipmentEvent sends a chaincode event.
 func (s *FoodtraceSmartContract) emitShipmentEvent(ctx contractapi.TransactionContextInterface, eventName string, shipment *model.Shipment, actor *actorInfo, additionalPayload map[string]interface{}) {
 	if shipment == nil || actor == nil {
@@ -739,3 +1116,33 @@ func (s *FoodtraceSmartContract) requireAdmin(ctx contractapi.TransactionContext
 	}
 	return nil
 }
+
+// snapshotShipment captures a shipment as a JSON-decoded map so later in-place edits can be diffed.
+func snapshotShipment(shipment *model.Shipment) map[string]interface{} {
+	snapshot := map[string]interface{}{}
+	if shipmentBytes, err := json.Marshal(shipment); err == nil {
+		_ = json.Unmarshal(shipmentBytes, &snapshot)
+	}
+	return snapshot
+}
+
+// emitShipmentFieldChanged emits a ShipmentFieldChanged event for an amendment, listing the fields
+// that differ from the before snapshot with their new values. action names the amendment
+// (e.g. "ShipmentHoldPlaced"); additionalPayload carries any update-specific fields such as a reason.
+func (s *FoodtraceSmartContract) emitShipmentFieldChanged(ctx contractapi.TransactionContextInterface, action string, before map[string]interface{}, after *model.Shipment, actor *actorInfo, additionalPayload map[string]interface{}) {
+	changes := diffShipmentStates(before, snapshotShipment(after))
+	delete(changes, "lastUpdatedAt")
+	changedFields := make([]string, 0, len(changes))
+	newValues := make(map[string]interface{}, len(changes))
+	for field, change := range changes {
+		changedFields = append(changedFields, field)
+		newValues[field] = change.(map[string]interface{})["to"]
+	}
+	sort.Strings(changedFields)
+
+	payload := map[string]interface{}{"action": action, "changedFields": changedFields, "newValues": newValues}
+	for k, v := range additionalPayload {
+		payload[k] = v
+	}
+	s.emitShipmentEvent(ctx, "ShipmentFieldChanged", after, actor, payload)
+}
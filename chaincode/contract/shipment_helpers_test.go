@@ -0,0 +1,411 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestTransferReasonRequirement(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   bool
+		reason     interface{} // nil omits transferReason from the stage data
+		wantErr    string
+		wantReason string
+	}{
+		{name: "required and missing", required: true, wantErr: "transferReason is required"},
+		{name: "required and blank", required: true, reason: "   ", wantErr: "transferReason is required"},
+		{name: "required and given", required: true, reason: "Scheduled pickup", wantReason: "Scheduled pickup"},
+		{name: "optional and missing", required: false},
+		{name: "optional and given", required: false, reason: " Rerouted ", wantReason: "Rerouted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.must(e.cc.SetTransferReasonRequired(e.as(e.admin), tt.required))
+
+			overrides := map[string]interface{}{}
+			if tt.reason != nil {
+				overrides["transferReason"] = tt.reason
+			}
+			err := e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(overrides))
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != model.StatusProcessed || shipment.CurrentOwnerID != e.processor.id {
+					t.Fatalf("rejected transfer changed the shipment: status %s, owner %s", shipment.Status, shipment.CurrentOwnerID)
+				}
+				return
+			}
+
+			_, payload := e.lastEvent()
+			if got, _ := payload["transferReason"].(string); got != tt.wantReason {
+				t.Fatalf("event transferReason = %q, want %q", got, tt.wantReason)
+			}
+			last := shipment.CustodyLog[len(shipment.CustodyLog)-1]
+			if last.FromID != e.processor.id || last.ToID != e.distributor.id || last.Stage != model.StatusDistributed || last.Reason != tt.wantReason {
+				t.Fatalf("custody entry = %+v", last)
+			}
+		})
+	}
+}
+
+func TestCustodyLogCoversEveryTransfer(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-1")
+	e.must(e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(map[string]interface{}{"transferReason": "Harvest intake"}), ""))
+	e.distribute("SHIP-1")
+	e.must(e.cc.ReceiveShipment(e.as(e.retailer), "SHIP-1", e.retailerData(map[string]interface{}{"transferReason": "Store delivery"})))
+
+	want := []struct {
+		from, to *testIdentity
+		stage    model.ShipmentStatus
+		reason   string
+	}{
+		{e.farmer, e.processor, model.StatusProcessed, "Harvest intake"},
+		{e.processor, e.distributor, model.StatusDistributed, ""},
+		{e.distributor, e.retailer, model.StatusDelivered, "Store delivery"},
+	}
+	log := e.shipment("SHIP-1").CustodyLog
+	if len(log) != len(want) {
+		t.Fatalf("custody log has %d entries, want %d", len(log), len(want))
+	}
+	for i, w := range want {
+		if log[i].FromID != w.from.id || log[i].ToID != w.to.id || log[i].Stage != w.stage || log[i].Reason != w.reason {
+			t.Errorf("entry %d = %+v", i, log[i])
+		}
+	}
+}
+
+func TestValidateShipmentID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr string
+	}{
+		{name: "plain ID", id: "SHIP-1"},
+		{name: "dots and underscores", id: "lot_2025.06"},
+		{name: "prefix only resembles reserved one", id: "GENERIC-1"},
+		{name: "empty", id: " ", wantErr: "cannot be empty"},
+		{name: "space", id: "SHIP 1", wantErr: "invalid characters"},
+		{name: "slash", id: "SHIP/1", wantErr: "invalid characters"},
+		{name: "composite key delimiter", id: "SHIP\x001", wantErr: "invalid characters"},
+		{name: "leading punctuation", id: "-SHIP", wantErr: "invalid characters"},
+		{name: "reserved prefix", id: "GEN_42", wantErr: "reserved prefix"},
+		{name: "reserved prefix in lower case", id: "gen_42", wantErr: "reserved prefix"},
+	}
+	creators := []struct {
+		name   string
+		create func(e *testEnv, id string) error
+	}{
+		{name: "CreateShipment", create: func(e *testEnv, id string) error {
+			return e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(nil))
+		}},
+		{name: "TransformAndCreateProducts", create: func(e *testEnv, id string) error {
+			e.createShipment("INPUT-1")
+			e.process("INPUT-1")
+			products, _ := json.Marshal([]model.NewProductDetail{{NewShipmentID: id, ProductName: "Jam", Quantity: 40, UnitOfMeasure: "kg"}})
+			return e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"INPUT-1"}]`, string(products), e.processorData(nil), false)
+		}},
+		{name: "SplitForRetail", create: func(e *testEnv, id string) error {
+			e.deliveredShipment("INPUT-1")
+			units, _ := json.Marshal([]model.NewProductDetail{{NewShipmentID: id, ProductName: "Punnet", Quantity: 100, UnitOfMeasure: "kg"}})
+			return e.cc.SplitForRetail(e.as(e.retailer), "INPUT-1", string(units))
+		}},
+	}
+	for _, c := range creators {
+		for _, tt := range tests {
+			t.Run(c.name+"/"+tt.name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				checkErr(t, c.create(e, tt.id), tt.wantErr)
+				if tt.wantErr == "" {
+					if shipment := e.shipment(tt.id); shipment.ID != tt.id {
+						t.Fatalf("stored shipment ID = %q, want %q", shipment.ID, tt.id)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestShipmentFieldChangedEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(e *testEnv)
+		update     func(e *testEnv) error
+		wantAction string
+		wantValues func(e *testEnv) map[string]interface{} // exactly the changed fields and their new values
+	}{
+		{
+			name:  "set expected delivery",
+			setup: func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			update: func(e *testEnv) error {
+				return e.cc.SetExpectedDelivery(e.as(e.retailer), "SHIP-1", "2025-06-03T08:00:00Z")
+			},
+			wantAction: "ShipmentExpectedDeliverySet",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"expectedDeliveryBy": "2025-06-03T08:00:00Z"}
+			},
+		},
+		{
+			name: "setting the same expected delivery changes nothing",
+			setup: func(e *testEnv) {
+				e.process("SHIP-1")
+				e.distribute("SHIP-1")
+				e.must(e.cc.SetExpectedDelivery(e.as(e.retailer), "SHIP-1", "2025-06-03T08:00:00Z"))
+			},
+			update: func(e *testEnv) error {
+				return e.cc.SetExpectedDelivery(e.as(e.retailer), "SHIP-1", "2025-06-03T08:00:00Z")
+			},
+			wantAction: "ShipmentExpectedDeliverySet",
+			wantValues: func(e *testEnv) map[string]interface{} { return map[string]interface{}{} },
+		},
+		{
+			name:       "flag",
+			update:     func(e *testEnv) error { return e.cc.FlagShipment(e.as(e.farmer), "SHIP-1", "Odd smell") },
+			wantAction: "ShipmentFlagged",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"underInvestigation": true, "investigationReason": "Odd smell", "investigationFlaggedBy": e.farmer.id}
+			},
+		},
+		{
+			name:       "clear investigation",
+			setup:      func(e *testEnv) { e.must(e.cc.FlagShipment(e.as(e.farmer), "SHIP-1", "Odd smell")) },
+			update:     func(e *testEnv) error { return e.cc.ClearInvestigation(e.as(e.admin), "SHIP-1") },
+			wantAction: "ShipmentInvestigationCleared",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"underInvestigation": false, "investigationReason": "", "investigationFlaggedBy": ""}
+			},
+		},
+		{
+			name:       "place hold",
+			update:     func(e *testEnv) error { return e.cc.PlaceHold(e.as(e.farmer), "SHIP-1", "Awaiting lab results") },
+			wantAction: "ShipmentHoldPlaced",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"onHold": true, "holdReason": "Awaiting lab results", "holdPlacedBy": e.farmer.id}
+			},
+		},
+		{
+			name:       "release hold",
+			setup:      func(e *testEnv) { e.must(e.cc.PlaceHold(e.as(e.farmer), "SHIP-1", "Awaiting lab results")) },
+			update:     func(e *testEnv) error { return e.cc.ReleaseHold(e.as(e.farmer), "SHIP-1") },
+			wantAction: "ShipmentHoldReleased",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"onHold": false, "holdReason": "", "holdPlacedBy": ""}
+			},
+		},
+		{
+			name:       "designate certifier",
+			update:     func(e *testEnv) error { return e.cc.DesignateCertifier(e.as(e.farmer), "SHIP-1", e.certifier.alias) },
+			wantAction: "ShipmentCertifierDesignated",
+			wantValues: func(e *testEnv) map[string]interface{} {
+				return map[string]interface{}{"designatedCertifierId": e.certifier.id}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			e.must(tt.update(e))
+
+			if len(e.stub.events) != 1 {
+				t.Fatalf("set %d events, want one", len(e.stub.events))
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentFieldChanged" || payload["action"] != tt.wantAction || payload["shipmentId"] != "SHIP-1" {
+				t.Fatalf("event %s with action %v, want ShipmentFieldChanged with action %s", name, payload["action"], tt.wantAction)
+			}
+			want := tt.wantValues(e)
+			wantFields := make([]string, 0, len(want))
+			for field := range want {
+				wantFields = append(wantFields, field)
+			}
+			sort.Strings(wantFields)
+			gotFields := []string{}
+			for _, field := range payload["changedFields"].([]interface{}) {
+				gotFields = append(gotFields, field.(string))
+			}
+			if !equalStrings(gotFields, wantFields) {
+				t.Fatalf("changedFields = %v, want %v", gotFields, wantFields)
+			}
+			newValues := payload["newValues"].(map[string]interface{})
+			if len(newValues) != len(want) {
+				t.Fatalf("newValues = %v, want %v", newValues, want)
+			}
+			for field, value := range want {
+				if newValues[field] != value {
+					t.Errorf("newValues[%s] = %v, want %v", field, newValues[field], value)
+				}
+			}
+		})
+	}
+}
+
+func TestLastUpdatedAtNeverMovesBackwards(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(e *testEnv) // SHIP-1 has been created
+		update     func(e *testEnv) error
+		wantStatus model.ShipmentStatus
+	}{
+		{
+			name:       "submit for certification",
+			update:     func(e *testEnv) error { return e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-1") },
+			wantStatus: model.StatusPendingCertification,
+		},
+		{
+			name: "process",
+			update: func(e *testEnv) error {
+				return e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(nil), "")
+			},
+			wantStatus: model.StatusProcessed,
+		},
+		{
+			name:  "distribute",
+			setup: func(e *testEnv) { e.process("SHIP-1") },
+			update: func(e *testEnv) error {
+				return e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(nil))
+			},
+			wantStatus: model.StatusDistributed,
+		},
+		{
+			name:       "receive",
+			setup:      func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			update:     func(e *testEnv) error { return e.cc.ReceiveShipment(e.as(e.retailer), "SHIP-1", e.retailerData(nil)) },
+			wantStatus: model.StatusDelivered,
+		},
+		{
+			name:       "flag",
+			update:     func(e *testEnv) error { return e.cc.FlagShipment(e.as(e.farmer), "SHIP-1", "Odd smell") },
+			wantStatus: model.StatusCreated,
+		},
+		{
+			name:       "void",
+			update:     func(e *testEnv) error { return e.cc.VoidShipment(e.as(e.farmer), "SHIP-1", "Entered twice") },
+			wantStatus: model.StatusVoided,
+		},
+	}
+	for _, tt := range tests {
+		for _, regressed := range []bool{true, false} {
+			name := tt.name + "/later timestamp"
+			if regressed {
+				name = tt.name + "/regressed timestamp"
+			}
+			t.Run(name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				e.createShipment("SHIP-1")
+				if tt.setup != nil {
+					tt.setup(e)
+				}
+				before := e.shipment("SHIP-1").LastUpdatedAt
+				want := e.now.Add(time.Second) // Time of the next transaction
+				if regressed {
+					e.now = before.Add(-time.Hour - time.Second)
+					want = before
+				}
+
+				e.must(tt.update(e))
+				shipment := e.shipment("SHIP-1")
+				if shipment.Status != tt.wantStatus {
+					t.Fatalf("status = %s, want %s", shipment.Status, tt.wantStatus)
+				}
+				if !shipment.LastUpdatedAt.Equal(want) {
+					t.Fatalf("lastUpdatedAt = %s, want %s", shipment.LastUpdatedAt, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDestinationRoleEnforcement(t *testing.T) {
+	if !enforceDestinationRoles {
+		t.Skip("destination role enforcement is disabled")
+	}
+	stages := []struct {
+		name    string
+		role    string
+		holder  func(e *testEnv) *testIdentity
+		prepare func(e *testEnv) // brings SHIP-1 to the stage, unless the stage creates it
+		advance func(e *testEnv, destination string) error
+	}{
+		{
+			name: "CreateShipment", role: "processor", holder: func(e *testEnv) *testIdentity { return e.processor },
+			advance: func(e *testEnv, destination string) error {
+				return e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Strawberries", "Test batch", 100, "kg",
+					e.farmerData(map[string]interface{}{"destinationProcessorId": destination}))
+			},
+		},
+		{
+			name: "ProcessShipment", role: "distributor", holder: func(e *testEnv) *testIdentity { return e.distributor },
+			prepare: func(e *testEnv) { e.createShipment("SHIP-1") },
+			advance: func(e *testEnv, destination string) error {
+				return e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(map[string]interface{}{"destinationDistributorId": destination}), "")
+			},
+		},
+		{
+			name: "DistributeShipment", role: "retailer", holder: func(e *testEnv) *testIdentity { return e.retailer },
+			prepare: func(e *testEnv) { e.createShipment("SHIP-1"); e.process("SHIP-1") },
+			advance: func(e *testEnv, destination string) error {
+				return e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(map[string]interface{}{"destinationRetailerId": destination}))
+			},
+		},
+		{
+			name: "TransformAndCreateProducts", role: "distributor", holder: func(e *testEnv) *testIdentity { return e.distributor },
+			prepare: func(e *testEnv) { e.createShipment("SHIP-1"); e.process("SHIP-1") },
+			advance: func(e *testEnv, destination string) error {
+				return e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"SHIP-1"}]`,
+					`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`,
+					e.processorData(map[string]interface{}{"destinationDistributorId": destination}), false)
+			},
+		},
+	}
+	for _, stage := range stages {
+		tests := []struct {
+			name        string
+			destination func(e *testEnv) *testIdentity
+			revoke      func(e *testEnv, holder *testIdentity) // applied to the role holder before the call
+			wantErr     bool
+		}{
+			{name: "role holder accepted", destination: stage.holder},
+			{name: "identity without the role rejected", destination: func(e *testEnv) *testIdentity { return e.certifier }, wantErr: true},
+			{
+				name: "removed role rejected", destination: stage.holder, wantErr: true,
+				revoke: func(e *testEnv, holder *testIdentity) {
+					e.must(e.cc.RemoveRoleFromIdentity(e.as(e.admin), holder.alias, stage.role))
+				},
+			},
+			{
+				name: "deactivated holder rejected", destination: stage.holder, wantErr: true,
+				revoke: func(e *testEnv, holder *testIdentity) { e.must(e.cc.DeactivateIdentity(e.as(e.admin), holder.alias)) },
+			},
+		}
+		for _, tt := range tests {
+			t.Run(stage.name+"/"+tt.name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				if stage.prepare != nil {
+					stage.prepare(e)
+				}
+				destination := tt.destination(e)
+				if tt.revoke != nil {
+					tt.revoke(e, destination)
+				}
+				wantErr := ""
+				if tt.wantErr {
+					wantErr = fmt.Sprintf("%s: destination identity '%s' (alias: %s) does not have '%s' role", stage.name, destination.id, destination.alias, stage.role)
+				}
+				checkErr(t, stage.advance(e, destination.alias), wantErr)
+			})
+		}
+	}
+}
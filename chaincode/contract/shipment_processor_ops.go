@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"foodtrace/model"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -16,7 +18,10 @@ import (
 
 // --- Lifecycle: Processor Operations ---
 
-func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionContextInterface, shipmentID string, processorDataJSON string) error {
+// ProcessShipment records processing of a shipment. byproductsJSON is an optional array of
+// NewProductDetail; each entry becomes a derived shipment owned by the processor with the
+// processed shipment as its input. Pass "" or "[]" when there are no byproducts.
+func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionContextInterface, shipmentID string, processorDataJSON string, byproductsJSON string) error {
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("ProcessShipment: failed to get actor info: %w", err)
@@ -31,15 +36,22 @@ func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionCont
 	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
 		return err
 	}
-	pdArgs, err := s.validateProcessorDataArgs(processorDataJSON)
+	pdArgs, err := s.validateProcessorDataArgs(ctx, processorDataJSON)
 	if err != nil {
 		return err
 	}
+	byproducts, err := s.parseByproducts(ctx, byproductsJSON, shipmentID)
+	if err != nil {
+		return fmt.Errorf("ProcessShipment: %w", err)
+	}
 
 	shipment, err := s.getShipmentByID(ctx, shipmentID)
 	if err != nil {
 		return fmt.Errorf("ProcessShipment: %w", err)
 	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("ProcessShipment: %w", err)
+	}
 
 	if shipment.Status != model.StatusCreated && shipment.Status != model.StatusCertified {
 		return fmt.Errorf("shipment '%s' cannot be processed. Current status: '%s'. Expected '%s' or '%s'",
@@ -48,6 +60,15 @@ func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionCont
 	if shipment.RecallInfo.IsRecalled {
 		return fmt.Errorf("recalled shipment '%s' cannot be processed", shipmentID)
 	}
+	if pdArgs.ExpiryDate.IsZero() && shipment.FarmerData != nil && shipment.FarmerData.CropType != "" {
+		expiryRequired, errCfg := configListContains(ctx, configExpiryRequiredCropTypes, shipment.FarmerData.CropType)
+		if errCfg != nil {
+			return fmt.Errorf("ProcessShipment: failed to read expiry-required crop types: %w", errCfg)
+		}
+		if expiryRequired {
+			return fmt.Errorf("processorData.expiryDate is required for perishable crop type '%s'", shipment.FarmerData.CropType)
+		}
+	}
 
 	if shipment.Status == model.StatusCreated {
 		if shipment.FarmerData == nil || shipment.FarmerData.DestinationProcessorID == "" {
@@ -64,10 +85,30 @@ func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionCont
 		}
 	}
 
+	cropType := ""
+	if shipment.FarmerData != nil {
+		cropType = shipment.FarmerData.CropType
+	}
+	coolingTarget, coolingKey, hasCoolingTarget, err := getCoolingTarget(ctx, pdArgs.ProcessingType, cropType)
+	if err != nil {
+		return fmt.Errorf("ProcessShipment: failed to read cooling targets: %w", err)
+	}
+	if hasCoolingTarget {
+		if pdArgs.CoolingEndTemp == nil {
+			return fmt.Errorf("processorData.coolingEndTemp is required: a cooling target of %.1f °C applies to '%s'", coolingTarget, coolingKey)
+		}
+		if *pdArgs.CoolingEndTemp > coolingTarget {
+			return fmt.Errorf("processorData.coolingEndTemp %.1f °C does not meet the cooling target of %.1f °C for '%s'", *pdArgs.CoolingEndTemp, coolingTarget, coolingKey)
+		}
+	}
+
 	destDistFullID, err := im.ResolveIdentity(pdArgs.DestinationDistributorID)
 	if err != nil {
 		return fmt.Errorf("ProcessShipment: failed to resolve processorData.destinationDistributorId '%s': %w", pdArgs.DestinationDistributorID, err)
 	}
+	if err := requireDestinationRole(im, "ProcessShipment", destDistFullID, pdArgs.DestinationDistributorID, "distributor"); err != nil {
+		return err
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
@@ -87,11 +128,17 @@ func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionCont
 		ExpiryDate:               pdArgs.ExpiryDate,
 		QualityCertifications:    pdArgs.QualityCertifications,
 		DestinationDistributorID: destDistFullID,
+		CoolingStartTemp:         pdArgs.CoolingStartTemp,
+		CoolingEndTemp:           pdArgs.CoolingEndTemp,
+	}
+	transferReason := transferReasonFromJSON(processorDataJSON)
+	if err := s.recordCustodyTransfer(ctx, shipment, actor, model.StatusProcessed, transferReason, now); err != nil {
+		return fmt.Errorf("ProcessShipment: %w", err)
 	}
 	shipment.Status = model.StatusProcessed
 	shipment.CurrentOwnerID = actor.fullID
 	shipment.CurrentOwnerAlias = actor.alias
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 	ensureShipmentSchemaCompliance(shipment) // Ensure sub-fields are initialized
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
@@ -103,19 +150,62 @@ func (s *FoodtraceSmartContract) ProcessShipment(ctx contractapi.TransactionCont
 		return fmt.Errorf("ProcessShipment: failed to update shipment '%s' on ledger: %w", shipmentID, err)
 	}
 
+	// Byproducts are reported on ShipmentProcessed: Fabric keeps only the last event set in a transaction.
+	byproductIDs := []string{}
+	byproductDetails := []map[string]interface{}{}
+	for _, bp := range byproducts {
+		bpKey, errKey := s.createShipmentCompositeKey(ctx, bp.NewShipmentID)
+		if errKey != nil {
+			return fmt.Errorf("ProcessShipment: failed to create composite key for byproduct '%s': %w", bp.NewShipmentID, errKey)
+		}
+		existingBp, errGet := ctx.GetStub().GetState(bpKey)
+		if errGet != nil {
+			return fmt.Errorf("ProcessShipment: failed to check for existing byproduct shipment '%s': %w", bp.NewShipmentID, errGet)
+		}
+		if existingBp != nil {
+			return fmt.Errorf("ProcessShipment: byproduct shipment with ID '%s' already exists", bp.NewShipmentID)
+		}
+
+		bpProcessorData := *shipment.ProcessorData
+		bpShipment := newDerivedShipment(bp, actor, &bpProcessorData, []string{shipmentID}, now)
+		bpBytes, errMarshal := json.Marshal(bpShipment)
+		if errMarshal != nil {
+			return fmt.Errorf("ProcessShipment: failed to marshal byproduct shipment '%s': %w", bp.NewShipmentID, errMarshal)
+		}
+		if errPut := ctx.GetStub().PutState(bpKey, bpBytes); errPut != nil {
+			return fmt.Errorf("ProcessShipment: failed to save byproduct shipment '%s': %w", bp.NewShipmentID, errPut)
+		}
+		byproductIDs = append(byproductIDs, bp.NewShipmentID)
+		byproductDetails = append(byproductDetails, map[string]interface{}{
+			"shipmentId": bp.NewShipmentID, "productName": bp.ProductName, "quantity": bp.Quantity, "unitOfMeasure": bp.UnitOfMeasure,
+		})
+		logger.Infof("ProcessShipment: Byproduct '%s' (ID: '%s') created from shipment '%s'.", bp.ProductName, bp.NewShipmentID, shipmentID)
+	}
+
 	eventPayload := map[string]interface{}{
 		"destinationDistributorFullId": destDistFullID, "processingType": pdArgs.ProcessingType,
 		"dateProcessed": pdArgs.DateProcessed.Format(time.RFC3339), "contaminationCheck": pdArgs.ContaminationCheck,
+		"byproductIds": byproductIDs, "byproducts": byproductDetails,
+	}
+	if transferReason != "" {
+		eventPayload["transferReason"] = transferReason
 	}
 	s.emitShipmentEvent(ctx, "ShipmentProcessed", shipment, actor, eventPayload)
 	logger.Infof("Shipment '%s' processed by '%s'", shipmentID, actor.alias)
 	return nil
 }
 
+// TransformAndCreateProducts consumes the input shipments and creates the new product shipments. An input
+// with a positive quantityConsumed below its remaining quantity is drawn down and keeps its status and
+// owner, so only the current owner may make such a draw; otherwise it is fully consumed and marked
+// CONSUMED_IN_PROCESSING. With aggregateEvents set, a single TransformationCompleted event carrying
+// per-input draw details replaces the per-shipment InputShipmentConsumedInTransformation and
+// DerivedProductCreated events.
 func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.TransactionContextInterface,
 	inputShipmentConsumptionJSON string,
 	newProductsDataJSON string,
-	processorDataJSON string) error {
+	processorDataJSON string,
+	aggregateEvents bool) error {
 
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
@@ -126,7 +216,7 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 		return fmt.Errorf("TransformAndCreateProducts: %w", err)
 	}
 
-	logger.Infof("Processor '%s' (alias: '%s') initiating transformation process (full consumption).", actor.fullID, actor.alias)
+	logger.Infof("Processor '%s' (alias: '%s') initiating transformation process.", actor.fullID, actor.alias)
 
 	var inputConsumptionDetails []model.InputShipmentConsumptionDetail
 	if err := json.Unmarshal([]byte(inputShipmentConsumptionJSON), &inputConsumptionDetails); err != nil {
@@ -144,7 +234,7 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 		return errors.New("TransformAndCreateProducts: at least one new product must be specified for creation")
 	}
 
-	transformationProcessorDataArgs, err := s.validateProcessorDataArgs(processorDataJSON)
+	transformationProcessorDataArgs, err := s.validateProcessorDataArgs(ctx, processorDataJSON)
 	if err != nil {
 		return fmt.Errorf("TransformAndCreateProducts: invalid processorDataJSON for transformation event: %w", err)
 	}
@@ -154,6 +244,19 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 		if err != nil {
 			return fmt.Errorf("TransformAndCreateProducts: failed to resolve DestinationDistributorID '%s' from processorDataJSON: %w", transformationProcessorDataArgs.DestinationDistributorID, err)
 		}
+		// The admin flag only matters when enforceDestinationRoles is off.
+		checkRole := enforceDestinationRoles
+		if !checkRole {
+			checkRole, err = getConfigBool(ctx, configVerifyTransformDestinationRole, false)
+			if err != nil {
+				return fmt.Errorf("TransformAndCreateProducts: failed to read destination role check setting: %w", err)
+			}
+		}
+		if checkRole {
+			if err := checkDestinationRole(im, "TransformAndCreateProducts", resolvedTransformationDestDistributorID, transformationProcessorDataArgs.DestinationDistributorID, "distributor"); err != nil {
+				return err
+			}
+		}
 	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
@@ -162,24 +265,31 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 	}
 
 	var consumedInputShipmentIDs []string
-	logger.Infof("TransformAndCreateProducts: Processing %d input shipments for full consumption.", len(inputConsumptionDetails))
+	var inputConsumptions []map[string]interface{} // Per-input draw details for the aggregated event
+	var blendEntries []model.BlendEntry
+	seenInputs := make(map[string]bool, len(inputConsumptionDetails)) // Same-transaction writes are not visible to GetState.
+	logger.Infof("TransformAndCreateProducts: Processing %d input shipments for consumption.", len(inputConsumptionDetails))
 	for i, inputDetail := range inputConsumptionDetails {
 		fieldNamePrefix := fmt.Sprintf("inputConsumptionDetails[%d]", i)
 		if errVal := s.validateRequiredString(inputDetail.ShipmentID, fieldNamePrefix+".ShipmentID", maxStringInputLength); errVal != nil {
 			return fmt.Errorf("TransformAndCreateProducts: %w", errVal)
 		}
+		if inputDetail.QuantityConsumed < 0 || math.IsNaN(inputDetail.QuantityConsumed) {
+			return fmt.Errorf("TransformAndCreateProducts: %s.QuantityConsumed cannot be negative, got %f", fieldNamePrefix, inputDetail.QuantityConsumed)
+		}
+		if seenInputs[inputDetail.ShipmentID] {
+			return fmt.Errorf("TransformAndCreateProducts: input shipment '%s' is listed more than once", inputDetail.ShipmentID)
+		}
+		seenInputs[inputDetail.ShipmentID] = true
 
 		inputShipment, errGet := s.getShipmentByID(ctx, inputDetail.ShipmentID)
 		if errGet != nil {
 			return fmt.Errorf("TransformAndCreateProducts: failed to get input shipment '%s': %w", inputDetail.ShipmentID, errGet)
 		}
-
-		if inputShipment.CurrentOwnerID != actor.fullID {
-			logger.Infof("TransformAndCreateProducts: transferring ownership of input shipment '%s' from '%s' to processor '%s'",
-				inputDetail.ShipmentID, inputShipment.CurrentOwnerAlias, actor.alias)
-			inputShipment.CurrentOwnerID = actor.fullID
-			inputShipment.CurrentOwnerAlias = actor.alias
+		if errHold := ensureShipmentMutable(inputShipment); errHold != nil {
+			return fmt.Errorf("TransformAndCreateProducts: %w", errHold)
 		}
+
 		validConsumableStatuses := map[model.ShipmentStatus]bool{
 			model.StatusDelivered: true, model.StatusProcessed: true, model.StatusCertified: true,
 		}
@@ -189,16 +299,39 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 		if inputShipment.RecallInfo.IsRecalled {
 			return fmt.Errorf("TransformAndCreateProducts: input shipment '%s' is recalled and cannot be consumed", inputDetail.ShipmentID)
 		}
-		if inputShipment.IsArchived {
-			return fmt.Errorf("TransformAndCreateProducts: input shipment '%s' is archived and cannot be consumed", inputDetail.ShipmentID)
-		}
 		if inputShipment.Status == model.StatusConsumedInProcessing {
 			return fmt.Errorf("TransformAndCreateProducts: input shipment '%s' has already been consumed in processing", inputDetail.ShipmentID)
 		}
 
-		inputShipment.Status = model.StatusConsumedInProcessing
-		inputShipment.Quantity = 0
-		inputShipment.LastUpdatedAt = now
+		consumedQuantity := inputShipment.Quantity
+		if inputDetail.QuantityConsumed > 0 {
+			if inputDetail.QuantityConsumed > inputShipment.Quantity {
+				return fmt.Errorf("TransformAndCreateProducts: %s.QuantityConsumed %.2f exceeds the %.2f %s remaining on input shipment '%s'",
+					fieldNamePrefix, inputDetail.QuantityConsumed, inputShipment.Quantity, inputShipment.UnitOfMeasure, inputDetail.ShipmentID)
+			}
+			consumedQuantity = inputDetail.QuantityConsumed
+		}
+		fullyConsumed := inputShipment.Quantity-consumedQuantity <= retailSplitTolerance // Absorb float residue from repeated draws
+		if !fullyConsumed && inputShipment.CurrentOwnerID != actor.fullID {
+			return fmt.Errorf("TransformAndCreateProducts: input shipment '%s' can only be partially consumed by its current owner '%s'",
+				inputDetail.ShipmentID, inputShipment.CurrentOwnerAlias)
+		}
+		blendEntries = append(blendEntries, model.BlendEntry{
+			ShipmentID: inputDetail.ShipmentID, Quantity: consumedQuantity, UnitOfMeasure: inputShipment.UnitOfMeasure,
+		})
+		inputShipment.Quantity -= consumedQuantity
+		if fullyConsumed {
+			inputShipment.Status = model.StatusConsumedInProcessing
+			inputShipment.Quantity = 0
+			// A partial draw leaves the remaining stock with its owner; only a consumed input changes hands.
+			if inputShipment.CurrentOwnerID != actor.fullID {
+				logger.Infof("TransformAndCreateProducts: transferring ownership of input shipment '%s' from '%s' to processor '%s'",
+					inputDetail.ShipmentID, inputShipment.CurrentOwnerAlias, actor.alias)
+				inputShipment.CurrentOwnerID = actor.fullID
+				inputShipment.CurrentOwnerAlias = actor.alias
+			}
+		}
+		touchShipment(inputShipment, now)
 
 		inputShipmentKey, _ := s.createShipmentCompositeKey(ctx, inputDetail.ShipmentID)
 		inputShipmentBytes, errMarshal := json.Marshal(inputShipment)
@@ -209,18 +342,42 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 			return fmt.Errorf("TransformAndCreateProducts: failed to save updated input shipment '%s': %w", inputDetail.ShipmentID, errPut)
 		}
 
-		s.emitShipmentEvent(ctx, "InputShipmentConsumedInTransformation", inputShipment, actor, map[string]interface{}{
-			"transformationEventOutputBatchID": transformationProcessorDataArgs.OutputBatchID,
-			"consumedQuantity":                 "FULL",
-		})
+		if !aggregateEvents {
+			s.emitShipmentEvent(ctx, "InputShipmentConsumedInTransformation", inputShipment, actor, map[string]interface{}{
+				"transformationEventOutputBatchID": transformationProcessorDataArgs.OutputBatchID,
+				"consumedQuantity":                 consumedQuantity,
+				"remainingQuantity":                inputShipment.Quantity,
+				"fullyConsumed":                    fullyConsumed,
+			})
+		}
 		consumedInputShipmentIDs = append(consumedInputShipmentIDs, inputDetail.ShipmentID)
-		logger.Infof("TransformAndCreateProducts: Input shipment '%s' marked as '%s' (fully consumed).", inputDetail.ShipmentID, model.StatusConsumedInProcessing)
+		inputConsumptions = append(inputConsumptions, map[string]interface{}{
+			"shipmentId":        inputDetail.ShipmentID,
+			"consumedQuantity":  consumedQuantity,
+			"remainingQuantity": inputShipment.Quantity,
+			"fullyConsumed":     fullyConsumed,
+		})
+		if fullyConsumed {
+			logger.Infof("TransformAndCreateProducts: Input shipment '%s' marked as '%s' (fully consumed).", inputDetail.ShipmentID, model.StatusConsumedInProcessing)
+		} else {
+			logger.Infof("TransformAndCreateProducts: Consumed %.2f %s of input shipment '%s'; %.2f remaining.", consumedQuantity, inputShipment.UnitOfMeasure, inputDetail.ShipmentID, inputShipment.Quantity)
+		}
+	}
+
+	var blendComposition []model.BlendEntry
+	if len(blendEntries) > 1 {
+		blendComposition, err = computeBlendComposition(blendEntries)
+		if err != nil {
+			return fmt.Errorf("TransformAndCreateProducts: %w", err)
+		}
 	}
 
 	logger.Infof("TransformAndCreateProducts: Creating %d new output product shipments.", len(newProductDetails))
+	createdShipmentIDs := []string{}
+	var lastOutputShipment model.Shipment
 	for i, newProdDetail := range newProductDetails {
 		fieldNamePrefix := fmt.Sprintf("newProductDetails[%d]", i)
-		if errVal := s.validateRequiredString(newProdDetail.NewShipmentID, fieldNamePrefix+".NewShipmentID", maxStringInputLength); errVal != nil {
+		if errVal := s.validateShipmentID(newProdDetail.NewShipmentID, fieldNamePrefix+".NewShipmentID"); errVal != nil {
 			return fmt.Errorf("TransformAndCreateProducts: %w", errVal)
 		}
 		if errVal := s.validateRequiredString(newProdDetail.ProductName, fieldNamePrefix+".ProductName", maxStringInputLength); errVal != nil {
@@ -235,6 +392,9 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 		if errVal := s.validateRequiredString(newProdDetail.UnitOfMeasure, fieldNamePrefix+".UnitOfMeasure", maxStringInputLength); errVal != nil {
 			return fmt.Errorf("TransformAndCreateProducts: %w", errVal)
 		}
+		if errVal := validateUnitOfMeasure(ctx, newProdDetail.UnitOfMeasure, fieldNamePrefix+".UnitOfMeasure"); errVal != nil {
+			return fmt.Errorf("TransformAndCreateProducts: %w", errVal)
+		}
 
 		newShipmentKey, errKey := s.createShipmentCompositeKey(ctx, newProdDetail.NewShipmentID)
 		if errKey != nil {
@@ -248,43 +408,23 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 			return fmt.Errorf("TransformAndCreateProducts: new product shipment with ID '%s' already exists", newProdDetail.NewShipmentID)
 		}
 
-		outputShipment := model.Shipment{
-			ObjectType:        shipmentObjectType,
-			ID:                newProdDetail.NewShipmentID,
-			ProductName:       newProdDetail.ProductName,
-			Description:       newProdDetail.Description,
-			Quantity:          newProdDetail.Quantity,
-			UnitOfMeasure:     newProdDetail.UnitOfMeasure,
-			CurrentOwnerID:    actor.fullID,
-			CurrentOwnerAlias: actor.alias,
-			Status:            model.StatusProcessed,
-			CreatedAt:         now,
-			LastUpdatedAt:     now,
-			IsArchived:        false,
-			InputShipmentIDs:  consumedInputShipmentIDs,
-			IsDerivedProduct:  true,
-			ProcessorData: &model.ProcessorData{
-				ProcessorID:              actor.fullID,
-				ProcessorAlias:           actor.alias,
-				DateProcessed:            transformationProcessorDataArgs.DateProcessed,
-				ProcessingType:           transformationProcessorDataArgs.ProcessingType,
-				ProcessingLineID:         transformationProcessorDataArgs.ProcessingLineID,
-				ProcessingLocation:       transformationProcessorDataArgs.ProcessingLocation,
-				ProcessingCoordinates:    transformationProcessorDataArgs.ProcessingCoordinates,
-				ContaminationCheck:       transformationProcessorDataArgs.ContaminationCheck,
-				OutputBatchID:            transformationProcessorDataArgs.OutputBatchID,
-				ExpiryDate:               transformationProcessorDataArgs.ExpiryDate,
-				QualityCertifications:    transformationProcessorDataArgs.QualityCertifications,
-				DestinationDistributorID: resolvedTransformationDestDistributorID,
-			},
-			FarmerData:           &model.FarmerData{},
-			CertificationRecords: []model.CertificationRecord{},
-			DistributorData:      &model.DistributorData{},
-			RetailerData:         &model.RetailerData{},
-			RecallInfo:           &model.RecallInfo{IsRecalled: false, LinkedShipmentIDs: []string{}},
-			History:              []model.HistoryEntry{},
-		}
-		ensureShipmentSchemaCompliance(&outputShipment)
+		outputShipment := newDerivedShipment(newProdDetail, actor, &model.ProcessorData{
+			ProcessorID:              actor.fullID,
+			ProcessorAlias:           actor.alias,
+			DateProcessed:            transformationProcessorDataArgs.DateProcessed,
+			ProcessingType:           transformationProcessorDataArgs.ProcessingType,
+			ProcessingLineID:         transformationProcessorDataArgs.ProcessingLineID,
+			ProcessingLocation:       transformationProcessorDataArgs.ProcessingLocation,
+			ProcessingCoordinates:    transformationProcessorDataArgs.ProcessingCoordinates,
+			ContaminationCheck:       transformationProcessorDataArgs.ContaminationCheck,
+			OutputBatchID:            transformationProcessorDataArgs.OutputBatchID,
+			ExpiryDate:               transformationProcessorDataArgs.ExpiryDate,
+			QualityCertifications:    transformationProcessorDataArgs.QualityCertifications,
+			DestinationDistributorID: resolvedTransformationDestDistributorID,
+			CoolingStartTemp:         transformationProcessorDataArgs.CoolingStartTemp,
+			CoolingEndTemp:           transformationProcessorDataArgs.CoolingEndTemp,
+			BlendComposition:         blendComposition,
+		}, consumedInputShipmentIDs, now)
 
 		outputShipmentBytes, errMarshal := json.Marshal(outputShipment)
 		if errMarshal != nil {
@@ -294,14 +434,222 @@ func (s *FoodtraceSmartContract) TransformAndCreateProducts(ctx contractapi.Tran
 			return fmt.Errorf("TransformAndCreateProducts: failed to save new output shipment '%s': %w", newProdDetail.NewShipmentID, errPut)
 		}
 
-		s.emitShipmentEvent(ctx, "DerivedProductCreated", &outputShipment, actor, map[string]interface{}{
+		if aggregateEvents {
+			lastOutputShipment = outputShipment
+		} else {
+			s.emitShipmentEvent(ctx, "DerivedProductCreated", &outputShipment, actor, map[string]interface{}{
+				"transformationEventOutputBatchID": transformationProcessorDataArgs.OutputBatchID,
+				"inputShipmentIDs":                 consumedInputShipmentIDs,
+			})
+		}
+		createdShipmentIDs = append(createdShipmentIDs, newProdDetail.NewShipmentID)
+		logger.Infof("TransformAndCreateProducts: New output product '%s' (ID: '%s') created.", newProdDetail.ProductName, newProdDetail.NewShipmentID)
+	}
+
+	if aggregateEvents {
+		s.emitShipmentEvent(ctx, "TransformationCompleted", &lastOutputShipment, actor, map[string]interface{}{
 			"transformationEventOutputBatchID": transformationProcessorDataArgs.OutputBatchID,
 			"inputShipmentIDs":                 consumedInputShipmentIDs,
+			"inputConsumptions":                inputConsumptions,
+			"newShipmentIDs":                   createdShipmentIDs,
+			"consumedCount":                    len(consumedInputShipmentIDs),
+			"createdCount":                     len(createdShipmentIDs),
 		})
-		logger.Infof("TransformAndCreateProducts: New output product '%s' (ID: '%s') created.", newProdDetail.ProductName, newProdDetail.NewShipmentID)
 	}
-
 	logger.Infof("TransformAndCreateProducts: Transformation process completed successfully by processor '%s'. %d inputs consumed, %d new products created.",
 		actor.alias, len(inputConsumptionDetails), len(newProductDetails))
 	return nil
 }
+
+// DisposeShipments consumes input shipments into CONSUMED_IN_PROCESSING without creating any outputs, e.g.
+// for spoiled or rejected stock. inputIDsJSON is an array of shipment IDs. A single ShipmentsDisposed
+// event is emitted for the batch.
+func (s *FoodtraceSmartContract) DisposeShipments(ctx contractapi.TransactionContextInterface, inputIDsJSON string, reason string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("DisposeShipments: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("processor"); err != nil {
+		return fmt.Errorf("DisposeShipments: %w", err)
+	}
+
+	var inputIDs []string
+	if err := json.Unmarshal([]byte(inputIDsJSON), &inputIDs); err != nil {
+		return fmt.Errorf("DisposeShipments: invalid inputIDsJSON: %w", err)
+	}
+	if len(inputIDs) == 0 {
+		return errors.New("DisposeShipments: at least one input shipment must be specified for disposal")
+	}
+	if err := s.validateStringArray(inputIDs, "inputIDs", maxArrayElements, maxStringInputLength); err != nil {
+		return fmt.Errorf("DisposeShipments: %w", err)
+	}
+	if err := s.validateRequiredString(reason, "reason", maxDescriptionLength); err != nil {
+		return fmt.Errorf("DisposeShipments: %w", err)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("DisposeShipments: failed to get transaction timestamp: %w", err)
+	}
+
+	seen := make(map[string]bool, len(inputIDs))
+	var lastDisposed *model.Shipment
+	for _, inputID := range inputIDs {
+		if seen[inputID] {
+			return fmt.Errorf("DisposeShipments: input shipment '%s' is listed more than once", inputID)
+		}
+		seen[inputID] = true
+
+		inputShipment, errGet := s.getShipmentByID(ctx, inputID)
+		if errGet != nil {
+			return fmt.Errorf("DisposeShipments: failed to get input shipment '%s': %w", inputID, errGet)
+		}
+		if errHold := ensureShipmentMutable(inputShipment); errHold != nil {
+			return fmt.Errorf("DisposeShipments: %w", errHold)
+		}
+		if inputShipment.RecallInfo != nil && inputShipment.RecallInfo.IsRecalled {
+			return fmt.Errorf("DisposeShipments: input shipment '%s' is recalled – use the recall destruction flow instead", inputID)
+		}
+		if inputShipment.CurrentOwnerID != actor.fullID {
+			return fmt.Errorf("DisposeShipments: caller '%s' is not the current owner of input shipment '%s'", actor.alias, inputID)
+		}
+		validDisposableStatuses := map[model.ShipmentStatus]bool{
+			model.StatusDelivered: true, model.StatusProcessed: true, model.StatusCertified: true,
+		}
+		if !validDisposableStatuses[inputShipment.Status] {
+			return fmt.Errorf("DisposeShipments: input shipment '%s' is not in a disposable state (current: %s). Expected one of: DELIVERED, PROCESSED, CERTIFIED", inputID, inputShipment.Status)
+		}
+
+		inputShipment.DisposalInfo = &model.DisposalInfo{
+			Reason:           reason,
+			DisposedQuantity: inputShipment.Quantity,
+			DisposedBy:       actor.fullID,
+			DisposedByAlias:  actor.alias,
+			DisposedAt:       now,
+		}
+		inputShipment.Status = model.StatusConsumedInProcessing
+		inputShipment.Quantity = 0
+		touchShipment(inputShipment, now)
+
+		inputShipmentKey, _ := s.createShipmentCompositeKey(ctx, inputID)
+		inputShipmentBytes, errMarshal := json.Marshal(inputShipment)
+		if errMarshal != nil {
+			return fmt.Errorf("DisposeShipments: failed to marshal input shipment '%s': %w", inputID, errMarshal)
+		}
+		if errPut := ctx.GetStub().PutState(inputShipmentKey, inputShipmentBytes); errPut != nil {
+			return fmt.Errorf("DisposeShipments: failed to save input shipment '%s': %w", inputID, errPut)
+		}
+		lastDisposed = inputShipment
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentsDisposed", lastDisposed, actor, map[string]interface{}{
+		"reason":              reason,
+		"disposedShipmentIDs": inputIDs,
+		"disposedCount":       len(inputIDs),
+	})
+	logger.Infof("DisposeShipments: processor '%s' disposed %d input shipments (reason: %s)", actor.alias, len(inputIDs), reason)
+	return nil
+}
+
+// parseByproducts decodes and validates the optional byproducts array passed to ProcessShipment.
+func (s *FoodtraceSmartContract) parseByproducts(ctx contractapi.TransactionContextInterface, byproductsJSON string, sourceShipmentID string) ([]model.NewProductDetail, error) {
+	byproducts := []model.NewProductDetail{}
+	if strings.TrimSpace(byproductsJSON) == "" {
+		return byproducts, nil
+	}
+	if err := json.Unmarshal([]byte(byproductsJSON), &byproducts); err != nil {
+		return nil, fmt.Errorf("invalid byproductsJSON: %w", err)
+	}
+	if len(byproducts) > maxArrayElements {
+		return nil, fmt.Errorf("byproductsJSON has %d items, exceeding maximum of %d", len(byproducts), maxArrayElements)
+	}
+	seen := make(map[string]bool)
+	for i, bp := range byproducts {
+		fieldNamePrefix := fmt.Sprintf("byproducts[%d]", i)
+		if err := s.validateShipmentID(bp.NewShipmentID, fieldNamePrefix+".newShipmentId"); err != nil {
+			return nil, err
+		}
+		if bp.NewShipmentID == sourceShipmentID || seen[bp.NewShipmentID] {
+			return nil, fmt.Errorf("%s.newShipmentId '%s' is duplicated", fieldNamePrefix, bp.NewShipmentID)
+		}
+		seen[bp.NewShipmentID] = true
+		if err := s.validateRequiredString(bp.ProductName, fieldNamePrefix+".productName", maxStringInputLength); err != nil {
+			return nil, err
+		}
+		if err := s.validateOptionalString(bp.Description, fieldNamePrefix+".description", maxDescriptionLength); err != nil {
+			return nil, err
+		}
+		if bp.Quantity <= 0 {
+			return nil, fmt.Errorf("%s.quantity must be positive, got %f", fieldNamePrefix, bp.Quantity)
+		}
+		if err := s.validateRequiredString(bp.UnitOfMeasure, fieldNamePrefix+".unitOfMeasure", maxStringInputLength); err != nil {
+			return nil, err
+		}
+		if err := validateUnitOfMeasure(ctx, bp.UnitOfMeasure, fieldNamePrefix+".unitOfMeasure"); err != nil {
+			return nil, err
+		}
+	}
+	return byproducts, nil
+}
+
+// blendPercentageTolerance is how far computed blend percentages may sum from 100 due to rounding.
+const blendPercentageTolerance = 0.01
+
+// computeBlendComposition fills in each input's percentage of the total consumed quantity. Percentages
+// are only meaningful when all inputs share a unit of measure, so mixed units yield no composition.
+func computeBlendComposition(entries []model.BlendEntry) ([]model.BlendEntry, error) {
+	total := 0.0
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.UnitOfMeasure, entries[0].UnitOfMeasure) {
+			logger.Infof("computeBlendComposition: inputs use different units ('%s', '%s'); blend composition not recorded", entries[0].UnitOfMeasure, entry.UnitOfMeasure)
+			return nil, nil
+		}
+		total += entry.Quantity
+	}
+	if total <= 0 {
+		logger.Infof("computeBlendComposition: inputs have no remaining quantity; blend composition not recorded")
+		return nil, nil
+	}
+	composition := make([]model.BlendEntry, len(entries))
+	sum := 0.0
+	for i, entry := range entries {
+		entry.Percentage = math.Round(entry.Quantity/total*10000) / 100
+		composition[i] = entry
+		sum += entry.Percentage
+	}
+	if math.Abs(sum-100) > blendPercentageTolerance*float64(len(entries)) {
+		return nil, fmt.Errorf("blend composition percentages sum to %.2f, expected 100", sum)
+	}
+	return composition, nil
+}
+
+// newDerivedShipment builds a processor-owned shipment derived from the given input shipments.
+func newDerivedShipment(detail model.NewProductDetail, actor *actorInfo, processorData *model.ProcessorData, inputShipmentIDs []string, now time.Time) model.Shipment {
+	derived := model.Shipment{
+		ObjectType:           shipmentObjectType,
+		ID:                   detail.NewShipmentID,
+		ProductName:          detail.ProductName,
+		Description:          detail.Description,
+		Quantity:             detail.Quantity,
+		UnitOfMeasure:        detail.UnitOfMeasure,
+		CurrentOwnerID:       actor.fullID,
+		CurrentOwnerAlias:    actor.alias,
+		Status:               model.StatusProcessed,
+		CreatedAt:            now,
+		CreatedByMSP:         actor.mspID,
+		LastUpdatedAt:        now,
+		IsArchived:           false,
+		InputShipmentIDs:     inputShipmentIDs,
+		IsDerivedProduct:     true,
+		ProcessorData:        processorData,
+		FarmerData:           &model.FarmerData{},
+		CertificationRecords: []model.CertificationRecord{},
+		DistributorData:      &model.DistributorData{},
+		RetailerData:         &model.RetailerData{},
+		RecallInfo:           &model.RecallInfo{IsRecalled: false, LinkedShipmentIDs: []string{}},
+		History:              []model.HistoryEntry{},
+	}
+	ensureShipmentSchemaCompliance(&derived)
+	return derived
+}
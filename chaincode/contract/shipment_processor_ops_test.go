@@ -0,0 +1,584 @@
+package contract
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestProcessShipmentWithByproducts(t *testing.T) {
+	tests := []struct {
+		name       string
+		byproducts string
+		wantIDs    []string
+		wantErr    string
+	}{
+		{name: "no byproducts", byproducts: "", wantIDs: []string{}},
+		{name: "empty array", byproducts: "[]", wantIDs: []string{}},
+		{name: "one byproduct", byproducts: `[{"newShipmentId":"PULP-1","productName":"Strawberry pulp","quantity":12.5,"unitOfMeasure":"kg"}]`, wantIDs: []string{"PULP-1"}},
+		{name: "invalid JSON", byproducts: `{"newShipmentId":"PULP-1"}`, wantErr: "invalid byproductsJSON"},
+		{name: "reserved ID", byproducts: `[{"newShipmentId":"GEN_1","productName":"Pulp","quantity":1,"unitOfMeasure":"kg"}]`, wantErr: "reserved prefix"},
+		{name: "ID of the source shipment", byproducts: `[{"newShipmentId":"SHIP-1","productName":"Pulp","quantity":1,"unitOfMeasure":"kg"}]`, wantErr: "duplicated"},
+		{name: "zero quantity", byproducts: `[{"newShipmentId":"PULP-1","productName":"Pulp","quantity":0,"unitOfMeasure":"kg"}]`, wantErr: "must be positive"},
+		{name: "missing product name", byproducts: `[{"newShipmentId":"PULP-1","quantity":1,"unitOfMeasure":"kg"}]`, wantErr: "productName cannot be empty"},
+		{name: "existing shipment ID", byproducts: `[{"newShipmentId":"SHIP-2","productName":"Pulp","quantity":1,"unitOfMeasure":"kg"}]`, wantErr: "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.createShipment("SHIP-2")
+			err := e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(nil), tt.byproducts)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+
+			name, payload := e.lastEvent()
+			if name != "ShipmentProcessed" {
+				t.Fatalf("last event = %s, want ShipmentProcessed", name)
+			}
+			gotIDs := []string{}
+			for _, id := range payload["byproductIds"].([]interface{}) {
+				gotIDs = append(gotIDs, id.(string))
+			}
+			if !equalStrings(gotIDs, tt.wantIDs) {
+				t.Fatalf("event byproductIds = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			if details := payload["byproducts"].([]interface{}); len(details) != len(tt.wantIDs) {
+				t.Fatalf("event lists %d byproducts, want %d", len(details), len(tt.wantIDs))
+			}
+			for _, id := range tt.wantIDs {
+				bp := e.shipment(id)
+				if !bp.IsDerivedProduct || !equalStrings(bp.InputShipmentIDs, []string{"SHIP-1"}) {
+					t.Fatalf("byproduct %s: derived %t from %v", id, bp.IsDerivedProduct, bp.InputShipmentIDs)
+				}
+				if bp.CurrentOwnerID != e.processor.id || bp.Status != model.StatusProcessed || bp.Quantity != 12.5 {
+					t.Fatalf("byproduct %s: owner %s, status %s, quantity %v", id, bp.CurrentOwnerID, bp.Status, bp.Quantity)
+				}
+			}
+			if source := e.shipment("SHIP-1"); source.Status != model.StatusProcessed || source.Quantity != 100 {
+				t.Fatalf("source shipment: status %s, quantity %v", source.Status, source.Quantity)
+			}
+		})
+	}
+}
+
+func TestProcessShipmentExpiryRequiredForPerishables(t *testing.T) {
+	tests := []struct {
+		name       string
+		perishable string // "" leaves the list unconfigured
+		cropType   string
+		expiry     interface{} // nil omits expiryDate
+		wantErr    string
+	}{
+		{name: "perishable without expiry", perishable: `["Strawberry","lettuce"]`, cropType: "strawberry", wantErr: "expiryDate is required for perishable crop type 'strawberry'"},
+		{name: "perishable with expiry", perishable: `["Strawberry","lettuce"]`, cropType: "strawberry", expiry: "2025-06-15T00:00:00Z"},
+		{name: "non-perishable without expiry", perishable: `["Strawberry","lettuce"]`, cropType: "walnut"},
+		{name: "unconfigured", cropType: "strawberry"},
+		{name: "emptied list", perishable: `[]`, cropType: "strawberry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.must(e.cc.CreateShipment(e.as(e.farmer), "SHIP-1", "Produce", "Test batch", 100, "kg", e.farmerData(map[string]interface{}{"cropType": tt.cropType})))
+			if tt.perishable != "" {
+				e.must(e.cc.SetExpiryRequiredCropTypes(e.as(e.admin), tt.perishable))
+			}
+			err := e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(map[string]interface{}{"expiryDate": tt.expiry}), "")
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != model.StatusCreated {
+					t.Fatalf("rejected processing changed status to %s", shipment.Status)
+				}
+				return
+			}
+			if tt.expiry != nil && shipment.ProcessorData.ExpiryDate.Format(time.RFC3339) != tt.expiry {
+				t.Fatalf("expiryDate = %s, want %v", shipment.ProcessorData.ExpiryDate, tt.expiry)
+			}
+		})
+	}
+}
+
+func TestProcessShipmentCoolingTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		targets   string // "" leaves targets unconfigured
+		startTemp interface{}
+		endTemp   interface{} // nil omits the field
+		wantErr   string
+	}{
+		{name: "no target records temperatures", startTemp: 21.0, endTemp: 7.0},
+		{name: "no target and no temperatures"},
+		{name: "meets crop target", targets: `{"Strawberry": 4}`, startTemp: 21.0, endTemp: 3.5},
+		{name: "meets crop target exactly", targets: `{"strawberry": 4}`, endTemp: 4.0},
+		{name: "misses crop target", targets: `{"strawberry": 4}`, startTemp: 21.0, endTemp: 5.0, wantErr: "does not meet the cooling target of 4.0 °C for 'strawberry'"},
+		{name: "target without end temperature", targets: `{"strawberry": 4}`, wantErr: "coolingEndTemp is required"},
+		{name: "processing type target takes precedence", targets: `{"washing": 2, "strawberry": 10}`, endTemp: 5.0, wantErr: "target of 2.0 °C for 'washing'"},
+		{name: "target for other crop", targets: `{"lettuce": 1}`, endTemp: 5.0},
+		{name: "keys differing only in case rejected", targets: `{"Berries": 4, "berries": 8}`, wantErr: "more than one entry for 'berries'"},
+		{name: "implausible start temperature", startTemp: 500.0, endTemp: 3.0, wantErr: "outside the plausible range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			var err error
+			if tt.targets != "" {
+				err = e.cc.SetCoolingTargets(e.as(e.admin), tt.targets)
+			}
+			if err == nil {
+				data := e.processorData(map[string]interface{}{"coolingStartTemp": tt.startTemp, "coolingEndTemp": tt.endTemp})
+				err = e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", data, "")
+			}
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			pd := e.shipment("SHIP-1").ProcessorData
+			for _, c := range []struct {
+				got  *float64
+				want interface{}
+			}{{pd.CoolingStartTemp, tt.startTemp}, {pd.CoolingEndTemp, tt.endTemp}} {
+				if (c.got == nil) != (c.want == nil) || (c.got != nil && *c.got != c.want) {
+					t.Fatalf("cooling temperatures = %v, %v; want %v, %v", pd.CoolingStartTemp, pd.CoolingEndTemp, tt.startTemp, tt.endTemp)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessingCoordinatesRequirement(t *testing.T) {
+	tests := []struct {
+		name        string
+		configure   bool // false leaves the setting at its default
+		required    bool
+		coordinates interface{} // nil omits processingCoordinates
+		wantErr     string
+	}{
+		{name: "default requires coordinates", wantErr: "processorData.processingCoordinates is required"},
+		{name: "required and missing", configure: true, required: true, wantErr: "processorData.processingCoordinates is required"},
+		{name: "required and given", configure: true, required: true, coordinates: map[string]float64{"latitude": 36.8, "longitude": -119.8}},
+		{name: "optional and missing", configure: true, required: false},
+		{name: "optional and given", configure: true, required: false, coordinates: map[string]float64{"latitude": 36.8, "longitude": -119.8}},
+		{name: "optional but out of range", configure: true, required: false, coordinates: map[string]float64{"latitude": 91, "longitude": -119.8}, wantErr: "latitude must be between -90 and 90"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.configure {
+				e.must(e.cc.SetProcessingCoordinatesRequired(e.as(e.admin), tt.required))
+			}
+
+			err := e.cc.ProcessShipment(e.as(e.processor), "SHIP-1", e.processorData(map[string]interface{}{"processingCoordinates": tt.coordinates}), "")
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != model.StatusCreated || shipment.ProcessorData.ProcessingType != "" {
+					t.Fatalf("rejected processing changed the shipment: status %s", shipment.Status)
+				}
+				return
+			}
+			if shipment.Status != model.StatusProcessed {
+				t.Fatalf("status = %s, want %s", shipment.Status, model.StatusProcessed)
+			}
+			if gotCoordinates := shipment.ProcessorData.ProcessingCoordinates != nil; gotCoordinates != (tt.coordinates != nil) {
+				t.Fatalf("stored coordinates = %+v, want present %t", shipment.ProcessorData.ProcessingCoordinates, tt.coordinates != nil)
+			}
+		})
+	}
+
+	t.Run("non-admin cannot change the setting", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetProcessingCoordinatesRequired(e.as(e.processor), false), "admin")
+	})
+}
+
+func TestTransformAndCreateProductsEventAggregation(t *testing.T) {
+	tests := []struct {
+		name       string
+		aggregate  bool
+		wantEvents []string
+	}{
+		{
+			name:       "per-item events",
+			aggregate:  false,
+			wantEvents: []string{"InputShipmentConsumedInTransformation", "InputShipmentConsumedInTransformation", "DerivedProductCreated", "DerivedProductCreated"},
+		},
+		{name: "aggregated event", aggregate: true, wantEvents: []string{"TransformationCompleted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, id := range []string{"INPUT-1", "INPUT-2"} {
+				e.createShipment(id)
+				e.process(id)
+			}
+			products := `[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"},` +
+				`{"newShipmentId":"JAM-2","productName":"Jam","quantity":30,"unitOfMeasure":"kg"}]`
+
+			e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"INPUT-1"},{"shipmentId":"INPUT-2"}]`, products, e.processorData(nil), tt.aggregate))
+			if got := e.txEventNames(); !equalStrings(got, tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", got, tt.wantEvents)
+			}
+			for _, id := range []string{"INPUT-1", "INPUT-2"} {
+				if status := e.shipment(id).Status; status != model.StatusConsumedInProcessing {
+					t.Fatalf("%s status = %s, want %s", id, status, model.StatusConsumedInProcessing)
+				}
+			}
+			if !tt.aggregate {
+				return
+			}
+			_, payload := e.lastEvent()
+			if payload["consumedCount"] != 2.0 || payload["createdCount"] != 2.0 {
+				t.Fatalf("payload = %v", payload)
+			}
+			created, _ := payload["newShipmentIDs"].([]interface{})
+			if len(created) != 2 || created[0] != "JAM-1" || created[1] != "JAM-2" {
+				t.Fatalf("newShipmentIDs = %v, want [JAM-1 JAM-2]", payload["newShipmentIDs"])
+			}
+			consumptions, _ := payload["inputConsumptions"].([]interface{})
+			if len(consumptions) != 2 {
+				t.Fatalf("inputConsumptions = %v, want two entries", payload["inputConsumptions"])
+			}
+			for i, id := range []string{"INPUT-1", "INPUT-2"} {
+				entry := consumptions[i].(map[string]interface{})
+				if entry["shipmentId"] != id || entry["consumedQuantity"] != 100.0 || entry["remainingQuantity"] != 0.0 || entry["fullyConsumed"] != true {
+					t.Fatalf("inputConsumptions[%d] = %v, want %s fully consumed", i, entry, id)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformDestinationDistributorRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		check       bool
+		destination func(e *testEnv) *testIdentity
+		wantErr     string
+	}{
+		{name: "check on, distributor destination", check: true, destination: func(e *testEnv) *testIdentity { return e.distributor }},
+		{name: "check on, non-distributor destination", check: true, destination: func(e *testEnv) *testIdentity { return e.retailer }, wantErr: "does not have 'distributor' role"},
+		// enforceDestinationRoles checks the role whatever the flag says.
+		{name: "check off, non-distributor destination still rejected", check: false, destination: func(e *testEnv) *testIdentity { return e.retailer }, wantErr: "does not have 'distributor' role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("INPUT-1")
+			e.process("INPUT-1")
+			e.must(e.cc.SetTransformDestinationRoleCheck(e.as(e.admin), tt.check))
+
+			destination := tt.destination(e)
+			processorData := e.processorData(map[string]interface{}{"destinationDistributorId": destination.alias})
+			err := e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"INPUT-1"}]`,
+				`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`, processorData, false)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				if status := e.shipment("INPUT-1").Status; status != model.StatusProcessed {
+					t.Fatalf("rejected transformation consumed the input: status %s", status)
+				}
+				return
+			}
+			if got := e.shipment("JAM-1").ProcessorData.DestinationDistributorID; got != destination.id {
+				t.Fatalf("product destinationDistributorId = %q, want %q", got, destination.id)
+			}
+		})
+	}
+
+	t.Run("non-admin cannot change the setting", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetTransformDestinationRoleCheck(e.as(e.processor), true), "admin")
+	})
+}
+
+func TestTransformBlendComposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		units   []string // unit of measure of each 100-unit input, SHIP-1, SHIP-2, ...
+		inputs  string
+		want    []float64 // percentage per input, in input order; nil when no composition is recorded
+		wantQty []float64
+	}{
+		{name: "even two-input blend", units: []string{"kg", "kg"}, inputs: `[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2"}]`, want: []float64{50, 50}, wantQty: []float64{100, 100}},
+		{
+			name: "partial draws weighted by consumed quantity", units: []string{"kg", "kg"},
+			inputs: `[{"shipmentId":"SHIP-1","quantityConsumed":30},{"shipmentId":"SHIP-2","quantityConsumed":90}]`,
+			want:   []float64{25, 75}, wantQty: []float64{30, 90},
+		},
+		{
+			name: "three-way blend rounds within tolerance", units: []string{"kg", "kg", "kg"},
+			inputs: `[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2"},{"shipmentId":"SHIP-3"}]`,
+			want:   []float64{33.33, 33.33, 33.33}, wantQty: []float64{100, 100, 100},
+		},
+		{name: "unit names compared case-insensitively", units: []string{"kg", "KG"}, inputs: `[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2","quantityConsumed":100}]`, want: []float64{50, 50}, wantQty: []float64{100, 100}},
+		{name: "single input has no composition", units: []string{"kg"}, inputs: `[{"shipmentId":"SHIP-1"}]`},
+		{name: "mixed units have no composition", units: []string{"kg", "lb"}, inputs: `[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for i, unit := range tt.units {
+				id := "SHIP-" + strconv.Itoa(i+1)
+				e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, unit, e.farmerData(nil)))
+				e.process(id)
+			}
+			e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), tt.inputs,
+				`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"},{"newShipmentId":"JAM-2","productName":"Jam","quantity":20,"unitOfMeasure":"kg"}]`,
+				e.processorData(nil), false))
+
+			for _, output := range []string{"JAM-1", "JAM-2"} {
+				got := e.shipment(output).ProcessorData.BlendComposition
+				if len(got) != len(tt.want) {
+					t.Fatalf("%s blend composition = %+v, want %d entries", output, got, len(tt.want))
+				}
+				for i, entry := range got {
+					id := "SHIP-" + strconv.Itoa(i+1)
+					if entry.ShipmentID != id || entry.Percentage != tt.want[i] || entry.Quantity != tt.wantQty[i] || entry.UnitOfMeasure != tt.units[i] {
+						t.Errorf("%s blend entry %d = %+v, want %s at %.2f%% of %.0f %s", output, i, entry, id, tt.want[i], tt.wantQty[i], tt.units[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTransformPartialConsumption(t *testing.T) {
+	tests := []struct {
+		name       string
+		draws      []string // quantityConsumed of each successive transformation of SHIP-1; "" omits it
+		wantErr    string   // expected from the last draw
+		wantUsed   float64  // consumedQuantity reported for the last draw
+		wantQty    float64
+		wantStatus model.ShipmentStatus
+	}{
+		{name: "full consumption by default", draws: []string{""}, wantUsed: 100, wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+		{name: "partial draw keeps the input", draws: []string{"40"}, wantUsed: 40, wantQty: 60, wantStatus: model.StatusProcessed},
+		{name: "draw of the whole remainder", draws: []string{"100"}, wantUsed: 100, wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+		{name: "successive draws", draws: []string{"40", "35"}, wantUsed: 35, wantQty: 25, wantStatus: model.StatusProcessed},
+		{name: "successive draws exhaust the input", draws: []string{"40", "60"}, wantUsed: 60, wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+		{name: "float residue absorbed", draws: []string{"33.3", "33.3", "33.4"}, wantUsed: 33.4, wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+		{name: "default draw takes the remainder", draws: []string{"70", ""}, wantUsed: 30, wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+		{name: "draw above the remainder rejected", draws: []string{"70", "30.5"}, wantErr: "QuantityConsumed 30.50 exceeds the 30.00 kg remaining on input shipment 'SHIP-1'", wantQty: 30, wantStatus: model.StatusProcessed},
+		{name: "draw above the quantity rejected", draws: []string{"100.5"}, wantErr: "exceeds the 100.00 kg remaining", wantQty: 100, wantStatus: model.StatusProcessed},
+		{name: "negative draw rejected", draws: []string{"-5"}, wantErr: "QuantityConsumed cannot be negative", wantQty: 100, wantStatus: model.StatusProcessed},
+		{name: "draw from a consumed input rejected", draws: []string{"", "10"}, wantErr: "is not in a consumable state (current: CONSUMED_IN_PROCESSING)", wantQty: 0, wantStatus: model.StatusConsumedInProcessing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			var err error
+			for i, draw := range tt.draws {
+				input := `[{"shipmentId":"SHIP-1"}]`
+				if draw != "" {
+					input = `[{"shipmentId":"SHIP-1","quantityConsumed":` + draw + `}]`
+				}
+				products := `[{"newShipmentId":"JAM-` + strconv.Itoa(i+1) + `","productName":"Jam","quantity":10,"unitOfMeasure":"kg"}]`
+				err = e.cc.TransformAndCreateProducts(e.as(e.processor), input, products, e.processorData(nil), false)
+				if i < len(tt.draws)-1 {
+					e.must(err)
+				}
+			}
+			checkErr(t, err, tt.wantErr)
+
+			input := e.shipment("SHIP-1")
+			if math.Abs(input.Quantity-tt.wantQty) > 1e-9 || input.Status != tt.wantStatus {
+				t.Fatalf("input quantity %v, status %s; want %v, %s", input.Quantity, input.Status, tt.wantQty, tt.wantStatus)
+			}
+			if tt.wantStatus == model.StatusProcessed && input.CurrentOwnerID != e.processor.id {
+				t.Fatalf("input owner = %s, want the processor", input.CurrentOwnerID)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+
+			ev := e.stub.events[0] // The input's event precedes the product's
+			var payload map[string]interface{}
+			e.must(json.Unmarshal(ev.Payload, &payload))
+			fully := tt.wantStatus == model.StatusConsumedInProcessing
+			if ev.EventName != "InputShipmentConsumedInTransformation" || payload["consumedQuantity"] != tt.wantUsed ||
+				math.Abs(payload["remainingQuantity"].(float64)-tt.wantQty) > 1e-9 || payload["fullyConsumed"] != fully {
+				t.Fatalf("event %s = %v, want %v consumed", ev.EventName, payload, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestTransformPartialDrawRequiresOwnership(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.deliveredShipment("SHIP-1") // Owned by the retailer
+	products := `[{"newShipmentId":"JAM-1","productName":"Jam","quantity":10,"unitOfMeasure":"kg"}]`
+
+	err := e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"SHIP-1","quantityConsumed":40}]`, products, e.processorData(nil), false)
+	checkErr(t, err, "input shipment 'SHIP-1' can only be partially consumed by its current owner")
+	if input := e.shipment("SHIP-1"); input.Quantity != 100 || input.CurrentOwnerID != e.retailer.id {
+		t.Fatalf("rejected draw changed the input: quantity %v, owner %s", input.Quantity, input.CurrentOwnerID)
+	}
+
+	// Consuming the whole input still hands it to the processor.
+	e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"SHIP-1"}]`, products, e.processorData(nil), false))
+	if input := e.shipment("SHIP-1"); input.Status != model.StatusConsumedInProcessing || input.CurrentOwnerID != e.processor.id {
+		t.Fatalf("input status %s, owner %s; want consumed and owned by the processor", input.Status, input.CurrentOwnerID)
+	}
+}
+
+func TestDisposeShipments(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(e *testEnv) // SHIP-1 and SHIP-2 are created; leaves them in the state under test
+		caller       func(e *testEnv) *testIdentity
+		inputIDs     string
+		reason       string
+		wantErr      string
+		partial      bool // SHIP-1 is written before the failure; Fabric discards it with the transaction
+		wantDisposed []string
+	}{
+		{
+			name:         "dispose one input",
+			setup:        func(e *testEnv) { e.process("SHIP-1") },
+			inputIDs:     `["SHIP-1"]`,
+			reason:       "Mould found during sorting",
+			wantDisposed: []string{"SHIP-1"},
+		},
+		{
+			name:         "dispose several inputs",
+			setup:        func(e *testEnv) { e.process("SHIP-1"); e.process("SHIP-2") },
+			inputIDs:     `["SHIP-1","SHIP-2"]`,
+			reason:       "Cooling failure",
+			wantDisposed: []string{"SHIP-1", "SHIP-2"},
+		},
+		{
+			name: "recalled input rejected",
+			setup: func(e *testEnv) {
+				e.process("SHIP-1")
+				e.must(e.cc.InitiateRecall(e.as(e.processor), "SHIP-1", "RC-1", "Listeria detected"))
+			},
+			inputIDs: `["SHIP-1"]`,
+			reason:   "Spoiled",
+			wantErr:  "input shipment 'SHIP-1' is recalled – use the recall destruction flow instead",
+		},
+		{
+			name: "already disposed input rejected",
+			setup: func(e *testEnv) {
+				e.process("SHIP-1")
+				e.must(e.cc.DisposeShipments(e.as(e.processor), `["SHIP-1"]`, "Spoiled"))
+			},
+			inputIDs: `["SHIP-1"]`,
+			reason:   "Spoiled again",
+			wantErr:  "is not in a disposable state (current: CONSUMED_IN_PROCESSING)",
+		},
+		{name: "input owned by someone else rejected", inputIDs: `["SHIP-1"]`, reason: "Spoiled", wantErr: "is not the current owner of input shipment 'SHIP-1'"},
+		{name: "duplicate input rejected", setup: func(e *testEnv) { e.process("SHIP-1") }, inputIDs: `["SHIP-1","SHIP-1"]`, reason: "Spoiled", partial: true, wantErr: "input shipment 'SHIP-1' is listed more than once"},
+		{name: "unknown input rejected", inputIDs: `["SHIP-9"]`, reason: "Spoiled", wantErr: "failed to get input shipment 'SHIP-9'"},
+		{name: "empty input list rejected", inputIDs: `[]`, reason: "Spoiled", wantErr: "at least one input shipment must be specified"},
+		{name: "invalid JSON rejected", inputIDs: `"SHIP-1"`, reason: "Spoiled", wantErr: "invalid inputIDsJSON"},
+		{name: "missing reason rejected", setup: func(e *testEnv) { e.process("SHIP-1") }, inputIDs: `["SHIP-1"]`, wantErr: "reason"},
+		{
+			name:     "non-processor rejected",
+			setup:    func(e *testEnv) { e.process("SHIP-1") },
+			caller:   func(e *testEnv) *testIdentity { return e.distributor },
+			inputIDs: `["SHIP-1"]`,
+			reason:   "Spoiled",
+			wantErr:  "processor",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.createShipment("SHIP-2")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			caller := e.processor
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1")
+
+			checkErr(t, e.cc.DisposeShipments(e.as(caller), tt.inputIDs, tt.reason), tt.wantErr)
+			if tt.wantErr != "" {
+				if after := e.shipment("SHIP-1"); !tt.partial && (after.Status != before.Status || after.Quantity != before.Quantity) {
+					t.Fatalf("rejected disposal changed SHIP-1: status %s, quantity %v", after.Status, after.Quantity)
+				}
+				return
+			}
+
+			for _, id := range tt.wantDisposed {
+				shipment := e.shipment(id)
+				if shipment.Status != model.StatusConsumedInProcessing || shipment.Quantity != 0 {
+					t.Errorf("%s: status %s, quantity %v", id, shipment.Status, shipment.Quantity)
+				}
+				info := shipment.DisposalInfo
+				if info == nil || info.Reason != tt.reason || info.DisposedQuantity != 100 || info.DisposedBy != e.processor.id || !info.DisposedAt.Equal(e.now) {
+					t.Errorf("%s: disposalInfo = %+v", id, info)
+				}
+			}
+			if shipment := e.shipment("SHIP-2"); len(tt.wantDisposed) == 1 && shipment.DisposalInfo != nil {
+				t.Fatalf("SHIP-2 was disposed: %+v", shipment.DisposalInfo)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentsDisposed" || payload["reason"] != tt.reason || payload["disposedCount"] != float64(len(tt.wantDisposed)) {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+}
+
+func TestProcessingDateNotInFuture(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  time.Duration // dateProcessed relative to the transaction time
+		wantErr string
+	}{
+		{name: "an hour ago", offset: -time.Hour},
+		{name: "transaction time", offset: 0},
+		{name: "future within clock skew", offset: 4 * time.Minute},
+		{name: "at the clock skew limit", offset: processingDateClockSkew},
+		{name: "future beyond clock skew", offset: processingDateClockSkew + time.Second, wantErr: "processorData.dateProcessed"},
+		{name: "next day", offset: 24 * time.Hour, wantErr: "cannot be in the future"},
+	}
+	entryPoints := []struct {
+		name    string
+		outputs []string // shipments that exist only if processing succeeded
+		run     func(e *testEnv, dateProcessed func(txTime time.Time) string) error
+	}{
+		{name: "ProcessShipment", outputs: []string{"PULP-1"}, run: func(e *testEnv, dateProcessed func(time.Time) string) error {
+			ctx := e.as(e.processor)
+			return e.cc.ProcessShipment(ctx, "SHIP-1", e.processorData(map[string]interface{}{"dateProcessed": dateProcessed(e.now)}),
+				`[{"newShipmentId":"PULP-1","productName":"Pulp","quantity":5,"unitOfMeasure":"kg"}]`)
+		}},
+		{name: "TransformAndCreateProducts", outputs: []string{"JAM-1"}, run: func(e *testEnv, dateProcessed func(time.Time) string) error {
+			e.process("SHIP-1")
+			ctx := e.as(e.processor)
+			return e.cc.TransformAndCreateProducts(ctx, `[{"shipmentId":"SHIP-1"}]`,
+				`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`,
+				e.processorData(map[string]interface{}{"dateProcessed": dateProcessed(e.now)}), false)
+		}},
+	}
+	for _, entry := range entryPoints {
+		for _, tt := range tests {
+			t.Run(entry.name+"/"+tt.name, func(t *testing.T) {
+				e := newSupplyChainEnv(t)
+				e.createShipment("SHIP-1")
+				err := entry.run(e, func(txTime time.Time) string { return txTime.Add(tt.offset).Format(time.RFC3339) })
+				checkErr(t, err, tt.wantErr)
+				for _, id := range entry.outputs {
+					_, err := e.cc.getShipmentByID(e.inTx(e.admin), id)
+					if exists := err == nil; exists != (tt.wantErr == "") {
+						t.Fatalf("output %s exists = %t, want %t", id, exists, tt.wantErr == "")
+					}
+				}
+			})
+		}
+	}
+}
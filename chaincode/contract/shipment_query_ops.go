@@ -9,12 +9,15 @@ import (
 	"errors"
 	"fmt"
 	"foodtrace/model"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 )
 
 // --- Query Functions ---
@@ -47,8 +50,10 @@ func (s *FoodtraceSmartContract) getShipmentByID(ctx contractapi.TransactionCont
 	return &shipment, nil
 }
 
-func (s *FoodtraceSmartContract) GetShipmentPublicDetails(ctx contractapi.TransactionContextInterface, shipmentID string) (*model.Shipment, error) {
-	logger.Debugf("GetShipmentPublicDetails: Querying details for shipment '%s'", shipmentID)
+// GetShipmentPublicDetails returns a shipment with its most recent history entries. With skipHistory set the
+// history is left empty; use GetShipmentHistory to page through it instead.
+func (s *FoodtraceSmartContract) GetShipmentPublicDetails(ctx contractapi.TransactionContextInterface, shipmentID string, skipHistory bool) (*model.Shipment, error) {
+	logger.Debugf("GetShipmentPublicDetails: Querying details for shipment '%s' (skipHistory: %t)", shipmentID, skipHistory)
 	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
 		return nil, err
 	}
@@ -60,6 +65,10 @@ func (s *FoodtraceSmartContract) GetShipmentPublicDetails(ctx contractapi.Transa
 	}
 
 	s.enrichShipmentAliases(im, shipment)
+	if skipHistory {
+		shipment.History = []model.HistoryEntry{}
+		return shipment, nil
+	}
 
 	shipmentKey, keyErr := s.createShipmentCompositeKey(ctx, shipmentID)
 	if keyErr != nil {
@@ -83,33 +92,17 @@ func (s *FoodtraceSmartContract) GetShipmentPublicDetails(ctx contractapi.Transa
 					logger.Warningf("GetShipmentPublicDetails: Error iterating shipment history for '%s': %v. Skipping entry.", shipmentID, iterErr)
 					continue
 				}
-				var pastShipmentState model.Shipment
-				_ = json.Unmarshal(historyItem.Value, &pastShipmentState)
-
-				actorIDForHistory := pastShipmentState.CurrentOwnerID
-				actorAliasForHistory := pastShipmentState.CurrentOwnerAlias
-
-				if actorAliasForHistory == "" && actorIDForHistory != "" {
-					actorInfo, _ := im.GetIdentityInfo(actorIDForHistory)
-					if actorInfo != nil {
-						actorAliasForHistory = actorInfo.ShortName
-					}
-				}
-				action := string(pastShipmentState.Status)
-				if historyItem.IsDelete {
-					action = "DELETED"
-				}
-
-				entry := model.HistoryEntry{
-					TxID:       historyItem.TxId,
-					Timestamp:  historyItem.Timestamp.AsTime(),
-					IsDelete:   historyItem.IsDelete,
-					Value:      string(historyItem.Value),
-					ActorID:    actorIDForHistory,
-					ActorAlias: actorAliasForHistory,
-					Action:     action,
-				}
-				historyEntries = append(historyEntries, entry)
+				historyEntries = append(historyEntries, buildHistoryEntry(im, historyItem))
+			}
+			maxEntries, errCfg := getConfigFloat(ctx, configMaxHistoryEntries, defaultMaxHistoryEntries)
+			if errCfg != nil {
+				logger.Warningf("GetShipmentPublicDetails: Failed to read history cap: %v. Using default of %d.", errCfg, defaultMaxHistoryEntries)
+				maxEntries = defaultMaxHistoryEntries
+			}
+			if limit := int(maxEntries); len(historyEntries) > limit {
+				// Keep the most recent entries, still in ledger (chronological) order
+				historyEntries = historyEntries[len(historyEntries)-limit:]
+				shipment.HistoryTruncated = true
 			}
 			shipment.History = historyEntries // Will be [] if no history, not null
 		}
@@ -117,6 +110,95 @@ func (s *FoodtraceSmartContract) GetShipmentPublicDetails(ctx contractapi.Transa
 	return shipment, nil
 }
 
+// GetShipmentHistory returns one page of a shipment's history in ledger order. The bookmark is the
+// position of the next entry as returned in nextBookmark; leave it empty to start from the first entry.
+// Only entries on the requested page are decoded, but every entry is counted for totalEntries.
+func (s *FoodtraceSmartContract) GetShipmentHistory(ctx contractapi.TransactionContextInterface, shipmentID string, pageSizeStr string, bookmark string) (*model.PaginatedHistoryResponse, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	offset := 0
+	if strings.TrimSpace(bookmark) != "" {
+		parsed, err := strconv.Atoi(strings.TrimSpace(bookmark))
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid bookmark '%s': must be a value returned as nextBookmark", bookmark)
+		}
+		offset = parsed
+	}
+	pageSize := int(parsePageSize(ctx, pageSizeStr))
+
+	// Confirms the shipment exists; GetHistoryForKey alone cannot tell a missing key from an empty history.
+	if _, err := s.getShipmentByID(ctx, shipmentID); err != nil {
+		return nil, err
+	}
+	shipmentKey, err := s.createShipmentCompositeKey(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentHistory: failed to create key for shipment '%s': %w", shipmentID, err)
+	}
+	historyIter, err := ctx.GetStub().GetHistoryForKey(shipmentKey)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentHistory: failed to get history for shipment '%s': %w", shipmentID, err)
+	}
+	defer historyIter.Close()
+
+	im := NewIdentityManager(ctx)
+	entries := []model.HistoryEntry{}
+	position := 0
+	for historyIter.HasNext() {
+		historyItem, iterErr := historyIter.Next()
+		if iterErr != nil {
+			logger.Warningf("GetShipmentHistory: Error iterating shipment history for '%s': %v. Skipping entry.", shipmentID, iterErr)
+			continue
+		}
+		if position >= offset && len(entries) < pageSize {
+			entries = append(entries, buildHistoryEntry(im, historyItem))
+		}
+		position++
+	}
+
+	nextBookmark := ""
+	if next := offset + len(entries); next < position {
+		nextBookmark = strconv.Itoa(next)
+	}
+	return &model.PaginatedHistoryResponse{
+		Entries:      entries, // Will be [] if empty, not null
+		NextBookmark: nextBookmark,
+		FetchedCount: int32(len(entries)),
+		TotalEntries: position,
+	}, nil
+}
+
+// buildHistoryEntry converts a ledger modification of a shipment into a HistoryEntry, attributing it to the
+// owner recorded in that state.
+func buildHistoryEntry(im *IdentityManager, historyItem *queryresult.KeyModification) model.HistoryEntry {
+	var pastShipmentState model.Shipment
+	_ = json.Unmarshal(historyItem.Value, &pastShipmentState)
+
+	actorIDForHistory := pastShipmentState.CurrentOwnerID
+	actorAliasForHistory := pastShipmentState.CurrentOwnerAlias
+
+	if actorAliasForHistory == "" && actorIDForHistory != "" {
+		actorInfo, _ := im.GetIdentityInfo(actorIDForHistory)
+		if actorInfo != nil {
+			actorAliasForHistory = actorInfo.ShortName
+		}
+	}
+	action := string(pastShipmentState.Status)
+	if historyItem.IsDelete {
+		action = "DELETED"
+	}
+
+	return model.HistoryEntry{
+		TxID:       historyItem.TxId,
+		Timestamp:  historyItem.Timestamp.AsTime(),
+		IsDelete:   historyItem.IsDelete,
+		Value:      string(historyItem.Value),
+		ActorID:    actorIDForHistory,
+		ActorAlias: actorAliasForHistory,
+		Action:     action,
+	}
+}
+
 // Fix for GetMyShipments in shipment_query_ops.go
 func (s *FoodtraceSmartContract) GetMyShipments(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
 	actor, err := s.getCurrentActorInfo(ctx)
@@ -124,28 +206,58 @@ func (s *FoodtraceSmartContract) GetMyShipments(ctx contractapi.TransactionConte
 		return nil, fmt.Errorf("GetMyShipments: failed to get actor info: %w", err)
 	}
 
-	pageSize, err := strconv.ParseInt(pageSizeStr, 10, 32)
-	if err != nil || pageSize <= 0 {
-		logger.Warningf("GetMyShipments: Invalid pageSize '%s', using default of 10. Error: %v", pageSizeStr, err)
-		pageSize = 10
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetMyShipments: Getting non-archived shipments for current owner: %s (alias: %s) with pageSize: %d, bookmark: '%s'", actor.fullID, actor.alias, pageSize, bookmark)
+	return s.queryShipmentsByOwner(ctx, "GetMyShipments", actor.fullID, pageSize, bookmark)
+}
+
+// GetShipmentsByOwner returns the non-archived shipments currently owned by the given identity. Admin only.
+func (s *FoodtraceSmartContract) GetShipmentsByOwner(ctx contractapi.TransactionContextInterface, ownerIdentityOrAlias string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsByOwner: %w", err)
+	}
+	if err := s.validateRequiredString(ownerIdentityOrAlias, "ownerIdentityOrAlias", maxStringInputLength); err != nil {
+		return nil, err
 	}
-	if pageSize > 100 {
-		logger.Warningf("GetMyShipments: Requested pageSize %d exceeds max of 100. Capping at 100.", pageSize)
-		pageSize = 100
+	ownerFullID, err := im.ResolveIdentity(ownerIdentityOrAlias)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByOwner: failed to resolve owner '%s': %w", ownerIdentityOrAlias, err)
 	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
 
-	logger.Infof("GetMyShipments: Getting non-archived shipments for current owner: %s (alias: %s) with pageSize: %d, bookmark: '%s'", actor.fullID, actor.alias, pageSize, bookmark)
+	logger.Infof("GetShipmentsByOwner: Getting non-archived shipments for owner: %s with pageSize: %d, bookmark: '%s'", ownerFullID, pageSize, bookmark)
+	return s.queryShipmentsByOwner(ctx, "GetShipmentsByOwner", ownerFullID, pageSize, bookmark)
+}
+
+// queryShipmentsByOwner pages through the non-archived, non-voided shipments owned by ownerFullID, falling
+// back to a LevelDB scan when the CouchDB query is unavailable.
+func (s *FoodtraceSmartContract) queryShipmentsByOwner(ctx contractapi.TransactionContextInterface, fnName string, ownerFullID string, pageSize int32, bookmark string) (*model.PaginatedShipmentResponse, error) {
 	im := NewIdentityManager(ctx)
 
-	queryString := fmt.Sprintf(`{"selector":{"objectType":"%s", "currentOwnerId":"%s", "isArchived":false}, "use_index":"_design/indexObjectTypeOwnerIsArchivedDoc"}`, shipmentObjectType, actor.fullID)
+	// Marshalled rather than formatted: ownerFullID may come from caller input.
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType":     shipmentObjectType,
+			"currentOwnerId": ownerFullID,
+			"isArchived":     false,
+			"status":         map[string]interface{}{"$ne": model.StatusVoided},
+		},
+		"use_index": "_design/indexObjectTypeOwnerIsArchivedDoc",
+	}
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to marshal query: %w", fnName, err)
+	}
 
-	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryBytes), int32(pageSize), bookmark)
 	if err != nil {
-		logger.Warningf("GetMyShipments: CouchDB GetQueryResultWithPagination for user '%s' failed: %v. Falling back to full scan (SLOW).", actor.fullID, err)
+		logger.Warningf("%s: CouchDB GetQueryResultWithPagination for user '%s' failed: %v. Falling back to full scan (SLOW).", fnName, ownerFullID, err)
 
 		allResultsIterator, metadataFallback, errScan := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(shipmentObjectType, []string{}, int32(pageSize), bookmark)
 		if errScan != nil {
-			return nil, fmt.Errorf("GetMyShipments: CouchDB query failed (%v) and LevelDB paginated scan also failed (%w)", err, errScan)
+			return nil, fmt.Errorf("%s: CouchDB query failed (%v) and LevelDB paginated scan also failed (%w)", fnName, err, errScan)
 		}
 		defer allResultsIterator.Close()
 
@@ -156,17 +268,17 @@ func (s *FoodtraceSmartContract) GetMyShipments(ctx contractapi.TransactionConte
 		for allResultsIterator.HasNext() {
 			queryResponse, iterErr := allResultsIterator.Next()
 			if iterErr != nil {
-				logger.Warningf("GetMyShipments fallback: Error iterating results: %v. Skipping.", iterErr)
+				logger.Warningf("%s fallback: Error iterating results: %v. Skipping.", fnName, iterErr)
 				continue
 			}
 
 			var ship model.Shipment
 			if err := json.Unmarshal(queryResponse.Value, &ship); err != nil {
-				logger.Warningf("GetMyShipments fallback: Error unmarshalling shipment: %v. Skipping.", err)
+				logger.Warningf("%s fallback: Error unmarshalling shipment: %v. Skipping.", fnName, err)
 				continue
 			}
 
-			if ship.CurrentOwnerID == actor.fullID && !ship.IsArchived {
+			if ship.CurrentOwnerID == ownerFullID && !ship.IsArchived && ship.Status != model.StatusVoided {
 				ensureShipmentSchemaCompliance(&ship)
 				s.enrichShipmentAliases(im, &ship)
 				ship.History = []model.HistoryEntry{} // FIXED: Initialize as empty slice
@@ -179,7 +291,7 @@ func (s *FoodtraceSmartContract) GetMyShipments(ctx contractapi.TransactionConte
 			Shipments:    myFilteredShipments, // Will be [] if empty, not null
 			NextBookmark: metadataFallback.GetBookmark(),
 			FetchedCount: actualFetchedCount,
-		}, errors.New("GetMyShipments: Fallback logic triggered, potentially incomplete or slow results. Ensure CouchDB index 'indexObjectTypeOwnerIsArchivedDoc' exists")
+		}, fmt.Errorf("%s: Fallback logic triggered, potentially incomplete or slow results. Ensure CouchDB index 'indexObjectTypeOwnerIsArchivedDoc' exists", fnName)
 	}
 	defer resultsIterator.Close()
 
@@ -187,154 +299,1012 @@ func (s *FoodtraceSmartContract) GetMyShipments(ctx contractapi.TransactionConte
 	var fetchedCountCouchDB int32 = 0
 
 	for resultsIterator.HasNext() {
-		queryResponse, iterErr := resultsIterator.Next()
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("%s: Error iterating CouchDB results: %v. Skipping.", fnName, iterErr)
+			continue
+		}
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("%s: Error unmarshalling shipment: %v. Skipping.", fnName, errUnmarshal)
+			continue
+		}
+		ensureShipmentSchemaCompliance(&ship)
+		s.enrichShipmentAliases(im, &ship)
+		ship.History = []model.HistoryEntry{}
+		shipmentsFromQuery = append(shipmentsFromQuery, &ship)
+		fetchedCountCouchDB++
+	}
+
+	logger.Infof("%s (CouchDB): Found %d non-archived shipments for user '%s' on this page.", fnName, fetchedCountCouchDB, ownerFullID)
+	return &model.PaginatedShipmentResponse{
+		Shipments:    shipmentsFromQuery, // Will be [] if empty, not null
+		NextBookmark: metadata.GetBookmark(),
+		FetchedCount: fetchedCountCouchDB,
+	}, nil
+}
+
+// Fix for GetAllShipments in shipment_query_ops.go
+func (s *FoodtraceSmartContract) GetAllShipments(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	pageSize := parsePageSize(ctx, pageSizeStr)
+	logger.Infof("GetAllShipments: Admin getting all non-archived shipments (pageSize: %d, bookmark: '%s')", pageSize, bookmark)
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(shipmentObjectType, []string{}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("GetAllShipments: failed to get shipments iterator: %w", err)
+	}
+	defer resultsIterator.Close()
+
+	shipments := []*model.Shipment{}
+	fetchedCount := int32(0)
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("GetAllShipments: Error iterating results: %v. Skipping.", iterErr)
+			continue
+		}
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("GetAllShipments: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+			continue
+		}
+		if !ship.IsArchived && ship.Status != model.StatusVoided {
+			ensureShipmentSchemaCompliance(&ship)
+			s.enrichShipmentAliases(im, &ship)
+			ship.History = []model.HistoryEntry{}
+			shipments = append(shipments, &ship)
+			fetchedCount++
+		}
+	}
+
+	logger.Infof("GetAllShipments: Retrieved %d non-archived shipments for this page.", fetchedCount)
+	return &model.PaginatedShipmentResponse{
+		Shipments:    shipments, // Will be [] if empty, not null
+		NextBookmark: metadata.GetBookmark(),
+		FetchedCount: fetchedCount,
+	}, nil
+}
+
+// Fix for GetShipmentsByStatus in shipment_query_ops.go
+func (s *FoodtraceSmartContract) GetShipmentsByStatus(ctx contractapi.TransactionContextInterface, statusToQuery string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	logger.Infof("GetShipmentsByStatus: Querying shipments with status '%s', pageSize: '%s', bookmark: '%s'", statusToQuery, pageSizeStr, bookmark)
+	targetStatus, err := parseShipmentStatus(statusToQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	im := NewIdentityManager(ctx)
+
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	queryString := fmt.Sprintf(`{"selector":{"objectType":"%s", "status":"%s", "isArchived":false}, "use_index":"_design/indexObjectTypeStatusIsArchivedDoc"}`, shipmentObjectType, targetStatus)
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByStatus: CouchDB query failed for status '%s': %w. Ensure index 'indexObjectTypeStatusIsArchivedDoc' exists", targetStatus, err)
+	}
+	defer resultsIterator.Close()
+
+	shipmentsFromQuery := []*model.Shipment{}
+	fetchedCountCouchDB := int32(0)
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("GetShipmentsByStatus: Error iterating CouchDB results: %v. Skipping.", iterErr)
+			continue
+		}
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("GetShipmentsByStatus: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+			continue
+		}
+		ensureShipmentSchemaCompliance(&ship)
+		s.enrichShipmentAliases(im, &ship)
+		ship.History = []model.HistoryEntry{} // FIXED: Initialize as empty slice
+		shipmentsFromQuery = append(shipmentsFromQuery, &ship)
+		fetchedCountCouchDB++
+	}
+
+	logger.Infof("GetShipmentsByStatus (CouchDB): Found %d non-archived shipments with status '%s' on this page.", fetchedCountCouchDB, targetStatus)
+	return &model.PaginatedShipmentResponse{
+		Shipments:    shipmentsFromQuery, // Will be [] if empty, not null
+		NextBookmark: metadata.GetBookmark(),
+		FetchedCount: fetchedCountCouchDB,
+	}, nil
+}
+
+// GetMyShipmentsByStatus returns the caller's non-archived shipments in the given status using a single CouchDB query.
+func (s *FoodtraceSmartContract) GetMyShipmentsByStatus(ctx contractapi.TransactionContextInterface, statusToQuery string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetMyShipmentsByStatus: failed to get actor info: %w", err)
+	}
+	targetStatus, err := parseShipmentStatus(statusToQuery)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetMyShipmentsByStatus: Getting shipments for '%s' (alias: %s) with status '%s' (pageSize: %d, bookmark: '%s')", actor.fullID, actor.alias, targetStatus, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":     shipmentObjectType,
+		"currentOwnerId": actor.fullID,
+		"status":         targetStatus,
+		"isArchived":     false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetMyShipmentsByStatus", selector, "indexObjectTypeOwnerStatusIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByTemperatureRange returns non-archived shipments whose distributor declared the given temperature range (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByTemperatureRange(ctx contractapi.TransactionContextInterface, rangeValue string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(rangeValue, "rangeValue", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByTemperatureRange: Getting shipments with temperatureRange '%s' (pageSize: %d, bookmark: '%s')", rangeValue, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                       shipmentObjectType,
+		"distributorData.temperatureRange": rangeValue,
+		"isArchived":                       false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByTemperatureRange", selector, "indexObjectTypeTemperatureRangeIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentDiff compares the shipment state written by txID1 with the state written by txID2.
+// Top-level fields are compared directly; nested objects (e.g. farmerData) are compared one level deep
+// and reported as "parent.child". Each change is returned as {"from": ..., "to": ...}.
+func (s *FoodtraceSmartContract) GetShipmentDiff(ctx contractapi.TransactionContextInterface, shipmentID string, txID1 string, txID2 string) (map[string]interface{}, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	if err := s.validateRequiredString(txID1, "txID1", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	if err := s.validateRequiredString(txID2, "txID2", maxStringInputLength); err != nil {
+		return nil, err
+	}
+
+	shipmentKey, err := s.createShipmentCompositeKey(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentDiff: failed to create composite key for shipment '%s': %w", shipmentID, err)
+	}
+	historyIter, err := ctx.GetStub().GetHistoryForKey(shipmentKey)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentDiff: failed to get history for shipment '%s': %w", shipmentID, err)
+	}
+	defer historyIter.Close()
+
+	versions := make(map[string]map[string]interface{})
+	timestamps := make(map[string]time.Time)
+	for historyIter.HasNext() {
+		historyItem, iterErr := historyIter.Next()
+		if iterErr != nil {
+			logger.Warningf("GetShipmentDiff: Error iterating shipment history for '%s': %v. Skipping entry.", shipmentID, iterErr)
+			continue
+		}
+		if historyItem.TxId != txID1 && historyItem.TxId != txID2 {
+			continue
+		}
+		state := map[string]interface{}{}
+		if !historyItem.IsDelete && len(historyItem.Value) > 0 {
+			if errUnmarshal := json.Unmarshal(historyItem.Value, &state); errUnmarshal != nil {
+				return nil, fmt.Errorf("GetShipmentDiff: failed to unmarshal shipment '%s' at tx '%s': %w", shipmentID, historyItem.TxId, errUnmarshal)
+			}
+		}
+		versions[historyItem.TxId] = state
+		timestamps[historyItem.TxId] = historyItem.Timestamp.AsTime()
+	}
+	for _, txID := range []string{txID1, txID2} {
+		if _, found := versions[txID]; !found {
+			return nil, fmt.Errorf("GetShipmentDiff: transaction '%s' not found in history of shipment '%s'", txID, shipmentID)
+		}
+	}
+
+	changes := diffShipmentStates(versions[txID1], versions[txID2])
+	return map[string]interface{}{
+		"shipmentId":   shipmentID,
+		"txId1":        txID1,
+		"txId2":        txID2,
+		"timestamp1":   timestamps[txID1],
+		"timestamp2":   timestamps[txID2],
+		"changedCount": len(changes),
+		"changes":      changes,
+	}, nil
+}
+
+// diffShipmentStates returns the fields that differ between two JSON-decoded shipment states.
+func diffShipmentStates(from, to map[string]interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	for k := range keys {
+		if k == "history" {
+			continue
+		}
+		fromVal, toVal := from[k], to[k]
+		if reflect.DeepEqual(fromVal, toVal) {
+			continue
+		}
+		fromMap, fromIsMap := fromVal.(map[string]interface{})
+		toMap, toIsMap := toVal.(map[string]interface{})
+		if (fromIsMap || fromVal == nil) && (toIsMap || toVal == nil) {
+			subKeys := make(map[string]bool)
+			for sk := range fromMap {
+				subKeys[sk] = true
+			}
+			for sk := range toMap {
+				subKeys[sk] = true
+			}
+			for sk := range subKeys {
+				if !reflect.DeepEqual(fromMap[sk], toMap[sk]) {
+					changes[k+"."+sk] = map[string]interface{}{"from": fromMap[sk], "to": toMap[sk]}
+				}
+			}
+			continue
+		}
+		changes[k] = map[string]interface{}{"from": fromVal, "to": toVal}
+	}
+	return changes
+}
+
+// GetShipmentsByProcessingType returns non-archived shipments processed with the given processing type (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByProcessingType(ctx contractapi.TransactionContextInterface, processingType string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(processingType, "processingType", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByProcessingType: Getting shipments with processingType '%s' (pageSize: %d, bookmark: '%s')", processingType, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                   shipmentObjectType,
+		"processorData.processingType": processingType,
+		"isArchived":                   false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByProcessingType", selector, "indexObjectTypeProcessingTypeIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByIrrigationMethod returns non-archived shipments whose farmer declared the given irrigation method (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByIrrigationMethod(ctx contractapi.TransactionContextInterface, method string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(method, "method", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByIrrigationMethod: Getting shipments with irrigationMethod '%s' (pageSize: %d, bookmark: '%s')", method, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                  shipmentObjectType,
+		"farmerData.irrigationMethod": method,
+		"isArchived":                  false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByIrrigationMethod", selector, "indexObjectTypeIrrigationMethodIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetMyDesignatedCertifications returns the caller's certification queue: non-archived shipments
+// designated to the calling certifier that are still awaiting a decision (CREATED or PENDING_CERTIFICATION).
+func (s *FoodtraceSmartContract) GetMyDesignatedCertifications(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetMyDesignatedCertifications: failed to get actor info: %w", err)
+	}
+	if err := NewIdentityManager(ctx).RequireRole("certifier"); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetMyDesignatedCertifications: Getting designated shipments for certifier '%s' (alias: %s) (pageSize: %d, bookmark: '%s')", actor.fullID, actor.alias, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":            shipmentObjectType,
+		"designatedCertifierId": actor.fullID,
+		"isArchived":            false,
+		"status": map[string]interface{}{
+			"$in": []model.ShipmentStatus{model.StatusCreated, model.StatusPendingCertification},
+		},
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetMyDesignatedCertifications", selector, "indexObjectTypeDesignatedCertifierIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsDestinedForMe returns distributed shipments whose distributor designated the calling
+// retailer as destination, i.e. incoming shipments the caller can receive.
+func (s *FoodtraceSmartContract) GetShipmentsDestinedForMe(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsDestinedForMe: failed to get actor info: %w", err)
+	}
+	if err := NewIdentityManager(ctx).RequireRole("retailer"); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsDestinedForMe: Getting incoming shipments for retailer '%s' (alias: %s) (pageSize: %d, bookmark: '%s')", actor.fullID, actor.alias, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                            shipmentObjectType,
+		"distributorData.destinationRetailerId": actor.fullID,
+		"status":                                model.StatusDistributed,
+		"isArchived":                            false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsDestinedForMe", selector, "indexObjectTypeDestinationRetailerStatusIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByFarmLocation returns non-archived shipments whose farmer declared the given farm location (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByFarmLocation(ctx contractapi.TransactionContextInterface, location string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(location, "location", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByFarmLocation: Getting shipments with farmLocation '%s' (pageSize: %d, bookmark: '%s')", location, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":              shipmentObjectType,
+		"farmerData.farmLocation": location,
+		"isArchived":              false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByFarmLocation", selector, "indexObjectTypeFarmLocationIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByBedType returns non-archived shipments grown in the given bed type (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByBedType(ctx contractapi.TransactionContextInterface, bedType string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(bedType, "bedType", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByBedType: Getting shipments with bedType '%s' (pageSize: %d, bookmark: '%s')", bedType, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":         shipmentObjectType,
+		"farmerData.bedType": bedType,
+		"isArchived":         false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByBedType", selector, "indexObjectTypeBedTypeIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByOrganicSinceBefore returns non-archived shipments from farms organic since dateStr
+// (RFC3339) or earlier. Shipments without an organicSince date are excluded. Dates are compared as
+// stored RFC3339 strings, so submitting them in UTC keeps the comparison exact.
+func (s *FoodtraceSmartContract) GetShipmentsByOrganicSinceBefore(ctx contractapi.TransactionContextInterface, dateStr string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	cutoff, err := parseDateString(dateStr, "dateStr", true)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByOrganicSinceBefore: Getting shipments organic since on or before '%s' (pageSize: %d, bookmark: '%s')", cutoff.Format(time.RFC3339), pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType": shipmentObjectType,
+		"farmerData.organicSince": map[string]interface{}{
+			"$gt":  time.Time{}.Format(time.RFC3339),
+			"$lte": cutoff.UTC().Format(time.RFC3339),
+		},
+		"isArchived": false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByOrganicSinceBefore", selector, "indexObjectTypeOrganicSinceIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByBreachCountRange returns non-archived, distributed shipments whose distributorData.breachCount
+// lies between minStr and maxStr inclusive. Shipments that never reached a distributor have no cold chain to
+// rate and are left out of every bucket.
+func (s *FoodtraceSmartContract) GetShipmentsByBreachCountRange(ctx contractapi.TransactionContextInterface, minStr string, maxStr string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	minBreaches, err := strconv.Atoi(strings.TrimSpace(minStr))
+	if err != nil || minBreaches < 0 {
+		return nil, fmt.Errorf("invalid min '%s': must be a non-negative integer", minStr)
+	}
+	maxBreaches, err := strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil || maxBreaches < 0 {
+		return nil, fmt.Errorf("invalid max '%s': must be a non-negative integer", maxStr)
+	}
+	if minBreaches > maxBreaches {
+		return nil, fmt.Errorf("min (%d) cannot be greater than max (%d)", minBreaches, maxBreaches)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByBreachCountRange: Getting shipments with %d-%d breaches (pageSize: %d, bookmark: '%s')", minBreaches, maxBreaches, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType": shipmentObjectType,
+		"distributorData.breachCount": map[string]interface{}{
+			"$gte": minBreaches,
+			"$lte": maxBreaches,
+		},
+		"distributorData.distributorId": map[string]interface{}{"$gt": ""},
+		"isArchived":                    false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByBreachCountRange", selector, "indexObjectTypeBreachCountIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByMinOrganicYears returns shipments from farms that have been organic for at least minYearsStr
+// whole years as of the transaction time, computed from farmerData.organicSince. Shipments without an
+// organicSince date are excluded. Admin only; scans one page of shipments per call.
+func (s *FoodtraceSmartContract) GetShipmentsByMinOrganicYears(ctx contractapi.TransactionContextInterface, minYearsStr string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsByMinOrganicYears: %w", err)
+	}
+	minYears, err := strconv.Atoi(strings.TrimSpace(minYearsStr))
+	if err != nil || minYears < 0 {
+		return nil, fmt.Errorf("invalid minYears '%s': must be a non-negative integer", minYearsStr)
+	}
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByMinOrganicYears: failed to get transaction timestamp: %w", err)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByMinOrganicYears: Scanning for shipments organic for at least %d years (pageSize: %d, bookmark: '%s')", minYears, pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsByMinOrganicYears", pageSize, bookmark, func(ship *model.Shipment) bool {
+		organicSince := ship.FarmerData.OrganicSince
+		return !organicSince.IsZero() && !organicSince.AddDate(minYears, 0, 0).After(now)
+	})
+}
+
+// GetShipmentsByRecallInitiator returns every shipment, archived or not, whose recall was initiated
+// by the given identity (admin only).
+func (s *FoodtraceSmartContract) GetShipmentsByRecallInitiator(ctx contractapi.TransactionContextInterface, identityOrAlias string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsByRecallInitiator: %w", err)
+	}
+	if err := s.validateRequiredString(identityOrAlias, "identityOrAlias", maxStringInputLength*2); err != nil {
+		return nil, err
+	}
+	initiatorID, err := im.ResolveIdentity(identityOrAlias)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByRecallInitiator: failed to resolve '%s': %w", identityOrAlias, err)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByRecallInitiator: Getting shipments recalled by '%s' (pageSize: %d, bookmark: '%s')", initiatorID, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":            shipmentObjectType,
+		"recallInfo.recalledBy": initiatorID,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByRecallInitiator", selector, "indexObjectTypeRecalledByDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByCreatorMSP returns non-archived shipments created by identities of the given MSP (admin only).
+// Shipments created before CreatedByMSP was recorded are not matched.
+func (s *FoodtraceSmartContract) GetShipmentsByCreatorMSP(ctx contractapi.TransactionContextInterface, mspID string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsByCreatorMSP: %w", err)
+	}
+	if err := s.validateRequiredString(mspID, "mspID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByCreatorMSP: Getting shipments created by MSP '%s' (pageSize: %d, bookmark: '%s')", mspID, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":   shipmentObjectType,
+		"createdByMsp": strings.TrimSpace(mspID),
+		"isArchived":   false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByCreatorMSP", selector, "indexObjectTypeCreatedByMspIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByFarmingPractice returns non-archived shipments grown with the given farming practice (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByFarmingPractice(ctx contractapi.TransactionContextInterface, practice string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(practice, "practice", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByFarmingPractice: Getting shipments with farmingPractice '%s' (pageSize: %d, bookmark: '%s')", practice, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                 shipmentObjectType,
+		"farmerData.farmingPractice": practice,
+		"isArchived":                 false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByFarmingPractice", selector, "indexObjectTypeFarmingPracticeIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByRetailerLine returns non-archived shipments on the given retailer line (exact match).
+func (s *FoodtraceSmartContract) GetShipmentsByRetailerLine(ctx contractapi.TransactionContextInterface, retailerLineID string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	if err := s.validateRequiredString(retailerLineID, "retailerLineID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByRetailerLine: Getting shipments with retailerLineId '%s' (pageSize: %d, bookmark: '%s')", retailerLineID, pageSize, bookmark)
+	selector := map[string]interface{}{
+		"objectType":                  shipmentObjectType,
+		"retailerData.retailerLineId": retailerLineID,
+		"isArchived":                  false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByRetailerLine", selector, "indexObjectTypeRetailerLineIsArchivedDoc", pageSize, bookmark)
+}
+
+// GetShipmentsByCertificationStatus returns non-archived shipments whose most recent certification
+// record has the given status (APPROVED, REJECTED or PENDING). The latest record is not indexable,
+// so each page scans up to pageSize shipments and returns only the matches; keep paging with the
+// returned bookmark until it is empty.
+func (s *FoodtraceSmartContract) GetShipmentsByCertificationStatus(ctx contractapi.TransactionContextInterface, status string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	targetStatus := model.CertificationStatus(strings.ToUpper(strings.TrimSpace(status)))
+	switch targetStatus {
+	case model.CertStatusApproved, model.CertStatusRejected, model.CertStatusPending:
+	default:
+		return nil, fmt.Errorf("invalid certification status '%s': must be one of %s, %s, %s", status, model.CertStatusApproved, model.CertStatusRejected, model.CertStatusPending)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByCertificationStatus: Scanning for latest certification status '%s' (pageSize: %d, bookmark: '%s')", targetStatus, pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsByCertificationStatus", pageSize, bookmark, func(ship *model.Shipment) bool {
+		if len(ship.CertificationRecords) == 0 {
+			return false
+		}
+		return ship.CertificationRecords[len(ship.CertificationRecords)-1].Status == targetStatus
+	})
+}
+
+// GetShipmentsByParticipant returns non-archived shipments an identity took part in at any stage: as
+// farmer, processor, distributor, retailer, certifier or current owner. The stage fields cannot be
+// OR-ed efficiently in CouchDB, so each page scans pageSize shipments. Admin, or the identity itself.
+func (s *FoodtraceSmartContract) GetShipmentsByParticipant(ctx contractapi.TransactionContextInterface, identityOrAlias string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByParticipant: failed to get actor info: %w", err)
+	}
+	if err := s.validateRequiredString(identityOrAlias, "identityOrAlias", maxStringInputLength*2); err != nil {
+		return nil, err
+	}
+	im := NewIdentityManager(ctx)
+	participantID, err := im.ResolveIdentity(identityOrAlias)
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsByParticipant: failed to resolve '%s': %w", identityOrAlias, err)
+	}
+	if participantID != actor.fullID {
+		if err := s.requireAdmin(ctx, im); err != nil {
+			return nil, fmt.Errorf("GetShipmentsByParticipant: only admin can query another identity's shipments: %w", err)
+		}
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByParticipant: Scanning for shipments involving '%s' (pageSize: %d, bookmark: '%s')", participantID, pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsByParticipant", pageSize, bookmark, func(ship *model.Shipment) bool {
+		if ship.CurrentOwnerID == participantID ||
+			ship.FarmerData.FarmerID == participantID ||
+			ship.ProcessorData.ProcessorID == participantID ||
+			ship.DistributorData.DistributorID == participantID ||
+			ship.RetailerData.RetailerID == participantID {
+			return true
+		}
+		for _, record := range ship.CertificationRecords {
+			if record.CertifierID == participantID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// GetShipmentsByCertificationComment returns shipments with a certification record whose comments contain
+// keyword (case-insensitive). Admin only; scans one page of shipments per call.
+func (s *FoodtraceSmartContract) GetShipmentsByCertificationComment(ctx contractapi.TransactionContextInterface, keyword string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsByCertificationComment: %w", err)
+	}
+	if err := s.validateRequiredString(keyword, "keyword", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	needle := strings.ToLower(strings.TrimSpace(keyword))
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByCertificationComment: Scanning for certification comments containing '%s' (pageSize: %d, bookmark: '%s')", keyword, pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsByCertificationComment", pageSize, bookmark, func(ship *model.Shipment) bool {
+		for _, record := range ship.CertificationRecords {
+			if strings.Contains(strings.ToLower(record.Comments), needle) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// GetShipmentsWithOverrides returns shipments carrying at least one admin override record, for
+// governance review of admin corrections. Admin only; scans one page of shipments per call.
+func (s *FoodtraceSmartContract) GetShipmentsWithOverrides(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsWithOverrides: %w", err)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsWithOverrides: Scanning for shipments with admin overrides (pageSize: %d, bookmark: '%s')", pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsWithOverrides", pageSize, bookmark, func(ship *model.Shipment) bool {
+		return len(ship.AdminOverrides) > 0
+	})
+}
+
+// GetShipmentsOwnedByInactive returns shipments whose current owner has been deactivated, so an admin
+// can reassign them. Admin only; scans one page of shipments per call.
+func (s *FoodtraceSmartContract) GetShipmentsOwnedByInactive(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsOwnedByInactive: %w", err)
+	}
+	inactiveIdentities, err := im.GetInactiveIdentities()
+	if err != nil {
+		return nil, fmt.Errorf("GetShipmentsOwnedByInactive: failed to list inactive identities: %w", err)
+	}
+	inactiveIDs := make(map[string]bool, len(inactiveIdentities))
+	for _, idInfo := range inactiveIdentities {
+		inactiveIDs[idInfo.FullID] = true
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsOwnedByInactive: Scanning for shipments owned by %d inactive identities (pageSize: %d, bookmark: '%s')", len(inactiveIDs), pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsOwnedByInactive", pageSize, bookmark, func(ship *model.Shipment) bool {
+		return inactiveIDs[ship.CurrentOwnerID]
+	})
+}
+
+// GetShipmentsMissingDestination returns shipments with no next-stage destination recorded for their
+// status, so operations can fix them. Admin only; scans one page of shipments per call.
+func (s *FoodtraceSmartContract) GetShipmentsMissingDestination(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetShipmentsMissingDestination: %w", err)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsMissingDestination: Scanning for shipments without a next-stage destination (pageSize: %d, bookmark: '%s')", pageSize, bookmark)
+	return s.scanShipmentsWithPagination(ctx, "GetShipmentsMissingDestination", pageSize, bookmark, func(ship *model.Shipment) bool {
+		return missingDestinationField(ship) != ""
+	})
+}
+
+// GetProductsConsumingInput returns every shipment (archived or not) that lists inputShipmentID among its
+// InputShipmentIDs: transformation outputs, byproducts and retail units one level downstream.
+func (s *FoodtraceSmartContract) GetProductsConsumingInput(ctx contractapi.TransactionContextInterface, inputShipmentID string) ([]*model.Shipment, error) {
+	if err := s.validateRequiredString(inputShipmentID, "inputShipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType":       shipmentObjectType,
+			"inputShipmentIds": map[string]interface{}{"$elemMatch": map[string]interface{}{"$eq": inputShipmentID}},
+		},
+		"use_index": "_design/indexObjectTypeInputShipmentIdsDoc",
+	}
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("GetProductsConsumingInput: failed to marshal query: %w", err)
+	}
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		return nil, fmt.Errorf("GetProductsConsumingInput: CouchDB query failed: %w. Ensure index 'indexObjectTypeInputShipmentIds' exists", err)
+	}
+	defer resultsIterator.Close()
+
+	products, err := s.processShipmentIterator(ctx, resultsIterator, true)
+	if err != nil {
+		return nil, fmt.Errorf("GetProductsConsumingInput: %w", err)
+	}
+	logger.Infof("GetProductsConsumingInput: Found %d products consuming input '%s'", len(products), inputShipmentID)
+	return products, nil
+}
+
+// GetLineageCertifications returns the certification records of a shipment and every ancestor reachable
+// through InputShipmentIDs, deduplicated. Ancestors deeper than maxLineageDepth are not followed, and
+// ancestors that cannot be read are skipped.
+func (s *FoodtraceSmartContract) GetLineageCertifications(ctx contractapi.TransactionContextInterface, shipmentID string) ([]model.CertificationRecord, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	root, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, fmt.Errorf("GetLineageCertifications: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+
+	records := []model.CertificationRecord{}
+	seenRecords := make(map[string]bool)
+	visited := map[string]bool{root.ID: true}
+	current := []*model.Shipment{root}
+	for depth := 0; len(current) > 0; depth++ {
+		var next []*model.Shipment
+		for _, ship := range current {
+			s.enrichShipmentAliases(im, ship)
+			for _, record := range ship.CertificationRecords {
+				recordKey := fmt.Sprintf("%s|%s|%s|%s|%s", record.CertifierID, record.InspectionDate.Format(time.RFC3339Nano),
+					record.InspectionReportHash, record.Status, record.CertifiedAt.Format(time.RFC3339Nano))
+				if seenRecords[recordKey] {
+					continue
+				}
+				seenRecords[recordKey] = true
+				records = append(records, record)
+			}
+			if depth >= maxLineageDepth {
+				if len(ship.InputShipmentIDs) > 0 {
+					logger.Warningf("GetLineageCertifications: lineage of '%s' exceeds depth %d; inputs of '%s' omitted", shipmentID, maxLineageDepth, ship.ID)
+				}
+				continue
+			}
+			for _, inputID := range ship.InputShipmentIDs {
+				if visited[inputID] {
+					continue
+				}
+				visited[inputID] = true
+				input, errInput := s.getShipmentByID(ctx, inputID)
+				if errInput != nil {
+					logger.Warningf("GetLineageCertifications: skipping input '%s' of '%s': %v", inputID, ship.ID, errInput)
+					continue
+				}
+				next = append(next, input)
+			}
+		}
+		current = next
+	}
+	return records, nil
+}
+
+// GetShipmentsByDateRange returns non-archived shipments created between startRFC3339 and endRFC3339
+// inclusive. The window may span at most maxTimeSeriesDays days.
+func (s *FoodtraceSmartContract) GetShipmentsByDateRange(ctx contractapi.TransactionContextInterface, startRFC3339 string, endRFC3339 string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	start, err := parseDateString(startRFC3339, "startRFC3339", true)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDateString(endRFC3339, "endRFC3339", true)
+	if err != nil {
+		return nil, err
+	}
+	if start.After(end) {
+		return nil, fmt.Errorf("startRFC3339 (%s) cannot be after endRFC3339 (%s)", startRFC3339, endRFC3339)
+	}
+	if end.Sub(start) > time.Duration(maxTimeSeriesDays)*24*time.Hour {
+		return nil, fmt.Errorf("range exceeds the maximum of %d days", maxTimeSeriesDays)
+	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
+
+	logger.Infof("GetShipmentsByDateRange: Getting shipments created between %s and %s (pageSize: %d, bookmark: '%s')", start.Format(time.RFC3339), end.Format(time.RFC3339), pageSize, bookmark)
+	// createdAt is stored in UTC with optional fractional seconds. Second-precision bounds without a zone
+	// sort before every timestamp in that second, so the upper bound is the start of the following second.
+	const boundLayout = "2006-01-02T15:04:05"
+	selector := map[string]interface{}{
+		"objectType": shipmentObjectType,
+		"createdAt": map[string]interface{}{
+			"$gte": start.UTC().Format(boundLayout),
+			"$lt":  end.UTC().Truncate(time.Second).Add(time.Second).Format(boundLayout),
+		},
+		"isArchived": false,
+	}
+	return s.queryShipmentsWithPagination(ctx, "GetShipmentsByDateRange", selector, "indexObjectTypeCreatedAtDoc", pageSize, bookmark)
+}
+
+// GetCreationTimeSeries counts shipments created per UTC day between startStr and endStr (RFC3339,
+// inclusive of both days), returning a "YYYY-MM-DD" -> count map with every day in the range present.
+// Admin only; the range may span at most maxTimeSeriesDays days.
+func (s *FoodtraceSmartContract) GetCreationTimeSeries(ctx contractapi.TransactionContextInterface, startStr string, endStr string) (map[string]int, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetCreationTimeSeries: %w", err)
+	}
+	start, err := parseDateString(startStr, "startStr", true)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDateString(endStr, "endStr", true)
+	if err != nil {
+		return nil, err
+	}
+	startDay := start.UTC().Truncate(24 * time.Hour)
+	endDay := end.UTC().Truncate(24 * time.Hour)
+	if endDay.Before(startDay) {
+		return nil, fmt.Errorf("endStr (%s) cannot be before startStr (%s)", endStr, startStr)
+	}
+	if days := int(endDay.Sub(startDay).Hours()/24) + 1; days > maxTimeSeriesDays {
+		return nil, fmt.Errorf("range spans %d days, exceeding maximum of %d", days, maxTimeSeriesDays)
+	}
+
+	series := map[string]int{}
+	for day := startDay; !day.After(endDay); day = day.AddDate(0, 0, 1) {
+		series[day.Format("2006-01-02")] = 0
+	}
+
+	// Date-only bounds sort before/after every timestamp on those days.
+	queryBytes, err := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType": shipmentObjectType,
+			"createdAt": map[string]interface{}{
+				"$gte": startDay.Format("2006-01-02"),
+				"$lt":  endDay.AddDate(0, 0, 1).Format("2006-01-02"),
+			},
+		},
+		"use_index": "_design/indexObjectTypeCreatedAtDoc",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetCreationTimeSeries: failed to build query: %w", err)
+	}
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		return nil, fmt.Errorf("GetCreationTimeSeries: CouchDB query failed: %w. Ensure index 'indexObjectTypeCreatedAt' exists", err)
+	}
+	defer resultsIterator.Close()
+
+	scanned := 0
+	for resultsIterator.HasNext() {
+		if scanned >= maxAggregateScan {
+			return nil, fmt.Errorf("GetCreationTimeSeries: more than %d shipments in range; narrow the range", maxAggregateScan)
+		}
+		resp, iterErr := resultsIterator.Next()
 		if iterErr != nil {
-			logger.Warningf("GetMyShipments: Error iterating CouchDB results: %v. Skipping.", iterErr)
+			logger.Warningf("GetCreationTimeSeries: Error iterating results: %v. Skipping.", iterErr)
 			continue
 		}
+		scanned++
 		var ship model.Shipment
-		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
-			logger.Warningf("GetMyShipments: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+		if err := json.Unmarshal(resp.Value, &ship); err != nil {
+			logger.Warningf("GetCreationTimeSeries: Error unmarshalling shipment: %v. Skipping.", err)
 			continue
 		}
-		ensureShipmentSchemaCompliance(&ship)
-		s.enrichShipmentAliases(im, &ship)
-		ship.History = []model.HistoryEntry{}
-		shipmentsFromQuery = append(shipmentsFromQuery, &ship)
-		fetchedCountCouchDB++
+		dayKey := ship.CreatedAt.UTC().Format("2006-01-02")
+		if _, inRange := series[dayKey]; inRange {
+			series[dayKey]++
+		}
 	}
-
-	logger.Infof("GetMyShipments (CouchDB): Found %d non-archived shipments for user '%s' on this page.", fetchedCountCouchDB, actor.alias)
-	return &model.PaginatedShipmentResponse{
-		Shipments:    shipmentsFromQuery, // Will be [] if empty, not null
-		NextBookmark: metadata.GetBookmark(),
-		FetchedCount: fetchedCountCouchDB,
-	}, nil
+	logger.Infof("GetCreationTimeSeries: Counted %d shipments between %s and %s", scanned, startDay.Format("2006-01-02"), endDay.Format("2006-01-02"))
+	return series, nil
 }
 
-// Fix for GetAllShipments in shipment_query_ops.go
-func (s *FoodtraceSmartContract) GetAllShipments(ctx contractapi.TransactionContextInterface, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
+// GetInventoryByProduct sums the quantity of live shipments of a product (case-insensitive name match),
+// grouped by unit of measure. Archived, voided, destroyed and consumed shipments are not counted.
+// Admin only. Fails rather than return partial totals if more than maxAggregateScan shipments exist.
+func (s *FoodtraceSmartContract) GetInventoryByProduct(ctx contractapi.TransactionContextInterface, productName string) (map[string]float64, error) {
 	im := NewIdentityManager(ctx)
-	pageSize, err := strconv.ParseInt(pageSizeStr, 10, 32)
-	if err != nil || pageSize <= 0 {
-		pageSize = 10
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetInventoryByProduct: %w", err)
 	}
-	if pageSize > 100 {
-		pageSize = 100
+	if err := s.validateRequiredString(productName, "productName", maxStringInputLength); err != nil {
+		return nil, err
 	}
-	logger.Infof("GetAllShipments: Admin getting all non-archived shipments (pageSize: %d, bookmark: '%s')", pageSize, bookmark)
+	productName = strings.TrimSpace(productName)
 
-	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(shipmentObjectType, []string{}, int32(pageSize), bookmark)
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
 	if err != nil {
-		return nil, fmt.Errorf("GetAllShipments: failed to get shipments iterator: %w", err)
+		return nil, fmt.Errorf("GetInventoryByProduct: failed to get shipment iterator: %w", err)
 	}
 	defer resultsIterator.Close()
 
-	shipments := []*model.Shipment{}
-	fetchedCount := int32(0)
-
+	totals := map[string]float64{}
+	scanned := 0
 	for resultsIterator.HasNext() {
-		queryResponse, iterErr := resultsIterator.Next()
+		if scanned >= maxAggregateScan {
+			return nil, fmt.Errorf("GetInventoryByProduct: more than %d shipments on the ledger; inventory cannot be totalled in one call", maxAggregateScan)
+		}
+		resp, iterErr := resultsIterator.Next()
 		if iterErr != nil {
-			logger.Warningf("GetAllShipments: Error iterating results: %v. Skipping.", iterErr)
+			logger.Warningf("GetInventoryByProduct: Error iterating results: %v. Skipping.", iterErr)
 			continue
 		}
-		var ship model.Shipment
-		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
-			logger.Warningf("GetAllShipments: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+		scanned++
+		var ship struct {
+			ProductName   string               `json:"productName"`
+			Quantity      float64              `json:"quantity"`
+			UnitOfMeasure string               `json:"unitOfMeasure"`
+			Status        model.ShipmentStatus `json:"status"`
+			IsArchived    bool                 `json:"isArchived"`
+		}
+		if err := json.Unmarshal(resp.Value, &ship); err != nil {
+			logger.Warningf("GetInventoryByProduct: Error unmarshalling shipment: %v. Skipping.", err)
 			continue
 		}
-		if !ship.IsArchived {
-			ensureShipmentSchemaCompliance(&ship)
-			s.enrichShipmentAliases(im, &ship)
-			ship.History = []model.HistoryEntry{}
-			shipments = append(shipments, &ship)
-			fetchedCount++
+		if ship.IsArchived || !strings.EqualFold(strings.TrimSpace(ship.ProductName), productName) {
+			continue
+		}
+		switch ship.Status {
+		case model.StatusConsumed, model.StatusConsumedInProcessing, model.StatusVoided, model.StatusDestroyed:
+			continue
 		}
+		totals[ship.UnitOfMeasure] += ship.Quantity
 	}
-
-	logger.Infof("GetAllShipments: Retrieved %d non-archived shipments for this page.", fetchedCount)
-	return &model.PaginatedShipmentResponse{
-		Shipments:    shipments, // Will be [] if empty, not null
-		NextBookmark: metadata.GetBookmark(),
-		FetchedCount: fetchedCount,
-	}, nil
+	logger.Infof("GetInventoryByProduct: Scanned %d shipments for product '%s', %d unit(s) found", scanned, productName, len(totals))
+	return totals, nil
 }
 
-// Fix for GetShipmentsByStatus in shipment_query_ops.go
-func (s *FoodtraceSmartContract) GetShipmentsByStatus(ctx contractapi.TransactionContextInterface, statusToQuery string, pageSizeStr string, bookmark string) (*model.PaginatedShipmentResponse, error) {
-	logger.Infof("GetShipmentsByStatus: Querying shipments with status '%s', pageSize: '%s', bookmark: '%s'", statusToQuery, pageSizeStr, bookmark)
-	var targetStatus model.ShipmentStatus
-
-	switch strings.ToUpper(statusToQuery) {
-	case string(model.StatusCreated):
-		targetStatus = model.StatusCreated
-	case string(model.StatusPendingCertification):
-		targetStatus = model.StatusPendingCertification
-	case string(model.StatusCertified):
-		targetStatus = model.StatusCertified
-	case string(model.StatusCertificationRejected):
-		targetStatus = model.StatusCertificationRejected
-	case string(model.StatusProcessed):
-		targetStatus = model.StatusProcessed
-	case string(model.StatusDistributed):
-		targetStatus = model.StatusDistributed
-	case string(model.StatusDelivered):
-		targetStatus = model.StatusDelivered
-	case string(model.StatusConsumed):
-		targetStatus = model.StatusConsumed
-	case string(model.StatusRecalled):
-		targetStatus = model.StatusRecalled
-	case string(model.StatusConsumedInProcessing):
-		targetStatus = model.StatusConsumedInProcessing
-	default:
-		return nil, fmt.Errorf("invalid statusToQuery: '%s'", statusToQuery)
+// CountShipmentsByStatus tallies non-archived shipments per status, with every status present. The
+// "archived" key counts archived shipments and "total" counts all shipments. Public. Every shipment is
+// streamed with only its status fields decoded, so the counts are never truncated.
+func (s *FoodtraceSmartContract) CountShipmentsByStatus(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	logger.Debug("Chaincode Call: CountShipmentsByStatus (public access)")
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("CountShipmentsByStatus: failed to get shipment iterator: %w", err)
 	}
+	defer resultsIterator.Close()
 
-	im := NewIdentityManager(ctx)
-
-	pageSize, err := strconv.ParseInt(pageSizeStr, 10, 32)
-	if err != nil || pageSize <= 0 {
-		pageSize = 10
+	counts := map[string]int{"total": 0, "archived": 0}
+	for _, status := range []model.ShipmentStatus{
+		model.StatusCreated, model.StatusPendingCertification, model.StatusCertified, model.StatusCertificationRejected,
+		model.StatusProcessed, model.StatusDistributed, model.StatusDelivered, model.StatusConsumed,
+		model.StatusRecalled, model.StatusConsumedInProcessing, model.StatusVoided, model.StatusDestroyed,
+	} {
+		counts[string(status)] = 0
 	}
-	if pageSize > 100 {
-		pageSize = 100
+
+	for resultsIterator.HasNext() {
+		resp, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("CountShipmentsByStatus: Error iterating results: %v. Skipping.", iterErr)
+			continue
+		}
+		// Only the two fields needed are decoded, not the full shipment.
+		var ship struct {
+			Status     model.ShipmentStatus `json:"status"`
+			IsArchived bool                 `json:"isArchived"`
+		}
+		if err := json.Unmarshal(resp.Value, &ship); err != nil {
+			logger.Warningf("CountShipmentsByStatus: Error unmarshalling shipment: %v. Skipping.", err)
+			continue
+		}
+		counts["total"]++
+		if ship.IsArchived {
+			counts["archived"]++
+			continue
+		}
+		counts[string(ship.Status)]++
 	}
+	return counts, nil
+}
 
-	queryString := fmt.Sprintf(`{"selector":{"objectType":"%s", "status":"%s", "isArchived":false}, "use_index":"_design/indexObjectTypeStatusIsArchivedDoc"}`, shipmentObjectType, targetStatus)
-	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+// maxCustomAttributeKeys caps the distinct keys GetCustomAttributeKeys will collect.
+const maxCustomAttributeKeys = 500
+
+// GetCustomAttributeKeys lists the distinct CustomAttributes keys used across all shipments, sorted. Admin only;
+// fails rather than return a partial list if more than maxAggregateScan shipments or maxCustomAttributeKeys keys exist.
+func (s *FoodtraceSmartContract) GetCustomAttributeKeys(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	im := NewIdentityManager(ctx)
+	if err := s.requireAdmin(ctx, im); err != nil {
+		return nil, fmt.Errorf("GetCustomAttributeKeys: %w", err)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
 	if err != nil {
-		return nil, fmt.Errorf("GetShipmentsByStatus: CouchDB query failed for status '%s': %w. Ensure index 'indexObjectTypeStatusIsArchivedDoc' exists", targetStatus, err)
+		return nil, fmt.Errorf("GetCustomAttributeKeys: failed to get shipment iterator: %w", err)
 	}
 	defer resultsIterator.Close()
 
-	shipmentsFromQuery := []*model.Shipment{}
-	fetchedCountCouchDB := int32(0)
-
+	seen := make(map[string]struct{})
+	scanned := 0
 	for resultsIterator.HasNext() {
-		queryResponse, iterErr := resultsIterator.Next()
+		if scanned >= maxAggregateScan {
+			return nil, fmt.Errorf("GetCustomAttributeKeys: more than %d shipments on the ledger; keys cannot be collected in one call", maxAggregateScan)
+		}
+		resp, iterErr := resultsIterator.Next()
 		if iterErr != nil {
-			logger.Warningf("GetShipmentsByStatus: Error iterating CouchDB results: %v. Skipping.", iterErr)
+			logger.Warningf("GetCustomAttributeKeys: Error iterating results: %v. Skipping.", iterErr)
 			continue
 		}
-		var ship model.Shipment
-		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
-			logger.Warningf("GetShipmentsByStatus: Error unmarshalling shipment: %v. Skipping.", errUnmarshal)
+		scanned++
+		var ship struct {
+			CustomAttributes map[string]string `json:"customAttributes"`
+		}
+		if err := json.Unmarshal(resp.Value, &ship); err != nil {
+			logger.Warningf("GetCustomAttributeKeys: Error unmarshalling shipment: %v. Skipping.", err)
 			continue
 		}
-		ensureShipmentSchemaCompliance(&ship)
-		s.enrichShipmentAliases(im, &ship)
-		ship.History = []model.HistoryEntry{} // FIXED: Initialize as empty slice
-		shipmentsFromQuery = append(shipmentsFromQuery, &ship)
-		fetchedCountCouchDB++
+		for key := range ship.CustomAttributes {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			if len(seen) >= maxCustomAttributeKeys {
+				return nil, fmt.Errorf("GetCustomAttributeKeys: more than %d distinct keys in use", maxCustomAttributeKeys)
+			}
+			seen[key] = struct{}{}
+		}
 	}
 
-	logger.Infof("GetShipmentsByStatus (CouchDB): Found %d non-archived shipments with status '%s' on this page.", fetchedCountCouchDB, targetStatus)
-	return &model.PaginatedShipmentResponse{
-		Shipments:    shipmentsFromQuery, // Will be [] if empty, not null
-		NextBookmark: metadata.GetBookmark(),
-		FetchedCount: fetchedCountCouchDB,
-	}, nil
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
 }
 
 func (s *FoodtraceSmartContract) QueryRelatedShipments(ctx contractapi.TransactionContextInterface, recalledShipmentID string, timeWindowHoursStr string) ([]model.RelatedShipmentInfo, error) {
@@ -505,13 +1475,7 @@ func (s *FoodtraceSmartContract) GetMyActionableShipments(ctx contractapi.Transa
 		userRoles = idInfo.Roles
 	}
 
-	pageSize, err := strconv.ParseInt(pageSizeStr, 10, 32)
-	if err != nil || pageSize <= 0 {
-		pageSize = 10
-	}
-	if pageSize > 100 {
-		pageSize = 100
-	}
+	pageSize := parsePageSize(ctx, pageSizeStr)
 
 	logger.Infof("GetMyActionableShipments: Getting actionable shipments for '%s' (alias: %s) with roles: %v, admin: %v",
 		actor.fullID, actor.alias, userRoles, isCallerAdmin)
@@ -570,6 +1534,11 @@ func (s *FoodtraceSmartContract) GetMyActionableShipments(ctx contractapi.Transa
 
 // Helper function to determine if a user can act on a shipment
 func (s *FoodtraceSmartContract) canUserActOnShipment(shipment *model.Shipment, userFullID string, userRoles []string, isAdmin bool) (bool, string) {
+	// Voided and destroyed shipments are terminal for everyone
+	if shipment.Status == model.StatusVoided || shipment.Status == model.StatusDestroyed {
+		return false, ""
+	}
+
 	// Admins can act on any shipment
 	if isAdmin {
 		return true, "ADMIN_ACTION"
@@ -702,3 +1671,244 @@ func (s *FoodtraceSmartContract) GetMyActionableShipmentsWithActions(ctx contrac
 		},
 	}, nil
 }
+
+// CanIActOnShipment reports whether the caller is the legitimate next actor on a shipment, the action
+// they may take and, when they cannot act, why not. It is read-only.
+func (s *FoodtraceSmartContract) CanIActOnShipment(ctx contractapi.TransactionContextInterface, shipmentID string) (map[string]interface{}, error) {
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return nil, err
+	}
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("CanIActOnShipment: failed to get actor info: %w", err)
+	}
+
+	im := NewIdentityManager(ctx)
+	isCallerAdmin, _ := im.IsCurrentUserAdmin()
+	userRoles := []string{}
+	if !isCallerAdmin {
+		idInfo, err := im.GetIdentityInfo(actor.fullID)
+		if err != nil {
+			return nil, fmt.Errorf("CanIActOnShipment: failed to get caller's identity info: %w", err)
+		}
+		userRoles = idInfo.Roles
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	canAct, actionType := s.canUserActOnShipment(shipment, actor.fullID, userRoles, isCallerAdmin)
+	reason := ""
+	if !canAct {
+		switch {
+		case shipment.Status == model.StatusVoided || shipment.Status == model.StatusDestroyed:
+			reason = fmt.Sprintf("shipment is in terminal status '%s'", shipment.Status)
+		case shipment.Status == model.StatusRecalled:
+			reason = "shipment has been recalled"
+		case shipment.Status == model.StatusConsumed || shipment.Status == model.StatusConsumedInProcessing:
+			reason = fmt.Sprintf("shipment has already been consumed (status '%s')", shipment.Status)
+		default:
+			reason = fmt.Sprintf("caller is not the designated actor for a shipment in status '%s' or lacks the required role", shipment.Status)
+		}
+	} else if shipment.IsArchived && !isCallerAdmin {
+		// canUserActOnShipment ignores archiving; mutating operations still refuse archived shipments.
+		canAct, actionType = false, ""
+		reason = "shipment is archived"
+	}
+
+	return map[string]interface{}{
+		"shipmentId": shipment.ID,
+		"status":     shipment.Status,
+		"canAct":     canAct,
+		"actionType": actionType,
+		"reason":     reason,
+	}, nil
+}
+
+// --- Query Helpers ---
+
+// parseShipmentStatus converts a case-insensitive status string into a model.ShipmentStatus.
+func parseShipmentStatus(statusStr string) (model.ShipmentStatus, error) {
+	switch strings.ToUpper(strings.TrimSpace(statusStr)) {
+	case string(model.StatusCreated):
+		return model.StatusCreated, nil
+	case string(model.StatusPendingCertification):
+		return model.StatusPendingCertification, nil
+	case string(model.StatusCertified):
+		return model.StatusCertified, nil
+	case string(model.StatusCertificationRejected):
+		return model.StatusCertificationRejected, nil
+	case string(model.StatusProcessed):
+		return model.StatusProcessed, nil
+	case string(model.StatusDistributed):
+		return model.StatusDistributed, nil
+	case string(model.StatusDelivered):
+		return model.StatusDelivered, nil
+	case string(model.StatusConsumed):
+		return model.StatusConsumed, nil
+	case string(model.StatusRecalled):
+		return model.StatusRecalled, nil
+	case string(model.StatusConsumedInProcessing):
+		return model.StatusConsumedInProcessing, nil
+	case string(model.StatusVoided):
+		return model.StatusVoided, nil
+	case string(model.StatusDestroyed):
+		return model.StatusDestroyed, nil
+	default:
+		return "", fmt.Errorf("invalid statusToQuery: '%s'", statusStr)
+	}
+}
+
+// parsePageSize parses a page size, capping it at 100. An empty or invalid value falls back to the
+// caller's role default.
+func parsePageSize(ctx contractapi.TransactionContextInterface, pageSizeStr string) int32 {
+	trimmed := strings.TrimSpace(pageSizeStr)
+	pageSize, err := strconv.ParseInt(trimmed, 10, 32)
+	if err != nil || pageSize <= 0 {
+		if trimmed != "" {
+			logger.Warningf("Invalid pageSize '%s', using role default", pageSizeStr)
+		}
+		pageSize = int64(defaultPageSizeForCaller(ctx))
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return int32(pageSize)
+}
+
+// defaultPageSizeForCaller returns the largest configured default page size among the caller's
+// roles (see SetRoleDefaultPageSizes), or 10 if none of them has one.
+func defaultPageSizeForCaller(ctx contractapi.TransactionContextInterface) int {
+	defaults, err := getRoleDefaultPageSizes(ctx)
+	if err != nil {
+		logger.Warningf("Failed to read role default page sizes: %v. Using %d.", err, fallbackPageSize)
+		return fallbackPageSize
+	}
+	im := NewIdentityManager(ctx)
+	callerFullID, err := im.GetCurrentIdentityFullID()
+	if err != nil {
+		return fallbackPageSize
+	}
+	idInfo, err := im.GetIdentityInfo(callerFullID)
+	if err != nil || idInfo == nil {
+		return fallbackPageSize
+	}
+	pageSize := 0
+	for _, role := range idInfo.Roles {
+		if size, ok := defaults[strings.ToLower(role)]; ok && size > pageSize {
+			pageSize = size
+		}
+	}
+	if pageSize == 0 {
+		return fallbackPageSize
+	}
+	return pageSize
+}
+
+// queryShipmentsWithPagination runs a CouchDB selector query against the named index and returns
+// a page of schema-compliant, alias-enriched shipments (without history). Voided shipments are
+// always excluded, in addition to any status condition in selector.
+func (s *FoodtraceSmartContract) queryShipmentsWithPagination(ctx contractapi.TransactionContextInterface, fnName string, selector map[string]interface{}, indexName string, pageSize int32, bookmark string) (*model.PaginatedShipmentResponse, error) {
+	notVoided := map[string]interface{}{"$ne": model.StatusVoided}
+	fullSelector := make(map[string]interface{}, len(selector)+1)
+	for field, cond := range selector {
+		fullSelector[field] = cond
+	}
+	if statusCond, ok := fullSelector["status"]; ok {
+		delete(fullSelector, "status")
+		fullSelector["$and"] = []interface{}{
+			map[string]interface{}{"status": statusCond},
+			map[string]interface{}{"status": notVoided},
+		}
+	} else {
+		fullSelector["status"] = notVoided
+	}
+	queryBytes, err := json.Marshal(map[string]interface{}{
+		"selector":  fullSelector,
+		"use_index": "_design/" + indexName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to build query: %w", fnName, err)
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryBytes), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("%s: CouchDB query failed: %w. Ensure index '%s' exists", fnName, err, indexName)
+	}
+	defer resultsIterator.Close()
+
+	im := NewIdentityManager(ctx)
+	shipmentsFromQuery := []*model.Shipment{}
+	fetchedCount := int32(0)
+
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("%s: Error iterating CouchDB results: %v. Skipping.", fnName, iterErr)
+			continue
+		}
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("%s: Error unmarshalling shipment: %v. Skipping.", fnName, errUnmarshal)
+			continue
+		}
+		ensureShipmentSchemaCompliance(&ship)
+		s.enrichShipmentAliases(im, &ship)
+		ship.History = []model.HistoryEntry{}
+		shipmentsFromQuery = append(shipmentsFromQuery, &ship)
+		fetchedCount++
+	}
+
+	logger.Infof("%s (CouchDB): Found %d shipments on this page.", fnName, fetchedCount)
+	return &model.PaginatedShipmentResponse{
+		Shipments:    shipmentsFromQuery, // Will be [] if empty, not null
+		NextBookmark: metadata.GetBookmark(),
+		FetchedCount: fetchedCount,
+	}, nil
+}
+
+// scanShipmentsWithPagination walks one page of shipments by composite key and returns the
+// non-archived, non-voided ones accepted by match. Used for filters CouchDB cannot index.
+func (s *FoodtraceSmartContract) scanShipmentsWithPagination(ctx contractapi.TransactionContextInterface, fnName string, pageSize int32, bookmark string, match func(*model.Shipment) bool) (*model.PaginatedShipmentResponse, error) {
+	im := NewIdentityManager(ctx)
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(shipmentObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to get shipments iterator: %w", fnName, err)
+	}
+	defer resultsIterator.Close()
+
+	shipments := []*model.Shipment{}
+	fetchedCount := int32(0)
+	for resultsIterator.HasNext() {
+		queryResponse, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			logger.Warningf("%s: Error iterating results: %v. Skipping.", fnName, iterErr)
+			continue
+		}
+		var ship model.Shipment
+		if errUnmarshal := json.Unmarshal(queryResponse.Value, &ship); errUnmarshal != nil {
+			logger.Warningf("%s: Error unmarshalling shipment: %v. Skipping.", fnName, errUnmarshal)
+			continue
+		}
+		if ship.IsArchived || ship.Status == model.StatusVoided {
+			continue
+		}
+		ensureShipmentSchemaCompliance(&ship)
+		if !match(&ship) {
+			continue
+		}
+		s.enrichShipmentAliases(im, &ship)
+		ship.History = []model.HistoryEntry{}
+		shipments = append(shipments, &ship)
+		fetchedCount++
+	}
+
+	logger.Infof("%s: Matched %d shipments on this page.", fnName, fetchedCount)
+	return &model.PaginatedShipmentResponse{
+		Shipments:    shipments, // Will be [] if empty, not null
+		NextBookmark: metadata.GetBookmark(),
+		FetchedCount: fetchedCount,
+	}, nil
+}
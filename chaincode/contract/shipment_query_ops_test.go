@@ -0,0 +1,2019 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// shipmentIDs returns the sorted IDs of the shipments on a page.
+func shipmentIDs(resp *model.PaginatedShipmentResponse) []string {
+	ids := []string{}
+	for _, ship := range resp.Shipments {
+		ids = append(ids, ship.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// requireIndexFields fails the test unless the named CouchDB index is packaged with the chaincode
+// and indexes exactly the given fields.
+func requireIndexFields(t *testing.T, name string, fields ...string) {
+	t.Helper()
+	indexBytes, err := os.ReadFile(filepath.Join("..", "META-INF", "statedb", "couchdb", "indexes", name+".json"))
+	if err != nil {
+		t.Fatalf("index %s is not packaged: %v", name, err)
+	}
+	var index struct {
+		Index struct {
+			Fields []string `json:"fields"`
+		} `json:"index"`
+		Ddoc string `json:"ddoc"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		t.Fatalf("index %s is not valid JSON: %v", name, err)
+	}
+	if index.Name != name || index.Ddoc != name+"Doc" {
+		t.Fatalf("index %s has name %q and ddoc %q", name, index.Name, index.Ddoc)
+	}
+	if !equalStrings(index.Index.Fields, fields) {
+		t.Fatalf("index %s fields = %v, want %v", name, index.Index.Fields, fields)
+	}
+}
+
+func TestGetMyShipmentsByStatus(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3", "SHIP-4"} {
+		e.createShipment(id)
+	}
+	e.process("SHIP-3")
+	archived := e.shipment("SHIP-4")
+	archived.IsArchived = true
+	e.putShipment(archived)
+
+	tests := []struct {
+		name    string
+		caller  *testIdentity
+		status  string
+		want    []string
+		wantErr string
+	}{
+		{name: "caller's shipments in requested status", caller: e.farmer, status: "CREATED", want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "status is case-insensitive", caller: e.farmer, status: "created", want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "caller has none in status", caller: e.farmer, status: "PROCESSED", want: []string{}},
+		{name: "other owner's status", caller: e.processor, status: "PROCESSED", want: []string{"SHIP-3"}},
+		{name: "invalid status", caller: e.farmer, status: "LOST", wantErr: "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetMyShipmentsByStatus(e.as(tt.caller), tt.status, "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		first, err := e.cc.GetMyShipmentsByStatus(e.as(e.farmer), "CREATED", "1", "")
+		e.must(err)
+		if first.FetchedCount != 1 || first.NextBookmark == "" {
+			t.Fatalf("first page fetched %d with bookmark %q", first.FetchedCount, first.NextBookmark)
+		}
+		second, err := e.cc.GetMyShipmentsByStatus(e.as(e.farmer), "CREATED", "1", first.NextBookmark)
+		e.must(err)
+		if second.FetchedCount != 1 || second.Shipments[0].ID == first.Shipments[0].ID {
+			t.Fatalf("second page = %v", shipmentIDs(second))
+		}
+	})
+
+	requireIndexFields(t, "indexObjectTypeOwnerStatusIsArchived", "objectType", "currentOwnerId", "status", "isArchived")
+}
+
+// TestIndexedFieldQueries covers the queries that exact-match a single shipment field through a
+// packaged CouchDB index. Each one runs against the same fixture: SHIP-1 and SHIP-3 carry the
+// value (SHIP-3 has moved on a stage), SHIP-2 another value, ARCHIVED the value but archived,
+// and VOIDED a value of its own but voided.
+func TestIndexedFieldQueries(t *testing.T) {
+	type query func(*FoodtraceSmartContract, contractapi.TransactionContextInterface, string, string, string) (*model.PaginatedShipmentResponse, error)
+	farmerField := func(key string) func(e *testEnv, id, value string) {
+		return func(e *testEnv, id, value string) {
+			e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(map[string]interface{}{key: value})))
+		}
+	}
+	queries := []struct {
+		name    string
+		query   query
+		stage   func(e *testEnv, id, value string) // creates shipment id with value in the queried field
+		advance func(e *testEnv, id string)        // moves a staged shipment one step further
+		index   string
+		field   string
+		// value is shared by SHIP-1, SHIP-3 and ARCHIVED; other, voided and unused are distinct
+		// values, and caseVariant differs from value only in case.
+		value, other, voided, unused, caseVariant string
+	}{
+		{
+			name:  "temperature range",
+			query: (*FoodtraceSmartContract).GetShipmentsByTemperatureRange,
+			stage: func(e *testEnv, id, value string) {
+				e.createShipment(id)
+				e.process(id)
+				e.must(e.cc.DistributeShipment(e.as(e.distributor), id, e.distributorData(map[string]interface{}{"temperatureRange": value})))
+			},
+			advance: (*testEnv).receive,
+			index:   "indexObjectTypeTemperatureRangeIsArchived", field: "distributorData.temperatureRange",
+			value: "0-4C", other: "2-8C", voided: "-18C", unused: "15-25C", caseVariant: "0-4c",
+		},
+		{
+			name:  "processing type",
+			query: (*FoodtraceSmartContract).GetShipmentsByProcessingType,
+			stage: func(e *testEnv, id, value string) {
+				e.createShipment(id)
+				e.must(e.cc.ProcessShipment(e.as(e.processor), id, e.processorData(map[string]interface{}{"processingType": value}), ""))
+			},
+			advance: (*testEnv).distribute,
+			index:   "indexObjectTypeProcessingTypeIsArchived", field: "processorData.processingType",
+			value: "frozen", other: "washing", voided: "dried", unused: "canning", caseVariant: "Frozen",
+		},
+		{
+			name:    "irrigation method",
+			query:   (*FoodtraceSmartContract).GetShipmentsByIrrigationMethod,
+			stage:   farmerField("irrigationMethod"),
+			advance: (*testEnv).process,
+			index:   "indexObjectTypeIrrigationMethodIsArchived", field: "farmerData.irrigationMethod",
+			value: "drip", other: "sprinkler", voided: "furrow", unused: "flood", caseVariant: "Drip",
+		},
+		{
+			name:    "farm location",
+			query:   (*FoodtraceSmartContract).GetShipmentsByFarmLocation,
+			stage:   farmerField("farmLocation"),
+			advance: (*testEnv).process,
+			index:   "indexObjectTypeFarmLocationIsArchived", field: "farmerData.farmLocation",
+			value: "Valley Farm", other: "Hill Farm", voided: "Ridge Farm", unused: "Coast Farm", caseVariant: "valley farm",
+		},
+		{
+			name:    "bed type",
+			query:   (*FoodtraceSmartContract).GetShipmentsByBedType,
+			stage:   farmerField("bedType"),
+			advance: (*testEnv).process,
+			index:   "indexObjectTypeBedTypeIsArchived", field: "farmerData.bedType",
+			value: "raised", other: "plastic mulch", voided: "straw", unused: "hydroponic", caseVariant: "Raised",
+		},
+		{
+			name:    "farming practice",
+			query:   (*FoodtraceSmartContract).GetShipmentsByFarmingPractice,
+			stage:   farmerField("farmingPractice"),
+			advance: (*testEnv).process,
+			index:   "indexObjectTypeFarmingPracticeIsArchived", field: "farmerData.farmingPractice",
+			value: "organic", other: "conventional", voided: "regenerative", unused: "biodynamic", caseVariant: "Organic",
+		},
+		{
+			name:  "retailer line",
+			query: (*FoodtraceSmartContract).GetShipmentsByRetailerLine,
+			stage: func(e *testEnv, id, value string) {
+				e.createShipment(id)
+				e.process(id)
+				e.distribute(id)
+				e.must(e.cc.ReceiveShipment(e.as(e.retailer), id, e.retailerData(map[string]interface{}{"retailerLineId": value})))
+			},
+			index: "indexObjectTypeRetailerLineIsArchived", field: "retailerData.retailerLineId",
+			value: "RL-1", other: "RL-2", voided: "RL-3", unused: "RL-404", caseVariant: "rl-1",
+		},
+	}
+	for _, q := range queries {
+		t.Run(q.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for id, value := range map[string]string{"SHIP-1": q.value, "SHIP-2": q.other, "SHIP-3": q.value, "ARCHIVED": q.value, "VOIDED": q.voided} {
+				q.stage(e, id, value)
+			}
+			if q.advance != nil {
+				q.advance(e, "SHIP-3") // later stages keep the queried field
+			}
+			archived := e.shipment("ARCHIVED")
+			archived.IsArchived = true
+			e.putShipment(archived)
+			voided := e.shipment("VOIDED")
+			voided.Status = model.StatusVoided
+			e.putShipment(voided)
+
+			tests := []struct {
+				name     string
+				value    string
+				pageSize string
+				want     []string
+				wantErr  string
+			}{
+				{name: "value with matches", value: q.value, want: []string{"SHIP-1", "SHIP-3"}},
+				{name: "matches collected across pages", value: q.value, pageSize: "1", want: []string{"SHIP-1", "SHIP-3"}},
+				{name: "other value", value: q.other, want: []string{"SHIP-2"}},
+				{name: "value without matches", value: q.unused, want: []string{}},
+				{name: "voided shipments excluded", value: q.voided, want: []string{}},
+				{name: "match is exact", value: q.caseVariant, want: []string{}},
+				{name: "empty value", value: " ", wantErr: "cannot be empty"},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					got := []string{}
+					bookmark := ""
+					for pages := 0; ; pages++ {
+						if pages > 5 {
+							t.Fatal("paging did not terminate")
+						}
+						resp, err := q.query(e.cc, e.as(e.retailer), tt.value, tt.pageSize, bookmark)
+						checkErr(t, err, tt.wantErr)
+						if err != nil {
+							return
+						}
+						got = append(got, shipmentIDs(resp)...)
+						if bookmark = resp.NextBookmark; bookmark == "" {
+							break
+						}
+					}
+					sort.Strings(got)
+					if !equalStrings(got, tt.want) {
+						t.Fatalf("shipments = %v, want %v", got, tt.want)
+					}
+				})
+			}
+
+			requireIndexFields(t, q.index, "objectType", q.field, "isArchived")
+		})
+	}
+}
+
+func TestGetShipmentDiff(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-1")
+	createdTx := e.stub.TxID
+	e.process("SHIP-1")
+	processedTx := e.stub.TxID
+
+	tests := []struct {
+		name         string
+		txID1, txID2 string
+		wantChanges  map[string][2]interface{} // field -> {from, to}; unlisted fields are not checked
+		wantUnlisted []string                  // fields that must not appear in the diff
+		wantErr      string
+	}{
+		{
+			name: "created vs processed", txID1: createdTx, txID2: processedTx,
+			wantChanges: map[string][2]interface{}{
+				"status":         {string(model.StatusCreated), string(model.StatusProcessed)},
+				"currentOwnerId": {e.farmer.id, e.processor.id},
+			},
+			wantUnlisted: []string{"id", "productName", "history"},
+		},
+		{
+			name: "reversed order swaps from and to", txID1: processedTx, txID2: createdTx,
+			wantChanges: map[string][2]interface{}{"status": {string(model.StatusProcessed), string(model.StatusCreated)}},
+		},
+		{name: "same transaction", txID1: createdTx, txID2: createdTx, wantUnlisted: []string{"status", "currentOwnerId"}},
+		{name: "unknown transaction", txID1: createdTx, txID2: "tx999", wantErr: "'tx999' not found"},
+		{name: "empty transaction", txID1: "", txID2: processedTx, wantErr: "cannot be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := e.cc.GetShipmentDiff(e.as(e.admin), "SHIP-1", tt.txID1, tt.txID2)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			changes := diff["changes"].(map[string]interface{})
+			if diff["changedCount"] != len(changes) {
+				t.Fatalf("changedCount = %v, but %d changes listed", diff["changedCount"], len(changes))
+			}
+			for field, want := range tt.wantChanges {
+				change, ok := changes[field].(map[string]interface{})
+				if !ok || change["from"] != want[0] || change["to"] != want[1] {
+					t.Errorf("change to %s = %v, want %v -> %v", field, changes[field], want[0], want[1])
+				}
+			}
+			for _, field := range tt.wantUnlisted {
+				if _, ok := changes[field]; ok {
+					t.Errorf("unexpected change to %s: %v", field, changes[field])
+				}
+			}
+		})
+	}
+
+	t.Run("sub-struct fields diffed individually", func(t *testing.T) {
+		diff, err := e.cc.GetShipmentDiff(e.as(e.admin), "SHIP-1", createdTx, processedTx)
+		e.must(err)
+		changes := diff["changes"].(map[string]interface{})
+		if _, ok := changes["processorData"]; ok {
+			t.Fatal("processorData reported as a whole instead of per field")
+		}
+		found := false
+		for field := range changes {
+			if strings.HasPrefix(field, "processorData.") {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("no processorData.* changes in %v", changes)
+		}
+	})
+}
+
+func TestGetShipmentsByCertificationStatus(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-APPROVED")
+	e.certify("SHIP-APPROVED")
+	e.createShipment("SHIP-REJECTED")
+	e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-REJECTED"))
+	e.must(e.cc.RecordCertification(e.as(e.certifier), "SHIP-REJECTED", e.now.Format(time.RFC3339), "", "REJECTED", "mould"))
+	e.createShipment("SHIP-NONE")
+	// Only the latest record counts, whatever came before it.
+	for id, statuses := range map[string][]model.CertificationStatus{
+		"SHIP-PENDING":    {model.CertStatusRejected, model.CertStatusPending},
+		"SHIP-REAPPROVED": {model.CertStatusRejected, model.CertStatusApproved},
+		"SHIP-ARCHIVED":   {model.CertStatusApproved},
+	} {
+		e.createShipment(id)
+		ship := e.shipment(id)
+		for _, status := range statuses {
+			ship.CertificationRecords = append(ship.CertificationRecords, model.CertificationRecord{CertifierID: e.certifier.id, Status: status})
+		}
+		ship.IsArchived = id == "SHIP-ARCHIVED"
+		e.putShipment(ship)
+	}
+
+	tests := []struct {
+		name    string
+		status  string
+		want    []string
+		wantErr string
+	}{
+		{name: "approved", status: "APPROVED", want: []string{"SHIP-APPROVED", "SHIP-REAPPROVED"}},
+		{name: "rejected", status: "REJECTED", want: []string{"SHIP-REJECTED"}},
+		{name: "pending", status: "PENDING", want: []string{"SHIP-PENDING"}},
+		{name: "status is case-insensitive", status: " rejected ", want: []string{"SHIP-REJECTED"}},
+		{name: "invalid status", status: "CERTIFIED", wantErr: "invalid certification status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetShipmentsByCertificationStatus(e.as(e.admin), tt.status, "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("paging scans the whole ledger", func(t *testing.T) {
+		got := []string{}
+		bookmark := ""
+		for pages := 0; ; pages++ {
+			if pages > 6 {
+				t.Fatal("paging did not terminate")
+			}
+			resp, err := e.cc.GetShipmentsByCertificationStatus(e.as(e.admin), "APPROVED", "2", bookmark)
+			e.must(err)
+			got = append(got, shipmentIDs(resp)...)
+			if bookmark = resp.NextBookmark; bookmark == "" {
+				break
+			}
+		}
+		sort.Strings(got)
+		if want := []string{"SHIP-APPROVED", "SHIP-REAPPROVED"}; !equalStrings(got, want) {
+			t.Fatalf("shipments across pages = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestGetMyDesignatedCertifications(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	otherCertifier := newTestIdentity("certifier2", "Org2MSP")
+	e.register(otherCertifier, "certifier")
+	for id, certifier := range map[string]*testIdentity{
+		"SHIP-1": e.certifier, "SHIP-2": e.certifier, "SHIP-3": e.certifier, "SHIP-4": otherCertifier, "SHIP-5": nil, "SHIP-6": e.certifier,
+	} {
+		e.createShipment(id)
+		if certifier != nil {
+			e.must(e.cc.DesignateCertifier(e.as(e.farmer), id, certifier.alias))
+		}
+	}
+	e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-2"))
+	e.must(e.cc.SubmitForCertification(e.as(e.farmer), "SHIP-5"))
+	e.certify("SHIP-3") // decided, so no longer pending
+	archived := e.shipment("SHIP-6")
+	archived.IsArchived = true
+	e.putShipment(archived)
+
+	tests := []struct {
+		name    string
+		caller  *testIdentity
+		want    []string
+		wantErr string
+	}{
+		{name: "designated pending shipments only", caller: e.certifier, want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "other certifier's queue", caller: otherCertifier, want: []string{"SHIP-4"}},
+		{name: "non-certifier", caller: e.farmer, wantErr: "role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetMyDesignatedCertifications(e.as(tt.caller), "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeDesignatedCertifierIsArchived", "objectType", "designatedCertifierId", "isArchived")
+}
+
+func TestGetShipmentPublicDetailsHistoryCap(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxEntries    func(full int) string // empty keeps the default cap
+		wantEntries   func(full int) int
+		wantTruncated bool
+		wantErr       string
+	}{
+		{name: "default cap", maxEntries: func(int) string { return "" }, wantEntries: func(full int) int { return full }},
+		{name: "cap above history size", maxEntries: func(full int) string { return strconv.Itoa(full + 1) }, wantEntries: func(full int) int { return full }},
+		{name: "cap equal to history size", maxEntries: func(full int) string { return strconv.Itoa(full) }, wantEntries: func(full int) int { return full }},
+		{name: "cap below history size", maxEntries: func(int) string { return "2" }, wantEntries: func(int) int { return 2 }, wantTruncated: true},
+		{name: "cap of one", maxEntries: func(int) string { return "1" }, wantEntries: func(int) int { return 1 }, wantTruncated: true},
+		{name: "zero cap rejected", maxEntries: func(int) string { return "0" }, wantErr: "positive integer"},
+		{name: "non-numeric cap rejected", maxEntries: func(int) string { return "all" }, wantErr: "positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.deliveredShipment("SHIP-1")
+			full, err := e.cc.GetShipmentPublicDetails(e.as(e.retailer), "SHIP-1", false)
+			e.must(err)
+			if len(full.History) < 4 || full.HistoryTruncated {
+				t.Fatalf("uncapped history has %d entries (truncated %t), want at least one per stage", len(full.History), full.HistoryTruncated)
+			}
+
+			if maxEntries := tt.maxEntries(len(full.History)); maxEntries != "" {
+				err := e.cc.SetMaxHistoryEntries(e.as(e.admin), maxEntries)
+				checkErr(t, err, tt.wantErr)
+				if tt.wantErr != "" {
+					return
+				}
+			}
+			got, err := e.cc.GetShipmentPublicDetails(e.as(e.retailer), "SHIP-1", false)
+			e.must(err)
+			want := tt.wantEntries(len(full.History))
+			if len(got.History) != want || got.HistoryTruncated != tt.wantTruncated {
+				t.Fatalf("history has %d entries (truncated %t), want %d (truncated %t)", len(got.History), got.HistoryTruncated, want, tt.wantTruncated)
+			}
+			// The most recent entries are kept, in ledger order.
+			for i, entry := range got.History {
+				if wantTx := full.History[len(full.History)-want+i].TxID; entry.TxID != wantTx {
+					t.Fatalf("entry %d has tx %s, want %s", i, entry.TxID, wantTx)
+				}
+			}
+		})
+	}
+
+	t.Run("skipHistory ignores the cap", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.deliveredShipment("SHIP-1")
+		e.must(e.cc.SetMaxHistoryEntries(e.as(e.admin), "1"))
+		got, err := e.cc.GetShipmentPublicDetails(e.as(e.retailer), "SHIP-1", true)
+		checkErr(t, err, "")
+		if len(got.History) != 0 || got.HistoryTruncated {
+			t.Fatalf("history has %d entries (truncated %t), want none", len(got.History), got.HistoryTruncated)
+		}
+	})
+
+	t.Run("non-admin cannot set the cap", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetMaxHistoryEntries(e.as(e.retailer), "1"), "admin")
+	})
+}
+
+func TestParsePageSizeRoleDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizesJSON string // "" leaves the defaults unconfigured
+		caller    func(e *testEnv) *testIdentity
+		extraRole string
+		pageSize  string
+		want      int32
+	}{
+		{name: "certifier built-in default", caller: func(e *testEnv) *testIdentity { return e.certifier }, want: 25},
+		{name: "role without default falls back", caller: func(e *testEnv) *testIdentity { return e.farmer }, want: fallbackPageSize},
+		{name: "blank pageSize uses role default", caller: func(e *testEnv) *testIdentity { return e.certifier }, pageSize: "  ", want: 25},
+		{name: "invalid pageSize uses role default", caller: func(e *testEnv) *testIdentity { return e.certifier }, pageSize: "many", want: 25},
+		{name: "explicit pageSize wins", caller: func(e *testEnv) *testIdentity { return e.certifier }, pageSize: "7", want: 7},
+		{name: "explicit pageSize is capped", caller: func(e *testEnv) *testIdentity { return e.certifier }, pageSize: "500", want: maxPageSize},
+		{name: "configured default", sizesJSON: `{"Distributor": 50}`, caller: func(e *testEnv) *testIdentity { return e.distributor }, want: 50},
+		{name: "configuration replaces built-in defaults", sizesJSON: `{"distributor": 50}`, caller: func(e *testEnv) *testIdentity { return e.certifier }, want: fallbackPageSize},
+		{name: "emptied configuration falls back", sizesJSON: `{}`, caller: func(e *testEnv) *testIdentity { return e.certifier }, want: fallbackPageSize},
+		{name: "largest default among roles", sizesJSON: `{"farmer": 15, "retailer": 40}`, caller: func(e *testEnv) *testIdentity { return e.farmer }, extraRole: "retailer", want: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			if tt.sizesJSON != "" {
+				e.must(e.cc.SetRoleDefaultPageSizes(e.as(e.admin), tt.sizesJSON))
+			}
+			caller := tt.caller(e)
+			if tt.extraRole != "" {
+				e.must(e.cc.AssignRoleToIdentity(e.as(e.admin), caller.alias, tt.extraRole))
+			}
+			if got := parsePageSize(e.as(caller), tt.pageSize); got != tt.want {
+				t.Fatalf("parsePageSize(%q) = %d, want %d", tt.pageSize, got, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name      string
+		sizesJSON string
+		wantErr   string
+	}{
+		{name: "malformed JSON", sizesJSON: `{"certifier":`, wantErr: "invalid sizesJSON"},
+		{name: "unknown role", sizesJSON: `{"auditor": 20}`, wantErr: "invalid role 'auditor'"},
+		{name: "zero size", sizesJSON: `{"certifier": 0}`, wantErr: "must be between 1 and 100"},
+		{name: "size above maximum", sizesJSON: `{"certifier": 101}`, wantErr: "must be between 1 and 100"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			checkErr(t, e.cc.SetRoleDefaultPageSizes(e.as(e.admin), tt.sizesJSON), tt.wantErr)
+			sizes, err := e.cc.GetRoleDefaultPageSizes(e.as(e.admin))
+			checkErr(t, err, "")
+			if len(sizes) != 1 || sizes["certifier"] != 25 {
+				t.Fatalf("defaults after rejection = %v, want the built-in ones", sizes)
+			}
+		})
+	}
+}
+
+func TestGetShipmentsDestinedForMe(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	otherRetailer := newTestIdentity("retailer2", "Org1MSP")
+	idleRetailer := newTestIdentity("retailer3", "Org1MSP")
+	e.register(otherRetailer, "retailer")
+	e.register(idleRetailer, "retailer")
+
+	destinations := []struct {
+		id        string
+		retailer  *testIdentity
+		delivered bool
+		archived  bool
+	}{
+		{id: "SHIP-1", retailer: e.retailer},
+		{id: "SHIP-2", retailer: e.retailer},
+		{id: "SHIP-3", retailer: otherRetailer},
+		{id: "SHIP-4", retailer: e.retailer, delivered: true},
+		{id: "SHIP-5", retailer: e.retailer, archived: true},
+	}
+	for _, d := range destinations {
+		e.createShipment(d.id)
+		e.process(d.id)
+		e.must(e.cc.DistributeShipment(e.as(e.distributor), d.id, e.distributorData(map[string]interface{}{"destinationRetailerId": d.retailer.alias})))
+		if d.delivered {
+			e.receive(d.id)
+		}
+		if d.archived {
+			archived := e.shipment(d.id)
+			archived.IsArchived = true
+			e.putShipment(archived)
+		}
+	}
+	e.createShipment("SHIP-6") // Not yet distributed
+
+	tests := []struct {
+		name    string
+		caller  *testIdentity
+		want    []string
+		wantErr string
+	}{
+		{name: "retailer with incoming shipments", caller: e.retailer, want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "other retailer sees only its own", caller: otherRetailer, want: []string{"SHIP-3"}},
+		{name: "retailer with no incoming shipments", caller: idleRetailer, want: []string{}},
+		{name: "non-retailer rejected", caller: e.distributor, wantErr: "retailer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetShipmentsDestinedForMe(e.as(tt.caller), "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeDestinationRetailerStatusIsArchived", "objectType", "distributorData.destinationRetailerId", "status", "isArchived")
+}
+
+func TestGetInventoryByProduct(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	stock := []struct {
+		id, product, unit string
+		quantity          float64
+		status            model.ShipmentStatus // "" keeps the shipment as created
+		archived          bool
+	}{
+		{id: "SHIP-1", product: "Strawberries", unit: "kg", quantity: 100},
+		{id: "SHIP-2", product: "strawberries ", unit: "kg", quantity: 40.5},
+		{id: "SHIP-3", product: "Strawberries", unit: "lb", quantity: 30},
+		{id: "SHIP-4", product: "Strawberries", unit: "punnet", quantity: 12},
+		{id: "SHIP-5", product: "Blueberries", unit: "kg", quantity: 70},
+		{id: "SHIP-6", product: "Strawberries", unit: "kg", quantity: 500, archived: true},
+		{id: "SHIP-7", product: "Strawberries", unit: "kg", quantity: 500, status: model.StatusConsumed},
+		{id: "SHIP-8", product: "Strawberries", unit: "lb", quantity: 500, status: model.StatusConsumedInProcessing},
+		{id: "SHIP-9", product: "Strawberries", unit: "kg", quantity: 500, status: model.StatusVoided},
+		{id: "SHIP-10", product: "Strawberries", unit: "kg", quantity: 500, status: model.StatusDestroyed},
+		{id: "SHIP-11", product: "Strawberries", unit: "kg", quantity: 5, status: model.StatusRecalled},
+	}
+	for _, s := range stock {
+		e.must(e.cc.CreateShipment(e.as(e.farmer), s.id, s.product, "Test batch", s.quantity, s.unit, e.farmerData(nil)))
+		if s.status != "" || s.archived {
+			shipment := e.shipment(s.id)
+			if s.status != "" {
+				shipment.Status = s.status
+			}
+			shipment.IsArchived = s.archived
+			e.putShipment(shipment)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		product string
+		want    map[string]float64
+		wantErr string
+	}{
+		{name: "several shipments across units", product: "Strawberries", want: map[string]float64{"kg": 145.5, "lb": 30, "punnet": 12}},
+		{name: "name match ignores case and padding", product: "  STRAWBERRIES ", want: map[string]float64{"kg": 145.5, "lb": 30, "punnet": 12}},
+		{name: "single shipment", product: "Blueberries", want: map[string]float64{"kg": 70}},
+		{name: "unknown product", product: "Raspberries", want: map[string]float64{}},
+		{name: "empty product name", product: " ", wantErr: "cannot be empty"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, product: "Strawberries", wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			totals, err := e.cc.GetInventoryByProduct(e.as(caller), tt.product)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if len(totals) != len(tt.want) {
+				t.Fatalf("totals = %v, want %v", totals, tt.want)
+			}
+			for unit, quantity := range tt.want {
+				if totals[unit] != quantity {
+					t.Fatalf("totals = %v, want %v", totals, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetInventoryByProductScanCap(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	ctx := e.as(e.admin)
+	putStock := func(i int) {
+		key, err := e.cc.createShipmentCompositeKey(ctx, fmt.Sprintf("SHIP-%05d", i))
+		e.must(err)
+		e.must(ctx.GetStub().PutState(key, []byte(`{"objectType":"Shipment","productName":"Strawberries","quantity":2,"unitOfMeasure":"kg","status":"CREATED"}`)))
+	}
+	for i := 0; i < maxAggregateScan; i++ {
+		putStock(i)
+	}
+
+	totals, err := e.cc.GetInventoryByProduct(e.as(e.admin), "Strawberries")
+	e.must(err)
+	if want := float64(2 * maxAggregateScan); len(totals) != 1 || totals["kg"] != want {
+		t.Fatalf("totals = %v, want map[kg:%v]", totals, want)
+	}
+
+	putStock(maxAggregateScan)
+	_, err = e.cc.GetInventoryByProduct(e.as(e.admin), "Strawberries")
+	checkErr(t, err, fmt.Sprintf("more than %d shipments on the ledger", maxAggregateScan))
+}
+
+func TestGetShipmentsByParticipant(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	// The hauler both processes and distributes, so it appears at two stages of SHIP-1.
+	hauler := newTestIdentity("hauler1", "Org1MSP")
+	e.register(hauler, "processor")
+	e.must(e.cc.AssignRoleToIdentity(e.as(e.admin), hauler.alias, "distributor"))
+
+	forHauler := func(id string) {
+		e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(map[string]interface{}{"destinationProcessorId": hauler.alias})))
+	}
+	forHauler("SHIP-1")
+	e.must(e.cc.ProcessShipment(e.as(hauler), "SHIP-1", e.processorData(map[string]interface{}{"destinationDistributorId": hauler.alias}), ""))
+	e.must(e.cc.DistributeShipment(e.as(hauler), "SHIP-1", e.distributorData(nil)))
+	forHauler("SHIP-2")
+	e.must(e.cc.ProcessShipment(e.as(hauler), "SHIP-2", e.processorData(nil), ""))
+	e.distribute("SHIP-2")
+	e.createShipment("SHIP-3")
+	e.certify("SHIP-3")
+	forHauler("SHIP-4")
+	e.must(e.cc.ProcessShipment(e.as(hauler), "SHIP-4", e.processorData(nil), ""))
+	e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-4", "Duplicate entry"))
+
+	tests := []struct {
+		name        string
+		caller      func(e *testEnv) *testIdentity
+		participant func(e *testEnv) string
+		want        []string
+		wantErr     string
+	}{
+		{name: "actor at two stages", caller: func(*testEnv) *testIdentity { return hauler }, participant: func(*testEnv) string { return hauler.alias }, want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "by full ID", caller: func(*testEnv) *testIdentity { return hauler }, participant: func(*testEnv) string { return hauler.id }, want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "admin queries another identity", caller: func(e *testEnv) *testIdentity { return e.admin }, participant: func(e *testEnv) string { return e.distributor.alias }, want: []string{"SHIP-2"}},
+		{name: "certifier through certification records", caller: func(e *testEnv) *testIdentity { return e.certifier }, participant: func(e *testEnv) string { return e.certifier.alias }, want: []string{"SHIP-3"}},
+		{name: "farmer at the first stage", caller: func(e *testEnv) *testIdentity { return e.farmer }, participant: func(e *testEnv) string { return e.farmer.alias }, want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "identity with no shipments", caller: func(e *testEnv) *testIdentity { return e.retailer }, participant: func(e *testEnv) string { return e.retailer.alias }, want: []string{}},
+		{name: "non-admin querying another identity", caller: func(e *testEnv) *testIdentity { return e.retailer }, participant: func(*testEnv) string { return hauler.alias }, wantErr: "only admin"},
+		{name: "unknown identity", caller: func(e *testEnv) *testIdentity { return e.admin }, participant: func(*testEnv) string { return "nobody" }, wantErr: "failed to resolve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetShipmentsByParticipant(e.as(tt.caller(e)), tt.participant(e), "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetShipmentsByOrganicSinceBefore(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for id, since := range map[string]string{
+		"SHIP-1": "2010-03-01T00:00:00Z",
+		"SHIP-2": "2015-01-01T00:00:00Z",
+		"SHIP-3": "2020-06-15T00:00:00Z",
+		"SHIP-4": "2012-01-01T00:00:00Z",
+		"SHIP-5": "2012-01-01T00:00:00Z",
+		"SHIP-6": "2003-01-01T00:00:00Z",
+	} {
+		e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(map[string]interface{}{"organicSince": since})))
+	}
+	archived := e.shipment("SHIP-4")
+	archived.IsArchived = true
+	e.putShipment(archived)
+	unrecorded := e.shipment("SHIP-5") // Predates the organicSince requirement
+	unrecorded.FarmerData.OrganicSince = time.Time{}
+	e.putShipment(unrecorded)
+	e.must(e.cc.VoidShipment(e.as(e.farmer), "SHIP-6", "Entered twice"))
+
+	tests := []struct {
+		name    string
+		date    string
+		want    []string
+		wantErr string
+	}{
+		{name: "some qualify", date: "2016-01-01T00:00:00Z", want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "cutoff is inclusive", date: "2015-01-01T00:00:00Z", want: []string{"SHIP-1", "SHIP-2"}},
+		{name: "cutoff in another time zone", date: "2015-01-01T01:00:00+02:00", want: []string{"SHIP-1"}},
+		{name: "all qualify", date: "2025-01-01T00:00:00Z", want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "voided shipments excluded", date: "2005-01-01T00:00:00Z", want: []string{}},
+		{name: "none qualify", date: "2000-01-01T00:00:00Z", want: []string{}},
+		{name: "invalid date", date: "2015-01-01", wantErr: "invalid format for dateStr"},
+		{name: "empty date", date: "", wantErr: "dateStr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := e.cc.GetShipmentsByOrganicSinceBefore(e.as(e.retailer), tt.date, "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeOrganicSinceIsArchived", "objectType", "farmerData.organicSince", "isArchived")
+}
+
+func TestGetShipmentsByRecallInitiator(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3", "SHIP-4", "SHIP-5"} {
+		e.createShipment(id)
+	}
+	e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+	e.must(e.cc.AddLinkedShipmentsToRecall(e.as(e.farmer), "RC-1", "SHIP-1", `["SHIP-2"]`, false))
+	e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-3", "RC-2", "Foreign material"))
+	e.must(e.cc.InitiateRecall(e.as(e.admin), "SHIP-4", "RC-3", "Mislabelled allergens"))
+
+	tests := []struct {
+		name      string
+		caller    func(e *testEnv) *testIdentity
+		initiator func(e *testEnv) string
+		want      []string
+		wantErr   string
+	}{
+		{name: "initiator with several recalls", initiator: func(e *testEnv) string { return e.farmer.alias }, want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "by full ID", initiator: func(e *testEnv) string { return e.farmer.id }, want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "other initiator", initiator: func(e *testEnv) string { return e.admin.alias }, want: []string{"SHIP-4"}},
+		{name: "initiator with no recalls", initiator: func(e *testEnv) string { return e.processor.alias }, want: []string{}},
+		{name: "unknown initiator", initiator: func(*testEnv) string { return "nobody" }, wantErr: "failed to resolve"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, initiator: func(e *testEnv) string { return e.farmer.alias }, wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			resp, err := e.cc.GetShipmentsByRecallInitiator(e.as(caller), tt.initiator(e), "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeRecalledBy", "objectType", "recallInfo.recalledBy")
+}
+
+func TestGetCreationTimeSeries(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for i, created := range []string{
+		"2025-05-31T23:59:00Z",
+		"2025-06-01T00:00:00Z",
+		"2025-06-01T12:00:00Z",
+		"2025-06-01T23:59:58Z",
+		"2025-06-02T08:30:00Z",
+		"2025-06-04T17:45:00Z",
+		"2025-06-06T00:00:01Z",
+	} {
+		createdAt, _ := time.Parse(time.RFC3339, created)
+		e.now = createdAt.Add(-time.Second) // as() advances the clock by a second
+		e.createShipment("SHIP-" + strconv.Itoa(i+1))
+	}
+
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		start   string
+		end     string
+		want    map[string]int
+		wantErr string
+	}{
+		{
+			name:  "shipments across several days",
+			start: "2025-06-01T00:00:00Z", end: "2025-06-05T00:00:00Z",
+			want: map[string]int{"2025-06-01": 3, "2025-06-02": 1, "2025-06-03": 0, "2025-06-04": 1, "2025-06-05": 0},
+		},
+		{
+			name:  "bounds cover whole days",
+			start: "2025-06-01T18:00:00Z", end: "2025-06-02T01:00:00Z",
+			want: map[string]int{"2025-06-01": 3, "2025-06-02": 1},
+		},
+		{
+			name:  "bounds in another time zone are bucketed by UTC day",
+			start: "2025-06-01T01:00:00+02:00", end: "2025-06-01T01:00:00+02:00",
+			want: map[string]int{"2025-05-31": 1},
+		},
+		{name: "single quiet day", start: "2025-06-03T00:00:00Z", end: "2025-06-03T00:00:00Z", want: map[string]int{"2025-06-03": 0}},
+		{name: "end before start", start: "2025-06-05T00:00:00Z", end: "2025-06-01T00:00:00Z", wantErr: "cannot be before startStr"},
+		{name: "range too long", start: "2025-01-01T00:00:00Z", end: "2026-01-02T00:00:00Z", wantErr: "exceeding maximum of 366"},
+		{name: "invalid start", start: "2025-06-01", end: "2025-06-05T00:00:00Z", wantErr: "invalid format for startStr"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, start: "2025-06-01T00:00:00Z", end: "2025-06-05T00:00:00Z", wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			series, err := e.cc.GetCreationTimeSeries(e.as(caller), tt.start, tt.end)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if len(series) != len(tt.want) {
+				t.Fatalf("series = %v, want %v", series, tt.want)
+			}
+			for day, count := range tt.want {
+				if got, ok := series[day]; !ok || got != count {
+					t.Fatalf("series = %v, want %v", series, tt.want)
+				}
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeCreatedAt", "objectType", "createdAt")
+}
+
+func TestGetShipmentsWithOverrides(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	consume := func(id string) {
+		e.deliveredShipment(id)
+		e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), id, ""))
+	}
+	consume("SHIP-1")
+	e.must(e.cc.AdminReopenConsumed(e.as(e.admin), "SHIP-1", "Marked consumed by mistake"))
+	consume("SHIP-2") // Consumed without an override
+	e.createShipment("SHIP-3")
+	consume("SHIP-4")
+	e.must(e.cc.AdminReopenConsumed(e.as(e.admin), "SHIP-4", "Marked consumed by mistake"))
+	e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-4", "Closed out"))
+	consume("SHIP-5")
+	e.must(e.cc.AdminReopenConsumed(e.as(e.admin), "SHIP-5", "Scanner fault"))
+
+	tests := []struct {
+		name     string
+		caller   func(e *testEnv) *testIdentity
+		pageSize string
+		want     []string
+		wantErr  string
+	}{
+		{name: "shipments with and without overrides", want: []string{"SHIP-1", "SHIP-5"}},
+		{name: "matches collected across pages", pageSize: "2", want: []string{"SHIP-1", "SHIP-5"}},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.retailer }, wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			got := []string{}
+			bookmark := ""
+			for page := 0; page < 10; page++ {
+				resp, err := e.cc.GetShipmentsWithOverrides(e.as(caller), tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if tt.wantErr != "" {
+					return
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLineageCertifications(t *testing.T) {
+	tests := []struct {
+		name          string
+		certified     []string         // inputs certified before processing
+		tamper        func(e *testEnv) // rewrites lineage state after the transform
+		wantInspected []string         // shipments whose certification is expected, in walk order
+	}{
+		{name: "both inputs certified", certified: []string{"SHIP-1", "SHIP-2"}, wantInspected: []string{"SHIP-1", "SHIP-2"}},
+		{name: "one input certified", certified: []string{"SHIP-2"}, wantInspected: []string{"SHIP-2"}},
+		{name: "no certified inputs"},
+		{
+			name: "record copied onto product deduplicated", certified: []string{"SHIP-1", "SHIP-2"},
+			tamper: func(e *testEnv) {
+				product := e.shipment("JAM-1")
+				product.CertificationRecords = append(product.CertificationRecords, e.shipment("SHIP-1").CertificationRecords...)
+				e.putShipment(product)
+			},
+			wantInspected: []string{"SHIP-1", "SHIP-2"},
+		},
+		{
+			name: "cycle back to the product", certified: []string{"SHIP-1", "SHIP-2"},
+			tamper: func(e *testEnv) {
+				input := e.shipment("SHIP-1")
+				input.InputShipmentIDs = []string{"JAM-1", "SHIP-2"}
+				e.putShipment(input)
+			},
+			wantInspected: []string{"SHIP-1", "SHIP-2"},
+		},
+		{
+			name: "missing input skipped", certified: []string{"SHIP-1"},
+			tamper: func(e *testEnv) {
+				product := e.shipment("JAM-1")
+				product.InputShipmentIDs = append(product.InputShipmentIDs, "GONE-1")
+				e.putShipment(product)
+			},
+			wantInspected: []string{"SHIP-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			inspected := map[string]time.Time{}
+			for _, id := range []string{"SHIP-1", "SHIP-2"} {
+				e.createShipment(id)
+			}
+			for _, id := range tt.certified {
+				e.certify(id)
+				inspected[id] = e.shipment(id).CertificationRecords[0].InspectionDate
+			}
+			for _, id := range []string{"SHIP-1", "SHIP-2"} {
+				e.process(id)
+			}
+			e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), `[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2"}]`,
+				`[{"newShipmentId":"JAM-1","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`, e.processorData(nil), false))
+			if tt.tamper != nil {
+				tt.tamper(e)
+			}
+
+			records, err := e.cc.GetLineageCertifications(e.as(e.retailer), "JAM-1")
+			e.must(err)
+			if len(records) != len(tt.wantInspected) {
+				t.Fatalf("%d records, want %d: %+v", len(records), len(tt.wantInspected), records)
+			}
+			for i, id := range tt.wantInspected {
+				if records[i].CertifierID != e.certifier.id || !records[i].InspectionDate.Equal(inspected[id]) {
+					t.Errorf("record %d = %+v, want the certification of %s", i, records[i], id)
+				}
+			}
+		})
+	}
+
+	t.Run("depth cap", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("BASE")
+		// LINK-0 <- LINK-1 <- ... <- LINK-(maxLineageDepth+1), each certified on its own inspection date.
+		for i := 0; i <= maxLineageDepth+1; i++ {
+			link := e.shipment("BASE")
+			link.ID = "LINK-" + strconv.Itoa(i)
+			link.InputShipmentIDs = []string{"LINK-" + strconv.Itoa(i+1)}
+			link.CertificationRecords = []model.CertificationRecord{{
+				CertifierID: e.certifier.id, InspectionDate: testEpoch.AddDate(0, 0, i), Status: model.CertStatusApproved,
+			}}
+			e.putShipment(link)
+		}
+		records, err := e.cc.GetLineageCertifications(e.as(e.retailer), "LINK-0")
+		e.must(err)
+		if len(records) != maxLineageDepth+1 {
+			t.Fatalf("%d records, want %d", len(records), maxLineageDepth+1)
+		}
+		if last := records[len(records)-1]; !last.InspectionDate.Equal(testEpoch.AddDate(0, 0, maxLineageDepth)) {
+			t.Fatalf("deepest record inspected %s, want the one at depth %d", last.InspectionDate, maxLineageDepth)
+		}
+	})
+
+	t.Run("unknown shipment", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		_, err := e.cc.GetLineageCertifications(e.as(e.retailer), "NOPE-1")
+		checkErr(t, err, "NOPE-1")
+	})
+}
+
+func TestGetShipmentsOwnedByInactive(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3", "SHIP-4"} {
+		e.createShipment(id)
+	}
+	e.process("SHIP-1")
+	e.process("SHIP-3")
+	e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-3", "Closed out"))
+	e.process("SHIP-4")
+	e.must(e.cc.DeactivateIdentity(e.as(e.admin), e.processor.alias))
+	// SHIP-2 stays with the active farmer.
+
+	tests := []struct {
+		name     string
+		caller   func(e *testEnv) *testIdentity
+		pageSize string
+		want     []string
+		wantErr  string
+	}{
+		{name: "inactive and active owners", want: []string{"SHIP-1", "SHIP-4"}},
+		{name: "matches collected across pages", pageSize: "1", want: []string{"SHIP-1", "SHIP-4"}},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			got := []string{}
+			bookmark := ""
+			for page := 0; page < 10; page++ {
+				resp, err := e.cc.GetShipmentsOwnedByInactive(e.as(caller), tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if tt.wantErr != "" {
+					return
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("reactivated owner no longer listed", func(t *testing.T) {
+		checkErr(t, e.cc.ReactivateIdentity(e.as(e.admin), e.processor.alias), "")
+		resp, err := e.cc.GetShipmentsOwnedByInactive(e.as(e.admin), "", "")
+		checkErr(t, err, "")
+		if got := shipmentIDs(resp); len(got) != 0 {
+			t.Fatalf("shipments = %v, want none", got)
+		}
+	})
+}
+
+func TestGetShipmentsMissingDestination(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(e *testEnv) // builds SHIP-1 in the state under test
+		listed bool
+	}{
+		{
+			name: "processed without distributor destination",
+			setup: func(e *testEnv) {
+				e.createShipment("SHIP-1")
+				e.process("SHIP-1")
+				shipment := e.shipment("SHIP-1") // Processed before a destination was required
+				shipment.ProcessorData.DestinationDistributorID = ""
+				e.putShipment(shipment)
+			},
+			listed: true,
+		},
+		{name: "processed with distributor destination", setup: func(e *testEnv) { e.createShipment("SHIP-1"); e.process("SHIP-1") }},
+		{
+			name: "created without processor destination",
+			setup: func(e *testEnv) {
+				e.createShipment("SHIP-1")
+				shipment := e.shipment("SHIP-1")
+				shipment.FarmerData.DestinationProcessorID = ""
+				e.putShipment(shipment)
+			},
+			listed: true,
+		},
+		{name: "created with processor destination", setup: func(e *testEnv) { e.createShipment("SHIP-1") }},
+		{
+			name: "distributed without retailer destination",
+			setup: func(e *testEnv) {
+				e.createShipment("SHIP-1")
+				e.process("SHIP-1")
+				e.distribute("SHIP-1")
+				shipment := e.shipment("SHIP-1")
+				shipment.DistributorData.DestinationRetailerID = ""
+				e.putShipment(shipment)
+			},
+			listed: true,
+		},
+		{name: "delivered needs no destination", setup: func(e *testEnv) { e.deliveredShipment("SHIP-1") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			tt.setup(e)
+			resp, err := e.cc.GetShipmentsMissingDestination(e.as(e.admin), "", "")
+			e.must(err)
+			want := []string{}
+			if tt.listed {
+				want = []string{"SHIP-1"}
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, want) {
+				t.Fatalf("shipments = %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("non-admin rejected", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		_, err := e.cc.GetShipmentsMissingDestination(e.as(e.processor), "", "")
+		checkErr(t, err, "admin")
+	})
+}
+
+func TestGetShipmentsByMinOrganicYears(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for id, since := range map[string]string{
+		"ORGANIC-10Y": "2015-01-01T00:00:00Z",
+		"ORGANIC-5Y":  "2020-06-01T12:00:00Z", // Exactly five years before testEpoch
+		"ORGANIC-3Y":  "2022-01-01T00:00:00Z",
+	} {
+		e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(map[string]interface{}{"organicSince": since})))
+	}
+	e.createShipment("NO-ORGANIC-SINCE")
+	legacy := e.shipment("NO-ORGANIC-SINCE") // Recorded before organicSince was required
+	legacy.FarmerData.OrganicSince = time.Time{}
+	e.putShipment(legacy)
+
+	tests := []struct {
+		name     string
+		caller   func(e *testEnv) *testIdentity
+		minYears string
+		want     []string
+		wantErr  string
+	}{
+		{name: "zero years matches every organic shipment", minYears: "0", want: []string{"ORGANIC-10Y", "ORGANIC-3Y", "ORGANIC-5Y"}},
+		{name: "boundary year qualifies", minYears: "5", want: []string{"ORGANIC-10Y", "ORGANIC-5Y"}},
+		{name: "one year past the boundary", minYears: "6", want: []string{"ORGANIC-10Y"}},
+		{name: "no shipment qualifies", minYears: "11", want: []string{}},
+		{name: "negative years rejected", minYears: "-1", wantErr: "invalid minYears '-1'"},
+		{name: "non-numeric years rejected", minYears: "five", wantErr: "invalid minYears 'five'"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, minYears: "0", wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			resp, err := e.cc.GetShipmentsByMinOrganicYears(e.as(caller), tt.minYears, "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetShipmentsByCertificationComment(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for id, comments := range map[string][]string{
+		"SHIP-1": {"Minor BRUISING on a few punnets"},
+		"SHIP-2": {"All clear"},
+		"SHIP-3": {"All clear", "Re-inspected: bruising within tolerance"}, // Matches on its second record
+		"SHIP-4": nil,
+	} {
+		e.createShipment(id)
+		if len(comments) == 0 {
+			continue
+		}
+		e.must(e.cc.SubmitForCertification(e.as(e.farmer), id))
+		e.must(e.cc.RecordCertification(e.as(e.certifier), id, e.now.Format(time.RFC3339), "", "APPROVED", comments[0]))
+		if len(comments) > 1 {
+			shipment := e.shipment(id)
+			extra := shipment.CertificationRecords[0]
+			extra.Comments = comments[1]
+			shipment.CertificationRecords = append(shipment.CertificationRecords, extra)
+			e.putShipment(shipment)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		keyword string
+		want    []string
+		wantErr string
+	}{
+		{name: "case-insensitive match across records", keyword: "Bruising", want: []string{"SHIP-1", "SHIP-3"}},
+		{name: "keyword is trimmed", keyword: "  all clear ", want: []string{"SHIP-2", "SHIP-3"}},
+		{name: "no match", keyword: "mold", want: []string{}},
+		{name: "empty keyword rejected", keyword: " ", wantErr: "keyword"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.certifier }, keyword: "bruising", wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			resp, err := e.cc.GetShipmentsByCertificationComment(e.as(caller), tt.keyword, "", "")
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := shipmentIDs(resp); !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProductsConsumingInput(t *testing.T) {
+	requireIndexFields(t, "indexObjectTypeInputShipmentIds", "objectType", "inputShipmentIds")
+
+	e := newSupplyChainEnv(t)
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3"} {
+		e.createShipment(id)
+		e.process(id)
+	}
+	transform := func(inputs, productID string) {
+		e.must(e.cc.TransformAndCreateProducts(e.as(e.processor), inputs,
+			`[{"newShipmentId":"`+productID+`","productName":"Jam","quantity":40,"unitOfMeasure":"kg"}]`, e.processorData(nil), false))
+	}
+	transform(`[{"shipmentId":"SHIP-1","quantityConsumed":40}]`, "JAM-1")
+	transform(`[{"shipmentId":"SHIP-1"},{"shipmentId":"SHIP-2","quantityConsumed":50}]`, "JAM-2")
+	transform(`[{"shipmentId":"SHIP-2"}]`, "JAM-3")
+	transform(`[{"shipmentId":"JAM-2"}]`, "JAM-4") // Two levels below SHIP-1 and SHIP-2
+	e.must(e.cc.ArchiveShipment(e.as(e.admin), "JAM-1", "Closed out"))
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{name: "input consumed by two products, one archived", input: "SHIP-1", want: []string{"JAM-1", "JAM-2"}},
+		{name: "partially consumed input", input: "SHIP-2", want: []string{"JAM-2", "JAM-3"}},
+		{name: "unconsumed input", input: "SHIP-3", want: []string{}},
+		{name: "derived product consumed again", input: "JAM-2", want: []string{"JAM-4"}},
+		{name: "empty ID rejected", input: " ", wantErr: "inputShipmentID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, err := e.cc.GetProductsConsumingInput(e.as(e.retailer), tt.input)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			got := []string{}
+			for _, product := range products {
+				got = append(got, product.ID)
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("products = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanIActOnShipment(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(e *testEnv) // SHIP-1 has been created
+		caller     func(e *testEnv) *testIdentity
+		wantAction string // "" expects canAct false
+		wantReason string
+	}{
+		{name: "owning farmer", caller: func(e *testEnv) *testIdentity { return e.farmer }, wantAction: "SUBMIT_FOR_CERTIFICATION"},
+		{name: "designated processor", caller: func(e *testEnv) *testIdentity { return e.processor }, wantAction: "PROCESS_SHIPMENT"},
+		{name: "admin", caller: func(e *testEnv) *testIdentity { return e.admin }, wantAction: "ADMIN_ACTION"},
+		{
+			name:       "retailer before delivery",
+			caller:     func(e *testEnv) *testIdentity { return e.retailer },
+			wantReason: "caller is not the designated actor for a shipment in status 'CREATED' or lacks the required role",
+		},
+		{
+			name:       "designated retailer after distribution",
+			setup:      func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			caller:     func(e *testEnv) *testIdentity { return e.retailer },
+			wantAction: "RECEIVE_SHIPMENT",
+		},
+		{
+			name:       "recalled shipment",
+			setup:      func(e *testEnv) { e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected")) },
+			caller:     func(e *testEnv) *testIdentity { return e.farmer },
+			wantReason: "shipment has been recalled",
+		},
+		{
+			name:       "voided shipment",
+			setup:      func(e *testEnv) { e.must(e.cc.VoidShipment(e.as(e.farmer), "SHIP-1", "Entered twice")) },
+			caller:     func(e *testEnv) *testIdentity { return e.admin },
+			wantReason: "shipment is in terminal status 'VOIDED'",
+		},
+		{
+			name:       "archived shipment",
+			setup:      func(e *testEnv) { e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-1", "Closed out")) },
+			caller:     func(e *testEnv) *testIdentity { return e.farmer },
+			wantReason: "shipment is archived",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+			before := e.shipment("SHIP-1")
+
+			result, err := e.cc.CanIActOnShipment(e.as(tt.caller(e)), "SHIP-1")
+			e.must(err)
+			if result["canAct"] != (tt.wantAction != "") || result["actionType"] != tt.wantAction || result["reason"] != tt.wantReason {
+				t.Fatalf("result = %v, want action %q, reason %q", result, tt.wantAction, tt.wantReason)
+			}
+			if result["shipmentId"] != "SHIP-1" || result["status"] != before.Status {
+				t.Fatalf("result = %v, want SHIP-1 in status %s", result, before.Status)
+			}
+			if after := e.shipment("SHIP-1"); !after.LastUpdatedAt.Equal(before.LastUpdatedAt) || len(e.stub.events) != 0 {
+				t.Fatal("CanIActOnShipment changed the shipment or emitted an event")
+			}
+		})
+	}
+
+	t.Run("unknown shipment", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		_, err := e.cc.CanIActOnShipment(e.as(e.farmer), "NOPE-1")
+		checkErr(t, err, "NOPE-1")
+	})
+}
+
+func TestGetShipmentsByBreachCountRange(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for breaches, id := range []string{"BREACH-0", "BREACH-1", "BREACH-2", "BREACH-3"} {
+		e.createShipment(id)
+		e.process(id)
+		e.distribute(id)
+		e.must(e.addSensorReading(id, 0, 3))
+		for i := 0; i < breaches; i++ {
+			e.must(e.addSensorReading(id, time.Duration(i+1)*time.Hour, 9)) // Above the declared 0-4C range
+		}
+	}
+	e.createShipment("UNDISTRIBUTED") // Has no cold-chain record yet
+	e.createShipment("ARCHIVED-2")
+	e.process("ARCHIVED-2")
+	e.distribute("ARCHIVED-2")
+	e.must(e.addSensorReading("ARCHIVED-2", 0, 9))
+	e.must(e.addSensorReading("ARCHIVED-2", time.Hour, 9))
+	archived := e.shipment("ARCHIVED-2")
+	archived.IsArchived = true
+	e.putShipment(archived)
+	if got := e.shipment("BREACH-3").DistributorData.TemperatureBreachCount; got != 3 {
+		t.Fatalf("BREACH-3 breachCount = %d, want 3", got)
+	}
+
+	tests := []struct {
+		name     string
+		min, max string
+		pageSize string
+		want     []string
+		wantErr  string
+	}{
+		{name: "single bucket", min: "2", max: "2", want: []string{"BREACH-2"}},
+		{name: "inclusive bounds", min: "1", max: "2", want: []string{"BREACH-1", "BREACH-2"}},
+		{name: "open-ended upper bound", min: "3", max: "100", want: []string{"BREACH-3"}},
+		{name: "matches collected across pages", min: "1", max: "3", pageSize: "1", want: []string{"BREACH-1", "BREACH-2", "BREACH-3"}},
+		{name: "empty bucket", min: "4", max: "9", want: []string{}},
+		{name: "padded bounds", min: " 0 ", max: " 0 ", want: []string{"BREACH-0"}},
+		{name: "min above max rejected", min: "3", max: "1", wantErr: "min (3) cannot be greater than max (1)"},
+		{name: "negative min rejected", min: "-1", max: "1", wantErr: "invalid min '-1'"},
+		{name: "non-numeric max rejected", min: "0", max: "lots", wantErr: "invalid max 'lots'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			bookmark := ""
+			for {
+				resp, err := e.cc.GetShipmentsByBreachCountRange(e.as(e.admin), tt.min, tt.max, tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if err != nil {
+					return
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeBreachCountIsArchived", "objectType", "distributorData.breachCount", "isArchived")
+}
+
+func TestGetShipmentsByOwner(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3", "ARCHIVED", "FARM-1", "VOIDED"} {
+		e.createShipment(id)
+	}
+	for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3", "ARCHIVED"} {
+		e.process(id)
+	}
+	archived := e.shipment("ARCHIVED")
+	archived.IsArchived = true
+	e.putShipment(archived)
+	e.must(e.cc.VoidShipment(e.as(e.farmer), "VOIDED", "Entered twice"))
+
+	tests := []struct {
+		name     string
+		caller   func(e *testEnv) *testIdentity
+		owner    func(e *testEnv) string
+		pageSize string
+		want     []string
+		wantErr  string
+	}{
+		{name: "by alias", owner: func(e *testEnv) string { return e.processor.alias }, want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "by full ID", owner: func(e *testEnv) string { return e.processor.id }, want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "voided shipments left out", owner: func(e *testEnv) string { return e.farmer.alias }, want: []string{"FARM-1"}},
+		{name: "matches collected across pages", owner: func(e *testEnv) string { return e.processor.alias }, pageSize: "2", want: []string{"SHIP-1", "SHIP-2", "SHIP-3"}},
+		{name: "owner without shipments", owner: func(e *testEnv) string { return e.certifier.alias }, want: []string{}},
+		{name: "unknown owner rejected", owner: func(e *testEnv) string { return "nobody" }, wantErr: "failed to resolve owner 'nobody'"},
+		{name: "missing owner rejected", owner: func(e *testEnv) string { return "" }, wantErr: "ownerIdentityOrAlias"},
+		{
+			name:    "non-admin rejected",
+			caller:  func(e *testEnv) *testIdentity { return e.processor },
+			owner:   func(e *testEnv) string { return e.processor.alias },
+			wantErr: "admin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			got := []string{}
+			bookmark := ""
+			for {
+				resp, err := e.cc.GetShipmentsByOwner(e.as(caller), tt.owner(e), tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if err != nil {
+					return
+				}
+				if resp.Shipments == nil || int(resp.FetchedCount) != len(resp.Shipments) {
+					t.Fatalf("shipments %v with fetchedCount %d", resp.Shipments, resp.FetchedCount)
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("page size capped", func(t *testing.T) {
+		for i := 0; i < maxPageSize+1; i++ {
+			e.createShipment("BULK-" + strconv.Itoa(i))
+		}
+		resp, err := e.cc.GetShipmentsByOwner(e.as(e.admin), e.farmer.alias, "1000", "")
+		e.must(err)
+		if resp.FetchedCount != maxPageSize || resp.NextBookmark == "" {
+			t.Fatalf("fetched %d with bookmark %q, want a capped page of %d", resp.FetchedCount, resp.NextBookmark, maxPageSize)
+		}
+	})
+
+	t.Run("LevelDB fallback", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.createShipment("SHIP-2")
+		e.process("SHIP-1")
+		e.stub.noCouch = true
+		resp, err := e.cc.GetShipmentsByOwner(e.as(e.admin), e.processor.alias, "", "")
+		checkErr(t, err, "Fallback logic triggered")
+		if got := shipmentIDs(resp); !equalStrings(got, []string{"SHIP-1"}) {
+			t.Fatalf("fallback shipments = %v, want [SHIP-1]", got)
+		}
+	})
+}
+
+func TestGetShipmentsByDateRange(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	for _, s := range []struct {
+		id    string
+		month time.Month
+	}{{"JUL", time.July}, {"AUG", time.August}, {"ARCHIVED", time.August}, {"SEP", time.September}} {
+		e.now = time.Date(2025, s.month, 1, 0, 0, 0, 0, time.UTC) // Created one second later
+		e.createShipment(s.id)
+	}
+	archived := e.shipment("ARCHIVED")
+	archived.IsArchived = true
+	e.putShipment(archived)
+	jul, sep := e.shipment("JUL").CreatedAt, e.shipment("SEP").CreatedAt
+
+	tests := []struct {
+		name       string
+		start, end string
+		pageSize   string
+		want       []string
+		wantErr    string
+	}{
+		{name: "whole window", start: "2025-06-15T00:00:00Z", end: "2025-09-30T00:00:00Z", want: []string{"AUG", "JUL", "SEP"}},
+		{name: "bounds are inclusive", start: jul.Format(time.RFC3339), end: sep.Format(time.RFC3339), want: []string{"AUG", "JUL", "SEP"}},
+		{name: "end just before a creation", start: jul.Format(time.RFC3339), end: sep.Add(-time.Second).Format(time.RFC3339), want: []string{"AUG", "JUL"}},
+		{name: "start just after a creation", start: jul.Add(time.Second).Format(time.RFC3339), end: sep.Format(time.RFC3339), want: []string{"AUG", "SEP"}},
+		{name: "offset bounds", start: "2025-07-31T20:00:00-04:00", end: "2025-09-01T02:00:01+02:00", want: []string{"AUG", "SEP"}},
+		{name: "matches collected across pages", start: "2025-06-15T00:00:00Z", end: "2025-09-30T00:00:00Z", pageSize: "1", want: []string{"AUG", "JUL", "SEP"}},
+		{name: "empty window", start: "2025-10-01T00:00:00Z", end: "2025-10-31T00:00:00Z", want: []string{}},
+		{name: "366 day window", start: "2024-09-01T00:00:00Z", end: "2025-09-02T00:00:00Z", want: []string{"AUG", "JUL", "SEP"}},
+		{name: "longer window rejected", start: "2024-09-01T00:00:00Z", end: "2025-09-02T00:00:01Z", wantErr: "range exceeds the maximum of 366 days"},
+		{name: "start after end rejected", start: "2025-09-01T00:00:00Z", end: "2025-07-01T00:00:00Z", wantErr: "cannot be after endRFC3339"},
+		{name: "missing start rejected", end: "2025-07-01T00:00:00Z", wantErr: "startRFC3339"},
+		{name: "invalid end rejected", start: "2025-07-01T00:00:00Z", end: "2025-09-01", wantErr: "endRFC3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			bookmark := ""
+			for {
+				resp, err := e.cc.GetShipmentsByDateRange(e.as(e.admin), tt.start, tt.end, tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if err != nil {
+					return
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeCreatedAt", "objectType", "createdAt")
+}
+
+func TestGetShipmentsByCreatorMSP(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	farmer2 := newTestIdentity("farmer2", "Org2MSP")
+	e.register(farmer2, "farmer")
+	e.createShipment("SHIP-1")
+	e.must(e.cc.CreateShipment(e.as(farmer2), "FARM-2", "Strawberries", "Test batch", 100, "kg", e.farmerData(nil)))
+	e.createShipment("PROCESSED")
+	e.must(e.cc.ProcessShipment(e.as(e.processor), "PROCESSED", e.processorData(nil), `[{"newShipmentId":"PULP-1","productName":"Strawberry pulp","quantity":12.5,"unitOfMeasure":"kg"}]`))
+	e.createShipment("ARCHIVED")
+	archived := e.shipment("ARCHIVED")
+	archived.IsArchived = true
+	e.putShipment(archived)
+	e.createShipment("LEGACY")
+	legacy := e.shipment("LEGACY") // Created before the creator MSP was recorded
+	legacy.CreatedByMSP = ""
+	e.putShipment(legacy)
+
+	for id, want := range map[string]string{"SHIP-1": "Org1MSP", "FARM-2": "Org2MSP", "PULP-1": "Org2MSP"} {
+		if got := e.shipment(id).CreatedByMSP; got != want {
+			t.Errorf("%s createdByMsp = %q, want %q", id, got, want)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		caller   func(e *testEnv) *testIdentity
+		mspID    string
+		pageSize string
+		want     []string
+		wantErr  string
+	}{
+		{name: "farmer organization", mspID: "Org1MSP", want: []string{"PROCESSED", "SHIP-1"}},
+		{name: "derived products follow their processor", mspID: "Org2MSP", want: []string{"FARM-2", "PULP-1"}},
+		{name: "padded MSP", mspID: " Org2MSP ", want: []string{"FARM-2", "PULP-1"}},
+		{name: "matches collected across pages", mspID: "Org1MSP", pageSize: "1", want: []string{"PROCESSED", "SHIP-1"}},
+		{name: "unknown MSP", mspID: "Org9MSP", want: []string{}},
+		{name: "missing MSP rejected", mspID: "", wantErr: "mspID"},
+		{name: "non-admin rejected", caller: func(e *testEnv) *testIdentity { return e.farmer }, mspID: "Org1MSP", wantErr: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			got := []string{}
+			bookmark := ""
+			for {
+				resp, err := e.cc.GetShipmentsByCreatorMSP(e.as(caller), tt.mspID, tt.pageSize, bookmark)
+				checkErr(t, err, tt.wantErr)
+				if err != nil {
+					return
+				}
+				got = append(got, shipmentIDs(resp)...)
+				if bookmark = resp.NextBookmark; bookmark == "" {
+					break
+				}
+			}
+			sort.Strings(got)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("shipments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	requireIndexFields(t, "indexObjectTypeCreatedByMspIsArchived", "objectType", "createdByMsp", "isArchived")
+}
+
+func TestCountShipmentsByStatus(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	empty, err := e.cc.CountShipmentsByStatus(e.as(e.farmer))
+	e.must(err)
+	if len(empty) != 14 || empty["total"] != 0 || empty[string(model.StatusCreated)] != 0 {
+		t.Fatalf("empty ledger counts = %v, want every status and total at 0", empty)
+	}
+
+	shipments := []struct {
+		id    string
+		setup func(e *testEnv, id string) // the shipment is created first
+		key   string                      // the count the shipment lands in
+	}{
+		{id: "CREATED", setup: func(e *testEnv, id string) {}, key: string(model.StatusCreated)},
+		{id: "PENDING", setup: func(e *testEnv, id string) { e.must(e.cc.SubmitForCertification(e.as(e.farmer), id)) }, key: string(model.StatusPendingCertification)},
+		{id: "CERTIFIED", setup: func(e *testEnv, id string) { e.certify(id) }, key: string(model.StatusCertified)},
+		{id: "PROCESSED-1", setup: func(e *testEnv, id string) { e.process(id) }, key: string(model.StatusProcessed)},
+		{id: "PROCESSED-2", setup: func(e *testEnv, id string) { e.process(id) }, key: string(model.StatusProcessed)},
+		{id: "DISTRIBUTED", setup: func(e *testEnv, id string) { e.process(id); e.distribute(id) }, key: string(model.StatusDistributed)},
+		{id: "DELIVERED", setup: func(e *testEnv, id string) { e.process(id); e.distribute(id); e.receive(id) }, key: string(model.StatusDelivered)},
+		{
+			id: "CONSUMED",
+			setup: func(e *testEnv, id string) {
+				e.process(id)
+				e.distribute(id)
+				e.receive(id)
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), id, ""))
+			},
+			key: string(model.StatusConsumed),
+		},
+		{
+			id: "DISPOSED",
+			setup: func(e *testEnv, id string) {
+				e.process(id)
+				e.must(e.cc.DisposeShipments(e.as(e.processor), `["`+id+`"]`, "Spoiled"))
+			},
+			key: string(model.StatusConsumedInProcessing),
+		},
+		{id: "VOIDED", setup: func(e *testEnv, id string) { e.must(e.cc.VoidShipment(e.as(e.farmer), id, "Entered twice")) }, key: string(model.StatusVoided)},
+		{
+			id: "ARCHIVED",
+			setup: func(e *testEnv, id string) {
+				shipment := e.shipment(id)
+				shipment.IsArchived = true
+				e.putShipment(shipment)
+			},
+			key: "archived",
+		},
+	}
+	want := map[string]int{}
+	for key := range empty {
+		want[key] = 0
+	}
+	for _, s := range shipments {
+		e.createShipment(s.id)
+		s.setup(e, s.id)
+		want[s.key]++
+		want["total"]++
+	}
+	ctx := e.as(e.admin)
+	malformedKey, err := e.cc.createShipmentCompositeKey(ctx, "MALFORMED")
+	e.must(err)
+	e.must(ctx.GetStub().PutState(malformedKey, []byte(`{"status":`)))
+
+	for _, caller := range []*testIdentity{e.admin, e.retailer, newTestIdentity("visitor", "Org9MSP")} {
+		got, err := e.cc.CountShipmentsByStatus(e.as(caller))
+		e.must(err)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: counts = %v, want %v", caller.alias, got, want)
+		}
+	}
+}
+
+func TestCountShipmentsByStatusIsNotCapped(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	ctx := e.as(e.admin)
+	for i := 0; i <= maxAggregateScan; i++ {
+		key, err := e.cc.createShipmentCompositeKey(ctx, fmt.Sprintf("SHIP-%05d", i))
+		e.must(err)
+		e.must(ctx.GetStub().PutState(key, []byte(`{"objectType":"Shipment","status":"CREATED"}`)))
+	}
+
+	got, err := e.cc.CountShipmentsByStatus(e.as(e.farmer))
+	e.must(err)
+	if got["total"] != maxAggregateScan+1 || got[string(model.StatusCreated)] != maxAggregateScan+1 {
+		t.Fatalf("counts = total %d, CREATED %d, want %d each", got["total"], got[string(model.StatusCreated)], maxAggregateScan+1)
+	}
+}
+
+func TestGetShipmentHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		pageSize string
+		bookmark func(total int) string
+		wantFrom func(total int) int
+		wantLen  func(total int) int
+		wantNext func(total int) string
+		wantErr  string
+	}{
+		{
+			name: "first page", id: "SHIP-1", pageSize: "2", bookmark: func(int) string { return "" },
+			wantFrom: func(int) int { return 0 }, wantLen: func(int) int { return 2 }, wantNext: func(int) string { return "2" },
+		},
+		{
+			name: "middle page", id: "SHIP-1", pageSize: "2", bookmark: func(int) string { return "1" },
+			wantFrom: func(int) int { return 1 }, wantLen: func(int) int { return 2 }, wantNext: func(int) string { return "3" },
+		},
+		{
+			name: "last page", id: "SHIP-1", pageSize: "2", bookmark: func(total int) string { return strconv.Itoa(total - 1) },
+			wantFrom: func(total int) int { return total - 1 }, wantLen: func(int) int { return 1 }, wantNext: func(int) string { return "" },
+		},
+		{
+			name: "page ending exactly at the last entry", id: "SHIP-1", pageSize: "2", bookmark: func(total int) string { return strconv.Itoa(total - 2) },
+			wantFrom: func(total int) int { return total - 2 }, wantLen: func(int) int { return 2 }, wantNext: func(int) string { return "" },
+		},
+		{
+			name: "whole history in one page", id: "SHIP-1", pageSize: "100", bookmark: func(int) string { return "" },
+			wantFrom: func(int) int { return 0 }, wantLen: func(total int) int { return total }, wantNext: func(int) string { return "" },
+		},
+		{
+			name: "bookmark past the end", id: "SHIP-1", pageSize: "2", bookmark: func(total int) string { return strconv.Itoa(total + 5) },
+			wantFrom: func(total int) int { return total }, wantLen: func(int) int { return 0 }, wantNext: func(int) string { return "" },
+		},
+		{name: "non-numeric bookmark rejected", id: "SHIP-1", pageSize: "2", bookmark: func(int) string { return "abc" }, wantErr: "invalid bookmark"},
+		{name: "negative bookmark rejected", id: "SHIP-1", pageSize: "2", bookmark: func(int) string { return "-1" }, wantErr: "invalid bookmark"},
+		{name: "unknown shipment", id: "SHIP-404", pageSize: "2", bookmark: func(int) string { return "" }, wantErr: "does not exist"},
+		{name: "empty shipment ID", id: "", pageSize: "2", bookmark: func(int) string { return "" }, wantErr: "shipmentID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.deliveredShipment("SHIP-1")
+			details, err := e.cc.GetShipmentPublicDetails(e.as(e.retailer), "SHIP-1", false)
+			e.must(err)
+			full := details.History
+			total := len(full)
+			if total < 4 {
+				t.Fatalf("history has %d entries, want at least one per stage", total)
+			}
+
+			got, err := e.cc.GetShipmentHistory(e.as(e.retailer), tt.id, tt.pageSize, tt.bookmark(total))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			from, wantLen := tt.wantFrom(total), tt.wantLen(total)
+			if len(got.Entries) != wantLen || int(got.FetchedCount) != wantLen {
+				t.Fatalf("page has %d entries (fetchedCount %d), want %d", len(got.Entries), got.FetchedCount, wantLen)
+			}
+			if got.NextBookmark != tt.wantNext(total) {
+				t.Fatalf("nextBookmark = %q, want %q", got.NextBookmark, tt.wantNext(total))
+			}
+			if got.TotalEntries != total {
+				t.Fatalf("totalEntries = %d, want %d", got.TotalEntries, total)
+			}
+			for i, entry := range got.Entries {
+				if want := full[from+i]; !reflect.DeepEqual(entry, want) {
+					t.Fatalf("entry %d = %+v, want %+v", i, entry, want)
+				}
+			}
+		})
+	}
+
+	t.Run("following bookmarks visits every entry once", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.deliveredShipment("SHIP-1")
+		details, err := e.cc.GetShipmentPublicDetails(e.as(e.retailer), "SHIP-1", false)
+		e.must(err)
+
+		txIDs := []string{}
+		bookmark := ""
+		for pages := 0; ; pages++ {
+			if pages > len(details.History) {
+				t.Fatal("pagination did not terminate")
+			}
+			page, err := e.cc.GetShipmentHistory(e.as(e.retailer), "SHIP-1", "3", bookmark)
+			e.must(err)
+			for _, entry := range page.Entries {
+				txIDs = append(txIDs, entry.TxID)
+			}
+			if bookmark = page.NextBookmark; bookmark == "" {
+				break
+			}
+		}
+		wantTxIDs := []string{}
+		for _, entry := range details.History {
+			wantTxIDs = append(wantTxIDs, entry.TxID)
+		}
+		if !equalStrings(txIDs, wantTxIDs) {
+			t.Fatalf("paged tx IDs = %v, want %v", txIDs, wantTxIDs)
+		}
+	})
+
+	t.Run("actor alias is resolved from the registry when the state lacks it", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.deliveredShipment("SHIP-1")
+		shipment := e.shipment("SHIP-1")
+		shipment.CurrentOwnerAlias = ""
+		e.putShipment(shipment)
+
+		got, err := e.cc.GetShipmentHistory(e.as(e.retailer), "SHIP-1", "100", "")
+		e.must(err)
+		last := got.Entries[len(got.Entries)-1]
+		if last.ActorID != e.retailer.id || last.ActorAlias != e.retailer.alias {
+			t.Fatalf("last entry actor = %s (%s), want %s (%s)", last.ActorID, last.ActorAlias, e.retailer.id, e.retailer.alias)
+		}
+		for i, entry := range got.Entries {
+			if entry.ActorAlias == "" {
+				t.Fatalf("entry %d has no actor alias", i)
+			}
+		}
+	})
+}
+
+func TestGetCustomAttributeKeys(t *testing.T) {
+	manyKeys := func(n int) map[string]string {
+		attrs := map[string]string{}
+		for i := 0; i < n; i++ {
+			attrs["key"+strconv.Itoa(i)] = "v"
+		}
+		return attrs
+	}
+	tests := []struct {
+		name    string
+		attrs   []map[string]string // custom attributes of each shipment on the ledger
+		caller  func(e *testEnv) *testIdentity
+		want    []string
+		wantErr string
+	}{
+		{name: "no shipments", want: []string{}},
+		{name: "shipments without attributes", attrs: []map[string]string{nil, {}}, want: []string{}},
+		{
+			name: "distinct keys across shipments are deduplicated and sorted",
+			attrs: []map[string]string{
+				{"variety": "Albion", "field": "F-7"},
+				nil,
+				{"variety": "Chandler", "packHouse": "PH-2"},
+				{"field": "F-9", "variety": "Albion", "grade": "A"},
+			},
+			want: []string{"field", "grade", "packHouse", "variety"},
+		},
+		{name: "keys at the cap", attrs: []map[string]string{manyKeys(maxCustomAttributeKeys - 1), {"key0": "v", "extra": "v"}}, want: append(sortedKeys(manyKeys(maxCustomAttributeKeys-1)), "extra")},
+		{name: "keys beyond the cap rejected", attrs: []map[string]string{manyKeys(maxCustomAttributeKeys), {"extra": "v"}}, wantErr: "distinct keys in use"},
+		{name: "non-admin rejected", attrs: []map[string]string{{"variety": "Albion"}}, caller: func(e *testEnv) *testIdentity { return e.farmer }, wantErr: "GetCustomAttributeKeys"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for i, attrs := range tt.attrs {
+				e.putShipment(&model.Shipment{
+					ObjectType: shipmentObjectType, ID: "SHIP-" + strconv.Itoa(i), Status: model.StatusCreated,
+					CurrentOwnerID: e.farmer.id, CustomAttributes: attrs,
+				})
+			}
+			caller := e.admin
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			got, err := e.cc.GetCustomAttributeKeys(e.as(caller))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			sort.Strings(tt.want)
+			if !equalStrings(got, tt.want) {
+				t.Fatalf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("malformed shipment record skipped", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.putShipment(&model.Shipment{ObjectType: shipmentObjectType, ID: "SHIP-1", CustomAttributes: map[string]string{"variety": "Albion"}})
+		ctx := e.as(e.admin)
+		key, err := e.cc.createShipmentCompositeKey(ctx, "SHIP-0")
+		e.must(err)
+		e.must(ctx.GetStub().PutState(key, []byte(`{"customAttributes":["not","a","map"]}`)))
+
+		got, err := e.cc.GetCustomAttributeKeys(e.as(e.admin))
+		checkErr(t, err, "")
+		if !equalStrings(got, []string{"variety"}) {
+			t.Fatalf("keys = %v, want [variety]", got)
+		}
+	})
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
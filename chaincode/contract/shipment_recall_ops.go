@@ -9,13 +9,95 @@ import (
 	"errors"
 	"fmt"
 	"foodtrace/model"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 // --- Lifecycle: Recall Operations ---
 
+// recallRegistryObjectType keys the RecallID registry. Attribute for composite key: RecallID.
+const recallRegistryObjectType = "RecallRegistry"
+
+// findUnregisteredRecallHolder returns the ID of a shipment other than excludeShipmentID whose RecallInfo
+// carries recallID, or "" if none. It covers recalls initiated before the recall registry existed. Without
+// CouchDB only the first maxAggregateScan shipments are checked.
+func (s *FoodtraceSmartContract) findUnregisteredRecallHolder(ctx contractapi.TransactionContextInterface, recallID, excludeShipmentID string) (string, error) {
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"objectType":          shipmentObjectType,
+			"recallInfo.recallId": recallID,
+		},
+		"use_index": "_design/indexObjectTypeRecallIdDoc",
+	}
+	queryBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal recallID query: %w", err)
+	}
+	resultsIterator, err := ctx.GetStub().GetQueryResult(string(queryBytes))
+	if err != nil {
+		logger.Warningf("findUnregisteredRecallHolder: CouchDB query failed: %v. Falling back to a key scan of the first %d shipments (SLOW).", err, maxAggregateScan)
+		resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(shipmentObjectType, []string{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get shipments iterator: %w", err)
+		}
+	}
+	defer resultsIterator.Close()
+
+	scanned := 0
+	for resultsIterator.HasNext() {
+		if scanned >= maxAggregateScan {
+			logger.Warningf("findUnregisteredRecallHolder: stopped after scanning %d shipments for recallID '%s'", maxAggregateScan, recallID)
+			break
+		}
+		resp, iterErr := resultsIterator.Next()
+		if iterErr != nil {
+			return "", fmt.Errorf("error iterating shipments: %w", iterErr)
+		}
+		scanned++
+		var ship struct {
+			ID         string `json:"id"`
+			RecallInfo *struct {
+				RecallID string `json:"recallId"`
+			} `json:"recallInfo"`
+		}
+		if err := json.Unmarshal(resp.Value, &ship); err != nil {
+			logger.Warningf("findUnregisteredRecallHolder: Error unmarshalling shipment: %v. Skipping.", err)
+			continue
+		}
+		if ship.ID != excludeShipmentID && ship.RecallInfo != nil && ship.RecallInfo.RecallID == recallID {
+			return ship.ID, nil
+		}
+	}
+	return "", nil
+}
+
 func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionContextInterface, shipmentID, recallID, reason string) error {
+	return s.initiateRecall(ctx, shipmentID, recallID, reason, "", nil)
+}
+
+// InitiateRecallWithSeverity initiates a classified recall and links any provided shipments in the same transaction.
+// A CLASS1 recall with no linked shipments flags impactAdvisory on the ShipmentRecalled event, prompting a downstream-impact check.
+func (s *FoodtraceSmartContract) InitiateRecallWithSeverity(ctx contractapi.TransactionContextInterface, shipmentID, recallID, reason, severity, linkedShipmentIDsJSON string) error {
+	recallSeverity, err := parseRecallSeverity(severity)
+	if err != nil {
+		return err
+	}
+	linkedShipmentIDs := []string{}
+	if strings.TrimSpace(linkedShipmentIDsJSON) != "" {
+		if err := json.Unmarshal([]byte(linkedShipmentIDsJSON), &linkedShipmentIDs); err != nil {
+			return fmt.Errorf("invalid linkedShipmentIDsJSON: %w", err)
+		}
+	}
+	if len(linkedShipmentIDs) > maxArrayElements {
+		return fmt.Errorf("number of linked shipment IDs (%d) exceeds maximum of %d", len(linkedShipmentIDs), maxArrayElements)
+	}
+	return s.initiateRecall(ctx, shipmentID, recallID, reason, recallSeverity, linkedShipmentIDs)
+}
+
+// initiateRecall marks a shipment as recalled, optionally classifying it and linking other shipments.
+func (s *FoodtraceSmartContract) initiateRecall(ctx contractapi.TransactionContextInterface, shipmentID, recallID, reason string, severity model.RecallSeverity, linkedShipmentIDs []string) error {
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("InitiateRecall: failed to get actor info: %w", err)
@@ -28,7 +110,11 @@ func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionConte
 	if err := s.validateRequiredString(recallID, "recallID", maxStringInputLength); err != nil {
 		return err
 	}
-	if err := s.validateRequiredString(reason, "reason", maxRecallReasonLength); err != nil {
+	maxLen, err := fieldMaxLength(ctx, fieldLimitRecallReason)
+	if err != nil {
+		return fmt.Errorf("InitiateRecall: failed to read length limit: %w", err)
+	}
+	if err := s.validateRequiredString(reason, "reason", maxLen); err != nil {
 		return err
 	}
 
@@ -47,6 +133,12 @@ func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionConte
 		return fmt.Errorf("unauthorized: only admin or current owner ('%s', alias '%s') can initiate recall for shipment '%s'", shipment.CurrentOwnerID, ownerAlias, shipmentID)
 	}
 
+	if shipment.IsArchived {
+		return fmt.Errorf("shipment '%s' is archived and cannot be recalled; unarchive it first (UnarchiveShipment)", shipmentID)
+	}
+	if shipment.Status == model.StatusDestroyed {
+		return fmt.Errorf("shipment '%s' has been destroyed and cannot be recalled again", shipmentID)
+	}
 	if shipment.RecallInfo.IsRecalled {
 		if shipment.RecallInfo.RecallID == recallID {
 			return fmt.Errorf("shipment '%s' is already part of this specific recall event '%s'", shipmentID, recallID)
@@ -54,10 +146,48 @@ func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionConte
 		logger.Warningf("Shipment '%s' was already recalled under recallID '%s'. This action will register an additional recall event '%s' or update details if applicable.", shipmentID, shipment.RecallInfo.RecallID, recallID)
 	}
 
+	registryKey, err := ctx.GetStub().CreateCompositeKey(recallRegistryObjectType, []string{recallID})
+	if err != nil {
+		return fmt.Errorf("InitiateRecall: failed to create recall registry key for '%s': %w", recallID, err)
+	}
+	registryBytes, err := ctx.GetStub().GetState(registryKey)
+	if err != nil {
+		return fmt.Errorf("InitiateRecall: failed to read recall registry for '%s': %w", recallID, err)
+	}
+	if registryBytes != nil {
+		var entry model.RecallRegistryEntry
+		if err := json.Unmarshal(registryBytes, &entry); err != nil {
+			return fmt.Errorf("InitiateRecall: failed to unmarshal recall registry entry for '%s': %w", recallID, err)
+		}
+		if entry.PrimaryShipmentID != shipmentID {
+			return fmt.Errorf("recallID '%s' is already in use for primary shipment '%s'; use AddLinkedShipmentsToRecall to add shipment '%s' to that recall, or choose a new recallID", recallID, entry.PrimaryShipmentID, shipmentID)
+		}
+	} else {
+		// Recalls started before the registry existed are only recorded on the shipments themselves.
+		holder, errHolder := s.findUnregisteredRecallHolder(ctx, recallID, shipmentID)
+		if errHolder != nil {
+			return fmt.Errorf("InitiateRecall: %w", errHolder)
+		}
+		if holder != "" {
+			return fmt.Errorf("recallID '%s' is already in use on shipment '%s'; use AddLinkedShipmentsToRecall to add shipment '%s' to that recall, or choose a new recallID", recallID, holder, shipmentID)
+		}
+	}
+
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
 		return fmt.Errorf("InitiateRecall: failed to get transaction timestamp: %w", err)
 	}
+	if registryBytes == nil {
+		entryBytes, err := json.Marshal(model.RecallRegistryEntry{
+			RecallID: recallID, PrimaryShipmentID: shipmentID, InitiatedBy: actor.fullID, InitiatedAt: now,
+		})
+		if err != nil {
+			return fmt.Errorf("InitiateRecall: failed to marshal recall registry entry for '%s': %w", recallID, err)
+		}
+		if err := ctx.GetStub().PutState(registryKey, entryBytes); err != nil {
+			return fmt.Errorf("InitiateRecall: failed to register recallID '%s': %w", recallID, err)
+		}
+	}
 
 	shipment.RecallInfo.IsRecalled = true
 	shipment.RecallInfo.RecallID = recallID
@@ -65,11 +195,18 @@ func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionConte
 	shipment.RecallInfo.RecallDate = now
 	shipment.RecallInfo.RecalledBy = actor.fullID
 	shipment.RecallInfo.RecalledByAlias = actor.alias
+	if severity != "" {
+		// A re-recall without a classification keeps the severity already on record
+		shipment.RecallInfo.Severity = severity
+	}
 
 	shipment.Status = model.StatusRecalled
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 	ensureShipmentSchemaCompliance(shipment) // Ensure sub-fields are initialized
 
+	// The ShipmentRecalled event below already lists the linked shipments
+	newlyLinked := s.linkShipmentsToRecall(ctx, actor, shipment, linkedShipmentIDs, now, false)
+
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
 	updatedBytes, err := json.Marshal(shipment)
 	if err != nil {
@@ -79,12 +216,41 @@ func (s *FoodtraceSmartContract) InitiateRecall(ctx contractapi.TransactionConte
 		return fmt.Errorf("InitiateRecall: failed to save recalled shipment '%s' to ledger: %w", shipmentID, err)
 	}
 
-	s.emitShipmentEvent(ctx, "ShipmentRecalled", shipment, actor, map[string]interface{}{"recallId": recallID, "reason": reason})
-	logger.Infof("Shipment '%s' recalled by '%s' (RecallID: %s)", shipmentID, actor.alias, recallID)
+	recallPayload := map[string]interface{}{"recallId": recallID, "reason": reason}
+	if severity != "" {
+		recallPayload["severity"] = severity
+		recallPayload["linkedShipmentIds"] = newlyLinked
+	}
+	if severity == model.RecallSeverityClass1 && len(newlyLinked) == 0 {
+		logger.Warningf("InitiateRecall: CLASS1 recall '%s' on shipment '%s' was initiated without linked shipments. Downstream impact should be assessed.", recallID, shipmentID)
+		// Carried on ShipmentRecalled itself: Fabric keeps only the last event set in a transaction.
+		recallPayload["impactAdvisory"] = true
+		recallPayload["advisory"] = "CLASS1 recall initiated without linked shipments; run QueryRelatedShipments and AddLinkedShipmentsToRecall to cover downstream impact"
+	}
+	s.emitShipmentEvent(ctx, "ShipmentRecalled", shipment, actor, recallPayload)
+	logger.Infof("Shipment '%s' recalled by '%s' (RecallID: %s, severity: '%s', linked: %d)", shipmentID, actor.alias, recallID, severity, len(newlyLinked))
 	return nil
 }
 
-func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.TransactionContextInterface, primaryRecallID, primaryShipmentID string, linkedShipmentIDsJSON string) error {
+// parseRecallSeverity validates a recall severity string. An empty string means unclassified.
+func parseRecallSeverity(severity string) (model.RecallSeverity, error) {
+	switch strings.ToUpper(strings.TrimSpace(severity)) {
+	case "":
+		return "", nil
+	case string(model.RecallSeverityClass1):
+		return model.RecallSeverityClass1, nil
+	case string(model.RecallSeverityClass2):
+		return model.RecallSeverityClass2, nil
+	case string(model.RecallSeverityClass3):
+		return model.RecallSeverityClass3, nil
+	default:
+		return "", fmt.Errorf("invalid recall severity '%s'. Must be one of: %s, %s, %s", severity, model.RecallSeverityClass1, model.RecallSeverityClass2, model.RecallSeverityClass3)
+	}
+}
+
+// AddLinkedShipmentsToRecall marks further shipments as recalled under an existing recall. With
+// aggregateEvents set, a single BulkRecallCompleted event replaces the per-shipment ShipmentRecalled events.
+func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.TransactionContextInterface, primaryRecallID, primaryShipmentID string, linkedShipmentIDsJSON string, aggregateEvents bool) error {
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("AddLinkedShipmentsToRecall: failed to get actor info: %w", err)
@@ -129,8 +295,42 @@ func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.Tran
 		return fmt.Errorf("AddLinkedShipmentsToRecall: failed to get transaction timestamp: %w", err)
 	}
 
-	newlyLinkedCount := 0
-	var actualNewlyLinkedIDsForPrimary []string
+	actualNewlyLinkedIDsForPrimary := s.linkShipmentsToRecall(ctx, actor, pShipment, linkedShipmentIDs, now, !aggregateEvents)
+	newlyLinkedCount := len(actualNewlyLinkedIDsForPrimary)
+
+	if newlyLinkedCount > 0 {
+		touchShipment(pShipment, now)
+		pShipKey, _ := s.createShipmentCompositeKey(ctx, primaryShipmentID)
+		pShipBytes, marshErr := json.Marshal(pShipment)
+		if marshErr != nil {
+			logger.Errorf("CRITICAL: AddLinkedShipmentsToRecall: Failed to marshal primary shipment '%s' after updating its linked IDs list: %v.", primaryShipmentID, marshErr)
+		} else {
+			if errPut := ctx.GetStub().PutState(pShipKey, pShipBytes); errPut != nil {
+				logger.Errorf("CRITICAL: AddLinkedShipmentsToRecall: Failed to save primary shipment '%s' after updating its linked IDs list: %v.", primaryShipmentID, errPut)
+			}
+		}
+	}
+	if aggregateEvents {
+		s.emitShipmentEvent(ctx, "BulkRecallCompleted", pShipment, actor, map[string]interface{}{
+			"recallId":          primaryRecallID,
+			"linkedShipmentIds": actualNewlyLinkedIDsForPrimary,
+			"requestedCount":    len(linkedShipmentIDs),
+			"linkedCount":       newlyLinkedCount,
+			"skippedCount":      len(linkedShipmentIDs) - newlyLinkedCount,
+		})
+	}
+	logger.Infof("AddLinkedShipmentsToRecall: Processed %d IDs; successfully linked %d new unique shipments to recall event '%s' for primary shipment '%s'", len(linkedShipmentIDs), newlyLinkedCount, primaryRecallID, primaryShipmentID)
+	return nil
+}
+
+// linkShipmentsToRecall marks each linked shipment as recalled under the primary shipment's recall and
+// records them on the in-memory primary shipment. The caller is responsible for saving the primary.
+// Invalid or missing linked shipments are skipped with a warning. A ShipmentRecalled event is emitted per
+// linked shipment only when emitPerItem is set. Returns the IDs that were newly linked.
+func (s *FoodtraceSmartContract) linkShipmentsToRecall(ctx contractapi.TransactionContextInterface, actor *actorInfo, pShipment *model.Shipment, linkedShipmentIDs []string, now time.Time, emitPerItem bool) []string {
+	newlyLinkedIDs := []string{}
+	primaryRecallID := pShipment.RecallInfo.RecallID
+	primaryShipmentID := pShipment.ID
 
 	for _, linkedID := range linkedShipmentIDs {
 		if errVal := s.validateRequiredString(linkedID, "linkedShipmentID in array", maxStringInputLength); errVal != nil {
@@ -148,6 +348,14 @@ func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.Tran
 			continue
 		}
 
+		if lShip.Status == model.StatusDestroyed {
+			logger.Infof("AddLinkedShipmentsToRecall: Linked shipment '%s' has been destroyed. Skipping.", linkedID)
+			continue
+		}
+		if lShip.IsArchived {
+			logger.Warningf("AddLinkedShipmentsToRecall: Linked shipment '%s' is archived and cannot be recalled; unarchive it first. Skipping.", linkedID)
+			continue
+		}
 		if lShip.RecallInfo.IsRecalled && lShip.RecallInfo.RecallID == primaryRecallID {
 			logger.Infof("AddLinkedShipmentsToRecall: Linked shipment '%s' already part of recall '%s'. Skipping.", linkedID, primaryRecallID)
 			continue
@@ -162,8 +370,9 @@ func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.Tran
 		lShip.RecallInfo.RecallDate = now
 		lShip.RecallInfo.RecalledBy = actor.fullID
 		lShip.RecallInfo.RecalledByAlias = actor.alias
+		lShip.RecallInfo.Severity = pShipment.RecallInfo.Severity
 		lShip.Status = model.StatusRecalled
-		lShip.LastUpdatedAt = now
+		touchShipment(lShip, now)
 		ensureShipmentSchemaCompliance(lShip) // Ensure sub-fields are initialized
 
 		lShipKey, keyErr := s.createShipmentCompositeKey(ctx, linkedID)
@@ -180,42 +389,88 @@ func (s *FoodtraceSmartContract) AddLinkedShipmentsToRecall(ctx contractapi.Tran
 			logger.Warningf("AddLinkedShipmentsToRecall: Failed to save recalled linked shipment '%s': %v. Skipping.", linkedID, errPut)
 			continue
 		}
-		s.emitShipmentEvent(ctx, "ShipmentRecalled", lShip, actor, map[string]interface{}{
-			"recallId": primaryRecallID, "reason": lShip.RecallInfo.RecallReason,
-			"linkedToPrimaryShipment": primaryShipmentID, "linkOperationBy": actor.fullID,
-		})
-		actualNewlyLinkedIDsForPrimary = append(actualNewlyLinkedIDsForPrimary, linkedID)
-		newlyLinkedCount++
+		if emitPerItem {
+			s.emitShipmentEvent(ctx, "ShipmentRecalled", lShip, actor, map[string]interface{}{
+				"recallId": primaryRecallID, "reason": lShip.RecallInfo.RecallReason,
+				"linkedToPrimaryShipment": primaryShipmentID, "linkOperationBy": actor.fullID,
+			})
+		}
+		newlyLinkedIDs = append(newlyLinkedIDs, linkedID)
 		logger.Infof("AddLinkedShipmentsToRecall: Linked shipment '%s' marked as recalled under event '%s'", linkedID, primaryRecallID)
 	}
 
-	if newlyLinkedCount > 0 {
-		currentLinksOnPrimary := make(map[string]bool)
-		for _, id := range pShipment.RecallInfo.LinkedShipmentIDs {
-			currentLinksOnPrimary[id] = true
+	currentLinksOnPrimary := make(map[string]bool)
+	for _, id := range pShipment.RecallInfo.LinkedShipmentIDs {
+		currentLinksOnPrimary[id] = true
+	}
+	for _, newLinkID := range newlyLinkedIDs {
+		if !currentLinksOnPrimary[newLinkID] {
+			pShipment.RecallInfo.LinkedShipmentIDs = append(pShipment.RecallInfo.LinkedShipmentIDs, newLinkID)
 		}
+	}
+	return newlyLinkedIDs
+}
 
-		addedToPrimaryList := false
-		for _, newLinkID := range actualNewlyLinkedIDsForPrimary {
-			if !currentLinksOnPrimary[newLinkID] {
-				pShipment.RecallInfo.LinkedShipmentIDs = append(pShipment.RecallInfo.LinkedShipmentIDs, newLinkID)
-				addedToPrimaryList = true
-			}
-		}
+// RecordDestruction logs the destruction of a recalled shipment's goods. The shipment moves to
+// DESTROYED, which is terminal. Callable by admin or the current owner.
+func (s *FoodtraceSmartContract) RecordDestruction(ctx contractapi.TransactionContextInterface, shipmentID, method, note string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("RecordDestruction: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
 
-		if addedToPrimaryList {
-			pShipment.LastUpdatedAt = now
-			pShipKey, _ := s.createShipmentCompositeKey(ctx, primaryShipmentID)
-			pShipBytes, marshErr := json.Marshal(pShipment)
-			if marshErr != nil {
-				logger.Errorf("CRITICAL: AddLinkedShipmentsToRecall: Failed to marshal primary shipment '%s' after updating its linked IDs list: %v.", primaryShipmentID, marshErr)
-			} else {
-				if errPut := ctx.GetStub().PutState(pShipKey, pShipBytes); errPut != nil {
-					logger.Errorf("CRITICAL: AddLinkedShipmentsToRecall: Failed to save primary shipment '%s' after updating its linked IDs list: %v.", primaryShipmentID, errPut)
-				}
-			}
-		}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
 	}
-	logger.Infof("AddLinkedShipmentsToRecall: Processed %d IDs; successfully linked %d new unique shipments to recall event '%s' for primary shipment '%s'", len(linkedShipmentIDs), newlyLinkedCount, primaryRecallID, primaryShipmentID)
+	if err := s.validateRequiredString(method, "method", maxStringInputLength); err != nil {
+		return err
+	}
+	if err := s.validateOptionalString(note, "note", maxDescriptionLength); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("RecordDestruction: %w", err)
+	}
+	isCallerAdmin, _ := im.IsCurrentUserAdmin()
+	if !isCallerAdmin && shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only admin or current owner ('%s', alias '%s') can record destruction of shipment '%s'", shipment.CurrentOwnerID, shipment.CurrentOwnerAlias, shipmentID)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("RecordDestruction: %w", err)
+	}
+	if shipment.Status != model.StatusRecalled {
+		return fmt.Errorf("shipment '%s' cannot be destroyed. Current status: '%s'. Expected '%s'", shipmentID, shipment.Status, model.StatusRecalled)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("RecordDestruction: failed to get transaction timestamp: %w", err)
+	}
+	shipment.Status = model.StatusDestroyed
+	shipment.DestructionInfo = &model.DestructionInfo{
+		Method:           method,
+		Note:             note,
+		DestroyedBy:      actor.fullID,
+		DestroyedByAlias: actor.alias,
+		DestroyedAt:      now,
+	}
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("RecordDestruction: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("RecordDestruction: failed to save shipment '%s': %w", shipmentID, err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentDestroyed", shipment, actor, map[string]interface{}{
+		"method": method, "note": note, "recallId": shipment.RecallInfo.RecallID,
+	})
+	logger.Infof("Shipment '%s' destroyed (%s) by '%s'", shipmentID, method, actor.alias)
 	return nil
 }
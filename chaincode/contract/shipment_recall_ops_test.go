@@ -0,0 +1,395 @@
+package contract
+
+import (
+	"encoding/json"
+	"testing"
+
+	"foodtrace/model"
+)
+
+func TestInitiateRecallWithSeverityImpactAdvisory(t *testing.T) {
+	tests := []struct {
+		name         string
+		severity     string
+		linkedJSON   string
+		wantAdvisory bool
+		wantLinked   int
+		wantErr      string
+	}{
+		{name: "class1 without linked shipments", severity: "class1", wantAdvisory: true},
+		{name: "class1 with only unknown linked shipments", severity: "CLASS1", linkedJSON: `["MISSING"]`, wantAdvisory: true},
+		{name: "class1 with linked shipments", severity: "CLASS1", linkedJSON: `["SHIP-2"]`, wantLinked: 1},
+		{name: "class2 without linked shipments", severity: "CLASS2"},
+		{name: "invalid severity", severity: "CLASS4", wantErr: "invalid recall severity"},
+		{name: "invalid linked JSON", severity: "CLASS1", linkedJSON: `SHIP-2`, wantErr: "invalid linkedShipmentIDsJSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.createShipment("SHIP-2")
+
+			err := e.cc.InitiateRecallWithSeverity(e.as(e.admin), "SHIP-1", "RC-1", "Listeria detected", tt.severity, tt.linkedJSON)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentRecalled" {
+				t.Fatalf("last event = %s, want ShipmentRecalled", name)
+			}
+			if advisory := payload["impactAdvisory"] == true; advisory != tt.wantAdvisory {
+				t.Fatalf("impactAdvisory = %v, want %t", payload["impactAdvisory"], tt.wantAdvisory)
+			}
+			if tt.wantAdvisory && payload["advisory"] == "" {
+				t.Fatal("advisory text missing")
+			}
+			if linked, _ := payload["linkedShipmentIds"].([]interface{}); len(linked) != tt.wantLinked {
+				t.Fatalf("linkedShipmentIds = %v, want %d entries", payload["linkedShipmentIds"], tt.wantLinked)
+			}
+			if tt.wantLinked > 0 && e.shipment("SHIP-2").Status != model.StatusRecalled {
+				t.Fatal("linked shipment was not recalled")
+			}
+		})
+	}
+}
+
+func TestInitiateRecallReRecallSeverity(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       func(e *testEnv) error
+		wantSeverity model.RecallSeverity
+	}{
+		{
+			name: "re-recall without severity keeps it",
+			action: func(e *testEnv) error {
+				return e.cc.InitiateRecall(e.as(e.admin), "SHIP-1", "RC-2", "Second contamination")
+			},
+			wantSeverity: model.RecallSeverityClass1,
+		},
+		{
+			name: "re-recall with severity replaces it",
+			action: func(e *testEnv) error {
+				return e.cc.InitiateRecallWithSeverity(e.as(e.admin), "SHIP-1", "RC-2", "Second contamination", "CLASS2", "")
+			},
+			wantSeverity: model.RecallSeverityClass2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.must(e.cc.InitiateRecallWithSeverity(e.as(e.admin), "SHIP-1", "RC-1", "Listeria detected", "CLASS1", ""))
+
+			e.must(tt.action(e))
+			recall := e.shipment("SHIP-1").RecallInfo
+			if recall.RecallID != "RC-2" || recall.Severity != tt.wantSeverity {
+				t.Fatalf("recall = %+v, want RC-2 with severity %q", recall, tt.wantSeverity)
+			}
+		})
+	}
+}
+
+func TestRecordDestruction(t *testing.T) {
+	tests := []struct {
+		name    string
+		caller  func(e *testEnv) *testIdentity
+		recall  bool
+		method  string
+		wantErr string
+	}{
+		{name: "owner destroys recalled shipment", recall: true, method: "Incineration"},
+		{name: "admin destroys recalled shipment", caller: func(e *testEnv) *testIdentity { return e.admin }, recall: true, method: "Landfill"},
+		{name: "non-recalled shipment rejected", method: "Incineration", wantErr: "cannot be destroyed. Current status: 'CREATED'"},
+		{name: "non-owner rejected", caller: func(e *testEnv) *testIdentity { return e.retailer }, recall: true, method: "Incineration", wantErr: "only admin or current owner"},
+		{name: "method required", recall: true, method: " ", wantErr: "method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.recall {
+				e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1").Status
+
+			err := e.cc.RecordDestruction(e.as(caller), "SHIP-1", tt.method, "Witnessed by inspector")
+			checkErr(t, err, tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != before || shipment.DestructionInfo != nil {
+					t.Fatalf("rejected destruction changed the shipment: status %s, destruction %+v", shipment.Status, shipment.DestructionInfo)
+				}
+				return
+			}
+
+			info := shipment.DestructionInfo
+			if shipment.Status != model.StatusDestroyed || info == nil {
+				t.Fatalf("status = %s, destruction = %+v; want DESTROYED with details", shipment.Status, info)
+			}
+			if info.Method != tt.method || info.Note != "Witnessed by inspector" || info.DestroyedBy != caller.id || info.DestroyedByAlias != caller.alias || !info.DestroyedAt.Equal(e.now) {
+				t.Fatalf("destruction = %+v", info)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentDestroyed" || payload["method"] != tt.method || payload["recallId"] != "RC-1" {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+
+	t.Run("destroyed shipments are terminal", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+		e.must(e.cc.RecordDestruction(e.as(e.admin), "SHIP-1", "Incineration", ""))
+
+		checkErr(t, e.cc.RecordDestruction(e.as(e.admin), "SHIP-1", "Landfill", ""), "has been destroyed")
+		checkErr(t, e.cc.InitiateRecall(e.as(e.admin), "SHIP-1", "RC-2", "Second recall"), "has been destroyed")
+		if info := e.shipment("SHIP-1").DestructionInfo; info.Method != "Incineration" {
+			t.Fatalf("destruction method = %s, want the original one", info.Method)
+		}
+	})
+
+	t.Run("destroyed shipments leave inventory", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.createShipment("SHIP-2")
+		e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+		e.must(e.cc.RecordDestruction(e.as(e.farmer), "SHIP-1", "Incineration", ""))
+		totals, err := e.cc.GetInventoryByProduct(e.as(e.admin), "Strawberries")
+		checkErr(t, err, "")
+		if len(totals) != 1 || totals["kg"] != 100 {
+			t.Fatalf("inventory = %v, want only SHIP-2's 100 kg", totals)
+		}
+	})
+}
+
+// txEventNames lists the events set during the current transaction, in order.
+func (e *testEnv) txEventNames() []string {
+	names := []string{}
+	for _, ev := range e.stub.events {
+		names = append(names, ev.EventName)
+	}
+	return names
+}
+
+func TestAddLinkedShipmentsToRecallEventAggregation(t *testing.T) {
+	tests := []struct {
+		name       string
+		aggregate  bool
+		wantEvents []string
+	}{
+		{name: "per-item events", aggregate: false, wantEvents: []string{"ShipmentRecalled", "ShipmentRecalled"}},
+		{name: "aggregated event", aggregate: true, wantEvents: []string{"BulkRecallCompleted"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3"} {
+				e.createShipment(id)
+			}
+			e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+
+			e.must(e.cc.AddLinkedShipmentsToRecall(e.as(e.farmer), "RC-1", "SHIP-1", `["SHIP-2","MISSING","SHIP-3"]`, tt.aggregate))
+			if got := e.txEventNames(); !equalStrings(got, tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", got, tt.wantEvents)
+			}
+			for _, id := range []string{"SHIP-2", "SHIP-3"} {
+				if recall := e.shipment(id).RecallInfo; !recall.IsRecalled || recall.RecallID != "RC-1" {
+					t.Fatalf("%s recall = %+v, want recalled under RC-1", id, recall)
+				}
+			}
+			if !tt.aggregate {
+				return
+			}
+			_, payload := e.lastEvent()
+			linked, _ := payload["linkedShipmentIds"].([]interface{})
+			if len(linked) != 2 || linked[0] != "SHIP-2" || linked[1] != "SHIP-3" {
+				t.Fatalf("linkedShipmentIds = %v, want [SHIP-2 SHIP-3]", payload["linkedShipmentIds"])
+			}
+			if payload["recallId"] != "RC-1" || payload["requestedCount"] != 3.0 || payload["linkedCount"] != 2.0 || payload["skippedCount"] != 1.0 {
+				t.Fatalf("payload = %v", payload)
+			}
+		})
+	}
+}
+
+func TestInitiateRecallOnArchivedShipment(t *testing.T) {
+	tests := []struct {
+		name       string
+		archived   bool
+		unarchived bool
+		caller     func(e *testEnv) *testIdentity
+		wantErr    string
+	}{
+		{name: "owner recalls active shipment"},
+		{name: "admin recalls active shipment", caller: func(e *testEnv) *testIdentity { return e.admin }},
+		{name: "owner recalls archived shipment", archived: true, wantErr: "shipment 'SHIP-1' is archived and cannot be recalled; unarchive it first"},
+		{name: "admin recalls archived shipment", archived: true, caller: func(e *testEnv) *testIdentity { return e.admin }, wantErr: "is archived and cannot be recalled"},
+		{name: "owner recalls unarchived shipment", archived: true, unarchived: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			if tt.archived {
+				e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-1", "Closed out"))
+			}
+			if tt.unarchived {
+				e.must(e.cc.UnarchiveShipment(e.as(e.admin), "SHIP-1"))
+			}
+			caller := e.farmer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+
+			checkErr(t, e.cc.InitiateRecall(e.as(caller), "SHIP-1", "RC-1", "Listeria detected"), tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if recalled := shipment.RecallInfo.IsRecalled; recalled != (tt.wantErr == "") {
+				t.Fatalf("isRecalled = %t after recall with error %q", recalled, tt.wantErr)
+			}
+			if tt.wantErr != "" && (shipment.Status != model.StatusCreated || !shipment.IsArchived) {
+				t.Fatalf("rejected recall changed the shipment: status %s, archived %t", shipment.Status, shipment.IsArchived)
+			}
+		})
+	}
+}
+
+func TestRecallSkipsArchivedLinkedShipments(t *testing.T) {
+	tests := []struct {
+		name   string
+		recall func(e *testEnv) error
+	}{
+		{
+			name: "InitiateRecallWithSeverity",
+			recall: func(e *testEnv) error {
+				return e.cc.InitiateRecallWithSeverity(e.as(e.admin), "SHIP-1", "RC-1", "Listeria detected", "CLASS2", `["SHIP-2","SHIP-3"]`)
+			},
+		},
+		{
+			name: "AddLinkedShipmentsToRecall",
+			recall: func(e *testEnv) error {
+				e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+				return e.cc.AddLinkedShipmentsToRecall(e.as(e.farmer), "RC-1", "SHIP-1", `["SHIP-2","SHIP-3"]`, false)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3"} {
+				e.createShipment(id)
+			}
+			e.must(e.cc.ArchiveShipment(e.as(e.admin), "SHIP-2", "Closed out"))
+
+			e.must(tt.recall(e))
+			if archived := e.shipment("SHIP-2"); archived.RecallInfo.IsRecalled || archived.Status != model.StatusCreated {
+				t.Fatalf("archived SHIP-2 was recalled: status %s, recall %+v", archived.Status, archived.RecallInfo)
+			}
+			if recall := e.shipment("SHIP-3").RecallInfo; !recall.IsRecalled || recall.RecallID != "RC-1" {
+				t.Fatalf("SHIP-3 recall = %+v, want recalled under RC-1", recall)
+			}
+			if linked := e.shipment("SHIP-1").RecallInfo.LinkedShipmentIDs; !equalStrings(linked, []string{"SHIP-3"}) {
+				t.Fatalf("linked shipments = %v, want [SHIP-3]", linked)
+			}
+		})
+	}
+}
+
+func TestInitiateRecallIDUniqueness(t *testing.T) {
+	legacyRecall := func(e *testEnv) { // SHIP-3 was recalled under RC-9 before the registry existed
+		shipment := e.shipment("SHIP-3")
+		shipment.RecallInfo.IsRecalled = true
+		shipment.RecallInfo.RecallID = "RC-9"
+		shipment.Status = model.StatusRecalled
+		e.putShipment(shipment)
+	}
+	tests := []struct {
+		name         string
+		setup        func(e *testEnv) // runs after SHIP-1 is recalled under RC-1
+		action       func(e *testEnv) error
+		wantErr      string
+		wantRecallID string // of SHIP-2 afterwards; "" means not recalled
+		wantPrimary  map[string]string
+	}{
+		{
+			name:         "new recall ID",
+			action:       func(e *testEnv) error { return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-2", "RC-2", "Mould") },
+			wantRecallID: "RC-2",
+			wantPrimary:  map[string]string{"RC-1": "SHIP-1", "RC-2": "SHIP-2"},
+		},
+		{
+			name:        "recall ID reused for an unrelated shipment rejected",
+			action:      func(e *testEnv) error { return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-2", "RC-1", "Mould") },
+			wantErr:     "recallID 'RC-1' is already in use for primary shipment 'SHIP-1'; use AddLinkedShipmentsToRecall",
+			wantPrimary: map[string]string{"RC-1": "SHIP-1"},
+		},
+		{
+			name: "admin reuse rejected too",
+			action: func(e *testEnv) error {
+				return e.cc.InitiateRecall(e.as(e.admin), "SHIP-2", "RC-1", "Mould")
+			},
+			wantErr:     "already in use for primary shipment 'SHIP-1'",
+			wantPrimary: map[string]string{"RC-1": "SHIP-1"},
+		},
+		{
+			name: "linking to the existing recall",
+			action: func(e *testEnv) error {
+				return e.cc.AddLinkedShipmentsToRecall(e.as(e.farmer), "RC-1", "SHIP-1", `["SHIP-2"]`, false)
+			},
+			wantRecallID: "RC-1",
+			wantPrimary:  map[string]string{"RC-1": "SHIP-1"},
+		},
+		{
+			name: "additional recall of the primary",
+			action: func(e *testEnv) error {
+				return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-2", "Second contamination")
+			},
+			wantPrimary: map[string]string{"RC-1": "SHIP-1", "RC-2": "SHIP-1"},
+		},
+		{
+			name:    "recall ID held by a pre-registry recall rejected",
+			setup:   legacyRecall,
+			action:  func(e *testEnv) error { return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-2", "RC-9", "Mould") },
+			wantErr: "recallID 'RC-9' is already in use on shipment 'SHIP-3'",
+		},
+		{
+			name:    "pre-registry recall found without CouchDB",
+			setup:   func(e *testEnv) { legacyRecall(e); e.stub.noCouch = true },
+			action:  func(e *testEnv) error { return e.cc.InitiateRecall(e.as(e.farmer), "SHIP-2", "RC-9", "Mould") },
+			wantErr: "recallID 'RC-9' is already in use on shipment 'SHIP-3'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			for _, id := range []string{"SHIP-1", "SHIP-2", "SHIP-3"} {
+				e.createShipment(id)
+			}
+			e.must(e.cc.InitiateRecall(e.as(e.farmer), "SHIP-1", "RC-1", "Listeria detected"))
+			if tt.setup != nil {
+				tt.setup(e)
+			}
+
+			checkErr(t, tt.action(e), tt.wantErr)
+			if recall := e.shipment("SHIP-2").RecallInfo; recall.IsRecalled != (tt.wantRecallID != "") || recall.RecallID != tt.wantRecallID {
+				t.Fatalf("SHIP-2 recall = %+v, want recall ID %q", recall, tt.wantRecallID)
+			}
+			ctx := e.as(e.admin)
+			for recallID, primary := range tt.wantPrimary {
+				key, err := ctx.GetStub().CreateCompositeKey(recallRegistryObjectType, []string{recallID})
+				e.must(err)
+				entryBytes, err := ctx.GetStub().GetState(key)
+				e.must(err)
+				var entry model.RecallRegistryEntry
+				if entryBytes == nil || json.Unmarshal(entryBytes, &entry) != nil || entry.PrimaryShipmentID != primary {
+					t.Fatalf("registry entry for %s = %s, want primary %s", recallID, entryBytes, primary)
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"foodtrace/model"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -60,10 +62,16 @@ func (s *FoodtraceSmartContract) ReceiveShipment(ctx contractapi.TransactionCont
 		Price:              rdArgs.Price,
 		QRCodeLink:         rdArgs.QRCodeLink,
 	}
+	// Judged at the transaction time: dateReceived is client-supplied and could be backdated to avoid the flag.
+	shipment.LateDelivery = shipment.ExpectedDeliveryBy != nil && now.After(*shipment.ExpectedDeliveryBy)
+	transferReason := transferReasonFromJSON(retailerDataJSON)
+	if err := s.recordCustodyTransfer(ctx, shipment, actor, model.StatusDelivered, transferReason, now); err != nil {
+		return fmt.Errorf("ReceiveShipment: %w", err)
+	}
 	shipment.Status = model.StatusDelivered
 	shipment.CurrentOwnerID = actor.fullID
 	shipment.CurrentOwnerAlias = actor.alias
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 	ensureShipmentSchemaCompliance(shipment) // Ensure sub-fields are initialized
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
@@ -81,7 +89,288 @@ func (s *FoodtraceSmartContract) ReceiveShipment(ctx contractapi.TransactionCont
 	if rdArgs.Price != 0 { // Send price if set explicitly (original logic)
 		eventPayload["price"] = rdArgs.Price
 	}
+	if transferReason != "" {
+		eventPayload["transferReason"] = transferReason
+	}
+	if shipment.LateDelivery {
+		eventPayload["lateDelivery"] = true
+		eventPayload["expectedDeliveryBy"] = shipment.ExpectedDeliveryBy.Format(time.RFC3339)
+	}
 	s.emitShipmentEvent(ctx, "ShipmentDelivered", shipment, actor, eventPayload)
 	logger.Infof("Shipment '%s' received by '%s'", shipmentID, actor.alias)
 	return nil
 }
+
+// SetExpectedDelivery records when the designated retailer expects a distributed shipment to arrive.
+// ReceiveShipment flags the shipment as a late delivery if its receipt is submitted after this time.
+func (s *FoodtraceSmartContract) SetExpectedDelivery(ctx contractapi.TransactionContextInterface, shipmentID string, expectedByStr string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("SetExpectedDelivery: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("retailer"); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+	expectedBy, err := parseDateString(expectedByStr, "expectedByStr", true)
+	if err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentAndVerifyStage(ctx, shipmentID, model.StatusDistributed, actor.fullID)
+	if err != nil {
+		return fmt.Errorf("SetExpectedDelivery: %w", err)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("SetExpectedDelivery: failed to get transaction timestamp: %w", err)
+	}
+	before := snapshotShipment(shipment)
+	shipment.ExpectedDeliveryBy = &expectedBy
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("SetExpectedDelivery: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("SetExpectedDelivery: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentFieldChanged(ctx, "ShipmentExpectedDeliverySet", before, shipment, actor, nil)
+	logger.Infof("Retailer '%s' expects shipment '%s' by %s", actor.alias, shipmentID, expectedBy.Format(time.RFC3339))
+	return nil
+}
+
+// MarkShipmentConsumed closes out a delivered shipment owned by the calling retailer. consumptionDetailsJSON
+// is optional and may carry {quantitySold, notes}.
+func (s *FoodtraceSmartContract) MarkShipmentConsumed(ctx contractapi.TransactionContextInterface, shipmentID string, consumptionDetailsJSON string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("MarkShipmentConsumed: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("retailer"); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+
+	var details struct {
+		QuantitySold *float64 `json:"quantitySold"`
+		Notes        string   `json:"notes"`
+	}
+	if strings.TrimSpace(consumptionDetailsJSON) != "" {
+		if err := json.Unmarshal([]byte(consumptionDetailsJSON), &details); err != nil {
+			return fmt.Errorf("MarkShipmentConsumed: invalid consumptionDetailsJSON: %w", err)
+		}
+	}
+	if err := s.validateOptionalString(details.Notes, "consumptionDetails.notes", maxDescriptionLength); err != nil {
+		return err
+	}
+
+	// getShipmentAndVerifyStage rejects archived and recalled shipments.
+	shipment, err := s.getShipmentAndVerifyStage(ctx, shipmentID, model.StatusDelivered, actor.fullID)
+	if err != nil {
+		return fmt.Errorf("MarkShipmentConsumed: %w", err)
+	}
+	if shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("MarkShipmentConsumed: caller '%s' is not the current owner of shipment '%s'", actor.alias, shipmentID)
+	}
+	if details.QuantitySold != nil && (*details.QuantitySold < 0 || *details.QuantitySold > shipment.Quantity) {
+		return fmt.Errorf("MarkShipmentConsumed: consumptionDetails.quantitySold must be between 0 and the shipment quantity %.2f", shipment.Quantity)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("MarkShipmentConsumed: failed to get transaction timestamp: %w", err)
+	}
+	if shipment.RetailerData == nil {
+		shipment.RetailerData = &model.RetailerData{RetailerID: actor.fullID, RetailerAlias: actor.alias}
+	}
+	shipment.RetailerData.ConsumedAt = &now
+	shipment.RetailerData.QuantitySold = details.QuantitySold
+	shipment.RetailerData.ConsumptionNotes = details.Notes
+	shipment.Status = model.StatusConsumed
+	touchShipment(shipment, now)
+
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("MarkShipmentConsumed: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("MarkShipmentConsumed: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	eventPayload := map[string]interface{}{"consumedAt": now.Format(time.RFC3339)}
+	if details.QuantitySold != nil {
+		eventPayload["quantitySold"] = *details.QuantitySold
+	}
+	if details.Notes != "" {
+		eventPayload["notes"] = details.Notes
+	}
+	s.emitShipmentEvent(ctx, "ShipmentConsumed", shipment, actor, eventPayload)
+	logger.Infof("Shipment '%s' marked consumed by retailer '%s'", shipmentID, actor.alias)
+	return nil
+}
+
+// newRetailUnitShipment builds a DELIVERED retail unit of parent. The unit is a portion of the same goods,
+// not a transformation, so it carries the parent's farm, certification, processing, cold-chain, retail and
+// recall linkage records, and references the parent through InputShipmentIDs.
+func newRetailUnitShipment(parent *model.Shipment, unit model.NewProductDetail, actor *actorInfo, now time.Time) model.Shipment {
+	unitProcessorData := *parent.ProcessorData
+	unitShipment := newDerivedShipment(unit, actor, &unitProcessorData, []string{parent.ID}, now)
+	unitShipment.IsDerivedProduct = false
+	unitShipment.Status = model.StatusDelivered
+
+	unitFarmerData := *parent.FarmerData
+	unitShipment.FarmerData = &unitFarmerData
+	unitShipment.CertificationRecords = append([]model.CertificationRecord{}, parent.CertificationRecords...)
+	unitDistributorData := *parent.DistributorData
+	unitShipment.DistributorData = &unitDistributorData
+	unitRetailerData := *parent.RetailerData
+	unitShipment.RetailerData = &unitRetailerData
+	unitRecallInfo := *parent.RecallInfo
+	unitRecallInfo.LinkedShipmentIDs = append([]string{}, parent.RecallInfo.LinkedShipmentIDs...)
+	unitShipment.RecallInfo = &unitRecallInfo
+	return unitShipment
+}
+
+// retailSplitTolerance absorbs floating point error when checking that split quantities sum to the parent.
+const retailSplitTolerance = 1e-6
+
+// SplitForRetail breaks a delivered shipment owned by the calling retailer into retail units. unitsJSON is
+// an array of {newShipmentId, productName, description, quantity, unitOfMeasure}; productName and
+// unitOfMeasure default to the parent's, and quantities must sum to the parent's quantity. Each unit is a
+// DELIVERED shipment whose InputShipmentIDs reference the parent, which is marked CONSUMED.
+func (s *FoodtraceSmartContract) SplitForRetail(ctx contractapi.TransactionContextInterface, shipmentID string, unitsJSON string) error {
+	actor, err := s.getCurrentActorInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("SplitForRetail: failed to get actor info: %w", err)
+	}
+	im := NewIdentityManager(ctx)
+	if err := im.RequireRole("retailer"); err != nil {
+		return err
+	}
+	if err := s.validateRequiredString(shipmentID, "shipmentID", maxStringInputLength); err != nil {
+		return err
+	}
+
+	shipment, err := s.getShipmentByID(ctx, shipmentID)
+	if err != nil {
+		return fmt.Errorf("SplitForRetail: %w", err)
+	}
+	if err := ensureShipmentMutable(shipment); err != nil {
+		return fmt.Errorf("SplitForRetail: %w", err)
+	}
+	if shipment.CurrentOwnerID != actor.fullID {
+		return fmt.Errorf("unauthorized: only the current owner can split shipment '%s' for retail", shipmentID)
+	}
+	if shipment.Status != model.StatusDelivered {
+		return fmt.Errorf("shipment '%s' must be in '%s' status to be split for retail (current: '%s')", shipmentID, model.StatusDelivered, shipment.Status)
+	}
+	if shipment.RecallInfo.IsRecalled {
+		return fmt.Errorf("recalled shipment '%s' cannot be split for retail", shipmentID)
+	}
+
+	var units []model.NewProductDetail
+	if err := json.Unmarshal([]byte(unitsJSON), &units); err != nil {
+		return fmt.Errorf("SplitForRetail: invalid unitsJSON: %w", err)
+	}
+	if len(units) == 0 {
+		return fmt.Errorf("SplitForRetail: at least one retail unit must be specified")
+	}
+	if len(units) > maxArrayElements {
+		return fmt.Errorf("SplitForRetail: %d retail units exceeds maximum of %d", len(units), maxArrayElements)
+	}
+	seen := make(map[string]bool)
+	total := 0.0
+	for i := range units {
+		unit := &units[i]
+		fieldNamePrefix := fmt.Sprintf("units[%d]", i)
+		if err := s.validateShipmentID(unit.NewShipmentID, fieldNamePrefix+".newShipmentId"); err != nil {
+			return err
+		}
+		if unit.NewShipmentID == shipmentID || seen[unit.NewShipmentID] {
+			return fmt.Errorf("%s.newShipmentId '%s' is duplicated", fieldNamePrefix, unit.NewShipmentID)
+		}
+		seen[unit.NewShipmentID] = true
+		if strings.TrimSpace(unit.ProductName) == "" {
+			unit.ProductName = shipment.ProductName
+		}
+		if err := s.validateRequiredString(unit.ProductName, fieldNamePrefix+".productName", maxStringInputLength); err != nil {
+			return err
+		}
+		if err := s.validateOptionalString(unit.Description, fieldNamePrefix+".description", maxDescriptionLength); err != nil {
+			return err
+		}
+		if unit.Quantity <= 0 {
+			return fmt.Errorf("%s.quantity must be positive, got %f", fieldNamePrefix, unit.Quantity)
+		}
+		if strings.TrimSpace(unit.UnitOfMeasure) == "" {
+			unit.UnitOfMeasure = shipment.UnitOfMeasure
+		} else if !strings.EqualFold(unit.UnitOfMeasure, shipment.UnitOfMeasure) {
+			return fmt.Errorf("%s.unitOfMeasure '%s' must match the parent's unit '%s'", fieldNamePrefix, unit.UnitOfMeasure, shipment.UnitOfMeasure)
+		}
+		total += unit.Quantity
+	}
+	if math.Abs(total-shipment.Quantity) > retailSplitTolerance {
+		return fmt.Errorf("retail unit quantities sum to %f %s but shipment '%s' holds %f %s", total, shipment.UnitOfMeasure, shipmentID, shipment.Quantity, shipment.UnitOfMeasure)
+	}
+
+	now, err := s.getCurrentTxTimestamp(ctx)
+	if err != nil {
+		return fmt.Errorf("SplitForRetail: failed to get transaction timestamp: %w", err)
+	}
+
+	unitIDs := []string{}
+	for _, unit := range units {
+		unitKey, errKey := s.createShipmentCompositeKey(ctx, unit.NewShipmentID)
+		if errKey != nil {
+			return fmt.Errorf("SplitForRetail: failed to create composite key for retail unit '%s': %w", unit.NewShipmentID, errKey)
+		}
+		existingUnit, errGet := ctx.GetStub().GetState(unitKey)
+		if errGet != nil {
+			return fmt.Errorf("SplitForRetail: failed to check for existing retail unit '%s': %w", unit.NewShipmentID, errGet)
+		}
+		if existingUnit != nil {
+			return fmt.Errorf("SplitForRetail: retail unit shipment with ID '%s' already exists", unit.NewShipmentID)
+		}
+
+		unitShipment := newRetailUnitShipment(shipment, unit, actor, now)
+		unitBytes, errMarshal := json.Marshal(unitShipment)
+		if errMarshal != nil {
+			return fmt.Errorf("SplitForRetail: failed to marshal retail unit '%s': %w", unit.NewShipmentID, errMarshal)
+		}
+		if errPut := ctx.GetStub().PutState(unitKey, unitBytes); errPut != nil {
+			return fmt.Errorf("SplitForRetail: failed to save retail unit '%s': %w", unit.NewShipmentID, errPut)
+		}
+		unitIDs = append(unitIDs, unit.NewShipmentID)
+	}
+
+	shipment.Status = model.StatusConsumed
+	shipment.Quantity = 0
+	shipment.RetailUnitIDs = unitIDs
+	touchShipment(shipment, now)
+	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
+	shipmentBytes, err := json.Marshal(shipment)
+	if err != nil {
+		return fmt.Errorf("SplitForRetail: failed to marshal shipment '%s': %w", shipmentID, err)
+	}
+	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
+		return fmt.Errorf("SplitForRetail: failed to update shipment '%s' on ledger: %w", shipmentID, err)
+	}
+
+	s.emitShipmentEvent(ctx, "ShipmentSplitForRetail", shipment, actor, map[string]interface{}{
+		"retailUnitIds": unitIDs, "splitQuantity": total,
+	})
+	logger.Infof("Shipment '%s' split into %d retail units by '%s'", shipmentID, len(unitIDs), actor.alias)
+	return nil
+}
@@ -0,0 +1,317 @@
+package contract
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+)
+
+func TestExpectedDeliveryLateness(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool          // false receives without an expected delivery time
+		delay    time.Duration // receipt time after the expectation was set; it is due after 2h
+		wantLate bool
+	}{
+		{name: "no expectation", delay: 5 * time.Hour},
+		{name: "on time", expected: true, delay: time.Hour},
+		{name: "exactly on time", expected: true, delay: 2 * time.Hour},
+		{name: "late", expected: true, delay: 3 * time.Hour, wantLate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.distribute("SHIP-1")
+			setAt := e.now.Add(time.Second) // Time of the next transaction
+			expectedBy := setAt.Add(2 * time.Hour)
+			if tt.expected {
+				e.must(e.cc.SetExpectedDelivery(e.as(e.retailer), "SHIP-1", expectedBy.Format(time.RFC3339)))
+				if got := e.shipment("SHIP-1").ExpectedDeliveryBy; got == nil || !got.Equal(expectedBy) {
+					t.Fatalf("expectedDeliveryBy = %v, want %s", got, expectedBy)
+				}
+			}
+
+			e.now = setAt.Add(tt.delay - time.Second)
+			e.receive("SHIP-1")
+			if late := e.shipment("SHIP-1").LateDelivery; late != tt.wantLate {
+				t.Fatalf("lateDelivery = %t, want %t", late, tt.wantLate)
+			}
+			_, payload := e.lastEvent()
+			if (payload["lateDelivery"] == true) != tt.wantLate {
+				t.Fatalf("event lateDelivery = %v, want %t", payload["lateDelivery"], tt.wantLate)
+			}
+			if tt.wantLate && payload["expectedDeliveryBy"] != expectedBy.Format(time.RFC3339) {
+				t.Fatalf("event expectedDeliveryBy = %v, want %s", payload["expectedDeliveryBy"], expectedBy.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestSetExpectedDeliveryRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		distribute bool
+		caller     func(e *testEnv) *testIdentity
+		expectedBy string
+		wantErr    string
+	}{
+		{name: "not yet distributed", expectedBy: "2025-06-03T08:00:00Z", wantErr: "DISTRIBUTED"},
+		{name: "not the designated retailer", distribute: true, caller: func(e *testEnv) *testIdentity { return e.distributor }, expectedBy: "2025-06-03T08:00:00Z", wantErr: "retailer"},
+		{name: "invalid time", distribute: true, expectedBy: "tomorrow", wantErr: "invalid format for expectedByStr"},
+		{name: "missing time", distribute: true, expectedBy: "", wantErr: "expectedByStr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			if tt.distribute {
+				e.distribute("SHIP-1")
+			}
+			caller := e.retailer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			checkErr(t, e.cc.SetExpectedDelivery(e.as(caller), "SHIP-1", tt.expectedBy), tt.wantErr)
+			if got := e.shipment("SHIP-1").ExpectedDeliveryBy; got != nil {
+				t.Fatalf("rejected call set expectedDeliveryBy to %s", got)
+			}
+		})
+	}
+}
+
+func TestSplitForRetail(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(e *testEnv) // SHIP-1 is created; leaves it in the state under test
+		caller  func(e *testEnv) *testIdentity
+		units   string
+		wantErr string
+	}{
+		{
+			name:  "balanced split",
+			setup: func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1"); e.receive("SHIP-1") },
+			units: `[{"newShipmentId":"UNIT-1","productName":"Punnet","quantity":60},{"newShipmentId":"UNIT-2","quantity":40,"unitOfMeasure":"KG"}]`,
+		},
+		{
+			name:    "quantity mismatch rejected",
+			setup:   func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1"); e.receive("SHIP-1") },
+			units:   `[{"newShipmentId":"UNIT-1","quantity":60},{"newShipmentId":"UNIT-2","quantity":30}]`,
+			wantErr: "retail unit quantities sum to 90.000000 kg but shipment 'SHIP-1' holds 100.000000 kg",
+		},
+		{
+			name:    "different unit of measure rejected",
+			setup:   func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1"); e.receive("SHIP-1") },
+			units:   `[{"newShipmentId":"UNIT-1","quantity":100,"unitOfMeasure":"lb"}]`,
+			wantErr: "must match the parent's unit 'kg'",
+		},
+		{
+			name:    "duplicate unit ID rejected",
+			setup:   func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1"); e.receive("SHIP-1") },
+			units:   `[{"newShipmentId":"UNIT-1","quantity":50},{"newShipmentId":"UNIT-1","quantity":50}]`,
+			wantErr: "units[1].newShipmentId 'UNIT-1' is duplicated",
+		},
+		{
+			name: "existing shipment ID rejected",
+			setup: func(e *testEnv) {
+				e.createShipment("SHIP-2")
+				e.process("SHIP-1")
+				e.distribute("SHIP-1")
+				e.receive("SHIP-1")
+			},
+			units:   `[{"newShipmentId":"SHIP-2","quantity":100}]`,
+			wantErr: "retail unit shipment with ID 'SHIP-2' already exists",
+		},
+		{name: "empty split rejected", setup: func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1"); e.receive("SHIP-1") }, units: `[]`, wantErr: "at least one retail unit"},
+		{
+			name:    "shipment not yet received rejected",
+			setup:   func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			units:   `[{"newShipmentId":"UNIT-1","quantity":100}]`,
+			wantErr: "only the current owner can split",
+		},
+		{
+			name: "consumed shipment rejected",
+			setup: func(e *testEnv) {
+				e.process("SHIP-1")
+				e.distribute("SHIP-1")
+				e.receive("SHIP-1")
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", ""))
+			},
+			units:   `[{"newShipmentId":"UNIT-1","quantity":100}]`,
+			wantErr: "must be in 'DELIVERED' status to be split for retail (current: 'CONSUMED')",
+		},
+		{
+			name:    "non-retailer rejected",
+			setup:   func(e *testEnv) { e.process("SHIP-1"); e.distribute("SHIP-1") },
+			caller:  func(e *testEnv) *testIdentity { return e.distributor },
+			units:   `[{"newShipmentId":"UNIT-1","quantity":100}]`,
+			wantErr: "retailer",
+		},
+		{
+			name: "recalled shipment rejected",
+			setup: func(e *testEnv) {
+				e.process("SHIP-1")
+				e.distribute("SHIP-1")
+				e.receive("SHIP-1")
+				e.must(e.cc.InitiateRecall(e.as(e.retailer), "SHIP-1", "RC-1", "Listeria detected"))
+			},
+			units:   `[{"newShipmentId":"UNIT-1","quantity":100}]`,
+			wantErr: "(current: 'RECALLED')",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			tt.setup(e)
+			caller := e.retailer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1")
+
+			checkErr(t, e.cc.SplitForRetail(e.as(caller), "SHIP-1", tt.units), tt.wantErr)
+			parent := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if parent.Status != before.Status || parent.Quantity != before.Quantity || len(parent.RetailUnitIDs) != 0 {
+					t.Fatalf("rejected split changed the parent: status %s, quantity %v, units %v", parent.Status, parent.Quantity, parent.RetailUnitIDs)
+				}
+				return
+			}
+
+			if parent.Status != model.StatusConsumed || parent.Quantity != 0 || !equalStrings(parent.RetailUnitIDs, []string{"UNIT-1", "UNIT-2"}) {
+				t.Fatalf("parent status %s, quantity %v, units %v", parent.Status, parent.Quantity, parent.RetailUnitIDs)
+			}
+			for _, want := range []struct {
+				id, productName string
+				quantity        float64
+			}{
+				{"UNIT-1", "Punnet", 60},
+				{"UNIT-2", "Strawberries", 40},
+			} {
+				unit := e.shipment(want.id)
+				if unit.Status != model.StatusDelivered || unit.CurrentOwnerID != e.retailer.id || !equalStrings(unit.InputShipmentIDs, []string{"SHIP-1"}) {
+					t.Errorf("%s: status %s, owner %s, inputs %v", want.id, unit.Status, unit.CurrentOwnerID, unit.InputShipmentIDs)
+				}
+				if unit.ProductName != want.productName || unit.Quantity != want.quantity || unit.FarmerData.CropType != "strawberry" {
+					t.Errorf("%s: product %q, quantity %v, crop %q", want.id, unit.ProductName, unit.Quantity, unit.FarmerData.CropType)
+				}
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentSplitForRetail" || payload["splitQuantity"] != 100.0 {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+}
+
+func TestMarkShipmentConsumed(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(e *testEnv) // SHIP-1 is delivered to e.retailer unless setup says otherwise
+		caller       func(e *testEnv) *testIdentity
+		details      string
+		wantErr      string
+		wantSold     *float64
+		wantNotes    string
+		wantEventKey string
+	}{
+		{name: "without details"},
+		{name: "with details", details: `{"quantitySold": 80, "notes": "Sold out by noon"}`, wantSold: func() *float64 { v := 80.0; return &v }(), wantNotes: "Sold out by noon", wantEventKey: "quantitySold"},
+		{name: "notes only", details: `{"notes": "Donated"}`, wantNotes: "Donated", wantEventKey: "notes"},
+		{name: "invalid JSON rejected", details: `{"quantitySold":`, wantErr: "invalid consumptionDetailsJSON"},
+		{name: "negative quantitySold rejected", details: `{"quantitySold": -1}`, wantErr: "quantitySold must be between 0 and the shipment quantity 100.00"},
+		{name: "quantitySold above shipment quantity rejected", details: `{"quantitySold": 100.5}`, wantErr: "quantitySold must be between 0 and the shipment quantity 100.00"},
+		{name: "overlong notes rejected", details: `{"notes": "` + strings.Repeat("n", maxDescriptionLength+1) + `"}`, wantErr: "consumptionDetails.notes"},
+		{name: "non-retailer rejected", caller: func(e *testEnv) *testIdentity { return e.distributor }, wantErr: "retailer"},
+		{
+			name: "retailer that does not own the shipment rejected",
+			caller: func(e *testEnv) *testIdentity {
+				other := newTestIdentity("retailer2", "Org1MSP")
+				e.register(other, "retailer")
+				return other
+			},
+			wantErr: "is not the current owner of shipment 'SHIP-1'",
+		},
+		{
+			name:    "undelivered shipment rejected",
+			setup:   func(e *testEnv) { e.createShipment("SHIP-1"); e.process("SHIP-1"); e.distribute("SHIP-1") },
+			wantErr: "status 'DISTRIBUTED', expected 'DELIVERED'",
+		},
+		{
+			name: "already consumed shipment rejected",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				e.must(e.cc.MarkShipmentConsumed(e.as(e.retailer), "SHIP-1", ""))
+			},
+			wantErr: "status 'CONSUMED', expected 'DELIVERED'",
+		},
+		{
+			name: "recalled shipment rejected",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				e.must(e.cc.InitiateRecall(e.as(e.retailer), "SHIP-1", "RC-1", "Listeria detected"))
+			},
+			wantErr: "shipment 'SHIP-1' is recalled",
+		},
+		{
+			name: "archived shipment rejected",
+			setup: func(e *testEnv) {
+				e.deliveredShipment("SHIP-1")
+				shipment := e.shipment("SHIP-1")
+				shipment.IsArchived = true
+				e.putShipment(shipment)
+			},
+			wantErr: "shipment 'SHIP-1' is archived",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			if tt.setup != nil {
+				tt.setup(e)
+			} else {
+				e.deliveredShipment("SHIP-1")
+			}
+			caller := e.retailer
+			if tt.caller != nil {
+				caller = tt.caller(e)
+			}
+			before := e.shipment("SHIP-1")
+
+			checkErr(t, e.cc.MarkShipmentConsumed(e.as(caller), "SHIP-1", tt.details), tt.wantErr)
+			shipment := e.shipment("SHIP-1")
+			if tt.wantErr != "" {
+				if shipment.Status != before.Status {
+					t.Fatalf("rejected call changed status from %s to %s", before.Status, shipment.Status)
+				}
+				return
+			}
+
+			if shipment.Status != model.StatusConsumed {
+				t.Fatalf("status = %s, want %s", shipment.Status, model.StatusConsumed)
+			}
+			retail := shipment.RetailerData
+			if retail.ConsumedAt == nil || !retail.ConsumedAt.Equal(e.now) {
+				t.Fatalf("consumedAt = %v, want %s", retail.ConsumedAt, e.now)
+			}
+			if (retail.QuantitySold == nil) != (tt.wantSold == nil) || (tt.wantSold != nil && *retail.QuantitySold != *tt.wantSold) {
+				t.Fatalf("quantitySold = %v, want %v", retail.QuantitySold, tt.wantSold)
+			}
+			if retail.ConsumptionNotes != tt.wantNotes {
+				t.Fatalf("consumptionNotes = %q, want %q", retail.ConsumptionNotes, tt.wantNotes)
+			}
+			name, payload := e.lastEvent()
+			if name != "ShipmentConsumed" || payload["consumedAt"] != e.now.Format(time.RFC3339) {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+			if tt.wantEventKey != "" && payload[tt.wantEventKey] == nil {
+				t.Fatalf("event payload %v lacks %s", payload, tt.wantEventKey)
+			}
+		})
+	}
+}
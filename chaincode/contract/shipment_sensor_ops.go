@@ -8,6 +8,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"foodtrace/model"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -28,10 +33,11 @@ func (s *FoodtraceSmartContract) AddDistributorSensorLog(ctx contractapi.Transac
 	}
 
 	var input struct {
-		Temperature float64        `json:"temperature"`
-		Humidity    float64        `json:"humidity"`
-		Coordinates model.GeoPoint `json:"coordinates"`
-		Timestamp   string         `json:"timestamp"`
+		Temperature        float64        `json:"temperature"`
+		ProductTemperature *float64       `json:"productTemperature"`
+		Humidity           float64        `json:"humidity"`
+		Coordinates        model.GeoPoint `json:"coordinates"`
+		Timestamp          string         `json:"timestamp"`
 	}
 	if err := json.Unmarshal([]byte(logJSON), &input); err != nil {
 		return fmt.Errorf("AddDistributorSensorLog: unmarshal log: %w", err)
@@ -39,6 +45,9 @@ func (s *FoodtraceSmartContract) AddDistributorSensorLog(ctx contractapi.Transac
 	if err := s.validateGeoPoint(&input.Coordinates, "coordinates", true); err != nil {
 		return err
 	}
+	if err := validatePlausibleTemperature(input.ProductTemperature, "productTemperature"); err != nil {
+		return err
+	}
 	ts, err := parseDateString(input.Timestamp, "timestamp", false)
 	if err != nil {
 		return err
@@ -85,19 +94,43 @@ func (s *FoodtraceSmartContract) AddDistributorSensorLog(ctx contractapi.Transac
 	if shipment.DistributorData == nil {
 		shipment.DistributorData = &model.DistributorData{}
 	}
+	duplicatePolicy, err := getConfigString(ctx, configDuplicateSensorReadings, duplicateReadingReject)
+	if err != nil {
+		return fmt.Errorf("AddDistributorSensorLog: failed to read duplicate reading policy: %w", err)
+	}
+	if duplicatePolicy != duplicateReadingAllow {
+		for _, existing := range shipment.DistributorData.SensorLogs {
+			if !existing.Timestamp.Equal(ts) {
+				continue
+			}
+			if duplicatePolicy == duplicateReadingSkip {
+				logger.Infof("AddDistributorSensorLog: Skipping duplicate reading at %s for shipment '%s'", ts.Format(time.RFC3339), shipmentID)
+				return nil
+			}
+			return fmt.Errorf("AddDistributorSensorLog: shipment '%s' already has a sensor reading at timestamp %s", shipmentID, ts.Format(time.RFC3339Nano))
+		}
+	}
 	reading := model.ColdChainLog{
-		Timestamp:   ts,
-		Temperature: input.Temperature,
-		Humidity:    input.Humidity,
-		Coordinates: input.Coordinates,
+		Timestamp:          ts,
+		Temperature:        input.Temperature,
+		ProductTemperature: input.ProductTemperature,
+		Humidity:           input.Humidity,
+		Coordinates:        input.Coordinates,
 	}
 	shipment.DistributorData.SensorLogs = append(shipment.DistributorData.SensorLogs, reading)
+	minTemp, maxTemp, haveBounds := sensorBreachBounds(shipment.DistributorData.TemperatureRange)
+	evaluatedTemp := breachTemperature(reading)
+	breached := haveBounds && (evaluatedTemp < minTemp || evaluatedTemp > maxTemp)
+	if breached {
+		shipment.DistributorData.TemperatureBreachCount++
+		shipment.DistributorData.BreachReadings = append(shipment.DistributorData.BreachReadings, reading)
+	}
 
 	now, err := s.getCurrentTxTimestamp(ctx)
 	if err != nil {
 		return fmt.Errorf("AddDistributorSensorLog: failed to get tx timestamp: %w", err)
 	}
-	shipment.LastUpdatedAt = now
+	touchShipment(shipment, now)
 	ensureShipmentSchemaCompliance(shipment)
 
 	shipmentKey, _ := s.createShipmentCompositeKey(ctx, shipmentID)
@@ -108,15 +141,213 @@ func (s *FoodtraceSmartContract) AddDistributorSensorLog(ctx contractapi.Transac
 	if err := ctx.GetStub().PutState(shipmentKey, shipmentBytes); err != nil {
 		return fmt.Errorf("AddDistributorSensorLog: update shipment '%s': %w", shipmentID, err)
 	}
+	// Fabric keeps one event per transaction, so a breach replaces the routine log event.
+	if breached {
+		logger.Warningf("AddDistributorSensorLog: cold chain breach on shipment '%s': %.1f outside %.1f to %.1f", shipmentID, evaluatedTemp, minTemp, maxTemp)
+		s.emitShipmentEvent(ctx, "ColdChainBreach", shipment, actor, map[string]interface{}{
+			"timestamp":   ts.Format(time.RFC3339),
+			"temperature": evaluatedTemp,
+			"minTemp":     minTemp,
+			"maxTemp":     maxTemp,
+			"breachCount": shipment.DistributorData.TemperatureBreachCount,
+		})
+		return nil
+	}
 	s.emitShipmentEvent(ctx, "DistributorSensorLogAdded", shipment, actor, map[string]interface{}{"timestamp": ts.Format(time.RFC3339)})
 	return nil
 }
 
 // GetDistributorSensorLogs retrieves all sensor readings for a shipment.
 func (s *FoodtraceSmartContract) GetDistributorSensorLogs(ctx contractapi.TransactionContextInterface, shipmentID string) ([]model.ColdChainLog, error) {
+	shipment, err := s.getShipmentForSensorRead(ctx, shipmentID, "GetDistributorSensorLogs")
+	if err != nil {
+		return nil, err
+	}
+	if shipment.DistributorData == nil || shipment.DistributorData.SensorLogs == nil {
+		return []model.ColdChainLog{}, nil
+	}
+	return shipment.DistributorData.SensorLogs, nil
+}
+
+// ValidateColdChainCompliance checks that consecutive sensor readings are no further apart
+// than the admin-configured sampling interval and reports any gaps found.
+func (s *FoodtraceSmartContract) ValidateColdChainCompliance(ctx contractapi.TransactionContextInterface, shipmentID string) (map[string]interface{}, error) {
+	shipment, err := s.getShipmentForSensorRead(ctx, shipmentID, "ValidateColdChainCompliance")
+	if err != nil {
+		return nil, err
+	}
+	intervalHours, err := getConfigFloat(ctx, configColdChainSamplingIntervalHours, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateColdChainCompliance: %w", err)
+	}
+
+	logs := []model.ColdChainLog{}
+	if shipment.DistributorData != nil && shipment.DistributorData.SensorLogs != nil {
+		logs = shipment.DistributorData.SensorLogs
+	}
+
+	gapDetails := []map[string]interface{}{}
+	if intervalHours > 0 {
+		for _, gap := range findColdChainGaps(logs, time.Duration(intervalHours*float64(time.Hour))) {
+			gapDetails = append(gapDetails, map[string]interface{}{
+				"afterReadingIndex": gap.afterIndex,
+				"from":              gap.from.Format(time.RFC3339),
+				"to":                gap.to.Format(time.RFC3339),
+				"gapHours":          gap.to.Sub(gap.from).Hours(),
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"shipmentId":            shipmentID,
+		"samplingIntervalHours": intervalHours,
+		"intervalConfigured":    intervalHours > 0,
+		"readingCount":          len(logs),
+		"gapCount":              len(gapDetails),
+		"gaps":                  gapDetails,
+		"compliant":             len(gapDetails) == 0,
+	}, nil
+}
+
+// GetColdChainScore summarizes cold chain quality as a 0-100 score. Starting from 100 it deducts:
+//   - 10 points per reading outside the declared distributorData.temperatureRange (max 50)
+//   - 5 points per degree of the largest deviation outside that range, rounded (max 30)
+//   - 5 points per sampling gap longer than the configured interval (max 20)
+//
+// A shipment with no sensor readings scores 0. If the temperature range is missing or cannot be parsed
+// (expected "min-max" or "min to max", e.g. "2-8C"), only gaps are scored.
+func (s *FoodtraceSmartContract) GetColdChainScore(ctx contractapi.TransactionContextInterface, shipmentID string) (int, error) {
+	shipment, err := s.getShipmentForSensorRead(ctx, shipmentID, "GetColdChainScore")
+	if err != nil {
+		return 0, err
+	}
+	intervalHours, err := getConfigFloat(ctx, configColdChainSamplingIntervalHours, 0)
+	if err != nil {
+		return 0, fmt.Errorf("GetColdChainScore: %w", err)
+	}
+
+	logs := []model.ColdChainLog{}
+	temperatureRange := ""
+	if shipment.DistributorData != nil {
+		if shipment.DistributorData.SensorLogs != nil {
+			logs = shipment.DistributorData.SensorLogs
+		}
+		temperatureRange = shipment.DistributorData.TemperatureRange
+	}
+	if len(logs) == 0 {
+		return 0, nil
+	}
+
+	breaches := 0
+	maxDeviation := 0.0
+	if minTemp, maxTemp, ok := parseTemperatureRange(temperatureRange); ok {
+		for _, reading := range logs {
+			deviation := 0.0
+			temp := breachTemperature(reading)
+			if temp < minTemp {
+				deviation = minTemp - temp
+			} else if temp > maxTemp {
+				deviation = temp - maxTemp
+			}
+			if deviation > 0 {
+				breaches++
+				if deviation > maxDeviation {
+					maxDeviation = deviation
+				}
+			}
+		}
+	}
+	gapCount := 0
+	if intervalHours > 0 {
+		gapCount = len(findColdChainGaps(logs, time.Duration(intervalHours*float64(time.Hour))))
+	}
+	return coldChainScore(breaches, maxDeviation, gapCount), nil
+}
+
+// coldChainScore applies the GetColdChainScore formula.
+func coldChainScore(breaches int, maxDeviation float64, gapCount int) int {
+	breachPenalty := min(10*breaches, 50)
+	deviationPenalty := min(int(math.Round(5*maxDeviation)), 30)
+	gapPenalty := min(5*gapCount, 20)
+	score := 100 - breachPenalty - deviationPenalty - gapPenalty
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// temperatureRangePattern matches declared ranges such as "2-8", "2°C - 8°C", "-1 to 4C".
+var temperatureRangePattern = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)\s*(?:°?\s*[CcFf])?\s*(?:-|–|to)\s*(-?\d+(?:\.\d+)?)\s*(?:°?\s*[CcFf])?\s*$`)
+
+// defaultSensorTemperatureRange is checked against sensor readings when a shipment declares no range.
+const defaultSensorTemperatureRange = "2-8"
+
+// sensorBreachBounds returns the bounds sensor readings are checked against, falling back to
+// defaultSensorTemperatureRange when rangeStr is empty. ok is false if a declared range cannot be parsed.
+func sensorBreachBounds(rangeStr string) (float64, float64, bool) {
+	if strings.TrimSpace(rangeStr) == "" {
+		rangeStr = defaultSensorTemperatureRange
+	}
+	return parseTemperatureRange(rangeStr)
+}
+
+// breachTemperature is the temperature a reading is checked against: the product core temperature when
+// reported, otherwise the ambient one.
+func breachTemperature(reading model.ColdChainLog) float64 {
+	if reading.ProductTemperature != nil {
+		return *reading.ProductTemperature
+	}
+	return reading.Temperature
+}
+
+// parseTemperatureRange extracts the bounds of a declared temperature range.
+func parseTemperatureRange(rangeStr string) (float64, float64, bool) {
+	m := temperatureRangePattern.FindStringSubmatch(rangeStr)
+	if m == nil {
+		return 0, 0, false
+	}
+	low, errLow := strconv.ParseFloat(m[1], 64)
+	high, errHigh := strconv.ParseFloat(m[2], 64)
+	if errLow != nil || errHigh != nil {
+		return 0, 0, false
+	}
+	if low > high {
+		low, high = high, low
+	}
+	return low, high, true
+}
+
+// coldChainGap describes a gap between two consecutive (time-ordered) sensor readings.
+type coldChainGap struct {
+	afterIndex int // Index (in time order) of the reading preceding the gap
+	from       time.Time
+	to         time.Time
+}
+
+// findColdChainGaps returns gaps longer than maxInterval between consecutive readings, ordered by time.
+func findColdChainGaps(logs []model.ColdChainLog, maxInterval time.Duration) []coldChainGap {
+	gaps := []coldChainGap{}
+	if maxInterval <= 0 || len(logs) < 2 {
+		return gaps
+	}
+	sorted := make([]model.ColdChainLog, len(logs))
+	copy(sorted, logs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Timestamp.Sub(sorted[i-1].Timestamp) > maxInterval {
+			gaps = append(gaps, coldChainGap{afterIndex: i - 1, from: sorted[i-1].Timestamp, to: sorted[i].Timestamp})
+		}
+	}
+	return gaps
+}
+
+// getShipmentForSensorRead loads a shipment and verifies the caller is the distributor
+// responsible for its sensor logs in the current stage.
+func (s *FoodtraceSmartContract) getShipmentForSensorRead(ctx contractapi.TransactionContextInterface, shipmentID, fnName string) (*model.Shipment, error) {
 	actor, err := s.getCurrentActorInfo(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("GetDistributorSensorLogs: failed to get actor info: %w", err)
+		return nil, fmt.Errorf("%s: failed to get actor info: %w", fnName, err)
 	}
 	im := NewIdentityManager(ctx)
 	if err := im.RequireRole("distributor"); err != nil {
@@ -127,37 +358,33 @@ func (s *FoodtraceSmartContract) GetDistributorSensorLogs(ctx contractapi.Transa
 	}
 	shipment, err := s.getShipmentByID(ctx, shipmentID)
 	if err != nil {
-		return nil, fmt.Errorf("GetDistributorSensorLogs: %w", err)
+		return nil, fmt.Errorf("%s: %w", fnName, err)
 	}
 	var designated string
 	switch shipment.Status {
 	case model.StatusProcessed:
 		if shipment.ProcessorData == nil {
-			return nil, fmt.Errorf("GetDistributorSensorLogs: missing ProcessorData for shipment '%s'", shipmentID)
+			return nil, fmt.Errorf("%s: missing ProcessorData for shipment '%s'", fnName, shipmentID)
 		}
 		designated = shipment.ProcessorData.DestinationDistributorID
 	case model.StatusDistributed:
 		if shipment.DistributorData == nil {
-			return nil, fmt.Errorf("GetDistributorSensorLogs: missing DistributorData for shipment '%s'", shipmentID)
+			return nil, fmt.Errorf("%s: missing DistributorData for shipment '%s'", fnName, shipmentID)
 		}
 		designated = shipment.DistributorData.DistributorID
 	default:
-		return nil, fmt.Errorf("GetDistributorSensorLogs: shipment '%s' status '%s' does not have sensor logs", shipmentID, shipment.Status)
+		return nil, fmt.Errorf("%s: shipment '%s' status '%s' does not have sensor logs", fnName, shipmentID, shipment.Status)
 	}
 	resolvedDesignated, err := im.ResolveIdentity(designated)
 	if err != nil {
-		return nil, fmt.Errorf("GetDistributorSensorLogs: failed to resolve designated distributor '%s': %w", designated, err)
+		return nil, fmt.Errorf("%s: failed to resolve designated distributor '%s': %w", fnName, designated, err)
 	}
 	resolvedActor, err := im.ResolveIdentity(actor.fullID)
 	if err != nil {
-		return nil, fmt.Errorf("GetDistributorSensorLogs: failed to resolve actor '%s': %w", actor.fullID, err)
+		return nil, fmt.Errorf("%s: failed to resolve actor '%s': %w", fnName, actor.fullID, err)
 	}
 	if resolvedDesignated != resolvedActor {
-		return nil, fmt.Errorf("GetDistributorSensorLogs: distributor '%s' not authorized for shipment '%s'", actor.alias, shipmentID)
+		return nil, fmt.Errorf("%s: distributor '%s' not authorized for shipment '%s'", fnName, actor.alias, shipmentID)
 	}
-
-	if shipment.DistributorData == nil || shipment.DistributorData.SensorLogs == nil {
-		return []model.ColdChainLog{}, nil
-	}
-	return shipment.DistributorData.SensorLogs, nil
+	return shipment, nil
 }
@@ -0,0 +1,305 @@
+package contract
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// addSensorReading has the distributor log a reading taken at testEpoch plus offset.
+func (e *testEnv) addSensorReading(id string, offset time.Duration, temperature float64) error {
+	logJSON, _ := json.Marshal(map[string]interface{}{
+		"temperature": temperature, "humidity": 80,
+		"coordinates": map[string]float64{"latitude": 37.0, "longitude": -120.0},
+		"timestamp":   testEpoch.Add(offset).Format(time.RFC3339),
+	})
+	return e.cc.AddDistributorSensorLog(e.as(e.distributor), id, string(logJSON))
+}
+
+func TestValidateColdChainCompliance(t *testing.T) {
+	tests := []struct {
+		name          string
+		intervalHours string
+		readingHours  []int
+		wantGaps      int
+		wantCompliant bool
+	}{
+		{name: "compliant sequence", intervalHours: "4", readingHours: []int{0, 3, 6, 10}, wantGaps: 0, wantCompliant: true},
+		{name: "gappy sequence", intervalHours: "4", readingHours: []int{0, 5, 7, 20}, wantGaps: 2, wantCompliant: false},
+		{name: "out of order readings are sorted", intervalHours: "4", readingHours: []int{6, 0, 3}, wantGaps: 0, wantCompliant: true},
+		{name: "no interval configured", intervalHours: "", readingHours: []int{0, 48}, wantGaps: 0, wantCompliant: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.distribute("SHIP-1")
+			if tt.intervalHours != "" {
+				e.must(e.cc.SetColdChainSamplingInterval(e.as(e.admin), tt.intervalHours))
+			}
+			for _, h := range tt.readingHours {
+				e.must(e.addSensorReading("SHIP-1", time.Duration(h)*time.Hour, 3))
+			}
+
+			report, err := e.cc.ValidateColdChainCompliance(e.as(e.distributor), "SHIP-1")
+			e.must(err)
+			if report["gapCount"] != tt.wantGaps || report["compliant"] != tt.wantCompliant {
+				t.Fatalf("gapCount = %v, compliant = %v; want %d, %t", report["gapCount"], report["compliant"], tt.wantGaps, tt.wantCompliant)
+			}
+			if report["readingCount"] != len(tt.readingHours) {
+				t.Fatalf("readingCount = %v, want %d", report["readingCount"], len(tt.readingHours))
+			}
+		})
+	}
+
+	t.Run("gap location", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		e.distribute("SHIP-1")
+		e.must(e.cc.SetColdChainSamplingInterval(e.as(e.admin), "2"))
+		for _, h := range []int{0, 1, 6} {
+			e.must(e.addSensorReading("SHIP-1", time.Duration(h)*time.Hour, 3))
+		}
+		report, err := e.cc.ValidateColdChainCompliance(e.as(e.distributor), "SHIP-1")
+		e.must(err)
+		gaps := report["gaps"].([]map[string]interface{})
+		if len(gaps) != 1 || gaps[0]["afterReadingIndex"] != 1 || gaps[0]["gapHours"] != 5.0 {
+			t.Fatalf("gaps = %v, want one 5h gap after reading 1", gaps)
+		}
+	})
+
+	t.Run("caller must be the shipment's distributor", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		e.distribute("SHIP-1")
+		_, err := e.cc.ValidateColdChainCompliance(e.as(e.retailer), "SHIP-1")
+		checkErr(t, err, "distributor")
+	})
+}
+
+func TestGetColdChainScore(t *testing.T) {
+	tests := []struct {
+		name             string
+		intervalHours    string
+		temperatureRange string // "" declares 0-4C
+		undeclaredRange  bool
+		readingHours     []int
+		temperatures     []float64
+		want             int
+	}{
+		{name: "clean chain", intervalHours: "4", readingHours: []int{0, 3, 6}, temperatures: []float64{2, 3, 2}, want: 100},
+		{name: "one breach", readingHours: []int{0, 1, 2}, temperatures: []float64{2, 6, 3}, want: 80},
+		{name: "breach below range", readingHours: []int{0, 1}, temperatures: []float64{2, -1.5}, want: 82},
+		{name: "gaps only", intervalHours: "4", readingHours: []int{0, 5, 10}, temperatures: []float64{2, 2, 2}, want: 90},
+		{name: "breach-heavy chain", readingHours: []int{0, 1, 2, 3, 4, 5}, temperatures: []float64{10, 12, 9, 15, 11, 14}, want: 20},
+		{name: "breaches and gaps", intervalHours: "1", readingHours: []int{0, 2, 4, 6, 8, 10}, temperatures: []float64{10, 12, 9, 15, 11, 14}, want: 0},
+		{name: "no readings", want: 0},
+		{name: "undeclared range scores gaps only", undeclaredRange: true, readingHours: []int{0, 1}, temperatures: []float64{1, 5}, want: 100},
+		{name: "unparseable range scores gaps only", temperatureRange: "keep chilled", intervalHours: "4", readingHours: []int{0, 5}, temperatures: []float64{30, 30}, want: 95},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			var temperatureRange interface{} = tt.temperatureRange
+			if tt.undeclaredRange {
+				temperatureRange = nil
+			} else if tt.temperatureRange == "" {
+				temperatureRange = "0-4C"
+			}
+			e.must(e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(map[string]interface{}{"temperatureRange": temperatureRange})))
+			if tt.intervalHours != "" {
+				e.must(e.cc.SetColdChainSamplingInterval(e.as(e.admin), tt.intervalHours))
+			}
+			for i, h := range tt.readingHours {
+				e.must(e.addSensorReading("SHIP-1", time.Duration(h)*time.Hour, tt.temperatures[i]))
+			}
+
+			for run := 0; run < 2; run++ { // The score is deterministic
+				score, err := e.cc.GetColdChainScore(e.as(e.distributor), "SHIP-1")
+				e.must(err)
+				if score != tt.want {
+					t.Fatalf("score = %d, want %d", score, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("caller must be the shipment's distributor", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		e.distribute("SHIP-1")
+		_, err := e.cc.GetColdChainScore(e.as(e.retailer), "SHIP-1")
+		checkErr(t, err, "distributor")
+	})
+}
+
+func TestAddDistributorSensorLogDuplicateTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string // "" keeps the default policy
+		offset    time.Duration
+		wantErr   string
+		wantCount int
+	}{
+		{name: "default rejects duplicate", offset: 0, wantErr: "already has a sensor reading at timestamp 2025-06-01T12:00:00Z", wantCount: 1},
+		{name: "default accepts distinct timestamp", offset: time.Second, wantCount: 2},
+		{name: "reject policy", policy: "REJECT", offset: 0, wantErr: "already has a sensor reading", wantCount: 1},
+		{name: "skip policy", policy: "skip", offset: 0, wantCount: 1},
+		{name: "skip policy records distinct timestamp", policy: "skip", offset: time.Hour, wantCount: 2},
+		{name: "allow policy", policy: "allow", offset: 0, wantCount: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.distribute("SHIP-1")
+			if tt.policy != "" {
+				e.must(e.cc.SetDuplicateSensorReadingPolicy(e.as(e.admin), tt.policy))
+			}
+			e.must(e.addSensorReading("SHIP-1", 0, 3))
+
+			checkErr(t, e.addSensorReading("SHIP-1", tt.offset, 3.5), tt.wantErr)
+			if logs := e.shipment("SHIP-1").DistributorData.SensorLogs; len(logs) != tt.wantCount {
+				t.Fatalf("%d sensor logs, want %d", len(logs), tt.wantCount)
+			}
+		})
+	}
+
+	t.Run("invalid policy rejected", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		checkErr(t, e.cc.SetDuplicateSensorReadingPolicy(e.as(e.admin), "ignore"), "invalid policy 'ignore'")
+	})
+}
+
+func TestAddDistributorSensorLogBreachDetection(t *testing.T) {
+	tests := []struct {
+		name               string
+		temperatureRange   interface{} // nil leaves the range undeclared
+		temperature        float64
+		productTemperature interface{} // nil reports ambient temperature only
+		wantBreach         bool
+		wantTemp           float64
+		wantMin, wantMax   float64
+	}{
+		{name: "within declared range", temperatureRange: "0-4C", temperature: 3},
+		{name: "at the upper bound", temperatureRange: "0-4C", temperature: 4},
+		{name: "above declared range", temperatureRange: "0-4C", temperature: 6, wantBreach: true, wantTemp: 6, wantMin: 0, wantMax: 4},
+		{name: "below declared range", temperatureRange: "0-4C", temperature: -1.5, wantBreach: true, wantTemp: -1.5, wantMin: 0, wantMax: 4},
+		{name: "plain range", temperatureRange: "2-8", temperature: 9, wantBreach: true, wantTemp: 9, wantMin: 2, wantMax: 8},
+		{name: "undeclared range falls back to the default", temperature: 1, wantBreach: true, wantTemp: 1, wantMin: 2, wantMax: 8},
+		{name: "undeclared range within the default", temperature: 5},
+		{name: "unparseable range is not checked", temperatureRange: "keep chilled", temperature: 30},
+		{name: "product temperature takes precedence", temperatureRange: "0-4C", temperature: 3, productTemperature: 7.0, wantBreach: true, wantTemp: 7, wantMin: 0, wantMax: 4},
+		{name: "in-range product temperature", temperatureRange: "0-4C", temperature: 9, productTemperature: 2.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.must(e.cc.DistributeShipment(e.as(e.distributor), "SHIP-1", e.distributorData(map[string]interface{}{"temperatureRange": tt.temperatureRange})))
+			reading := map[string]interface{}{
+				"temperature": tt.temperature, "humidity": 80,
+				"coordinates": map[string]float64{"latitude": 37.0, "longitude": -120.0},
+				"timestamp":   testEpoch.Format(time.RFC3339),
+			}
+			if tt.productTemperature != nil {
+				reading["productTemperature"] = tt.productTemperature
+			}
+			logJSON, _ := json.Marshal(reading)
+
+			e.must(e.cc.AddDistributorSensorLog(e.as(e.distributor), "SHIP-1", string(logJSON)))
+			data := e.shipment("SHIP-1").DistributorData
+			if len(data.SensorLogs) != 1 {
+				t.Fatalf("%d sensor logs, want 1", len(data.SensorLogs))
+			}
+			name, payload := e.lastEvent()
+			if !tt.wantBreach {
+				if data.TemperatureBreachCount != 0 || len(data.BreachReadings) != 0 || name != "DistributorSensorLogAdded" {
+					t.Fatalf("breachCount %d, breach readings %d, event %s", data.TemperatureBreachCount, len(data.BreachReadings), name)
+				}
+				return
+			}
+			if data.TemperatureBreachCount != 1 || len(data.BreachReadings) != 1 || data.BreachReadings[0].Temperature != tt.temperature {
+				t.Fatalf("breachCount %d, breach readings %+v", data.TemperatureBreachCount, data.BreachReadings)
+			}
+			if name != "ColdChainBreach" || payload["shipmentId"] != "SHIP-1" || payload["temperature"] != tt.wantTemp ||
+				payload["minTemp"] != tt.wantMin || payload["maxTemp"] != tt.wantMax || payload["breachCount"] != 1.0 {
+				t.Fatalf("event %s = %v", name, payload)
+			}
+		})
+	}
+
+	t.Run("breaches accumulate", func(t *testing.T) {
+		e := newSupplyChainEnv(t)
+		e.createShipment("SHIP-1")
+		e.process("SHIP-1")
+		e.distribute("SHIP-1")
+		for i, temperature := range []float64{6, 3, 7, 2, -2} {
+			e.must(e.addSensorReading("SHIP-1", time.Duration(i)*time.Hour, temperature))
+		}
+		data := e.shipment("SHIP-1").DistributorData
+		if len(data.SensorLogs) != 5 || data.TemperatureBreachCount != 3 || len(data.BreachReadings) != 3 {
+			t.Fatalf("%d logs, breachCount %d, %d breach readings", len(data.SensorLogs), data.TemperatureBreachCount, len(data.BreachReadings))
+		}
+		if _, payload := e.lastEvent(); payload["breachCount"] != 3.0 {
+			t.Fatalf("event breachCount = %v, want 3", payload["breachCount"])
+		}
+	})
+}
+
+func TestAddDistributorSensorLogProductTemperature(t *testing.T) {
+	tests := []struct {
+		name               string
+		productTemperature interface{} // nil omits the field
+		wantProduct        *float64
+		wantErr            string
+	}{
+		{name: "ambient only"},
+		{name: "ambient and product", productTemperature: 1.5, wantProduct: float64Ptr(1.5)},
+		{name: "product at freezing point", productTemperature: 0.0, wantProduct: float64Ptr(0)},
+		{name: "implausible product temperature rejected", productTemperature: maxPlausibleTempC + 1, wantErr: "productTemperature"},
+		{name: "non-numeric product temperature rejected", productTemperature: "cold", wantErr: "unmarshal log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSupplyChainEnv(t)
+			e.createShipment("SHIP-1")
+			e.process("SHIP-1")
+			e.distribute("SHIP-1")
+			reading := map[string]interface{}{
+				"temperature": 3.5, "humidity": 80,
+				"coordinates": map[string]float64{"latitude": 37.0, "longitude": -120.0},
+				"timestamp":   testEpoch.Format(time.RFC3339),
+			}
+			if tt.productTemperature != nil {
+				reading["productTemperature"] = tt.productTemperature
+			}
+			logJSON, _ := json.Marshal(reading)
+
+			checkErr(t, e.cc.AddDistributorSensorLog(e.as(e.distributor), "SHIP-1", string(logJSON)), tt.wantErr)
+			logs, err := e.cc.GetDistributorSensorLogs(e.as(e.distributor), "SHIP-1")
+			e.must(err)
+			if tt.wantErr != "" {
+				if len(logs) != 0 {
+					t.Fatalf("rejected reading was stored: %+v", logs)
+				}
+				return
+			}
+			if len(logs) != 1 || logs[0].Temperature != 3.5 {
+				t.Fatalf("logs = %+v, want one reading at 3.5", logs)
+			}
+			got := logs[0].ProductTemperature
+			if (got == nil) != (tt.wantProduct == nil) || (got != nil && *got != *tt.wantProduct) {
+				t.Fatalf("productTemperature = %v, want %v", got, tt.wantProduct)
+			}
+		})
+	}
+}
@@ -0,0 +1,613 @@
+package contract
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"foodtrace/model"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testStub extends shimtest.MockStub with the ledger features the contract relies on but the
+// mock leaves unimplemented: key history, CouchDB selector queries, paginated queries and events.
+type testStub struct {
+	*shimtest.MockStub
+	history map[string][]*queryresult.KeyModification
+	events  []*pb.ChaincodeEvent
+	noCouch bool   // Rich queries fail, as they do on a LevelDB state database
+	failGet string // GetState fails for composite keys of this object type
+}
+
+func newTestStub() *testStub {
+	return &testStub{
+		MockStub: shimtest.NewMockStub("foodtrace", nil),
+		history:  map[string][]*queryresult.KeyModification{},
+	}
+}
+
+func (stub *testStub) GetState(key string) ([]byte, error) {
+	if stub.failGet != "" && strings.HasPrefix(key, "\x00"+stub.failGet+"\x00") {
+		return nil, fmt.Errorf("simulated ledger error reading %q", key)
+	}
+	return stub.MockStub.GetState(key)
+}
+
+func (stub *testStub) PutState(key string, value []byte) error {
+	if err := stub.MockStub.PutState(key, value); err != nil {
+		return err
+	}
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId: stub.TxID, Value: value, Timestamp: stub.TxTimestamp, IsDelete: len(value) == 0,
+	})
+	return nil
+}
+
+func (stub *testStub) DelState(key string) error {
+	if err := stub.MockStub.DelState(key); err != nil {
+		return err
+	}
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId: stub.TxID, Timestamp: stub.TxTimestamp, IsDelete: true,
+	})
+	return nil
+}
+
+// SetEvent records every event; Fabric delivers only the last one set in a transaction.
+func (stub *testStub) SetEvent(name string, payload []byte) error {
+	stub.events = append(stub.events, &pb.ChaincodeEvent{EventName: name, Payload: payload, TxId: stub.TxID})
+	return nil
+}
+
+// writeCount returns the number of state writes and deletes recorded so far.
+func (stub *testStub) writeCount() int {
+	n := 0
+	for _, mods := range stub.history {
+		n += len(mods)
+	}
+	return n
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{entries: stub.history[key]}, nil
+}
+
+func (stub *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if stub.noCouch {
+		return nil, errors.New("ExecuteQuery not supported for leveldb")
+	}
+	kvs, _, err := stub.runQuery(query, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return &kvIterator{kvs: kvs}, nil
+}
+
+func (stub *testStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if stub.noCouch {
+		return nil, nil, errors.New("ExecuteQuery not supported for leveldb")
+	}
+	kvs, next, err := stub.runQuery(query, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &kvIterator{kvs: kvs}, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(kvs)), Bookmark: next}, nil
+}
+
+func (stub *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	iter, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	var all []*queryresult.KV
+	for iter.HasNext() {
+		kv, errNext := iter.Next()
+		if errNext != nil {
+			return nil, nil, errNext
+		}
+		all = append(all, kv)
+	}
+	page, next := paginateKVs(all, pageSize, bookmark)
+	return &kvIterator{kvs: page}, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(page)), Bookmark: next}, nil
+}
+
+// runQuery evaluates a CouchDB query over every JSON document in state, in key order.
+func (stub *testStub) runQuery(query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+	var q struct {
+		Selector map[string]interface{} `json:"selector"`
+		Limit    int                    `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, "", fmt.Errorf("invalid query %q: %w", query, err)
+	}
+	var matches []*queryresult.KV
+	for elem := stub.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		var doc map[string]interface{}
+		if json.Unmarshal(stub.State[key], &doc) != nil {
+			continue
+		}
+		if selectorMatches(doc, q.Selector) {
+			matches = append(matches, &queryresult.KV{Key: key, Value: stub.State[key]})
+		}
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	if pageSize <= 0 {
+		return matches, "", nil
+	}
+	page, next := paginateKVs(matches, pageSize, bookmark)
+	return page, next, nil
+}
+
+// paginateKVs returns the page after the bookmark key and the bookmark for the following page.
+func paginateKVs(all []*queryresult.KV, pageSize int32, bookmark string) ([]*queryresult.KV, string) {
+	start := 0
+	if bookmark != "" {
+		start = sort.Search(len(all), func(i int) bool { return all[i].Key > bookmark })
+	}
+	end := len(all)
+	if pageSize > 0 && start+int(pageSize) < end {
+		end = start + int(pageSize)
+	}
+	page := all[start:end]
+	if end < len(all) && len(page) > 0 {
+		return page, page[len(page)-1].Key
+	}
+	return page, ""
+}
+
+// selectorMatches implements the subset of the CouchDB selector syntax used by the contract.
+func selectorMatches(doc interface{}, selector map[string]interface{}) bool {
+	for field, cond := range selector {
+		switch field {
+		case "$and":
+			for _, sub := range cond.([]interface{}) {
+				if !selectorMatches(doc, sub.(map[string]interface{})) {
+					return false
+				}
+			}
+			continue
+		case "$or":
+			matched := false
+			for _, sub := range cond.([]interface{}) {
+				if selectorMatches(doc, sub.(map[string]interface{})) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		value, found := lookupField(doc, field)
+		if !conditionMatches(value, found, cond) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupField(doc interface{}, path string) (interface{}, bool) {
+	current := doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func conditionMatches(value interface{}, found bool, cond interface{}) bool {
+	ops, isOps := cond.(map[string]interface{})
+	if isOps {
+		for op := range ops {
+			if !strings.HasPrefix(op, "$") {
+				isOps = false
+				break
+			}
+		}
+	}
+	if !isOps {
+		return found && reflect.DeepEqual(value, cond)
+	}
+	for op, arg := range ops {
+		if op == "$exists" {
+			if found != arg.(bool) {
+				return false
+			}
+			continue
+		}
+		if !found {
+			return false
+		}
+		switch op {
+		case "$eq":
+			if !reflect.DeepEqual(value, arg) {
+				return false
+			}
+		case "$ne":
+			if reflect.DeepEqual(value, arg) {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			c, ok := compareJSON(value, arg)
+			if !ok {
+				return false
+			}
+			if (op == "$gt" && c <= 0) || (op == "$gte" && c < 0) || (op == "$lt" && c >= 0) || (op == "$lte" && c > 0) {
+				return false
+			}
+		case "$in":
+			matched := false
+			for _, candidate := range arg.([]interface{}) {
+				if reflect.DeepEqual(value, candidate) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		case "$elemMatch":
+			elems, ok := value.([]interface{})
+			if !ok {
+				return false
+			}
+			sub := arg.(map[string]interface{})
+			matched := false
+			for _, elem := range elems {
+				if conditionMatches(elem, true, sub) || selectorMatches(elem, sub) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		default:
+			panic("testStub: unsupported selector operator " + op)
+		}
+	}
+	return true
+}
+
+func compareJSON(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case av < bv:
+			return -1, true
+		case av > bv:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	}
+	return 0, false
+}
+
+type kvIterator struct {
+	kvs []*queryresult.KV
+	pos int
+}
+
+func (it *kvIterator) HasNext() bool { return it.pos < len(it.kvs) }
+func (it *kvIterator) Close() error  { return nil }
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("iterator exhausted")
+	}
+	it.pos++
+	return it.kvs[it.pos-1], nil
+}
+
+type historyIterator struct {
+	entries []*queryresult.KeyModification
+	pos     int
+}
+
+func (it *historyIterator) HasNext() bool { return it.pos < len(it.entries) }
+func (it *historyIterator) Close() error  { return nil }
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("iterator exhausted")
+	}
+	it.pos++
+	return it.entries[it.pos-1], nil
+}
+
+// testIdentity is a client identity with a fixed X.509 ID and MSP.
+type testIdentity struct {
+	id    string
+	alias string
+	mspID string
+	cert  *x509.Certificate
+}
+
+func newTestIdentity(alias, mspID string) *testIdentity {
+	return &testIdentity{id: "x509::CN=" + alias + "::CN=ca." + strings.ToLower(mspID), alias: alias, mspID: mspID}
+}
+
+func (c *testIdentity) GetID() (string, error)    { return c.id, nil }
+func (c *testIdentity) GetMSPID() (string, error) { return c.mspID, nil }
+func (c *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return "", false, nil
+}
+func (c *testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return fmt.Errorf("attribute %s not found", attrName)
+}
+func (c *testIdentity) GetX509Certificate() (*x509.Certificate, error) { return c.cert, nil }
+
+// testEnv drives the contract through a sequence of mocked transactions. Each transaction gets a
+// new ID and a timestamp one second after the previous one.
+type testEnv struct {
+	t    *testing.T
+	cc   *FoodtraceSmartContract
+	stub *testStub
+	now  time.Time
+	txs  int
+
+	admin, farmer, processor, certifier, distributor, retailer *testIdentity
+}
+
+var testEpoch = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestEnv(t *testing.T) *testEnv {
+	return &testEnv{
+		t: t, cc: new(FoodtraceSmartContract), stub: newTestStub(), now: testEpoch,
+		admin:       newTestIdentity("admin", "Org1MSP"),
+		farmer:      newTestIdentity("farmer1", "Org1MSP"),
+		processor:   newTestIdentity("processor1", "Org2MSP"),
+		certifier:   newTestIdentity("certifier1", "Org2MSP"),
+		distributor: newTestIdentity("distributor1", "Org3MSP"),
+		retailer:    newTestIdentity("retailer1", "Org3MSP"),
+	}
+}
+
+// newSupplyChainEnv bootstraps the admin and registers one identity for each supply chain role.
+func newSupplyChainEnv(t *testing.T) *testEnv {
+	e := newTestEnv(t)
+	e.must(e.cc.BootstrapLedger(e.as(e.admin)))
+	for role, id := range map[string]*testIdentity{
+		"farmer": e.farmer, "processor": e.processor, "certifier": e.certifier,
+		"distributor": e.distributor, "retailer": e.retailer,
+	} {
+		e.register(id, role)
+	}
+	return e
+}
+
+// as starts a new transaction invoked by caller and returns its context.
+func (e *testEnv) as(caller *testIdentity) contractapi.TransactionContextInterface {
+	e.txs++
+	e.now = e.now.Add(time.Second)
+	e.stub.MockTransactionStart(fmt.Sprintf("tx%03d", e.txs))
+	e.stub.TxTimestamp.Seconds, e.stub.TxTimestamp.Nanos = e.now.Unix(), 0
+	e.stub.events = nil
+	return e.inTx(caller)
+}
+
+// inTx returns a context for caller in the current transaction, leaving its recorded events intact.
+func (e *testEnv) inTx(caller *testIdentity) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(e.stub)
+	ctx.SetClientIdentity(caller)
+	return ctx
+}
+
+// register has the admin register id under its alias and, if given, assign it role.
+func (e *testEnv) register(id *testIdentity, role string) {
+	e.t.Helper()
+	e.must(e.cc.RegisterIdentity(e.as(e.admin), id.id, id.alias, id.alias))
+	if role != "" {
+		e.must(e.cc.AssignRoleToIdentity(e.as(e.admin), id.alias, role))
+	}
+}
+
+func (e *testEnv) must(err error) {
+	e.t.Helper()
+	if err != nil {
+		e.t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// lastEvent returns the name and decoded payload of the event Fabric would deliver for the last transaction.
+func (e *testEnv) lastEvent() (string, map[string]interface{}) {
+	e.t.Helper()
+	if len(e.stub.events) == 0 {
+		e.t.Fatal("no event was set")
+	}
+	ev := e.stub.events[len(e.stub.events)-1]
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+		e.t.Fatalf("event %s has invalid payload: %v", ev.EventName, err)
+	}
+	return ev.EventName, payload
+}
+
+func (e *testEnv) shipment(id string) *model.Shipment {
+	e.t.Helper()
+	shipment, err := e.cc.getShipmentByID(e.inTx(e.admin), id)
+	if err != nil {
+		e.t.Fatalf("failed to read shipment %s: %v", id, err)
+	}
+	return shipment
+}
+
+// putShipment writes a shipment directly, for states the contract API cannot reach.
+func (e *testEnv) putShipment(shipment *model.Shipment) {
+	e.t.Helper()
+	ctx := e.as(e.admin)
+	key, err := e.cc.createShipmentCompositeKey(ctx, shipment.ID)
+	e.must(err)
+	shipmentBytes, err := json.Marshal(shipment)
+	e.must(err)
+	e.must(ctx.GetStub().PutState(key, shipmentBytes))
+}
+
+func (e *testEnv) farmerData(overrides map[string]interface{}) string {
+	data := map[string]interface{}{
+		"farmerName": "Jane Farmer", "farmLocation": "Valley Farm",
+		"farmCoordinates": map[string]float64{"latitude": 36.7, "longitude": -119.7},
+		"cropType":        "strawberry", "plantingDate": "2025-01-10T00:00:00Z", "harvestDate": "2025-05-20T00:00:00Z",
+		"farmingPractice": "organic", "bedType": "raised", "irrigationMethod": "drip",
+		"organicSince": "2015-01-01T00:00:00Z", "bufferZoneMeters": 10.0,
+		"destinationProcessorId": e.processor.alias,
+	}
+	return encodeTestJSON(data, overrides)
+}
+
+func (e *testEnv) processorData(overrides map[string]interface{}) string {
+	data := map[string]interface{}{
+		"dateProcessed": e.now.Format(time.RFC3339), "processingType": "washing", "processingLineId": "PL-1",
+		"processingLocation": "Plant A", "processingCoordinates": map[string]float64{"latitude": 36.8, "longitude": -119.8},
+		"contaminationCheck": "PASSED", "destinationDistributorId": e.distributor.alias,
+	}
+	return encodeTestJSON(data, overrides)
+}
+
+func (e *testEnv) distributorData(overrides map[string]interface{}) string {
+	data := map[string]interface{}{
+		"pickupDateTime": e.now.Format(time.RFC3339), "distributionLineId": "DL-1", "temperatureRange": "0-4C",
+		"storageTemperatures": []float64{2.5, 3.0}, "distributionCenter": "DC North",
+		"destinationRetailerId": e.retailer.alias,
+	}
+	return encodeTestJSON(data, overrides)
+}
+
+func (e *testEnv) retailerData(overrides map[string]interface{}) string {
+	data := map[string]interface{}{
+		"dateReceived": e.now.Format(time.RFC3339), "retailerLineId": "RL-1", "productNameRetail": "Fresh Strawberries",
+		"storeLocation": "Main St", "storeCoordinates": map[string]float64{"latitude": 36.9, "longitude": -119.9},
+	}
+	return encodeTestJSON(data, overrides)
+}
+
+// encodeTestJSON applies overrides to data (a nil override removes the field) and marshals it.
+func encodeTestJSON(data, overrides map[string]interface{}) string {
+	for k, v := range overrides {
+		if v == nil {
+			delete(data, k)
+		} else {
+			data[k] = v
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// createShipment has the farmer create a 100 kg shipment destined for the processor.
+func (e *testEnv) createShipment(id string) {
+	e.t.Helper()
+	e.must(e.cc.CreateShipment(e.as(e.farmer), id, "Strawberries", "Test batch", 100, "kg", e.farmerData(nil)))
+}
+
+// certify takes a created shipment through submission and an approving certification.
+func (e *testEnv) certify(id string) {
+	e.t.Helper()
+	e.must(e.cc.SubmitForCertification(e.as(e.farmer), id))
+	e.must(e.cc.RecordCertification(e.as(e.certifier), id, e.now.Format(time.RFC3339), "", "APPROVED", "ok"))
+}
+
+func (e *testEnv) process(id string) {
+	e.t.Helper()
+	e.must(e.cc.ProcessShipment(e.as(e.processor), id, e.processorData(nil), ""))
+}
+
+func (e *testEnv) distribute(id string) {
+	e.t.Helper()
+	e.must(e.cc.DistributeShipment(e.as(e.distributor), id, e.distributorData(nil)))
+}
+
+func (e *testEnv) receive(id string) {
+	e.t.Helper()
+	e.must(e.cc.ReceiveShipment(e.as(e.retailer), id, e.retailerData(nil)))
+}
+
+// deliveredShipment creates a shipment and takes it through to the retailer.
+func (e *testEnv) deliveredShipment(id string) {
+	e.t.Helper()
+	e.createShipment(id)
+	e.process(id)
+	e.distribute(id)
+	e.receive(id)
+}
+
+// checkErr fails the test unless err matches wantErr: "" expects success, anything else a
+// substring of the error message.
+func checkErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Fatalf("expected error containing %q, got nil", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Fatalf("expected error containing %q, got: %v", wantErr, err)
+	}
+}
+
+func TestSupplyChainHappyPath(t *testing.T) {
+	e := newSupplyChainEnv(t)
+	e.createShipment("SHIP-1")
+	e.certify("SHIP-1")
+	e.process("SHIP-1")
+	e.distribute("SHIP-1")
+	e.receive("SHIP-1")
+
+	shipment := e.shipment("SHIP-1")
+	if shipment.Status != model.StatusDelivered {
+		t.Fatalf("status = %s, want %s", shipment.Status, model.StatusDelivered)
+	}
+	if shipment.CurrentOwnerID != e.retailer.id {
+		t.Fatalf("owner = %s, want retailer", shipment.CurrentOwnerID)
+	}
+	if name, _ := e.lastEvent(); name != "ShipmentDelivered" {
+		t.Fatalf("last event = %s, want ShipmentDelivered", name)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func float64Ptr(v float64) *float64 { return &v }
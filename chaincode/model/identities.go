@@ -5,7 +5,10 @@
 // File: model/identities.go
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // IdentityInfo stores information about registered participants in the system.
 type IdentityInfo struct {
@@ -19,4 +22,17 @@ type IdentityInfo struct {
 	RegisteredBy    string    `json:"registeredBy"`    // Full ID of identity that registered this one
 	RegisteredAt    time.Time `json:"registeredAt"`    // Timestamp when identity was registered
 	LastUpdatedAt   time.Time `json:"lastUpdatedAt"`   // Timestamp of last update to this record
+	IsActive        bool      `json:"isActive"`        // False once an admin deactivates (suspends) this identity
+	CertFingerprint string    `json:"certFingerprint"` // SHA-256 of the X.509 certificate, captured when the identity registers itself
+}
+
+// UnmarshalJSON defaults IsActive to true so records written before deactivation support remain active.
+func (i *IdentityInfo) UnmarshalJSON(data []byte) error {
+	type identityInfoAlias IdentityInfo
+	aux := identityInfoAlias{IsActive: true}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*i = IdentityInfo(aux)
+	return nil
 }
@@ -20,6 +20,8 @@ const (
 	StatusConsumed              ShipmentStatus = "CONSUMED"               // (Optional) Shipment marked as consumed/sold by retailer
 	StatusRecalled              ShipmentStatus = "RECALLED"               // Shipment has been recalled
 	StatusConsumedInProcessing  ShipmentStatus = "CONSUMED_IN_PROCESSING" // Input shipment consumed in a transformation
+	StatusVoided                ShipmentStatus = "VOIDED"                 // Shipment voided by its farmer before any downstream action
+	StatusDestroyed             ShipmentStatus = "DESTROYED"              // Recalled shipment whose goods were destroyed (terminal)
 )
 
 // CertificationStatus defines the possible states of an organic certification.
@@ -31,6 +33,18 @@ const (
 	CertStatusRejected CertificationStatus = "REJECTED"
 )
 
+// ContaminationCheckFailed is the ProcessorData.ContaminationCheck result recording a failed check.
+const ContaminationCheckFailed = "FAILED"
+
+// RecallSeverity classifies the health risk of a recall (modelled on FDA recall classes).
+type RecallSeverity string
+
+const (
+	RecallSeverityClass1 RecallSeverity = "CLASS1" // Reasonable probability of serious adverse health consequences
+	RecallSeverityClass2 RecallSeverity = "CLASS2" // Temporary or medically reversible adverse health consequences
+	RecallSeverityClass3 RecallSeverity = "CLASS3" // Unlikely to cause adverse health consequences
+)
+
 // GeoPoint represents a latitude/longitude coordinate.
 type GeoPoint struct {
 	Latitude  float64 `json:"latitude"`
@@ -39,47 +53,60 @@ type GeoPoint struct {
 
 // ColdChainLog represents a single immutable sensor reading during distribution.
 type ColdChainLog struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Temperature float64   `json:"temperature"`
-	Humidity    float64   `json:"humidity"`
-	Coordinates GeoPoint  `json:"coordinates"`
+	Timestamp          time.Time `json:"timestamp"`
+	Temperature        float64   `json:"temperature"` // Ambient temperature
+	ProductTemperature *float64  `json:"productTemperature,omitempty"`
+	Humidity           float64   `json:"humidity"`
+	Coordinates        GeoPoint  `json:"coordinates"`
 }
 
 // FarmerData holds information specific to the farming stage.
 type FarmerData struct {
-	FarmerID                  string    `json:"farmerId"`
-	FarmerName                string    `json:"farmerName"`
-	FarmerAlias               string    `json:"farmerAlias"`
-	FarmLocation              string    `json:"farmLocation"`
-	FarmCoordinates           *GeoPoint `json:"farmCoordinates"`
-	CropType                  string    `json:"cropType"`
-	PlantingDate              time.Time `json:"plantingDate"`
-	FertilizerUsed            string    `json:"fertilizerUsed"`
-	CertificationDocumentHash string    `json:"certificationDocumentHash"`
-	CertificationDocumentURL  string    `json:"certificationDocumentURL"`
-	HarvestDate               time.Time `json:"harvestDate"`
-	FarmingPractice           string    `json:"farmingPractice"`
-	BedType                   string    `json:"bedType"`
-	IrrigationMethod          string    `json:"irrigationMethod"`
-	OrganicSince              time.Time `json:"organicSince"`
-	BufferZoneMeters          float64   `json:"bufferZoneMeters"`
-	DestinationProcessorID    string    `json:"destinationProcessorId"`
+	FarmerID                  string      `json:"farmerId"`
+	FarmerName                string      `json:"farmerName"`
+	FarmerAlias               string      `json:"farmerAlias"`
+	FarmLocation              string      `json:"farmLocation"`
+	FarmCoordinates           *GeoPoint   `json:"farmCoordinates"`
+	CropType                  string      `json:"cropType"`
+	PlantingDate              time.Time   `json:"plantingDate"`
+	FertilizerUsed            string      `json:"fertilizerUsed"`
+	CertificationDocumentHash string      `json:"certificationDocumentHash"`
+	CertificationDocumentURL  string      `json:"certificationDocumentURL"`
+	HarvestDate               time.Time   `json:"harvestDate"`
+	HarvestDates              []time.Time `json:"harvestDates,omitempty"` // Staggered harvest days; HarvestDate is the earliest
+	FarmingPractice           string      `json:"farmingPractice"`
+	BedType                   string      `json:"bedType"`
+	IrrigationMethod          string      `json:"irrigationMethod"`
+	OrganicSince              time.Time   `json:"organicSince"`
+	BufferZoneMeters          float64     `json:"bufferZoneMeters"`
+	DestinationProcessorID    string      `json:"destinationProcessorId"`
 }
 
 // ProcessorData holds information specific to the processing stage.
 type ProcessorData struct {
-	ProcessorID              string    `json:"processorId"`
-	ProcessorAlias           string    `json:"processorAlias"`
-	DateProcessed            time.Time `json:"dateProcessed"`
-	ProcessingType           string    `json:"processingType"`
-	ProcessingLineID         string    `json:"processingLineId"`
-	ProcessingLocation       string    `json:"processingLocation"`
-	ProcessingCoordinates    *GeoPoint `json:"processingCoordinates"`
-	ContaminationCheck       string    `json:"contaminationCheck"`
-	OutputBatchID            string    `json:"outputBatchId"` // For simple processing; for transformations, new Shipment.ID is used.
-	ExpiryDate               time.Time `json:"expiryDate"`
-	QualityCertifications    []string  `json:"qualityCertifications"`
-	DestinationDistributorID string    `json:"destinationDistributorId"`
+	ProcessorID              string       `json:"processorId"`
+	ProcessorAlias           string       `json:"processorAlias"`
+	DateProcessed            time.Time    `json:"dateProcessed"`
+	ProcessingType           string       `json:"processingType"`
+	ProcessingLineID         string       `json:"processingLineId"`
+	ProcessingLocation       string       `json:"processingLocation"`
+	ProcessingCoordinates    *GeoPoint    `json:"processingCoordinates"`
+	ContaminationCheck       string       `json:"contaminationCheck"`
+	OutputBatchID            string       `json:"outputBatchId"` // For simple processing; for transformations, new Shipment.ID is used.
+	ExpiryDate               time.Time    `json:"expiryDate"`
+	QualityCertifications    []string     `json:"qualityCertifications"`
+	DestinationDistributorID string       `json:"destinationDistributorId"`
+	CoolingStartTemp         *float64     `json:"coolingStartTemp,omitempty"` // °C at the start of post-processing cooling
+	CoolingEndTemp           *float64     `json:"coolingEndTemp,omitempty"`   // °C at the end of post-processing cooling
+	BlendComposition         []BlendEntry `json:"blendComposition,omitempty"` // Input contributions for multi-input transformations
+}
+
+// BlendEntry records how much one input shipment contributed to a transformation's outputs.
+type BlendEntry struct {
+	ShipmentID    string  `json:"shipmentId"`
+	Quantity      float64 `json:"quantity"` // Quantity consumed from the input
+	UnitOfMeasure string  `json:"unitOfMeasure"`
+	Percentage    float64 `json:"percentage"` // Share of the total consumed quantity
 }
 
 // CertificationRecord holds information specific to an organic certification event.
@@ -96,19 +123,34 @@ type CertificationRecord struct {
 
 // DistributorData holds information specific to the distribution stage.
 type DistributorData struct {
-	DistributorID         string         `json:"distributorId"`
-	DistributorAlias      string         `json:"distributorAlias"`
-	PickupDateTime        time.Time      `json:"pickupDateTime"`
-	DeliveryDateTime      time.Time      `json:"deliveryDateTime"`
-        DistributionLineID    string         `json:"distributionLineId"`
-        TemperatureRange      string         `json:"temperatureRange"`
-        StorageTemperatures   []float64     `json:"storageTemperatures"`
-        TransitLocationLog    []string       `json:"transitLocationLog"`
-        TransitGPSLog         []GeoPoint     `json:"transitGpsLog"`
-        SensorLogs            []ColdChainLog `json:"sensorLogs"`
-        TransportConditions   string         `json:"transportConditions"`
-        DistributionCenter    string         `json:"distributionCenter"`
-	DestinationRetailerID string         `json:"destinationRetailerId"`
+	DistributorID         string            `json:"distributorId"`
+	DistributorAlias      string            `json:"distributorAlias"`
+	PickupDateTime        time.Time         `json:"pickupDateTime"`
+	DeliveryDateTime      time.Time         `json:"deliveryDateTime"`
+	DistributionLineID    string            `json:"distributionLineId"`
+	TemperatureRange      string            `json:"temperatureRange"`
+	StorageTemperatures   []float64         `json:"storageTemperatures"`
+	TransitLocationLog    []string          `json:"transitLocationLog"`
+	TransitGPSLog         []GeoPoint        `json:"transitGpsLog"`
+	SensorLogs            []ColdChainLog    `json:"sensorLogs"`
+	TransportConditions   string            `json:"transportConditions"`
+	DistributionCenter    string            `json:"distributionCenter"`
+	DestinationRetailerID string            `json:"destinationRetailerId"`
+	Legs                  []DistributionLeg `json:"legs"` // Hops between distribution centers, in chronological order
+
+	// Maintained by AddDistributorSensorLog for readings outside TemperatureRange.
+	TemperatureBreachCount int            `json:"breachCount"`
+	BreachReadings         []ColdChainLog `json:"breachReadings,omitempty"`
+}
+
+// DistributionLeg is one hop of a multi-center distribution journey.
+type DistributionLeg struct {
+	FromCenter          string    `json:"fromCenter"`
+	ToCenter            string    `json:"toCenter"`
+	PickupDateTime      time.Time `json:"pickupDateTime"`
+	DropoffDateTime     time.Time `json:"dropoffDateTime"`
+	TransportConditions string    `json:"transportConditions"`
+	RecordedAt          time.Time `json:"recordedAt"`
 }
 
 // RetailerData holds information specific to the retail stage.
@@ -126,42 +168,145 @@ type RetailerData struct {
 	StoreCoordinates   *GeoPoint `json:"storeCoordinates"`
 	Price              float64   `json:"price"`
 	QRCodeLink         string    `json:"qrCodeLink"`
+
+	// Set by MarkShipmentConsumed.
+	ConsumedAt       *time.Time `json:"consumedAt,omitempty"`
+	QuantitySold     *float64   `json:"quantitySold,omitempty"`
+	ConsumptionNotes string     `json:"consumptionNotes,omitempty"`
 }
 
 // RecallInfo holds information about a shipment recall.
 type RecallInfo struct {
-	IsRecalled        bool      `json:"isRecalled"`
+	IsRecalled        bool           `json:"isRecalled"`
+	RecallID          string         `json:"recallId"`
+	RecallReason      string         `json:"recallReason"`
+	RecallDate        time.Time      `json:"recallDate"`
+	RecalledBy        string         `json:"recalledBy"`
+	RecalledByAlias   string         `json:"recalledByAlias"`
+	LinkedShipmentIDs []string       `json:"linkedShipmentIds"`
+	Severity          RecallSeverity `json:"severity"` // Empty when the recall was not classified
+}
+
+// VoidInfo records why and by whom a shipment was voided.
+type VoidInfo struct {
+	Reason        string    `json:"reason"`
+	VoidedBy      string    `json:"voidedBy"`
+	VoidedByAlias string    `json:"voidedByAlias"`
+	VoidedAt      time.Time `json:"voidedAt"`
+}
+
+// RecallRegistryEntry claims a RecallID for the shipment whose recall introduced it.
+type RecallRegistryEntry struct {
 	RecallID          string    `json:"recallId"`
-	RecallReason      string    `json:"recallReason"`
-	RecallDate        time.Time `json:"recallDate"`
-	RecalledBy        string    `json:"recalledBy"`
-	RecalledByAlias   string    `json:"recalledByAlias"`
-	LinkedShipmentIDs []string  `json:"linkedShipmentIds"`
+	PrimaryShipmentID string    `json:"primaryShipmentId"`
+	InitiatedBy       string    `json:"initiatedBy"`
+	InitiatedAt       time.Time `json:"initiatedAt"`
+}
+
+// DestructionInfo records how and by whom recalled goods were destroyed.
+type DestructionInfo struct {
+	Method           string    `json:"method"`
+	Note             string    `json:"note"`
+	DestroyedBy      string    `json:"destroyedBy"`
+	DestroyedByAlias string    `json:"destroyedByAlias"`
+	DestroyedAt      time.Time `json:"destroyedAt"`
+}
+
+// DisposalInfo records why and by whom an input was consumed without producing outputs.
+type DisposalInfo struct {
+	Reason           string    `json:"reason"`
+	DisposedQuantity float64   `json:"disposedQuantity"`
+	DisposedBy       string    `json:"disposedBy"`
+	DisposedByAlias  string    `json:"disposedByAlias"`
+	DisposedAt       time.Time `json:"disposedAt"`
+}
+
+// AdminOverride records an administrative correction made outside the normal lifecycle.
+type AdminOverride struct {
+	Action         string         `json:"action"`
+	Reason         string         `json:"reason"`
+	PreviousStatus ShipmentStatus `json:"previousStatus"`
+	NewStatus      ShipmentStatus `json:"newStatus"`
+	AdminID        string         `json:"adminId"`
+	AdminAlias     string         `json:"adminAlias"`
+	Timestamp      time.Time      `json:"timestamp"`
+}
+
+// CustodyTransfer records a shipment passing from one owner to another at a lifecycle stage.
+type CustodyTransfer struct {
+	FromID        string         `json:"fromId"`
+	FromAlias     string         `json:"fromAlias"`
+	ToID          string         `json:"toId"`
+	ToAlias       string         `json:"toAlias"`
+	Stage         ShipmentStatus `json:"stage"` // Status the shipment moved to with the transfer
+	Reason        string         `json:"reason,omitempty"`
+	TransferredAt time.Time      `json:"transferredAt"`
+}
+
+// CorrectiveAction records a step taken by the owner to address a rejected certification.
+type CorrectiveAction struct {
+	Description string    `json:"description"`
+	ActorID     string    `json:"actorId"`
+	ActorAlias  string    `json:"actorAlias"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// AttachedDocument references an off-chain document by hash, attached to a shipment at any stage.
+type AttachedDocument struct {
+	DocType         string         `json:"docType"`
+	DocHash         string         `json:"docHash"`
+	DocURL          string         `json:"docURL"`
+	AttachedBy      string         `json:"attachedBy"`
+	AttachedByAlias string         `json:"attachedByAlias"`
+	AttachedAt      time.Time      `json:"attachedAt"`
+	StageStatus     ShipmentStatus `json:"stageStatus"` // Shipment status at the time of attachment
 }
 
 // Shipment is the central data structure for tracking a food item through the supply chain.
 type Shipment struct {
-	ObjectType           string                `json:"objectType"`  // "Shipment"
-	ID                   string                `json:"id"`          // Unique ID for the shipment
-	ProductName          string                `json:"productName"` // General product name
-	Description          string                `json:"description"`
-	Quantity             float64               `json:"quantity"`
-	UnitOfMeasure        string                `json:"unitOfMeasure"`
-	CurrentOwnerID       string                `json:"currentOwnerId"`
-	CurrentOwnerAlias    string                `json:"currentOwnerAlias"`
-	Status               ShipmentStatus        `json:"status"`
-	CreatedAt            time.Time             `json:"createdAt"`
-	LastUpdatedAt        time.Time             `json:"lastUpdatedAt"`
-	IsArchived           bool                  `json:"isArchived"`
-	InputShipmentIDs     []string              `json:"inputShipmentIds"` // IDs of shipments consumed to create this one
-	IsDerivedProduct     bool                  `json:"isDerivedProduct"` // True if this shipment was created from other input shipments
-	FarmerData           *FarmerData           `json:"farmerData"`
-	CertificationRecords []CertificationRecord `json:"certificationRecords"`
-	ProcessorData        *ProcessorData        `json:"processorData"`
-	DistributorData      *DistributorData      `json:"distributorData"`
-	RetailerData         *RetailerData         `json:"retailerData"`
-	RecallInfo           *RecallInfo           `json:"recallInfo"`
-	History              []HistoryEntry        `json:"history"` // Populated by GetShipmentPublicDetails
+	ObjectType             string                `json:"objectType"`  // "Shipment"
+	ID                     string                `json:"id"`          // Unique ID for the shipment
+	ProductName            string                `json:"productName"` // General product name
+	Description            string                `json:"description"`
+	Quantity               float64               `json:"quantity"`
+	UnitOfMeasure          string                `json:"unitOfMeasure"`
+	CurrentOwnerID         string                `json:"currentOwnerId"`
+	CurrentOwnerAlias      string                `json:"currentOwnerAlias"`
+	Status                 ShipmentStatus        `json:"status"`
+	CreatedAt              time.Time             `json:"createdAt"`
+	CreatedByMSP           string                `json:"createdByMsp"` // MSP of the identity that created the shipment; empty on older records
+	LastUpdatedAt          time.Time             `json:"lastUpdatedAt"`
+	IsArchived             bool                  `json:"isArchived"`
+	ArchiveReason          string                `json:"archiveReason"`           // Cleared on unarchive
+	ArchivedAt             time.Time             `json:"archivedAt"`              // Zero unless archived
+	InputShipmentIDs       []string              `json:"inputShipmentIds"`        // IDs of shipments consumed to create this one
+	IsDerivedProduct       bool                  `json:"isDerivedProduct"`        // True if this shipment was created from other input shipments
+	RetailUnitIDs          []string              `json:"retailUnitIds,omitempty"` // Units this shipment was split into by SplitForRetail
+	FarmerData             *FarmerData           `json:"farmerData"`
+	CertificationRecords   []CertificationRecord `json:"certificationRecords"`
+	ProcessorData          *ProcessorData        `json:"processorData"`
+	DistributorData        *DistributorData      `json:"distributorData"`
+	RetailerData           *RetailerData         `json:"retailerData"`
+	RecallInfo             *RecallInfo           `json:"recallInfo"`
+	Documents              []AttachedDocument    `json:"documents"`                  // Supporting documents attached at any stage
+	CustomAttributes       map[string]string     `json:"customAttributes,omitempty"` // Free-form key/value metadata
+	VoidInfo               *VoidInfo             `json:"voidInfo,omitempty"`
+	DestructionInfo        *DestructionInfo      `json:"destructionInfo,omitempty"`
+	DisposalInfo           *DisposalInfo         `json:"disposalInfo,omitempty"`
+	AdminOverrides         []AdminOverride       `json:"adminOverrides,omitempty"`    // Governance log of admin corrections
+	CustodyLog             []CustodyTransfer     `json:"custodyLog,omitempty"`        // Ownership changes between parties
+	CorrectiveActions      []CorrectiveAction    `json:"correctiveActions,omitempty"` // Owner remediation after a rejected certification
+	DesignatedCertifierID  string                `json:"designatedCertifierId"`       // Optional; when set only this certifier (or an admin) may certify
+	UnderInvestigation     bool                  `json:"underInvestigation"`          // Flagged for review without a recall
+	InvestigationReason    string                `json:"investigationReason"`
+	InvestigationFlaggedBy string                `json:"investigationFlaggedBy"`
+	OnHold                 bool                  `json:"onHold"` // Lifecycle transitions blocked until released
+	HoldReason             string                `json:"holdReason"`
+	HoldPlacedBy           string                `json:"holdPlacedBy"`
+	ExpectedDeliveryBy     *time.Time            `json:"expectedDeliveryBy,omitempty"` // Set by the designated retailer while DISTRIBUTED
+	LateDelivery           bool                  `json:"lateDelivery"`                 // Received after ExpectedDeliveryBy
+	History                []HistoryEntry        `json:"history"`                      // Populated by GetShipmentPublicDetails
+	HistoryTruncated       bool                  `json:"historyTruncated,omitempty"`   // History holds only the most recent entries
 }
 
 // HistoryEntry represents one historical state of a shipment or an event.
@@ -189,9 +334,10 @@ type RelatedShipmentInfo struct {
 	EventTimestamp    time.Time      `json:"eventTimestamp"` // Timestamp of the relating event (e.g., DateProcessed)
 }
 
-// InputShipmentConsumptionDetail defines the ID of an input shipment to be fully consumed.
+// InputShipmentConsumptionDetail identifies an input shipment to consume, in full or in part.
 type InputShipmentConsumptionDetail struct {
-	ShipmentID string `json:"shipmentId"` // ID of the input shipment (ingredient) to be fully consumed
+	ShipmentID       string  `json:"shipmentId"`                 // ID of the input shipment (ingredient) to consume
+	QuantityConsumed float64 `json:"quantityConsumed,omitempty"` // Zero consumes the full remaining quantity
 }
 
 // NewProductDetail defines the properties of a new product created from a transformation.
@@ -209,3 +355,11 @@ type PaginatedShipmentResponse struct {
 	NextBookmark string      `json:"nextBookmark"`
 	FetchedCount int32       `json:"fetchedCount"`
 }
+
+// PaginatedHistoryResponse is one page of a shipment's history, oldest first.
+type PaginatedHistoryResponse struct {
+	Entries      []HistoryEntry `json:"entries"`
+	NextBookmark string         `json:"nextBookmark"` // Empty on the last page
+	FetchedCount int32          `json:"fetchedCount"`
+	TotalEntries int            `json:"totalEntries"`
+}